@@ -2,11 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/csv"
 	"errors"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/cobaltspeech/log"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/storage"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 	"github.com/spf13/cobra"
 )
 
@@ -18,24 +23,49 @@ var adminCmd = &cobra.Command{
 func init() {
 	adminCmd.AddCommand(
 		adminSetPass,
+		adminSetRoleCmd,
+		adminReset2FACmd,
+		adminUnlockCmd,
+		adminFailuresCmd,
 		adminDeleteCmd,
 	)
+
+	adminSetPass.Flags().StringVar(&newAdminRole, "role", string(api.RoleOwner),
+		"role to give the admin if it is created. One of: "+roleNames())
+	adminFailuresCmd.Flags().IntVar(&failuresLimit, "limit", 50, "number of failures to show")
+}
+
+var newAdminRole string
+
+func roleNames() string {
+	roles := api.AllRoles()
+	names := make([]string, len(roles))
+	for i, r := range roles {
+		names[i] = string(r)
+	}
+
+	return strings.Join(names, ", ")
 }
 
 var adminSetPass = &cobra.Command{
 	Use:   "setpass",
 	Short: "Set the password for a new or existing admin",
 	Args:  cobra.ExactArgs(2),
-	Run: withLAndDB(func(l log.Logger, pool *pgxpool.Pool, args []string) error {
-		table := db.NewAdminTable(l, pool)
+	Run: withLAndDB(func(l log.Logger, b *storage.Backend, args []string) error {
+		table := b.Admins
 
 		user := args[0]
 		pass := args[1]
 
-		err := table.ChangePassword(context.Background(), user, pass)
+		role, err := api.ParseRole(newAdminRole)
+		if err != nil {
+			return err
+		}
+
+		err = table.ResetPassword(context.Background(), user, pass, "")
 		if errors.Is(err, db.ErrNoUser) {
-			l.Info("msg", "admin does not exist, creating it", "admin", user)
-			err = table.AddAdmin(context.Background(), user, pass)
+			l.Info("msg", "admin does not exist, creating it", "admin", user, "role", role)
+			err = table.AddAdmin(context.Background(), user, pass, role)
 		}
 		if err != nil {
 			return err
@@ -47,12 +77,103 @@ var adminSetPass = &cobra.Command{
 	}),
 }
 
+var adminSetRoleCmd = &cobra.Command{
+	Use:   "setrole ADMIN ROLE",
+	Short: "Change the role of an existing admin",
+	Long: `Change the role of an existing admin. The roles are:
+
+  viewer    read users
+  uploader  read and create users
+  migrator  read and update users, and migrate Discord members to a new cohort
+  owner     everything`,
+	Args: cobra.ExactArgs(2),
+	Run: withLAndDB(func(l log.Logger, b *storage.Backend, args []string) error {
+		role, err := api.ParseRole(args[1])
+		if err != nil {
+			return err
+		}
+
+		err = b.Admins.SetRole(context.Background(), args[0], role)
+		if err != nil {
+			return err
+		}
+
+		l.Info("msg", "set role for admin", "admin", args[0], "role", role)
+
+		return nil
+	}),
+}
+
+var adminReset2FACmd = &cobra.Command{
+	Use:   "reset2fa ADMIN",
+	Short: "Turn off two-factor authentication for an admin who lost their authenticator app",
+	Args:  cobra.ExactArgs(1),
+	Run: withLAndDB(func(l log.Logger, b *storage.Backend, args []string) error {
+		err := b.Admins.ResetTOTP(context.Background(), args[0])
+		if err != nil {
+			return err
+		}
+
+		l.Info("msg", "reset two-factor authentication for admin", "admin", args[0])
+
+		return nil
+	}),
+}
+
+var adminUnlockCmd = &cobra.Command{
+	Use:   "unlock ADMIN",
+	Short: "Clear the lockout of an admin after too many failed logins",
+	Args:  cobra.ExactArgs(1),
+	Run: withLAndDB(func(l log.Logger, b *storage.Backend, args []string) error {
+		err := b.Logins.Unlock(context.Background(), args[0])
+		if err != nil {
+			return err
+		}
+
+		l.Info("msg", "unlocked admin", "admin", args[0])
+
+		return nil
+	}),
+}
+
+var failuresLimit int
+
+var adminFailuresCmd = &cobra.Command{
+	Use:   "failures [ADMIN]",
+	Short: "List recent failed logins, newest first, possibly only for one admin",
+	Args:  cobra.MaximumNArgs(1),
+	Run: withLAndDB(func(l log.Logger, b *storage.Backend, args []string) error {
+		var admin string
+		if len(args) > 0 {
+			admin = args[0]
+		}
+
+		failures, err := b.Logins.ListFailures(
+			context.Background(), admin, failuresLimit)
+		if err != nil {
+			return err
+		}
+
+		w := csv.NewWriter(os.Stdout)
+		defer w.Flush()
+
+		w.Write([]string{"time", "admin", "ip", "reason"}) //nolint:errcheck // writing to stdout
+		for _, f := range failures {
+			w.Write([]string{ //nolint:errcheck // We're writing to stdout.
+				f.CreatedAt.Format(time.RFC3339), f.Username, f.IP, f.Reason,
+			})
+		}
+
+		return nil
+	}),
+}
+
 var adminDeleteCmd = &cobra.Command{
 	Use:   "delete",
 	Short: "Delete an admin from the database",
 	Args:  cobra.ExactArgs(1),
-	Run: withLAndDB(func(l log.Logger, pool *pgxpool.Pool, args []string) error {
-		table := db.NewAdminTable(l, pool)
+	Run: withLAndDB(func(l log.Logger, b *storage.Backend, args []string) error {
+		table := b.Admins
 
 		user := args[0]
 
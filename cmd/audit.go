@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Check the audit trail",
+}
+
+func init() {
+	auditCmd.AddCommand(auditVerifyCmd)
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify [HASH...]",
+	Short: "Check that no audit events have been changed or removed",
+	Long: `Check that no audit events have been changed or removed, by checking the hash chain from the
+first event to the newest. The first broken link is reported. If the chain is intact, the ID, time,
+and hash of the newest event are printed.
+
+Pass hashes of earlier chain heads, as printed by "client audit head", to also check that the
+chain still contains them. This shows that the events up to each of them were not rewritten.`,
+	Run: withLAndDB(func(l log.Logger, b *storage.Backend, args []string) error {
+		ctx := context.Background()
+		table := b.Audit
+
+		checked, broken, err := table.VerifyChain(ctx)
+		if err != nil {
+			return err
+		}
+		if broken != nil {
+			return fmt.Errorf("audit chain is broken at %v (%d events verified before it)",
+				broken, checked)
+		}
+
+		for _, arg := range args {
+			hash, err := hex.DecodeString(arg)
+			if err != nil {
+				return fmt.Errorf("invalid hash %q: %w", arg, err)
+			}
+			id, err := table.FindHash(ctx, hash)
+			if errors.Is(err, db.ErrNoAuditEvents) {
+				return fmt.Errorf("no event in the audit chain has hash %s", arg)
+			}
+			if err != nil {
+				return err
+			}
+			l.Info("msg", "found earlier chain head", "id", id, "hash", arg)
+		}
+
+		head, err := table.ChainHead(ctx)
+		if errors.Is(err, db.ErrNoAuditEvents) {
+			l.Info("msg", "no audit events to verify")
+
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		l.Info("msg", "audit chain is intact", "events", checked)
+		fmt.Println(head.ID, head.CreatedAt.UTC().Format(time.RFC3339), hex.EncodeToString(head.Hash))
+
+		return nil
+	}),
+}
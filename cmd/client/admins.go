@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"github.com/kylrth/disco-bouncer/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+var adminsCmd = &cobra.Command{
+	Use:   "admins",
+	Short: "Manage admin accounts (owners only)",
+}
+
+var newAdminRole string
+
+func init() {
+	adminsCmd.AddCommand(
+		adminsListCmd,
+		adminsCreateCmd,
+		adminsSetRoleCmd,
+		adminsDisableCmd,
+		adminsEnableCmd,
+		adminsResetPassCmd,
+		adminsDeleteCmd,
+	)
+
+	adminsCreateCmd.Flags().StringVar(&newAdminRole, "role", string(api.RoleViewer),
+		"role of the new admin (viewer, uploader, migrator, or owner)")
+}
+
+var adminsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List admin accounts",
+	Args:  cobra.NoArgs,
+	Run: withLAndC(func(_ log.Logger, c *client.Client, _ []string) error {
+		admins, err := c.Admins.List(cmdCtx)
+		if err != nil {
+			return err
+		}
+
+		w := csv.NewWriter(os.Stdout)
+		defer w.Flush()
+
+		w.Write([]string{ //nolint:errcheck // We're writing to stdout.
+			"id", "username", "role", "disabled", "two_factor", "created", "last_login", "locked",
+		})
+		for _, a := range admins {
+			lastLogin, locked := "", ""
+			if a.LastLogin != nil {
+				lastLogin = a.LastLogin.Format(time.RFC3339)
+			}
+			if a.LockedAt != nil {
+				locked = a.LockedAt.Format(time.RFC3339)
+			}
+
+			w.Write([]string{ //nolint:errcheck // We're writing to stdout.
+				strconv.Itoa(a.ID), a.Username, string(a.Role), strconv.FormatBool(a.Disabled),
+				strconv.FormatBool(a.TOTPEnabled), a.CreatedAt.Format(time.RFC3339), lastLogin, locked,
+			})
+		}
+
+		return nil
+	}),
+}
+
+var adminsCreateCmd = &cobra.Command{
+	Use:   "create USERNAME",
+	Short: "Create an admin, prompting for the initial password",
+	Args:  cobra.ExactArgs(1),
+	Run: withLAndC(func(l log.Logger, c *client.Client, args []string) error {
+		role, err := api.ParseRole(newAdminRole)
+		if err != nil {
+			return err
+		}
+
+		password, err := promptPassword("Initial password: ")
+		if err != nil {
+			return err
+		}
+
+		_, err = c.Admins.Create(cmdCtx, args[0], password, role)
+		if err != nil {
+			return err
+		}
+
+		l.Info("msg", "created admin", "admin", args[0], "role", role)
+
+		return nil
+	}),
+}
+
+var adminsSetRoleCmd = &cobra.Command{
+	Use:   "setrole USERNAME ROLE",
+	Short: "Change the role of an admin",
+	Args:  cobra.ExactArgs(2),
+	Run: withLAndC(func(l log.Logger, c *client.Client, args []string) error {
+		role, err := api.ParseRole(args[1])
+		if err != nil {
+			return err
+		}
+
+		_, err = c.Admins.Patch(cmdCtx, args[0], &api.AdminPatch{Role: &role})
+		if err != nil {
+			return err
+		}
+
+		l.Info("msg", "set role for admin", "admin", args[0], "role", role)
+
+		return nil
+	}),
+}
+
+var adminsDisableCmd = &cobra.Command{
+	Use:   "disable USERNAME",
+	Short: "Disable an admin, ending their sessions and stopping their API tokens",
+	Args:  cobra.ExactArgs(1),
+	Run:   withLAndC(setDisabled(true)),
+}
+
+var adminsEnableCmd = &cobra.Command{
+	Use:   "enable USERNAME",
+	Short: "Enable a disabled admin",
+	Args:  cobra.ExactArgs(1),
+	Run:   withLAndC(setDisabled(false)),
+}
+
+func setDisabled(disabled bool) func(log.Logger, *client.Client, []string) error {
+	return func(l log.Logger, c *client.Client, args []string) error {
+		_, err := c.Admins.Patch(cmdCtx, args[0],
+			&api.AdminPatch{Disabled: &disabled})
+		if err != nil {
+			return err
+		}
+
+		l.Info("msg", "set admin disabled", "admin", args[0], "disabled", disabled)
+
+		return nil
+	}
+}
+
+var adminsResetPassCmd = &cobra.Command{
+	Use:   "resetpass USERNAME",
+	Short: "Set a new password for an admin, prompting for it",
+	Args:  cobra.ExactArgs(1),
+	Run: withLAndC(func(_ log.Logger, c *client.Client, args []string) error {
+		password, err := promptPassword("New password: ")
+		if err != nil {
+			return err
+		}
+
+		return c.Admins.ResetPassword(cmdCtx, args[0], password)
+	}),
+}
+
+var adminsDeleteCmd = &cobra.Command{
+	Use:   "delete USERNAME",
+	Short: "Delete an admin, ending their sessions and deleting their API tokens",
+	Args:  cobra.ExactArgs(1),
+	Run: withLAndC(func(_ log.Logger, c *client.Client, args []string) error {
+		return c.Admins.Delete(cmdCtx, args[0])
+	}),
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"github.com/kylrth/disco-bouncer/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+var auditFilter api.AuditFilter
+
+func init() {
+	auditCmd.AddCommand(auditHeadCmd)
+
+	auditCmd.Flags().StringVar(&auditFilter.Actor, "actor", "",
+		"only show changes made by this admin")
+	auditCmd.Flags().StringVar(&auditFilter.Action, "action", "",
+		`only show this action, like "DELETE /api/users/:id"`)
+	auditCmd.Flags().StringVar(&auditFilter.Target, "target", "",
+		"only show changes to this user ID or admin username")
+	auditCmd.Flags().DurationVar(&auditSince, "since", 0,
+		"only show changes made within this long ago")
+	auditCmd.Flags().IntVar(&auditFilter.BeforeID, "before", 0,
+		"only show events older than this event ID")
+	auditCmd.Flags().IntVar(&auditFilter.Limit, "limit", 100,
+		"show at most this many events")
+}
+
+var auditSince time.Duration
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Show the changes made through the API, newest first",
+	Long: `Show the changes made through the API, newest first. The changes column is a JSON object
+with the before and after values of each changed field. The values of secret fields are redacted.`,
+	Args: cobra.NoArgs,
+	Run: withLAndC(func(_ log.Logger, c *client.Client, _ []string) error {
+		if auditSince > 0 {
+			auditFilter.Since = time.Now().Add(-auditSince)
+		}
+
+		events, err := c.Audit.List(cmdCtx, &auditFilter)
+		if err != nil {
+			return err
+		}
+
+		w := csv.NewWriter(os.Stdout)
+		defer w.Flush()
+
+		w.Write([]string{ //nolint:errcheck // We're writing to stdout.
+			"id", "time", "actor", "token", "action", "target", "changes", "request_id",
+		})
+		for _, e := range events {
+			changes := ""
+			if len(e.Changes) > 0 {
+				b, err := json.Marshal(e.Changes)
+				if err != nil {
+					return err
+				}
+				changes = string(b)
+			}
+
+			w.Write([]string{ //nolint:errcheck // We're writing to stdout.
+				strconv.Itoa(e.ID), e.Time.Format(time.RFC3339), e.Actor, e.Token, e.Action,
+				e.Target, changes, e.RequestID,
+			})
+		}
+
+		return nil
+	}),
+}
+
+var auditHeadCmd = &cobra.Command{
+	Use:   "head",
+	Short: "Print the ID and hash of the newest event in the audit chain",
+	Long: `Print the ID, time, and hash of the newest event in the audit chain. Keep a copy of the hash
+somewhere else, like in a message or a commit. Passing it to "bouncer audit verify" later shows
+that the audit trail up to that event has not been rewritten.`,
+	Args: cobra.NoArgs,
+	Run: withLAndC(func(_ log.Logger, c *client.Client, _ []string) error {
+		head, err := c.Audit.Head(cmdCtx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(head.ID, head.Time.UTC().Format(time.RFC3339), head.Hash)
+
+		return nil
+	}),
+}
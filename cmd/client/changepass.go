@@ -2,7 +2,6 @@ package main
 
 import (
 	"bufio"
-	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -20,18 +19,26 @@ var changePassCmd = &cobra.Command{
 }
 
 func changePass(_ log.Logger, c *client.Client, _ []string) error {
-	// prompt for password
-	fmt.Fprint(os.Stderr, "New password: ")
+	password, err := promptPassword("New password: ")
+	if err != nil {
+		return err
+	}
+
+	return c.Admin.ChangePassword(cmdCtx, os.Getenv("BOUNCER_PASS"), password)
+}
+
+// promptPassword reads a password from stdin after printing the prompt to stderr.
+func promptPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
 	scanner := bufio.NewScanner(os.Stdin)
 	if !scanner.Scan() {
 		err := scanner.Err()
 		if err != nil {
-			return fmt.Errorf("read password: %w", err)
+			return "", fmt.Errorf("read password: %w", err)
 		}
 
-		return errors.New("no password entered")
+		return "", errors.New("no password entered")
 	}
-	password := scanner.Text()
 
-	return c.Admin.ChangePassword(context.Background(), os.Getenv("BOUNCER_PASS"), password)
+	return scanner.Text(), nil
 }
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"strconv"
 
@@ -26,7 +25,7 @@ func deleteIDs(l log.Logger, c *client.Client, ids []string) error {
 			continue
 		}
 
-		err = c.Users.DeleteUser(context.Background(), i)
+		err = c.Users.DeleteUser(cmdCtx, i)
 		if err != nil {
 			return fmt.Errorf("delete user %d: %w", i, err)
 		}
@@ -10,7 +10,7 @@ import (
 	"strconv"
 
 	"github.com/cobaltspeech/log"
-	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 	"github.com/kylrth/disco-bouncer/pkg/client"
 	"github.com/kylrth/disco-bouncer/pkg/encrypt"
 	"github.com/spf13/cobra"
@@ -71,11 +71,12 @@ func get(c *client.Client, ids []string) error {
 
 	if len(ids) == 0 {
 		// get all
-		users, err := c.Users.GetAllUsers(context.Background())
-		if err != nil {
-			return err
+		for user, err := range c.Users.All(cmdCtx) {
+			if err != nil {
+				return err
+			}
+			writeUser(w, user)
 		}
-		writeUser(w, users...)
 
 		return nil
 	}
@@ -83,7 +84,7 @@ func get(c *client.Client, ids []string) error {
 	if useHashes {
 		// get by hashes
 		for _, hash := range ids {
-			users, err := c.Users.GetAllUsers(context.Background(), client.WithKeyHash(hash))
+			users, err := c.Users.GetAllUsers(cmdCtx, client.WithKeyHash(hash))
 			if err != nil {
 				return err
 			}
@@ -94,7 +95,7 @@ func get(c *client.Client, ids []string) error {
 	}
 
 	if useKeys {
-		return getByKeys(context.Background(), w, c, ids)
+		return getByKeys(cmdCtx, w, c, ids)
 	}
 
 	return getByIDs(w, c, ids)
@@ -121,7 +122,7 @@ func getByKeys(ctx context.Context, w *csv.Writer, c *client.Client, keys []stri
 
 var ErrNotFound = errors.New("not found")
 
-func getWithKey(ctx context.Context, c *client.Client, key string) (*db.User, error) {
+func getWithKey(ctx context.Context, c *client.Client, key string) (*api.User, error) {
 	hash, err := encrypt.MD5Hash(key)
 	if err != nil {
 		return nil, err
@@ -160,7 +161,7 @@ func getByIDs(w *csv.Writer, c *client.Client, ids []string) error {
 	}
 
 	for _, id := range idInts {
-		user, err := c.Users.GetUser(context.Background(), id)
+		user, err := c.Users.GetUser(cmdCtx, id)
 		if err != nil {
 			return err
 		}
@@ -170,7 +171,7 @@ func getByIDs(w *csv.Writer, c *client.Client, ids []string) error {
 	return nil
 }
 
-func writeUser(w *csv.Writer, us ...*db.User) {
+func writeUser(w *csv.Writer, us ...*api.User) {
 	for _, u := range us {
 		w.Write([]string{ //nolint:errcheck // We're writing to stdout.
 			strconv.Itoa(u.ID),
@@ -10,6 +10,7 @@ import (
 	"os"
 
 	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 	"github.com/kylrth/disco-bouncer/pkg/client"
 	"github.com/kylrth/disco-bouncer/pkg/encrypt"
 	"github.com/spf13/cobra"
@@ -98,7 +99,7 @@ func migrate(l log.Logger, c *client.Client, year string) error {
 			continue
 		}
 
-		err = migrateTryBoth(context.Background(), l, c, line[1], line[2], year)
+		err = migrateTryBoth(cmdCtx, l, c, line[1], line[2], year)
 		if err != nil {
 			return err
 		}
@@ -129,7 +130,7 @@ func migrateOnly(l log.Logger, c *client.Client, year string) error {
 			continue
 		}
 
-		ctx := context.Background()
+		ctx := cmdCtx
 		var with string // for logging
 		var err error
 
@@ -151,7 +152,8 @@ func migrateOnly(l log.Logger, c *client.Client, year string) error {
 
 func migrateByKey(ctx context.Context, l log.Logger, c *client.Client, key, year string) error {
 	// Do the same as getWithKey but keep the name ciphertext the same. We don't want to reupload
-	// the plaintext name when we update the FinishYear.
+	// the plaintext name when we update the FinishYear, so we patch only that field. If-Match
+	// keeps us from clobbering a change made since we read the user.
 	hash, err := encrypt.MD5Hash(key)
 	if err != nil {
 		return err
@@ -174,9 +176,8 @@ func migrateByKey(ctx context.Context, l log.Logger, c *client.Client, key, year
 
 		l.Debug("msg", "found user by key hash", "key", key, "id", u.ID)
 
-		u.FinishYear = year
-
-		err = c.Users.UpdateUser(ctx, u)
+		_, err = c.Users.Patch(ctx, u.ID, &api.UserPatch{FinishYear: &year},
+			client.IfMatch(u.Version))
 		if err != nil {
 			return err
 		}
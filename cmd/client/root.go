@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
+	"runtime"
+	"time"
 
 	"github.com/cobaltspeech/log"
 	"github.com/cobaltspeech/log/pkg/level"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+	"github.com/kylrth/disco-bouncer/internal/tracing"
 	"github.com/kylrth/disco-bouncer/pkg/client"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func main() {
@@ -26,6 +33,8 @@ var rootCmd = &cobra.Command{
 var (
 	verbosity int
 	serverURL string
+	sso       bool
+	discord   bool
 )
 
 func init() {
@@ -36,46 +45,110 @@ func init() {
 		changePassCmd,
 		runhashCmd,
 		migrateCmd,
+		adminsCmd,
+		totpCmd,
+		sessionsCmd,
+		auditCmd,
 	)
 
 	rootCmd.PersistentFlags().IntVarP(&verbosity, "verbosity", "v", 2, "set verbosity (1-4)")
 	rootCmd.PersistentFlags().StringVarP(
 		&serverURL, "server", "s", "http://localhost:8321", "server URL")
+	rootCmd.PersistentFlags().BoolVar(&sso, "sso", false,
+		"log in with single sign-on in a browser instead of BOUNCER_USER and BOUNCER_PASS")
+	rootCmd.PersistentFlags().BoolVar(&discord, "discord", false,
+		"log in with Discord in a browser instead of BOUNCER_USER and BOUNCER_PASS")
 }
 
+// cmdCtx is the context of the running command. Its span is the parent of the spans of every
+// request made by the command.
+var cmdCtx = context.Background()
+
 func withLogger(f func(log.Logger, []string) error) func(*cobra.Command, []string) {
-	return func(_ *cobra.Command, args []string) {
-		l := log.NewLeveledLogger(log.WithFilterLevel(level.Verbosity(verbosity)))
+	return func(cmd *cobra.Command, args []string) {
+		l := logging.Redact(log.NewLeveledLogger(log.WithFilterLevel(level.Verbosity(verbosity))))
 
-		err := f(l, args)
+		err := runTraced(cmd, func() error { return f(l, args) })
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			if errors.Is(err, client.ErrForbidden) {
+				fmt.Fprintln(os.Stderr, "Your account's role does not allow this. Ask an owner "+
+					"to change it with `bouncer admin setrole`.")
+			}
+			if errors.Is(err, client.ErrAccountLocked) {
+				fmt.Fprintln(os.Stderr, "Ask the server admin to clear the lockout with "+
+					"`bouncer admin unlock`.")
+			}
 			os.Exit(1)
 		}
 	}
 }
 
+// runTraced runs f in a span named after the command, if BOUNCER_TRACING_EXPORTER is set. The
+// spans are flushed before it returns.
+func runTraced(cmd *cobra.Command, f func() error) error {
+	shutdown, err := tracing.Setup(
+		context.Background(), os.Getenv("BOUNCER_TRACING_EXPORTER"), "bouncer-client")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdown(ctx) // Failing to send spans shouldn't fail the command.
+	}()
+
+	var span trace.Span
+	cmdCtx, span = tracing.Start(context.Background(), cmd.CommandPath())
+	err = f()
+	tracing.End(span, err)
+
+	return err
+}
+
 func withLAndC(f func(log.Logger, *client.Client, []string) error) func(*cobra.Command, []string) {
 	return withLogger(func(l log.Logger, args []string) error {
+		if token := os.Getenv("BOUNCER_TOKEN"); token != "" {
+			c, err := client.NewClient(serverURL, client.WithToken(token))
+			if err != nil {
+				return err
+			}
+
+			return f(l, c, args)
+		}
+
 		c, err := client.NewClient(serverURL)
 		if err != nil {
 			return err
 		}
 
-		err = c.Admin.Login(
-			context.Background(), os.Getenv("BOUNCER_USER"), os.Getenv("BOUNCER_PASS"))
+		switch {
+		case sso:
+			err = c.Admin.LoginOIDC(cmdCtx, openBrowser)
+		case discord:
+			err = c.Admin.LoginDiscord(cmdCtx, openBrowser)
+		default:
+			err = c.Admin.Login(
+				cmdCtx, os.Getenv("BOUNCER_USER"), os.Getenv("BOUNCER_PASS"))
+			if errors.Is(err, client.ErrTOTPRequired) {
+				err = loginTOTP(c)
+			}
+		}
+		if err != nil && (sso || discord) {
+			return fmt.Errorf("login in browser: %w", err)
+		}
 		if err != nil {
 			_, userSet := os.LookupEnv("BOUNCER_USER")
 			_, passSet := os.LookupEnv("BOUNCER_PASS")
 			if !userSet || !passSet {
-				fmt.Fprintln(os.Stderr,
-					"Be sure to set credentials with BOUNCER_USER and BOUNCER_PASS")
+				fmt.Fprintln(os.Stderr, "Be sure to set credentials with BOUNCER_USER and "+
+					"BOUNCER_PASS, or set an API token with BOUNCER_TOKEN")
 			}
 
 			return fmt.Errorf("login: %w", err)
 		}
 		defer func() {
-			err := c.Admin.Logout(context.Background())
+			err := c.Admin.Logout(cmdCtx)
 			if err != nil {
 				l.Error("msg", "failed to log out", "error", err)
 			}
@@ -84,3 +157,36 @@ func withLAndC(f func(log.Logger, *client.Client, []string) error) func(*cobra.C
 		return f(l, c, args)
 	})
 }
+
+// loginTOTP finishes logging in with the two-factor code in BOUNCER_TOTP, or prompts for one.
+func loginTOTP(c *client.Client) error {
+	code := os.Getenv("BOUNCER_TOTP")
+	if code == "" {
+		var err error
+		code, err = promptPassword("Two-factor code: ")
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.Admin.LoginTOTP(cmdCtx, code)
+}
+
+// openBrowser prints the URL and tries to open it in a browser. If that fails, the URL can still be
+// opened by hand.
+func openBrowser(u string) error {
+	fmt.Fprintln(os.Stderr, "Log in to continue:", u)
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+	_ = cmd.Start() // best effort
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "Manage your logged-in sessions",
+}
+
+func init() {
+	sessionsCmd.AddCommand(sessionsListCmd, sessionsRevokeCmd)
+}
+
+var sessionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List your logged-in sessions, including the one used by this command",
+	Args:  cobra.NoArgs,
+	Run: withLAndC(func(_ log.Logger, c *client.Client, _ []string) error {
+		sessions, err := c.Admin.Sessions(cmdCtx)
+		if err != nil {
+			return err
+		}
+
+		w := csv.NewWriter(os.Stdout)
+		defer w.Flush()
+
+		w.Write([]string{ //nolint:errcheck // We're writing to stdout.
+			"id", "created", "last_seen", "ip", "user_agent", "current",
+		})
+		for _, s := range sessions {
+			w.Write([]string{ //nolint:errcheck // We're writing to stdout.
+				strconv.Itoa(s.ID), s.CreatedAt.Format(time.RFC3339), s.LastSeen.Format(time.RFC3339),
+				s.IP, s.UserAgent, strconv.FormatBool(s.Current),
+			})
+		}
+
+		return nil
+	}),
+}
+
+var sessionsRevokeCmd = &cobra.Command{
+	Use:   "revoke ID",
+	Short: "Log out one of your sessions",
+	Args:  cobra.ExactArgs(1),
+	Run: withLAndC(func(_ log.Logger, c *client.Client, args []string) error {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+
+		return c.Admin.RevokeSession(cmdCtx, id)
+	}),
+}
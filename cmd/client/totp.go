@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+var totpCmd = &cobra.Command{
+	Use:   "totp",
+	Short: "Manage two-factor authentication for your account",
+}
+
+func init() {
+	totpCmd.AddCommand(totpEnrollCmd, totpDisableCmd)
+}
+
+var totpEnrollCmd = &cobra.Command{
+	Use:   "enroll",
+	Short: "Enable two-factor authentication with an authenticator app",
+	Long: `Enable two-factor authentication with an authenticator app.
+
+The secret is printed along with an otpauth:// URI that can be turned into a QR code. After adding
+it to your authenticator app, enter a code from the app to finish enrolling. The recovery codes
+printed afterward can each be used once in place of a code, so store them somewhere safe.`,
+	Args: cobra.NoArgs,
+	Run: withLAndC(func(_ log.Logger, c *client.Client, _ []string) error {
+		ctx := cmdCtx
+
+		enrollment, err := c.Admin.EnrollTOTP(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("secret: %s\nuri: %s\n", enrollment.Secret, enrollment.URI)
+
+		code, err := promptPassword("Code from authenticator app: ")
+		if err != nil {
+			return err
+		}
+		codes, err := c.Admin.ConfirmTOTP(ctx, code)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Two-factor authentication enabled. Recovery codes:")
+		for _, rc := range codes {
+			fmt.Println(rc)
+		}
+
+		return nil
+	}),
+}
+
+var totpDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Disable two-factor authentication, prompting for a code or recovery code",
+	Args:  cobra.NoArgs,
+	Run: withLAndC(func(_ log.Logger, c *client.Client, _ []string) error {
+		code, err := promptPassword("Two-factor code: ")
+		if err != nil {
+			return err
+		}
+
+		return c.Admin.DisableTOTP(cmdCtx, code)
+	}),
+}
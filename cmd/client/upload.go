@@ -2,7 +2,6 @@ package main
 
 import (
 	"bufio"
-	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
@@ -12,7 +11,7 @@ import (
 	"strings"
 
 	"github.com/cobaltspeech/log"
-	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 	"github.com/kylrth/disco-bouncer/pkg/client"
 	"github.com/spf13/cobra"
 )
@@ -48,7 +47,7 @@ access to the Discord server.
 }
 
 func upload(l log.Logger, c *client.Client) error {
-	ch := make(chan *db.User)
+	ch := make(chan *api.User)
 
 	go func() {
 		err := getInput(ch)
@@ -73,7 +72,7 @@ func upload(l log.Logger, c *client.Client) error {
 			return fmt.Errorf("finish year '%s' does not start with 4 digits", u.FinishYear)
 		}
 
-		id, key, err := c.Users.Upload(context.Background(), u)
+		id, key, err := c.Users.Upload(cmdCtx, u)
 		if err != nil {
 			return fmt.Errorf("upload user: %w", err)
 		}
@@ -86,7 +85,7 @@ func upload(l log.Logger, c *client.Client) error {
 
 var finishYearMatcher = regexp.MustCompile(`^\d{4}`)
 
-func getInput(c chan<- *db.User) error {
+func getInput(c chan<- *api.User) error {
 	// check if info is on stdin
 	stat, _ := os.Stdin.Stat()
 	if (stat.Mode() & os.ModeCharDevice) == 0 {
@@ -99,7 +98,7 @@ func getInput(c chan<- *db.User) error {
 	return nil
 }
 
-func getInputFromStdin(c chan<- *db.User) error {
+func getInputFromStdin(c chan<- *api.User) error {
 	r := csv.NewReader(os.Stdin)
 	r.ReuseRecord = true
 	r.FieldsPerRecord = 6
@@ -130,12 +129,12 @@ func getInputFromStdin(c chan<- *db.User) error {
 	}
 }
 
-func promptUser(c chan<- *db.User) {
+func promptUser(c chan<- *api.User) {
 	scanner := bufio.NewScanner(os.Stdin)
 	defer close(c)
 
 	for {
-		var u db.User
+		var u api.User
 
 		fmt.Fprint(os.Stderr, "Name (leave empty to quit): ")
 		if scanner.Scan() {
@@ -193,8 +192,8 @@ func isUploadHeader(line []string) bool {
 		line[5] == "alumni_board"
 }
 
-func parseUser(line []string) (*db.User, error) {
-	var u db.User
+func parseUser(line []string) (*api.User, error) {
+	var u api.User
 	u.Name = line[0]
 	u.FinishYear = line[1]
 
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cobaltspeech/log"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the server configuration",
+}
+
+func init() {
+	configCmd.AddCommand(configCheckCmd)
+}
+
+var configCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the configuration and print the effective settings",
+	Long: `Check the configuration and print the effective settings as YAML, with secrets redacted.
+Settings come from flags, then environment variables, then the config file, then the defaults.`,
+	Args: cobra.NoArgs,
+	Run: withLogger(func(_ log.Logger, _ []string) error {
+		err := conf.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid config:\n%w", err)
+		}
+
+		e := yaml.NewEncoder(os.Stdout)
+		e.SetIndent(2)
+
+		err = e.Encode(conf.Redacted())
+		if err != nil {
+			return err
+		}
+
+		return e.Close()
+	}),
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Migrate, back up, and restore the database",
+	Long: `Migrate, back up, and restore the database. Unlike the other commands, these don't apply
+migrations before they run.`,
+}
+
+var (
+	dbMigrateTo int
+	dbYes       bool
+)
+
+func init() {
+	dbCmd.AddCommand(
+		dbStatusCmd,
+		dbMigrateCmd,
+		dbDownCmd,
+		dbDumpCmd,
+		dbRestoreCmd,
+	)
+
+	dbMigrateCmd.Flags().IntVar(&dbMigrateTo, "to", -1, "migration to go up or down to")
+	dbMigrateCmd.Flags().Lookup("to").DefValue = "newest"
+	dbDownCmd.Flags().BoolVarP(&dbYes, "yes", "y", false, "don't ask for confirmation")
+	dbRestoreCmd.Flags().BoolVarP(&dbYes, "yes", "y", false, "don't ask for confirmation")
+}
+
+var dbStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Print the migration the database is at",
+	Long: `Print the migration the database is at and the newest migration known to this version of
+the server. If a migration failed partway through, the database is marked dirty.`,
+	Args: cobra.NoArgs,
+	Run: withLAndBackend(func(_ log.Logger, b *storage.Backend, _ []string) error {
+		latest, err := b.LatestMigration()
+		if err != nil {
+			return err
+		}
+		version, dirty, err := b.MigrationVersion(context.Background())
+		if err != nil {
+			return fmt.Errorf("get migration version: %w", err)
+		}
+
+		fmt.Printf("migration %d of %d\n", version, latest)
+		switch {
+		case dirty:
+			fmt.Printf("dirty: migration %d failed partway through\n", version)
+		case version < latest:
+			fmt.Printf("%d migrations to apply\n", latest-version)
+		}
+
+		return nil
+	}),
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply migrations to the database",
+	Long: `Apply migrations to the database, up to the newest one or to --to. If --to is older than
+the migration the database is at, newer migrations are reverted, which may delete data.`,
+	Args: cobra.NoArgs,
+	Run: withLAndBackend(func(l log.Logger, b *storage.Backend, _ []string) error {
+		to := dbMigrateTo
+		if to < 0 {
+			var err error
+			to, err = b.LatestMigration()
+			if err != nil {
+				return err
+			}
+		}
+
+		err := storage.MigrateTo(conf.DatabaseURL, to)
+		if err != nil {
+			return err
+		}
+		l.Info("msg", "migrated database", "migration", to)
+
+		return nil
+	}),
+}
+
+var dbDownCmd = &cobra.Command{
+	Use:   "down N",
+	Short: "Revert the newest N migrations",
+	Long: `Revert the newest N migrations applied to the database. Reverting a migration may delete
+the data in the columns and tables it added, so take a dump first. The server applies all
+migrations when it starts, so stop it before reverting.`,
+	Args: cobra.ExactArgs(1),
+	Run: withLAndBackend(func(l log.Logger, b *storage.Backend, args []string) error {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid number of migrations %q", args[0])
+		}
+
+		version, _, err := b.MigrationVersion(context.Background())
+		if err != nil {
+			return fmt.Errorf("get migration version: %w", err)
+		}
+		err = confirm(fmt.Sprintf(
+			"Revert %d migrations from migration %d? Data may be deleted.", n, version))
+		if err != nil {
+			return err
+		}
+
+		err = storage.MigrateDown(conf.DatabaseURL, n)
+		if err != nil {
+			return err
+		}
+
+		version, _, err = b.MigrationVersion(context.Background())
+		if err != nil {
+			return fmt.Errorf("get migration version: %w", err)
+		}
+		l.Info("msg", "reverted migrations", "count", n, "migration", version)
+
+		return nil
+	}),
+}
+
+var dbDumpCmd = &cobra.Command{
+	Use:   "dump [FILE]",
+	Short: "Write a JSON snapshot of the database",
+	Long: `Write a JSON snapshot of the users, admins, and audit trail (including admissions) to FILE,
+or to stdout. Admins are included without their passwords, two-factor secrets, API tokens, or
+sessions. The snapshot is consistent even while the server is running.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: withLAndBackend(func(l log.Logger, b *storage.Backend, args []string) error {
+		s, err := b.Dump(context.Background())
+		if err != nil {
+			return err
+		}
+
+		w := os.Stdout
+		if len(args) == 1 && args[0] != "-" {
+			// An existing snapshot is never overwritten.
+			w, err = os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+			if err != nil {
+				return err
+			}
+			defer w.Close()
+		}
+
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		err = e.Encode(s)
+		if err == nil && w != os.Stdout {
+			err = w.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("write snapshot: %w", err)
+		}
+
+		l.Info("msg", "dumped database", "migration", s.Migration, "users", len(s.Users),
+			"admins", len(s.Admins), "auditEvents", len(s.AuditEvents))
+
+		return nil
+	}),
+}
+
+var dbRestoreCmd = &cobra.Command{
+	Use:   "restore FILE",
+	Short: "Restore a snapshot written by dump",
+	Long: `Restore a snapshot written by dump. The users are replaced with the users in the
+snapshot. Admins in the snapshot get back their role and whether they were disabled; admins that
+no longer exist are created without a password, so set one with "admin setpass". The audit trail
+is never rolled back: missing events are added back, and newer events are kept.
+
+The database must be the same kind (Postgres or SQLite) and at the same migration as the
+snapshot. Use "db migrate --to" first if it isn't.`,
+	Args: cobra.ExactArgs(1),
+	Run: withLAndBackend(func(l log.Logger, b *storage.Backend, args []string) error {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		var s db.Snapshot
+		err = json.NewDecoder(f).Decode(&s)
+		if err != nil {
+			return fmt.Errorf("read snapshot: %w", err)
+		}
+
+		err = confirm(fmt.Sprintf("Replace all current users with the %d users from the snapshot "+
+			"taken %s?", len(s.Users), s.CreatedAt.Format(time.RFC3339)))
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		sum, err := b.Restore(ctx, &s)
+		if err != nil {
+			return err
+		}
+		l.Info("msg", "restored database", "users", sum.Users,
+			"adminsCreated", sum.AdminsCreated, "adminsUpdated", sum.AdminsUpdated,
+			"auditEventsAdded", sum.AuditEvents, "auditEventsKept", sum.AuditUnchanged)
+
+		_, broken, err := b.Audit.VerifyChain(ctx)
+		if err != nil {
+			return fmt.Errorf("verify audit chain: %w", err)
+		}
+		if broken != nil {
+			l.Error("msg", "audit chain is broken after restoring", "at", broken)
+		}
+
+		return nil
+	}),
+}
+
+// confirm asks the question on stderr, and returns an error unless the answer is "yes" or --yes
+// was passed.
+func confirm(question string) error {
+	if dbYes {
+		return nil
+	}
+
+	fmt.Fprint(os.Stderr, question+" Type yes to continue: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if strings.TrimSpace(answer) != "yes" {
+		return errors.New("not confirmed")
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	healthcheckURL  string
+	healthcheckLive bool
+)
+
+func init() {
+	healthcheckCmd.Flags().StringVar(&healthcheckURL, "url", "",
+		"base URL of the server (default from the listen address)")
+	healthcheckCmd.Flags().BoolVar(&healthcheckLive, "live", false,
+		"only check that the server is running (/healthz) instead of ready (/readyz)")
+}
+
+var healthcheckCmd = &cobra.Command{
+	Use:   "healthcheck",
+	Short: "Check whether the server is ready, for a Docker HEALTHCHECK",
+	Long: `Check whether the server is ready, for a Docker HEALTHCHECK. The readiness report is
+printed, and the exit status is 1 if the server is not ready.`,
+	Args: cobra.NoArgs,
+	Run: withLogger(func(_ log.Logger, _ []string) error {
+		base := healthcheckURL
+		if base == "" {
+			host, port, err := net.SplitHostPort(conf.Listen)
+			if err != nil {
+				return fmt.Errorf("invalid listen address: %w", err)
+			}
+			if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+				host = "127.0.0.1"
+			}
+			base = "http://" + net.JoinHostPort(host, port)
+		}
+		p := "/readyz"
+		if healthcheckLive {
+			p = "/healthz"
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+p, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		_, err = io.Copy(os.Stdout, resp.Body)
+		if err != nil {
+			return err
+		}
+		fmt.Println()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s returned %s", p, resp.Status)
+		}
+
+		return nil
+	}),
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/storage"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+var oidcCmd = &cobra.Command{
+	Use:   "oidc",
+	Short: "Manage which single sign-on identities can log in",
+}
+
+func init() {
+	oidcCmd.AddCommand(
+		oidcAllowCmd,
+		oidcListCmd,
+		oidcRemoveCmd,
+	)
+}
+
+var (
+	oidcBySubject bool
+	oidcRole      string
+)
+
+func init() {
+	oidcAllowCmd.Flags().BoolVar(&oidcBySubject, "subject", false,
+		"match IDENTITY against the subject claim instead of the verified email address")
+	oidcAllowCmd.Flags().StringVar(&oidcRole, "role", string(api.RoleViewer),
+		"role to give the admin if it is created on first login. One of: "+roleNames())
+}
+
+var oidcAllowCmd = &cobra.Command{
+	Use:   "allow IDENTITY ADMIN",
+	Short: "Allow an identity to log in as an admin with single sign-on",
+	Long: `Allow an identity to log in as an admin with single sign-on. By default IDENTITY is an email
+address, which must be verified by the identity provider. If the admin does not exist, it is created
+with --role on the first login.`,
+	Args: cobra.ExactArgs(2),
+	Run: withLAndDB(func(l log.Logger, b *storage.Backend, args []string) error {
+		role, err := api.ParseRole(oidcRole)
+		if err != nil {
+			return err
+		}
+
+		kind := db.IdentityEmail
+		if oidcBySubject {
+			kind = db.IdentitySubject
+		}
+
+		id, err := b.Identities.AddIdentity(context.Background(), kind, args[0], args[1], role)
+		if err != nil {
+			return err
+		}
+
+		l.Info("msg", "allowed identity", "id", id, "kind", kind, "identity", args[0],
+			"admin", args[1])
+
+		return nil
+	}),
+}
+
+var oidcListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the identities allowed to log in with single sign-on",
+	Args:  cobra.NoArgs,
+	Run: withLAndDB(func(_ log.Logger, b *storage.Backend, _ []string) error {
+		identities, err := b.Identities.ListIdentities(context.Background())
+		if err != nil {
+			return err
+		}
+
+		w := csv.NewWriter(os.Stdout)
+		defer w.Flush()
+
+		w.Write([]string{ //nolint:errcheck // We're writing to stdout.
+			"id", "kind", "identity", "admin", "role", "created",
+		})
+		for _, i := range identities {
+			w.Write([]string{ //nolint:errcheck // We're writing to stdout.
+				strconv.Itoa(i.ID), i.Kind, i.Value, i.Username, string(i.Role),
+				i.CreatedAt.Format(time.RFC3339),
+			})
+		}
+
+		return nil
+	}),
+}
+
+var oidcRemoveCmd = &cobra.Command{
+	Use:   "remove ID",
+	Short: "Stop allowing an identity to log in with single sign-on",
+	Long: `Stop allowing an identity to log in with single sign-on. The admin is not deleted, and its
+existing sessions are not revoked.`,
+	Args: cobra.ExactArgs(1),
+	Run: withLAndDB(func(_ log.Logger, b *storage.Backend, args []string) error {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid ID: %w", err)
+		}
+
+		err = b.Identities.RemoveIdentity(context.Background(), id)
+		if errors.Is(err, db.ErrNoIdentity) {
+			return fmt.Errorf("no identity with ID %d", id)
+		}
+
+		return err
+	}),
+}
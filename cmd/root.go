@@ -5,8 +5,10 @@ import (
 	"os"
 
 	"github.com/cobaltspeech/log"
-	"github.com/cobaltspeech/log/pkg/level"
 	"github.com/spf13/cobra"
+
+	"github.com/kylrth/disco-bouncer/internal/config"
+	"github.com/kylrth/disco-bouncer/internal/logging"
 )
 
 func main() {
@@ -21,22 +23,40 @@ var rootCmd = &cobra.Command{
 	Short: "Avoid Panic! at the Disco(rd)",
 }
 
-var verbosity int
+// conf is loaded by withLogger before the command runs.
+var conf *config.Config
 
 func init() {
 	rootCmd.AddCommand(
 		serveCmd,
+		configCmd,
+		healthcheckCmd,
 		adminCmd,
+		tokenCmd,
+		oidcCmd,
+		auditCmd,
+		dbCmd,
 	)
 
-	rootCmd.PersistentFlags().IntVarP(&verbosity, "verbosity", "v", 2, "set verbosity (1-4)")
+	config.AddFlags(rootCmd.PersistentFlags())
 }
 
 func withLogger(f func(log.Logger, []string) error) func(*cobra.Command, []string) {
-	return func(_ *cobra.Command, args []string) {
-		l := log.NewLeveledLogger(log.WithFilterLevel(level.Verbosity(verbosity)))
+	return func(cmd *cobra.Command, args []string) {
+		err := func() error {
+			var err error
+			conf, err = config.Load(cmd.Flags(), os.LookupEnv)
+			if err != nil {
+				return err
+			}
+
+			l, err := logging.New(os.Stderr, conf.Log.Format, conf.Log.Verbosity)
+			if err != nil {
+				return err
+			}
 
-		err := f(l, args)
+			return f(l, args)
+		}()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
@@ -6,35 +6,63 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/cobaltspeech/log"
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/logger"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/spf13/cobra"
 
 	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+	"github.com/kylrth/disco-bouncer/internal/metrics"
 	"github.com/kylrth/disco-bouncer/internal/server"
+	"github.com/kylrth/disco-bouncer/internal/storage"
+	"github.com/kylrth/disco-bouncer/internal/supervisor"
+	"github.com/kylrth/disco-bouncer/internal/tracing"
 	"github.com/kylrth/disco-bouncer/pkg/bouncerbot"
 )
 
-func withLAndDB(f func(log.Logger, *pgxpool.Pool, []string) error) func(*cobra.Command, []string) {
+func withLAndDB(
+	f func(log.Logger, *storage.Backend, []string) error,
+) func(*cobra.Command, []string) {
 	return withLogger(func(l log.Logger, args []string) error {
-		pgURI := os.Getenv("DATABASE_URL")
-		err := db.ApplyMigrations(pgURI)
+		if conf.DatabaseURL == "" {
+			return errors.New("database URL is not set")
+		}
+
+		// The database may still be starting up, for example when it's started at the same time by
+		// docker-compose, so the server waits for it until it's stopped or the timeout passes.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		b, err := storage.Connect(ctx, l, conf.DatabaseURL, conf.Retry.Startup())
+		stop()
 		if err != nil {
-			return fmt.Errorf("apply database migrations: %w", err)
+			return err
+		}
+		defer b.Close()
+
+		return f(l, b, args)
+	})
+}
+
+// withLAndBackend is like withLAndDB, but doesn't apply migrations first or wait for the database.
+func withLAndBackend(
+	f func(log.Logger, *storage.Backend, []string) error,
+) func(*cobra.Command, []string) {
+	return withLogger(func(l log.Logger, args []string) error {
+		if conf.DatabaseURL == "" {
+			return errors.New("database URL is not set")
 		}
 
-		pool, err := pgxpool.New(context.Background(), os.Getenv("DATABASE_URL"))
+		b, err := storage.Open(context.Background(), l, conf.DatabaseURL)
 		if err != nil {
-			return fmt.Errorf("connect to database: %w", err)
+			return err
 		}
-		defer pool.Close()
+		defer b.Close()
 
-		return f(l, pool, args)
+		return f(l, b, args)
 	})
 }
 
@@ -42,60 +70,231 @@ var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Serve the disco-bouncer data manager API",
 	Args:  cobra.NoArgs,
-	Run: withLAndDB(func(l log.Logger, p *pgxpool.Pool, _ []string) error {
-		return serve(l, p)
+	Run: withLAndDB(func(l log.Logger, b *storage.Backend, _ []string) error {
+		return serve(l, b)
 	}),
 }
 
-func serve(l log.Logger, pool *pgxpool.Pool) error {
-	aTable := db.NewAdminTable(l, pool)
-	uTable := db.NewUserTable(l, pool)
+func serve(l log.Logger, b *storage.Backend) error {
+	err := conf.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
 
-	app := fiber.New()
-	app.Use(logger.New(logger.Config{Output: os.Stderr}))
-	server.AddAuthHandlers(l, app, pool, aTable)
-	server.AddCRUDHandlers(l, app, uTable)
+	shutdownTracing, err := tracing.Setup(context.Background(), conf.Tracing.Exporter, "bouncer")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// The last spans are flushed after everything else has stopped.
+		ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			l.Error("msg", "failed to flush trace spans", "error", err)
+		}
+	}()
+
+	aTable := b.Admins
+	uTable := b.Users
 
-	token := os.Getenv("DISCORD_TOKEN")
-	if token == "disable" {
+	app := fiber.New(fiber.Config{ErrorHandler: server.ErrorHandler(l)})
+	app.Use(logging.RequestIDMiddleware())
+	if conf.Tracing.Exporter != "none" {
+		app.Use(tracing.Middleware())
+	}
+	var m *metrics.Metrics
+	if conf.Metrics {
+		m = metrics.New()
+		app.Use(m.Middleware())
+		app.Get("/metrics", m.Handler())
+		m.WatchPendingUsers(l, uTable)
+	}
+	// Health checks and scrapes run every few seconds, so they would drown out everything else.
+	app.Use(logging.AccessLog(l, func(c *fiber.Ctx) bool {
+		return c.Path() == "/healthz" || c.Path() == "/readyz" || c.Path() == "/metrics"
+	}))
+	box, err := totpBox(l)
+	if err != nil {
+		return err
+	}
+	limits := server.DefaultLoginLimits()
+	limits.MaxFailures = conf.Login.MaxFailures
+	guard := server.NewLoginGuard(l, b.Logins, limits)
+	sessionLimits := server.SessionLimits{
+		IdleTimeout: conf.Sessions.IdleTimeout,
+		MaxAge:      conf.Sessions.MaxAge,
+	}
+	sessions := server.NewSessions(b.SessionStorage(), b.Sessions, sessionLimits)
+	server.AddAuthHandlers(l, app, aTable, b.Tokens, box, guard, sessions, b.Audit)
+	server.AddAdminHandlers(l, app, aTable)
+	server.AddAuditHandlers(l, app, b.Audit)
+	err = addOIDC(l, app, b.Identities, aTable, guard, sessions)
+	if err != nil {
+		return err
+	}
+	validator := &server.UserValidator{}
+	server.AddCRUDHandlers(l, app, uTable, validator)
+
+	sup := supervisor.New(l)
+	sup.ShutdownTimeout = conf.ShutdownTimeout
+	var botStatus server.BotStatuser // left nil if the bot is disabled
+	if conf.Bot.Enabled {
+		dec := bouncerbot.TableDecrypter{Table: uTable, Logger: l, Retry: conf.Retry.Queries()}
+		bot, err := bouncerbot.NewWithDecrypter(l, conf.Bot.Token, dec)
+		if err != nil {
+			return fmt.Errorf("set up Discord bot: %w", err)
+		}
+		bot.RecordAdmissions(b.Audit)
+		if m != nil {
+			bot.Observe(m)
+			m.WatchGuildInfo(bot)
+		}
+		err = addGuildInfo(l, bot)
+		if err != nil {
+			return fmt.Errorf("add guild info: %w", err)
+		}
+
+		// The bot is restarted if it can't connect, without stopping the API.
+		sup.Add(supervisor.Component{
+			Name:    "Discord bot",
+			Restart: true,
+			Run: func(ctx context.Context) error {
+				return bot.Run(ctx, func() time.Time { return supervisor.Deadline(ctx) })
+			},
+		})
+
+		server.AddDiscordHandlers(l, app, bot)
+		validator.Cohorts = bot
+		botStatus = bot
+		err = addDiscordLogin(l, app, b.Identities, aTable, bot, guard, sessions)
+		if err != nil {
+			return err
+		}
+	} else {
 		l.Info("msg", "running without Discord bot")
+	}
+	server.AddHealthHandlers(l, app, b, botStatus)
+	sup.Add(supervisor.Component{Name: "HTTP server", Run: serveHTTP(app)})
 
-		return app.Listen(":80")
+	// The HTTP server is stopped first, then the bot. The database is closed by withLAndDB after
+	// both have stopped.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return sup.Run(ctx)
+}
+
+// serveHTTP returns the Run function of the HTTP server, which waits for requests in progress to
+// finish when it is stopped, until the supervisor's shutdown deadline.
+func serveHTTP(app *fiber.App) func(context.Context) error {
+	return func(ctx context.Context) error {
+		errs := make(chan error, 1)
+		go func() {
+			errs <- app.Listen(conf.Listen)
+		}()
+
+		select {
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			sctx, cancel := context.WithDeadline(context.Background(), supervisor.Deadline(ctx))
+			defer cancel()
+
+			return app.ShutdownWithContext(sctx)
+		}
+	}
+}
+
+// totpBox returns the SecretBox for the configured TOTP key, or nil if it is not set.
+func totpBox(l log.Logger) (*server.SecretBox, error) {
+	if conf.TOTPKey == "" {
+		l.Info("msg", "TOTP key not set; two-factor authentication is disabled")
+
+		return nil, nil //nolint:nilnil // a nil box disables two-factor enrollment
 	}
 
-	bot, err := bouncerbot.New(l, token, uTable)
+	box, err := server.NewSecretBox(conf.TOTPKey)
 	if err != nil {
-		return fmt.Errorf("set up Discord bot: %w", err)
+		return nil, fmt.Errorf("read TOTP key: %w", err)
 	}
-	err = addGuildInfo(l, bot)
+
+	return box, nil
+}
+
+// errNoIdentities is returned if single sign-on is configured but the database can't store the
+// allowlist and login codes.
+var errNoIdentities = errors.New("the database does not support single sign-on")
+
+// addOIDC adds single sign-on if an OIDC issuer is configured.
+func addOIDC(
+	l log.Logger, app *fiber.App, identities db.OIDCStore, aTable db.AdminStore,
+	guard *server.LoginGuard, sessions *server.Sessions,
+) error {
+	if conf.OIDC.Issuer == "" {
+		l.Info("msg", "OIDC issuer not set; single sign-on is disabled")
+
+		return nil
+	}
+	if identities == nil {
+		return errNoIdentities
+	}
+
+	provider, err := server.NewOIDCProvider(context.Background(), server.OIDCConfig{
+		Issuer:       conf.OIDC.Issuer,
+		ClientID:     conf.OIDC.ClientID,
+		ClientSecret: conf.OIDC.ClientSecret,
+		RedirectURL:  conf.OIDC.RedirectURL,
+	})
 	if err != nil {
-		return fmt.Errorf("add guild info: %w", err)
+		return err
 	}
+	server.AddOIDCHandlers(l, app, provider, aTable, identities, guard, sessions)
+
+	return nil
+}
+
+// addDiscordLogin adds logging in with Discord if a Discord client ID is configured.
+func addDiscordLogin(
+	l log.Logger, app *fiber.App, codes db.OIDCStore, aTable db.AdminStore,
+	bot *bouncerbot.Bot, guard *server.LoginGuard, sessions *server.Sessions,
+) error {
+	if conf.DiscordLogin.ClientID == "" {
+		l.Info("msg", "Discord client ID not set; Discord login is disabled")
 
-	err = bot.Open()
+		return nil
+	}
+	if codes == nil {
+		return errNoIdentities
+	}
+
+	roles, err := conf.DiscordLogin.Roles()
 	if err != nil {
-		return fmt.Errorf("start Discord bot: %w", err)
+		return fmt.Errorf("read Discord admin roles: %w", err)
 	}
-	defer func() {
-		err = bot.Close()
-		if err != nil {
-			l.Error("msg", "error closing Discord connection", "error", err)
-		}
-	}()
-	l.Info("msg", "started bot; press Ctrl+C to exit")
 
-	server.AddDiscordHandlers(l, app, bot)
+	cfg := server.DiscordLoginConfig{
+		ClientID:     conf.DiscordLogin.ClientID,
+		ClientSecret: conf.DiscordLogin.ClientSecret,
+		RedirectURL:  conf.DiscordLogin.RedirectURL,
+		Roles:        roles,
+	}
+	server.AddDiscordLoginHandlers(l, app, server.NewDiscordLogin(cfg, bot), aTable,
+		codes, guard, sessions)
 
-	return app.Listen(":80")
+	return nil
 }
 
-const guildInfoFile = "/data/guildinfo.json"
-
 func addGuildInfo(l log.Logger, bot *bouncerbot.Bot) error {
 	// We'll give the bot a callback that saves the guild info to disk whenever it changes.
 	bot.AddGuildInfoCallback(saveGuildInfo(l))
 
-	b, err := os.ReadFile(guildInfoFile)
+	if conf.Bot.GuildID != "" {
+		bot.GetGuildInfo(conf.Bot.GuildID)
+
+		return nil
+	}
+
+	b, err := os.ReadFile(conf.GuildInfoFile())
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			l.Info("msg", "guild info file does not exist; listening for guild messages as well")
@@ -133,7 +332,7 @@ func saveGuildInfo(l log.Logger) func(*bouncerbot.GuildInfo) {
 			return
 		}
 
-		err = os.WriteFile(guildInfoFile, b, 0o600)
+		err = os.WriteFile(conf.GuildInfoFile(), b, 0o600)
 		if err != nil {
 			l.Error("msg", "failed to write guild info file", "error", err)
 		}
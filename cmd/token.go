@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/storage"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"github.com/spf13/cobra"
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage API tokens for non-interactive access",
+}
+
+func init() {
+	tokenCmd.AddCommand(
+		tokenCreateCmd,
+		tokenListCmd,
+		tokenRevokeCmd,
+	)
+}
+
+var (
+	tokenScopes  []string
+	tokenExpires time.Duration
+)
+
+func init() {
+	scopes := make([]string, 0, len(api.AllScopes()))
+	for _, s := range api.AllScopes() {
+		scopes = append(scopes, string(s))
+	}
+
+	tokenCreateCmd.Flags().StringSliceVar(&tokenScopes, "scope", nil,
+		"scope to grant the token (can be repeated). One of: "+strings.Join(scopes, ", "))
+	tokenCreateCmd.Flags().DurationVar(&tokenExpires, "expires", 0,
+		"how long until the token expires. If 0, the token never expires")
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create ADMIN NAME",
+	Short: "Create an API token for an admin and print it",
+	Long: `Create an API token for an admin and print it. The token is only shown once, so store it
+somewhere safe. Use it with the client by setting BOUNCER_TOKEN.`,
+	Args: cobra.ExactArgs(2),
+	Run: withLAndDB(func(_ log.Logger, b *storage.Backend, args []string) error {
+		if len(tokenScopes) == 0 {
+			return errors.New("at least one --scope is required")
+		}
+		_, err := api.ParseScopes(tokenScopes)
+		if err != nil {
+			return err
+		}
+
+		var expires *time.Time
+		if tokenExpires > 0 {
+			t := time.Now().Add(tokenExpires)
+			expires = &t
+		}
+
+		token, err := b.Tokens.CreateToken(
+			context.Background(), args[0], args[1], tokenScopes, expires)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(token)
+
+		return nil
+	}),
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list [ADMIN]",
+	Short: "List API tokens, possibly only for one admin",
+	Args:  cobra.MaximumNArgs(1),
+	Run: withLAndDB(func(_ log.Logger, b *storage.Backend, args []string) error {
+		var admin string
+		if len(args) > 0 {
+			admin = args[0]
+		}
+
+		tokens, err := b.Tokens.ListTokens(context.Background(), admin)
+		if err != nil {
+			return err
+		}
+
+		w := csv.NewWriter(os.Stdout)
+		defer w.Flush()
+
+		w.Write([]string{ //nolint:errcheck // We're writing to stdout.
+			"id", "admin", "name", "scopes", "created", "expires", "last_used",
+		})
+		for _, t := range tokens {
+			w.Write([]string{ //nolint:errcheck // We're writing to stdout.
+				strconv.Itoa(t.ID), t.Admin, t.Name, strings.Join(t.Scopes, " "),
+				t.CreatedAt.Format(time.RFC3339), formatTime(t.ExpiresAt),
+				formatTime(t.LastUsedAt),
+			})
+		}
+
+		return nil
+	}),
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke ID",
+	Short: "Revoke an API token by ID",
+	Args:  cobra.ExactArgs(1),
+	Run: withLAndDB(func(_ log.Logger, b *storage.Backend, args []string) error {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid ID: %w", err)
+		}
+
+		return b.Tokens.RevokeToken(context.Background(), id)
+	}),
+}
@@ -0,0 +1,264 @@
+// Package config defines the configuration of the server. It is loaded from a YAML file,
+// environment variables, and command line flags, in increasing order of precedence.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/server"
+	"github.com/kylrth/disco-bouncer/internal/storage"
+	"github.com/kylrth/disco-bouncer/internal/tracing"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// Config is the configuration of the server.
+type Config struct {
+	// Listen is the address the API listens on.
+	Listen string `yaml:"listen"`
+
+	// ShutdownTimeout is how long the server waits for requests and admissions in progress when it
+	// is stopped. The HTTP server and the bot share it.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// Metrics turns on the Prometheus metrics at /metrics.
+	Metrics bool `yaml:"metrics"`
+
+	// DataDir is where the server keeps files between restarts, like the guild info.
+	DataDir string `yaml:"data_dir"`
+
+	DatabaseURL string `yaml:"database_url"`
+
+	Retry    Retry    `yaml:"retry"`
+	Log      Log      `yaml:"log"`
+	Tracing  Tracing  `yaml:"tracing"`
+	Bot      Bot      `yaml:"bot"`
+	Sessions Sessions `yaml:"sessions"`
+	Login    Login    `yaml:"login"`
+
+	// TOTPKey is the hex-encoded 32-byte key used to encrypt TOTP secrets. If it is empty, admins
+	// can't enroll in two-factor authentication.
+	TOTPKey string `yaml:"totp_key"`
+
+	OIDC         OIDC         `yaml:"oidc"`
+	DiscordLogin DiscordLogin `yaml:"discord_login"`
+}
+
+// Retry configures how database operations that fail with transient errors are retried, for
+// example while the database is still starting up.
+type Retry struct {
+	// InitialInterval is the wait before the first retry. The wait grows after each attempt, up to
+	// MaxInterval.
+	InitialInterval time.Duration `yaml:"initial_interval"`
+	MaxInterval     time.Duration `yaml:"max_interval"`
+
+	// StartupTimeout is how long to wait for the database when the server starts. 0 turns off
+	// retries at startup.
+	StartupTimeout time.Duration `yaml:"startup_timeout"`
+
+	// QueryTimeout is how long to retry the queries made while admitting a user. 0 turns off
+	// retries of queries.
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+}
+
+// Startup returns the policy for connecting to the database at startup.
+func (r *Retry) Startup() db.RetryPolicy {
+	return db.RetryPolicy{
+		InitialInterval: r.InitialInterval,
+		MaxInterval:     r.MaxInterval,
+		Timeout:         r.StartupTimeout,
+	}
+}
+
+// Queries returns the policy for queries made while admitting a user.
+func (r *Retry) Queries() db.RetryPolicy {
+	return db.RetryPolicy{
+		InitialInterval: r.InitialInterval,
+		MaxInterval:     r.MaxInterval,
+		Timeout:         r.QueryTimeout,
+	}
+}
+
+// Log configures the server logs.
+type Log struct {
+	// Format is "text" or "json".
+	Format string `yaml:"format"`
+
+	// Verbosity is between 1 (errors only) and 4 (everything).
+	Verbosity int `yaml:"verbosity"`
+}
+
+// Tracing configures where OpenTelemetry spans are sent.
+type Tracing struct {
+	// Exporter is "none", "stdout", or "otlp". The OTLP exporter is configured with the standard
+	// OTEL_EXPORTER_OTLP_* environment variables.
+	Exporter string `yaml:"exporter"`
+}
+
+// Bot configures the Discord bot.
+type Bot struct {
+	Enabled bool   `yaml:"enabled"`
+	Token   string `yaml:"token"`
+
+	// GuildID is the guild served by the bot. If it is empty, the guild is read from the guild info
+	// saved in DataDir, or discovered from the first guild message the bot sees.
+	GuildID string `yaml:"guild_id"`
+}
+
+// Sessions configures when admin sessions end.
+type Sessions struct {
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	MaxAge      time.Duration `yaml:"max_age"`
+}
+
+// Login configures the protection against guessing passwords.
+type Login struct {
+	// MaxFailures is the number of failed logins in a row before an admin is locked out. 0 turns
+	// off lockouts.
+	MaxFailures int `yaml:"max_failures"`
+}
+
+// OIDC configures single sign-on with an OpenID Connect identity provider. It is off if Issuer is
+// empty.
+type OIDC struct {
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	RedirectURL  string `yaml:"redirect_url"`
+}
+
+// DiscordLogin configures logging in with a Discord account. It is off if ClientID is empty.
+type DiscordLogin struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	RedirectURL  string `yaml:"redirect_url"`
+
+	// AdminRoles maps guild roles to admin roles, like "moderator=uploader,staff=owner".
+	AdminRoles string `yaml:"admin_roles"`
+}
+
+// Default returns the configuration used for settings that aren't set anywhere else.
+func Default() *Config {
+	sessions := server.DefaultSessionLimits()
+
+	return &Config{
+		Listen:          ":80",
+		ShutdownTimeout: 10 * time.Second,
+		Metrics:         true,
+		DataDir:         "/data",
+		Retry: Retry{
+			InitialInterval: 500 * time.Millisecond,
+			MaxInterval:     10 * time.Second,
+			StartupTimeout:  2 * time.Minute,
+			QueryTimeout:    5 * time.Second,
+		},
+		Log: Log{
+			Format:    "text",
+			Verbosity: 2,
+		},
+		Tracing: Tracing{Exporter: "none"},
+		Bot:     Bot{Enabled: true},
+		Sessions: Sessions{
+			IdleTimeout: sessions.IdleTimeout,
+			MaxAge:      sessions.MaxAge,
+		},
+		Login: Login{MaxFailures: server.DefaultLoginLimits().MaxFailures},
+	}
+}
+
+// GuildInfoFile is where the guild info is saved.
+func (c *Config) GuildInfoFile() string {
+	return filepath.Join(c.DataDir, "guildinfo.json")
+}
+
+// Validate returns every problem with the configuration.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Listen != "", "listen is required")
+	check(c.ShutdownTimeout > 0, "shutdown_timeout must be positive")
+	check(c.DataDir != "", "data_dir is required")
+	check(c.DatabaseURL != "", "database_url is required")
+	check(c.Retry.InitialInterval > 0 && c.Retry.InitialInterval <= c.Retry.MaxInterval,
+		"retry.initial_interval must be positive and at most retry.max_interval")
+	check(c.Retry.StartupTimeout >= 0, "retry.startup_timeout must not be negative")
+	check(c.Retry.QueryTimeout >= 0, "retry.query_timeout must not be negative")
+	check(c.Log.Format == "text" || c.Log.Format == "json",
+		"log.format must be text or json, not %q", c.Log.Format)
+	check(c.Log.Verbosity >= 1 && c.Log.Verbosity <= 4,
+		"log.verbosity must be between 1 and 4, not %d", c.Log.Verbosity)
+	check(slices.Contains(tracing.Exporters, c.Tracing.Exporter),
+		"tracing.exporter must be one of %s, not %q",
+		strings.Join(tracing.Exporters, ", "), c.Tracing.Exporter)
+	check(!c.Bot.Enabled || c.Bot.Token != "", "bot.token is required when the bot is enabled")
+	check(c.Sessions.IdleTimeout > 0, "sessions.idle_timeout must be positive")
+	check(c.Sessions.MaxAge > 0, "sessions.max_age must be positive")
+	check(c.Login.MaxFailures >= 0, "login.max_failures must not be negative")
+
+	if c.TOTPKey != "" {
+		_, err := server.NewSecretBox(c.TOTPKey)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("totp_key: %w", err))
+		}
+	}
+
+	if c.DatabaseURL != "" {
+		_, err := storage.Kind(c.DatabaseURL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("database_url: %w", err))
+		}
+	}
+
+	if c.OIDC.Issuer != "" {
+		check(c.OIDC.ClientID != "" && c.OIDC.RedirectURL != "",
+			"oidc.client_id and oidc.redirect_url are required with oidc.issuer")
+	}
+	if c.DiscordLogin.ClientID != "" {
+		check(c.Bot.Enabled, "discord_login needs the bot to be enabled")
+		check(c.DiscordLogin.ClientSecret != "" && c.DiscordLogin.RedirectURL != "",
+			"discord_login.client_secret and discord_login.redirect_url are required with "+
+				"discord_login.client_id")
+
+		_, err := c.DiscordLogin.Roles()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("discord_login.admin_roles: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Roles parses AdminRoles.
+func (d *DiscordLogin) Roles() (map[string]api.Role, error) {
+	out := make(map[string]api.Role)
+	for pair := range strings.SplitSeq(d.AdminRoles, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		i := strings.LastIndex(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("expected GUILD_ROLE=ADMIN_ROLE, got %q", pair)
+		}
+		role, err := api.ParseRole(strings.TrimSpace(pair[i+1:]))
+		if err != nil {
+			return nil, err
+		}
+		out[strings.TrimSpace(pair[:i])] = role
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no roles set")
+	}
+
+	return out, nil
+}
@@ -0,0 +1,206 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/kylrth/disco-bouncer/internal/config"
+)
+
+func writeFile(t *testing.T, name, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(contents), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+
+	return path
+}
+
+func load(t *testing.T, env map[string]string, args ...string) (*config.Config, error) {
+	t.Helper()
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	config.AddFlags(fs)
+	err := fs.Parse(args)
+	if err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+
+	return config.Load(fs, func(name string) (string, bool) {
+		v, ok := env[name]
+
+		return v, ok
+	})
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	t.Parallel()
+
+	path := writeFile(t, "bouncer.yaml", `
+listen: ":8000"
+data_dir: /var/lib/bouncer
+database_url: postgres://file
+log:
+  verbosity: 3
+sessions:
+  idle_timeout: 1h
+`)
+	env := map[string]string{
+		config.FileEnv:     path,
+		"DATABASE_URL":     "postgres://env",
+		"BOUNCER_LISTEN":   ":9000",
+		"DISCORD_TOKEN":    "token",
+		"BOUNCER_DATA_DIR": "/env",
+
+		"BOUNCER_RETRY_STARTUP_TIMEOUT": "30s",
+	}
+
+	c, err := load(t, env, "--listen", ":9999", "-v", "4")
+	if err != nil {
+		t.Fatalf("error from Load: %v", err)
+	}
+
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"listen from flag", c.Listen, ":9999"},
+		{"verbosity from flag", c.Log.Verbosity, 4},
+		{"data_dir from env", c.DataDir, "/env"},
+		{"database_url from env", c.DatabaseURL, "postgres://env"},
+		{"idle_timeout from file", c.Sessions.IdleTimeout, time.Hour},
+		{"max_age from default", c.Sessions.MaxAge, config.Default().Sessions.MaxAge},
+		{"startup_timeout from env", c.Retry.Startup().Timeout, 30 * time.Second},
+		{"log format from default", c.Log.Format, "text"},
+	}
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("%s: expected %v, got %v", check.name, check.want, check.got)
+		}
+	}
+	if c.GuildInfoFile() != "/env/guildinfo.json" {
+		t.Errorf("unexpected guild info file %q", c.GuildInfoFile())
+	}
+	if err = c.Validate(); err != nil {
+		t.Errorf("unexpected error from Validate: %v", err)
+	}
+}
+
+func TestLoad_File(t *testing.T) {
+	t.Parallel()
+
+	secret := writeFile(t, "token", "from-file\n")
+
+	c, err := load(t, map[string]string{"DISCORD_TOKEN_FILE": secret})
+	if err != nil {
+		t.Fatalf("error from Load: %v", err)
+	}
+	if c.Bot.Token != "from-file" {
+		t.Errorf("expected token from file, got %q", c.Bot.Token)
+	}
+	if c.Redacted().Bot.Token != "REDACTED" || c.Bot.Token != "from-file" {
+		t.Error("Redacted did not copy the config and redact the token")
+	}
+
+	_, err = load(t, map[string]string{"DISCORD_TOKEN": "x", "DISCORD_TOKEN_FILE": secret})
+	if err == nil {
+		t.Error("expected error when both DISCORD_TOKEN and DISCORD_TOKEN_FILE are set")
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		file string
+		env  map[string]string
+	}{
+		"unknown key":   {file: "lsiten: :80\n"},
+		"bad duration":  {env: map[string]string{"BOUNCER_SESSION_MAX_AGE": "soon"}},
+		"bad bool":      {env: map[string]string{"BOUNCER_BOT_ENABLED": "sometimes"}},
+		"missing _FILE": {env: map[string]string{"DATABASE_URL_FILE": "/does/not/exist"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			env := tc.env
+			if tc.file != "" {
+				env = map[string]string{config.FileEnv: writeFile(t, "bouncer.yaml", tc.file)}
+			}
+
+			_, err := load(t, env)
+			if err == nil {
+				t.Error("expected error from Load")
+			}
+		})
+	}
+}
+
+func TestLoad_DisableBot(t *testing.T) {
+	t.Parallel()
+
+	c, err := load(t, map[string]string{"DISCORD_TOKEN": "disable", "DATABASE_URL": "postgres://"})
+	if err != nil {
+		t.Fatalf("error from Load: %v", err)
+	}
+	if c.Bot.Enabled || c.Bot.Token != "" {
+		t.Errorf("expected the bot to be disabled, got %+v", c.Bot)
+	}
+	if err = c.Validate(); err != nil {
+		t.Errorf("unexpected error from Validate: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	c := config.Default()
+	c.Log.Format = "xml"
+	c.TOTPKey = "abc"
+	c.DiscordLogin.ClientID = "123"
+	c.Retry.InitialInterval = time.Minute
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("expected error from Validate")
+	}
+	for _, want := range []string{
+		"database_url", "retry.initial_interval", "log.format", "bot.token", "totp_key",
+		"discord_login.client_secret", "discord_login.admin_roles",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %s: %v", want, err)
+		}
+	}
+}
+
+func TestValidate_SQLite(t *testing.T) {
+	t.Parallel()
+
+	c := config.Default()
+	c.Bot.Token = "token"
+	c.DatabaseURL = "sqlite://bouncer.db"
+	if err := c.Validate(); err != nil {
+		t.Errorf("unexpected error from Validate: %v", err)
+	}
+
+	c.OIDC = config.OIDC{Issuer: "https://issuer", ClientID: "id", RedirectURL: "https://cb"}
+	if err := c.Validate(); err != nil {
+		t.Errorf("unexpected error from Validate with OIDC: %v", err)
+	}
+
+	c.DatabaseURL = "mysql://bouncer"
+	err := c.Validate()
+	if err == nil || !strings.Contains(err.Error(), "unknown database URL scheme") {
+		t.Errorf("unexpected error from Validate: %v", err)
+	}
+}
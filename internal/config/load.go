@@ -0,0 +1,308 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// setting describes how one field of the Config can be set from the environment or a flag. Every
+// environment variable NAME can also be read from the file named by NAME_FILE, for use with Docker
+// secrets.
+type setting struct {
+	key   string // the YAML key, for errors
+	env   string
+	flag  string // empty if the setting has no flag
+	short string
+	usage string
+
+	// secret settings are redacted by Redacted.
+	secret bool
+
+	// field returns a pointer to the field in c, which is a *string, *int, *bool, or
+	// *time.Duration.
+	field func(c *Config) any
+}
+
+var settings = []setting{
+	{
+		key: "listen", env: "BOUNCER_LISTEN", flag: "listen",
+		usage: "address for the API to listen on",
+		field: func(c *Config) any { return &c.Listen },
+	},
+	{
+		key: "shutdown_timeout", env: "BOUNCER_SHUTDOWN_TIMEOUT",
+		field: func(c *Config) any { return &c.ShutdownTimeout },
+	},
+	{
+		key: "metrics", env: "BOUNCER_METRICS", flag: "metrics",
+		usage: "serve Prometheus metrics at /metrics",
+		field: func(c *Config) any { return &c.Metrics },
+	},
+	{
+		key: "data_dir", env: "BOUNCER_DATA_DIR", flag: "data-dir",
+		usage: "directory for files kept between restarts",
+		field: func(c *Config) any { return &c.DataDir },
+	},
+	{
+		key: "database_url", env: "DATABASE_URL", flag: "database-url", secret: true,
+		usage: "postgres:// or sqlite:// database URL",
+		field: func(c *Config) any { return &c.DatabaseURL },
+	},
+	{
+		key: "retry.initial_interval", env: "BOUNCER_RETRY_INITIAL_INTERVAL",
+		field: func(c *Config) any { return &c.Retry.InitialInterval },
+	},
+	{
+		key: "retry.max_interval", env: "BOUNCER_RETRY_MAX_INTERVAL",
+		field: func(c *Config) any { return &c.Retry.MaxInterval },
+	},
+	{
+		key: "retry.startup_timeout", env: "BOUNCER_RETRY_STARTUP_TIMEOUT",
+		field: func(c *Config) any { return &c.Retry.StartupTimeout },
+	},
+	{
+		key: "retry.query_timeout", env: "BOUNCER_RETRY_QUERY_TIMEOUT",
+		field: func(c *Config) any { return &c.Retry.QueryTimeout },
+	},
+	{
+		key: "log.format", env: "BOUNCER_LOG_FORMAT", flag: "log-format",
+		usage: "log format (text or json)",
+		field: func(c *Config) any { return &c.Log.Format },
+	},
+	{
+		key: "log.verbosity", env: "BOUNCER_LOG_VERBOSITY", flag: "verbosity", short: "v",
+		usage: "set verbosity (1-4)",
+		field: func(c *Config) any { return &c.Log.Verbosity },
+	},
+	{
+		key: "tracing.exporter", env: "BOUNCER_TRACING_EXPORTER", flag: "trace-exporter",
+		usage: "where to send trace spans (none, stdout, or otlp)",
+		field: func(c *Config) any { return &c.Tracing.Exporter },
+	},
+	{
+		key: "bot.enabled", env: "BOUNCER_BOT_ENABLED", flag: "bot",
+		usage: "run the Discord bot",
+		field: func(c *Config) any { return &c.Bot.Enabled },
+	},
+	{
+		key: "bot.token", env: "DISCORD_TOKEN", secret: true,
+		field: func(c *Config) any { return &c.Bot.Token },
+	},
+	{
+		key: "bot.guild_id", env: "BOUNCER_GUILD_ID", flag: "guild-id",
+		usage: "ID of the guild served by the bot",
+		field: func(c *Config) any { return &c.Bot.GuildID },
+	},
+	{
+		key: "sessions.idle_timeout", env: "BOUNCER_SESSION_IDLE_TIMEOUT",
+		field: func(c *Config) any { return &c.Sessions.IdleTimeout },
+	},
+	{
+		key: "sessions.max_age", env: "BOUNCER_SESSION_MAX_AGE",
+		field: func(c *Config) any { return &c.Sessions.MaxAge },
+	},
+	{
+		key: "login.max_failures", env: "BOUNCER_LOGIN_MAX_FAILURES",
+		field: func(c *Config) any { return &c.Login.MaxFailures },
+	},
+	{
+		key: "totp_key", env: "BOUNCER_TOTP_KEY", secret: true,
+		field: func(c *Config) any { return &c.TOTPKey },
+	},
+	{
+		key: "oidc.issuer", env: "BOUNCER_OIDC_ISSUER",
+		field: func(c *Config) any { return &c.OIDC.Issuer },
+	},
+	{
+		key: "oidc.client_id", env: "BOUNCER_OIDC_CLIENT_ID",
+		field: func(c *Config) any { return &c.OIDC.ClientID },
+	},
+	{
+		key: "oidc.client_secret", env: "BOUNCER_OIDC_CLIENT_SECRET", secret: true,
+		field: func(c *Config) any { return &c.OIDC.ClientSecret },
+	},
+	{
+		key: "oidc.redirect_url", env: "BOUNCER_OIDC_REDIRECT_URL",
+		field: func(c *Config) any { return &c.OIDC.RedirectURL },
+	},
+	{
+		key: "discord_login.client_id", env: "BOUNCER_DISCORD_CLIENT_ID",
+		field: func(c *Config) any { return &c.DiscordLogin.ClientID },
+	},
+	{
+		key: "discord_login.client_secret", env: "BOUNCER_DISCORD_CLIENT_SECRET", secret: true,
+		field: func(c *Config) any { return &c.DiscordLogin.ClientSecret },
+	},
+	{
+		key: "discord_login.redirect_url", env: "BOUNCER_DISCORD_REDIRECT_URL",
+		field: func(c *Config) any { return &c.DiscordLogin.RedirectURL },
+	},
+	{
+		key: "discord_login.admin_roles", env: "BOUNCER_DISCORD_ADMIN_ROLES",
+		field: func(c *Config) any { return &c.DiscordLogin.AdminRoles },
+	},
+}
+
+// FileEnv is the environment variable naming the config file, if the --config flag is not set.
+const FileEnv = "BOUNCER_CONFIG"
+
+// AddFlags adds the --config flag and the flags for the settings that have them. Flags that were
+// already added, for example as persistent flags of a parent command, are skipped.
+func AddFlags(fs *pflag.FlagSet) {
+	defaults := Default()
+
+	if fs.Lookup("config") == nil {
+		fs.String("config", "", "YAML config file (default from $"+FileEnv+")")
+	}
+	for _, s := range settings {
+		if s.flag == "" || fs.Lookup(s.flag) != nil {
+			continue
+		}
+
+		usage := s.usage + " ($" + s.env + ")"
+		switch p := s.field(defaults).(type) {
+		case *string:
+			fs.StringP(s.flag, s.short, *p, usage)
+		case *int:
+			fs.IntP(s.flag, s.short, *p, usage)
+		case *bool:
+			fs.BoolP(s.flag, s.short, *p, usage)
+		case *time.Duration:
+			fs.DurationP(s.flag, s.short, *p, usage)
+		}
+	}
+}
+
+// Load reads the configuration. Settings are taken from the first of these that sets them: flags
+// in fs that were changed from their defaults, environment variables, the config file, and
+// Default. The config file is named by the --config flag or $BOUNCER_CONFIG. lookupEnv is usually
+// os.LookupEnv.
+//
+// Load does not validate the configuration; see Validate.
+func Load(fs *pflag.FlagSet, lookupEnv func(string) (string, bool)) (*Config, error) {
+	out := Default()
+
+	path, _ := lookupEnv(FileEnv)
+	if f := fs.Lookup("config"); f != nil && f.Changed {
+		path = f.Value.String()
+	}
+	if path != "" {
+		err := readFile(path, out)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		v, ok, err := getEnv(lookupEnv, s.env)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			err = set(s.field(out), v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+
+		if s.flag == "" {
+			continue
+		}
+		if f := fs.Lookup(s.flag); f != nil && f.Changed {
+			err = set(s.field(out), f.Value.String())
+			if err != nil {
+				return nil, fmt.Errorf("invalid --%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	// DISCORD_TOKEN=disable used to be the only way to turn off the bot.
+	if out.Bot.Token == "disable" {
+		out.Bot.Enabled = false
+		out.Bot.Token = ""
+	}
+
+	return out, nil
+}
+
+func readFile(path string, c *Config) error {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+	default:
+		return fmt.Errorf("config file %s must be YAML (.yaml or .yml)", path)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	d.KnownFields(true)
+	err = d.Decode(c)
+	if err != nil && !errors.Is(err, io.EOF) { // An empty file sets nothing.
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// getEnv returns the value of the environment variable, or the contents of the file named by
+// name+"_FILE" without the trailing newline. Setting both is an error.
+func getEnv(lookupEnv func(string) (string, bool), name string) (string, bool, error) {
+	v, ok := lookupEnv(name)
+	path, fileOK := lookupEnv(name + "_FILE")
+	if !fileOK {
+		return v, ok, nil
+	}
+	if ok {
+		return "", false, fmt.Errorf("only one of %s and %s_FILE can be set", name, name)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("read %s_FILE: %w", name, err)
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), true, nil
+}
+
+func set(field any, v string) error {
+	var err error
+	switch p := field.(type) {
+	case *string:
+		*p = v
+	case *int:
+		*p, err = strconv.Atoi(v)
+	case *bool:
+		*p, err = strconv.ParseBool(v)
+	case *time.Duration:
+		*p, err = time.ParseDuration(v)
+	default:
+		err = fmt.Errorf("unsupported setting type %T", field)
+	}
+
+	return err
+}
+
+// Redacted returns a copy of the configuration with the values of secret settings replaced.
+func (c *Config) Redacted() *Config {
+	out := *c
+	for _, s := range settings {
+		if p, ok := s.field(&out).(*string); ok && s.secret && *p != "" {
+			*p = "REDACTED"
+		}
+	}
+
+	return &out
+}
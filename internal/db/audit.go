@@ -0,0 +1,381 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/jackc/pgx/v5"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// AuditTable records the changes made through the API and the admissions made by the bot. Each
+// event is chained to the previous one by a SHA-256 hash, so that changing or removing an event
+// can be detected with VerifyChain.
+type AuditTable struct {
+	logger log.Logger
+	pool   PgxIface
+}
+
+// NewAuditTable creates a new AuditTable backed by a Postgres connection pool.
+func NewAuditTable(l log.Logger, pool PgxIface) *AuditTable {
+	out := AuditTable{
+		logger: l,
+		pool:   pool,
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (t *AuditTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(t.logger, ctx)
+}
+
+// AuditEvent is a change made through the API. See api.AuditEvent.
+type AuditEvent struct {
+	ID        int
+	CreatedAt time.Time
+	Actor     string
+	Token     string
+	Action    string
+	Target    string
+	Changes   map[string]api.FieldChange
+	RequestID string
+
+	// PrevHash is the hash of the previous event in the chain, or empty for the first event. Both
+	// are nil for events recorded before the chain was added.
+	PrevHash []byte
+	Hash     []byte
+}
+
+// API returns the event as sent by the API.
+func (e *AuditEvent) API() *api.AuditEvent {
+	return &api.AuditEvent{
+		ID:        e.ID,
+		Time:      e.CreatedAt,
+		Actor:     e.Actor,
+		Token:     e.Token,
+		Action:    e.Action,
+		Target:    e.Target,
+		Changes:   e.Changes,
+		RequestID: e.RequestID,
+		PrevHash:  hex.EncodeToString(e.PrevHash),
+		Hash:      hex.EncodeToString(e.Hash),
+	}
+}
+
+// Record adds the event to the end of the chain. The ID is set by the database, and the time and
+// hashes are set on e.
+func (t *AuditTable) Record(ctx context.Context, e *AuditEvent) error {
+	err := t.record(ctx, e)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to record audit event", "actor", e.Actor,
+			"action", e.Action, "target", e.Target, "error", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// auditChainLock is the key of the advisory lock held while adding to the chain.
+const auditChainLock = 0x6175646974 // "audit" in ASCII
+
+func (t *AuditTable) record(ctx context.Context, e *AuditEvent) error {
+	var changes []byte
+	if len(e.Changes) > 0 {
+		var err error
+		changes, err = json.Marshal(e.Changes)
+		if err != nil {
+			return fmt.Errorf("encode changes: %w", err)
+		}
+	}
+	// The database stores microseconds, and the hash must match what is read back.
+	e.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+
+	tx, err := t.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck // This does nothing after Commit.
+
+	// Concurrent events must not be chained to the same head.
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", auditChainLock)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(ctx, "SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1").
+		Scan(&e.PrevHash)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if e.PrevHash == nil {
+		// This is the first chained event.
+		e.PrevHash = []byte{}
+	}
+	e.Hash, err = ChainHash(e.PrevHash, e, changes)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		"INSERT INTO audit_events "+
+			"(created_at, actor, token, action, target, changes, request_id, prev_hash, hash) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		e.CreatedAt, e.Actor, e.Token, e.Action, e.Target, changes, e.RequestID, e.PrevHash, e.Hash,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ChainHash returns the SHA-256 hash of the previous hash followed by the canonical JSON encoding
+// of the event, not including its ID. changes is the JSON encoding of e.Changes, or empty if there
+// are none. Every backend chains events with it.
+func ChainHash(prev []byte, e *AuditEvent, changes []byte) ([]byte, error) {
+	// The database normalizes JSONB, so the changes are decoded and encoded again to get the same
+	// bytes before and after they are stored.
+	canonical := json.RawMessage("null")
+	if len(changes) > 0 {
+		d := json.NewDecoder(bytes.NewReader(changes))
+		d.UseNumber()
+
+		var v any
+		err := d.Decode(&v)
+		if err != nil {
+			return nil, fmt.Errorf("decode changes: %w", err)
+		}
+		canonical, err = json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("encode changes: %w", err)
+		}
+	}
+
+	record, err := json.Marshal(struct {
+		Time      string          `json:"time"`
+		Actor     string          `json:"actor"`
+		Token     string          `json:"token"`
+		Action    string          `json:"action"`
+		Target    string          `json:"target"`
+		Changes   json.RawMessage `json:"changes"`
+		RequestID string          `json:"request_id"`
+	}{
+		Time:      e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		Actor:     e.Actor,
+		Token:     e.Token,
+		Action:    e.Action,
+		Target:    e.Target,
+		Changes:   canonical,
+		RequestID: e.RequestID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	h.Write(prev)
+	h.Write(record)
+
+	return h.Sum(nil), nil
+}
+
+const auditFields = "id, created_at, actor, token, action, target, changes, request_id, " +
+	"prev_hash, hash"
+
+// scanAuditEvent scans the auditFields of one row. The changes are also returned as stored.
+func scanAuditEvent(row pgx.Row) (*AuditEvent, []byte, error) {
+	var e AuditEvent
+	var changes []byte
+	err := row.Scan(&e.ID, &e.CreatedAt, &e.Actor, &e.Token, &e.Action, &e.Target, &changes,
+		&e.RequestID, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(changes) > 0 {
+		err = json.Unmarshal(changes, &e.Changes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decode changes of event %d: %w", e.ID, err)
+		}
+	}
+
+	return &e, changes, nil
+}
+
+// ListEvents returns the events selected by the filter, newest first.
+func (t *AuditTable) ListEvents(ctx context.Context, f *api.AuditFilter) ([]*AuditEvent, error) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, cond+"$"+strconv.Itoa(len(args)))
+	}
+
+	if f.Actor != "" {
+		add("actor=", f.Actor)
+	}
+	if f.Action != "" {
+		add("action=", f.Action)
+	}
+	if f.Target != "" {
+		add("target=", f.Target)
+	}
+	if !f.Since.IsZero() {
+		add("created_at>=", f.Since)
+	}
+	if f.BeforeID > 0 {
+		add("id<", f.BeforeID)
+	}
+
+	query := "SELECT " + auditFields + " FROM audit_events"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := t.pool.Query(ctx, query, args...)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to list audit events", "error", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*AuditEvent
+	for rows.Next() {
+		e, _, err := scanAuditEvent(rows)
+		if err != nil {
+			t.log(ctx).Error("msg", "failed to scan audit event", "error", err)
+
+			return out, err
+		}
+
+		out = append(out, e)
+	}
+
+	t.log(ctx).Debug("msg", "listed audit events", "count", len(out))
+
+	return out, rows.Err()
+}
+
+// ErrNoAuditEvents is returned by ChainHead if no events have been chained yet.
+var ErrNoAuditEvents = errors.New("no audit events")
+
+// ChainHead returns the newest event in the chain. Publishing its hash somewhere else makes it
+// possible to detect if the chain is later rewritten.
+func (t *AuditTable) ChainHead(ctx context.Context) (*AuditEvent, error) {
+	e, _, err := scanAuditEvent(t.pool.QueryRow(ctx,
+		"SELECT "+auditFields+" FROM audit_events WHERE hash IS NOT NULL ORDER BY id DESC LIMIT 1",
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNoAuditEvents
+		}
+		t.log(ctx).Error("msg", "failed to get audit chain head", "error", err)
+
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// ChainBreak describes the first event that doesn't fit in the chain.
+type ChainBreak struct {
+	ID     int
+	Reason string
+}
+
+func (b *ChainBreak) String() string {
+	return fmt.Sprintf("event %d: %s", b.ID, b.Reason)
+}
+
+// VerifyChain checks the hash of every event, oldest first, and returns the first broken link, or
+// nil if the chain is intact. checked is the number of events whose hashes were checked before the
+// break. Events recorded before the chain was added are skipped.
+func (t *AuditTable) VerifyChain(ctx context.Context) (checked int, broken *ChainBreak, err error) {
+	rows, err := t.pool.Query(ctx, "SELECT "+auditFields+" FROM audit_events ORDER BY id")
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to list audit events", "error", err)
+
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var prev *AuditEvent
+	for rows.Next() {
+		e, changes, err := scanAuditEvent(rows)
+		if err != nil {
+			t.log(ctx).Error("msg", "failed to scan audit event", "error", err)
+
+			return checked, nil, err
+		}
+
+		if reason := ChainError(prev, e, changes); reason != "" {
+			return checked, &ChainBreak{ID: e.ID, Reason: reason}, nil
+		}
+		if e.Hash != nil {
+			prev = e
+			checked++
+		}
+	}
+
+	return checked, nil, rows.Err()
+}
+
+// ChainError returns why e does not follow prev in the chain, or "" if it does. prev is nil if no
+// chained events come before e. changes is e.Changes as stored.
+func ChainError(prev, e *AuditEvent, changes []byte) string {
+	switch {
+	case e.Hash == nil && prev == nil:
+		return "" // recorded before the chain was added
+	case e.Hash == nil:
+		return "missing hash"
+	case e.PrevHash == nil:
+		return "missing previous hash"
+	case prev == nil && len(e.PrevHash) > 0:
+		return "first event in the chain has a previous hash"
+	case prev != nil && !bytes.Equal(e.PrevHash, prev.Hash):
+		return fmt.Sprintf("previous hash does not match event %d", prev.ID)
+	}
+
+	want, err := ChainHash(e.PrevHash, e, changes)
+	if err != nil {
+		return err.Error()
+	}
+	if !bytes.Equal(want, e.Hash) {
+		return "hash does not match contents"
+	}
+
+	return ""
+}
+
+// FindHash returns the ID of the event with the hash, or ErrNoAuditEvents if there is none.
+func (t *AuditTable) FindHash(ctx context.Context, hash []byte) (int, error) {
+	var id int
+	err := t.pool.QueryRow(ctx, "SELECT id FROM audit_events WHERE hash=$1", hash).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrNoAuditEvents
+		}
+		t.log(ctx).Error("msg", "failed to find audit event by hash", "error", err)
+
+		return 0, err
+	}
+
+	return id, nil
+}
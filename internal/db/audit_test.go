@@ -0,0 +1,183 @@
+package db_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/cobaltspeech/log/pkg/testinglog"
+	"github.com/google/go-cmp/cmp"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestAuditTable(t *testing.T) { //nolint:funlen // testing sequential calls
+	t.Parallel()
+
+	mockDB, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("error opening mock db: %v", err)
+	}
+	defer mockDB.Close()
+
+	logger := testinglog.NewConvenientLogger(t)
+	table := db.NewAuditTable(logger, mockDB)
+	ctx := context.Background()
+
+	changes := map[string]api.FieldChange{
+		"ta":            {Before: json.RawMessage("false"), After: json.RawMessage("true")},
+		"name_key_hash": {Redacted: true},
+	}
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		t.Fatalf("failed to encode changes: %v", err)
+	}
+
+	// record the first event in the chain
+	event := db.AuditEvent{
+		Actor: "jane", Action: "PATCH /api/users/:id", Target: "1", Changes: changes,
+		RequestID: "req-1",
+	}
+	expectRecord := func(prev []byte, args ...any) {
+		mockDB.ExpectBegin()
+		mockDB.ExpectExec("SELECT pg_advisory_xact_lock").
+			WithArgs(pgxmock.AnyArg()).
+			WillReturnResult(pgxmock.NewResult("SELECT", 1))
+		head := pgxmock.NewRows([]string{"hash"})
+		if prev != nil {
+			head.AddRow(prev)
+		}
+		mockDB.ExpectQuery("SELECT hash FROM audit_events").WillReturnRows(head)
+		mockDB.ExpectExec("INSERT INTO audit_events").
+			WithArgs(append(append([]any{pgxmock.AnyArg()}, args...),
+				pgxmock.AnyArg(), pgxmock.AnyArg())...).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		mockDB.ExpectCommit()
+	}
+	expectRecord(nil, "jane", "", "PATCH /api/users/:id", "1", encoded, "req-1")
+	err = table.Record(ctx, &event)
+	if err != nil {
+		t.Errorf("error from Record: %v", err)
+	}
+	if len(event.PrevHash) != 0 || len(event.Hash) != 32 {
+		t.Errorf("unexpected hashes for first event: %x, %x", event.PrevHash, event.Hash)
+	}
+
+	// record a change without a diff, chained to the first
+	second := db.AuditEvent{
+		Actor: "jane", Token: "ci", Action: "DELETE /admin/sessions/:id", Target: "3",
+		RequestID: "req-2",
+	}
+	expectRecord(event.Hash, "jane", "ci", "DELETE /admin/sessions/:id", "3", []byte(nil), "req-2")
+	err = table.Record(ctx, &second)
+	if err != nil {
+		t.Errorf("error from Record: %v", err)
+	}
+	if string(second.PrevHash) != string(event.Hash) {
+		t.Errorf("second event is not chained to the first: %x", second.PrevHash)
+	}
+
+	// list Jane's changes to user 1
+	event.ID, second.ID = 7, 8
+	eventRows := func(events ...*db.AuditEvent) *pgxmock.Rows {
+		rows := pgxmock.NewRows([]string{
+			"id", "created_at", "actor", "token", "action", "target", "changes", "request_id",
+			"prev_hash", "hash",
+		})
+		for _, e := range events {
+			var changes []byte
+			if e.Changes != nil {
+				changes = encoded
+			}
+			rows.AddRow(e.ID, e.CreatedAt, e.Actor, e.Token, e.Action, e.Target, changes,
+				e.RequestID, e.PrevHash, e.Hash)
+		}
+
+		return rows
+	}
+	mockDB.ExpectQuery(
+		"SELECT id, created_at, actor, token, action, target, changes, request_id, prev_hash, "+
+			"hash FROM audit_events WHERE actor=\\$1 AND target=\\$2 AND id<\\$3 "+
+			"ORDER BY id DESC LIMIT \\$4").
+		WithArgs("jane", "1", 10, 5).
+		WillReturnRows(eventRows(&event))
+	events, err := table.ListEvents(ctx, &api.AuditFilter{
+		Actor: "jane", Target: "1", BeforeID: 10, Limit: 5,
+	})
+	if err != nil {
+		t.Errorf("error from ListEvents: %v", err)
+	}
+	if diff := cmp.Diff([]*db.AuditEvent{&event}, events); diff != "" {
+		t.Error("unexpected events (-want +got):\n" + diff)
+	}
+
+	// the chain is intact
+	mockDB.ExpectQuery("SELECT (.+) FROM audit_events ORDER BY id").
+		WillReturnRows(eventRows(&event, &second))
+	checked, broken, err := table.VerifyChain(ctx)
+	if err != nil {
+		t.Errorf("error from VerifyChain: %v", err)
+	}
+	if checked != 2 || broken != nil {
+		t.Errorf("expected 2 events checked and no break, got %d and %v", checked, broken)
+	}
+
+	// changing an event breaks the chain
+	tampered := event
+	tampered.Target = "2"
+	mockDB.ExpectQuery("SELECT (.+) FROM audit_events ORDER BY id").
+		WillReturnRows(eventRows(&tampered, &second))
+	checked, broken, err = table.VerifyChain(ctx)
+	if err != nil {
+		t.Errorf("error from VerifyChain: %v", err)
+	}
+	want := &db.ChainBreak{ID: 7, Reason: "hash does not match contents"}
+	if diff := cmp.Diff(want, broken); checked != 0 || diff != "" {
+		t.Errorf("unexpected break after %d events (-want +got):\n%s", checked, diff)
+	}
+
+	// so does removing one
+	mockDB.ExpectQuery("SELECT (.+) FROM audit_events ORDER BY id").
+		WillReturnRows(eventRows(&second))
+	checked, broken, err = table.VerifyChain(ctx)
+	if err != nil {
+		t.Errorf("error from VerifyChain: %v", err)
+	}
+	want = &db.ChainBreak{ID: 8, Reason: "first event in the chain has a previous hash"}
+	if diff := cmp.Diff(want, broken); checked != 0 || diff != "" {
+		t.Errorf("unexpected break after %d events (-want +got):\n%s", checked, diff)
+	}
+
+	// the head is the newest chained event
+	mockDB.ExpectQuery("SELECT (.+) FROM audit_events WHERE hash IS NOT NULL").
+		WillReturnRows(eventRows(&second))
+	head, err := table.ChainHead(ctx)
+	if err != nil {
+		t.Errorf("error from ChainHead: %v", err)
+	}
+	if diff := cmp.Diff(&second, head); diff != "" {
+		t.Error("unexpected head (-want +got):\n" + diff)
+	}
+
+	// earlier heads can be found by hash
+	mockDB.ExpectQuery("SELECT id FROM audit_events WHERE hash").
+		WithArgs(event.Hash).
+		WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(7))
+	id, err := table.FindHash(ctx, event.Hash)
+	if err != nil || id != 7 {
+		t.Errorf("expected event 7 from FindHash, got %d and %v", id, err)
+	}
+	mockDB.ExpectQuery("SELECT id FROM audit_events WHERE hash").
+		WithArgs([]byte("nope")).
+		WillReturnRows(pgxmock.NewRows([]string{"id"}))
+	_, err = table.FindHash(ctx, []byte("nope"))
+	if !errors.Is(err, db.ErrNoAuditEvents) {
+		t.Errorf("unexpected error from FindHash: %v", err)
+	}
+
+	if err = mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
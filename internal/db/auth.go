@@ -4,9 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/cobaltspeech/log"
+	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -21,31 +26,47 @@ type AdminTable struct {
 func NewAdminTable(l log.Logger, pool PgxIface) *AdminTable {
 	out := AdminTable{
 		logger: l,
-		pool:   pool,
+		pool:   traced(pool, "admins"),
 	}
 
 	return &out
 }
 
-// AddAdmin creates a new admin account.
-func (a *AdminTable) AddAdmin(ctx context.Context, user, pass string) error {
+// log returns the logger with the request ID in ctx, if any.
+func (a *AdminTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(a.logger, ctx)
+}
+
+// ErrAdminExists is returned by AddAdmin if the username is already taken.
+var ErrAdminExists = errors.New("admin already exists")
+
+// AddAdmin creates a new admin account with the given role.
+func (a *AdminTable) AddAdmin(ctx context.Context, user, pass string, role api.Role) error {
 	hashed, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
 	if err != nil {
-		a.logger.Error("msg", "failed to hash password for new admin", "user", user, "error", err)
+		a.log(ctx).Error("msg", "failed to hash password for new admin", "user", user, "error", err)
 
 		return fmt.Errorf("hash password: %w", err)
 	}
 
-	_, err = a.pool.Exec(
-		ctx, "INSERT INTO admins (username, password) VALUES ($1, $2)", user, string(hashed),
+	_, err = a.pool.Exec(ctx,
+		"INSERT INTO admins (username, password, role) VALUES ($1, $2, $3)",
+		user, string(hashed), string(role),
 	)
 	if err != nil {
-		a.logger.Error("msg", "failed to store new admin", "user", user, "error", err)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			a.log(ctx).Info("msg", "admin already exists", "user", user)
+
+			return ErrAdminExists
+		}
+
+		a.log(ctx).Error("msg", "failed to store new admin", "user", user, "error", err)
 
 		return err
 	}
 
-	a.logger.Debug("msg", "stored new admin", "user", user)
+	a.log(ctx).Debug("msg", "stored new admin", "user", user, "role", role)
 
 	return nil
 }
@@ -55,45 +76,52 @@ func (a *AdminTable) AddAdmin(ctx context.Context, user, pass string) error {
 func (a *AdminTable) DeleteAdmin(ctx context.Context, user string) error {
 	tag, err := a.pool.Exec(ctx, "DELETE FROM admins WHERE username=$1", user)
 	if err != nil {
-		a.logger.Error("msg", "failed to delete admin", "user", user, "error", err)
+		a.log(ctx).Error("msg", "failed to delete admin", "user", user, "error", err)
 
 		return err
 	}
 	if tag.RowsAffected() < 1 {
-		a.logger.Info("msg", "no user found to delete", "user", user)
+		a.log(ctx).Info("msg", "no user found to delete", "user", user)
 
 		return ErrNoUser
 	}
 
-	a.logger.Debug("msg", "deleted admin", "user", user)
+	a.log(ctx).Debug("msg", "deleted admin", "user", user)
 
 	return nil
 }
 
-// CheckPassword returns true if the admin exists and the password matches the hash on file.
-// Otherwise it returns false.
+// CheckPassword returns true if the admin exists, is not disabled, and the password matches the
+// hash on file. Otherwise it returns false.
 func (a *AdminTable) CheckPassword(ctx context.Context, user, pass string) (bool, error) {
 	var hashed string
-	err := a.pool.QueryRow(ctx, "SELECT password FROM admins WHERE username=$1", user).
-		Scan(&hashed)
+	var disabled bool
+	err := a.pool.QueryRow(ctx, "SELECT password, disabled FROM admins WHERE username=$1", user).
+		Scan(&hashed, &disabled)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			a.logger.Info("msg", "checked password for nonexistent user", "user", user)
+			a.log(ctx).Info("msg", "checked password for nonexistent user", "user", user)
 
 			return false, nil
 		}
 
-		a.logger.Error("msg", "failed to check password", "user", user, "error", err)
+		a.log(ctx).Error("msg", "failed to check password", "user", user, "error", err)
 
 		return false, err
 	}
 
+	if disabled {
+		a.log(ctx).Info("msg", "checked password for disabled admin", "user", user)
+
+		return false, nil
+	}
+
 	passed := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(pass)) == nil
 
 	if passed {
-		a.logger.Debug("msg", "successful password check", "user", user)
+		a.log(ctx).Debug("msg", "successful password check", "user", user)
 	} else {
-		a.logger.Debug("msg", "unsuccessful password check", "user", user)
+		a.log(ctx).Debug("msg", "unsuccessful password check", "user", user)
 	}
 
 	return passed, nil
@@ -107,7 +135,7 @@ var ErrNoUser = errors.New("user not found")
 func (a *AdminTable) ChangePassword(ctx context.Context, user, pass string) error {
 	hashed, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
 	if err != nil {
-		a.logger.Error("msg", "failed to hash new password", "user", user, "error", err)
+		a.log(ctx).Error("msg", "failed to hash new password", "user", user, "error", err)
 
 		return err
 	}
@@ -115,17 +143,249 @@ func (a *AdminTable) ChangePassword(ctx context.Context, user, pass string) erro
 	tag, err := a.pool.Exec(
 		ctx, "UPDATE admins SET password=$2 WHERE username=$1", user, string(hashed))
 	if err != nil {
-		a.logger.Error("msg", "failed to update password", "user", user, "error", err)
+		a.log(ctx).Error("msg", "failed to update password", "user", user, "error", err)
+
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		a.log(ctx).Info("msg", "no user found to update password", "user", user)
+
+		return ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "password updated", "user", user)
+
+	return nil
+}
+
+// ResetPassword changes the password of the admin and revokes every session of theirs except the
+// one with the key, which may be empty to revoke them all. Both happen in one transaction, so that
+// a failure can't change the password but leave the old sessions working. ErrNoUser is returned if
+// the admin is not in the database.
+func (a *AdminTable) ResetPassword(ctx context.Context, user, pass, keep string) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to hash new password", "user", user, "error", err)
+
+		return err
+	}
+
+	tx, err := a.pool.Begin(ctx)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to begin transaction", "user", user, "error", err)
+
+		return err
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck // This does nothing after Commit.
+
+	tag, err := tx.Exec(ctx, "UPDATE admins SET password=$2 WHERE username=$1", user, string(hashed))
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to update password", "user", user, "error", err)
+
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		a.log(ctx).Info("msg", "no user found to update password", "user", user)
+
+		return ErrNoUser
+	}
+
+	tag, err = tx.Exec(ctx,
+		"DELETE FROM admin_sessions WHERE session_key<>$2 "+
+			"AND admin_id=(SELECT id FROM admins WHERE username=$1)",
+		user, keep,
+	)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to revoke sessions", "user", user, "error", err)
+
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to commit password reset", "user", user, "error", err)
+
+		return err
+	}
+
+	a.log(ctx).Debug("msg", "password reset", "user", user, "revoked", tag.RowsAffected())
+
+	return nil
+}
+
+// Admin describes an admin account. The password hash is not included.
+type Admin struct {
+	ID        int
+	Username  string
+	Role      api.Role
+	Disabled  bool
+	CreatedAt time.Time
+	LastLogin *time.Time
+
+	TOTPEnabled bool
+	LockedAt    *time.Time
+}
+
+// API converts the admin to its wire representation.
+func (a *Admin) API() *api.Admin {
+	out := api.Admin(*a)
+
+	return &out
+}
+
+const adminFields = "id, username, role, disabled, created_at, last_login, totp_enabled, " +
+	"locked_at"
+
+func scanAdmin(row pgx.Row) (*Admin, error) {
+	var out Admin
+	err := row.Scan(&out.ID, &out.Username, &out.Role, &out.Disabled, &out.CreatedAt, &out.LastLogin,
+		&out.TOTPEnabled, &out.LockedAt)
+
+	return &out, err
+}
+
+// ListAdmins returns every admin account, ordered by ID.
+func (a *AdminTable) ListAdmins(ctx context.Context) ([]*Admin, error) {
+	rows, err := a.pool.Query(ctx, "SELECT "+adminFields+" FROM admins ORDER BY id")
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to list admins", "error", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Admin
+	for rows.Next() {
+		admin, scanErr := scanAdmin(rows)
+		if scanErr != nil {
+			a.log(ctx).Error("msg", "failed to scan admin", "error", scanErr)
+
+			return out, scanErr
+		}
+
+		out = append(out, admin)
+	}
+
+	a.log(ctx).Debug("msg", "listed admins", "count", len(out))
+
+	return out, rows.Err()
+}
+
+// GetAdmin returns the admin by username. ErrNoUser is returned if the admin is not in the
+// database.
+func (a *AdminTable) GetAdmin(ctx context.Context, user string) (*Admin, error) {
+	return a.getAdmin(ctx, "username", user)
+}
+
+// GetAdminByID returns the admin by ID. ErrNoUser is returned if the admin is not in the database.
+func (a *AdminTable) GetAdminByID(ctx context.Context, id int) (*Admin, error) {
+	return a.getAdmin(ctx, "id", id)
+}
+
+func (a *AdminTable) getAdmin(ctx context.Context, column string, value any) (*Admin, error) {
+	admin, err := scanAdmin(a.pool.QueryRow(ctx,
+		"SELECT "+adminFields+" FROM admins WHERE "+column+"=$1", value))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			a.log(ctx).Info("msg", "admin not in database", column, value)
+
+			return nil, ErrNoUser
+		}
+
+		a.log(ctx).Error("msg", "failed to get admin", column, value, "error", err)
+
+		return nil, err
+	}
+
+	return admin, nil
+}
+
+// RecordLogin sets the last login time of the admin to now.
+func (a *AdminTable) RecordLogin(ctx context.Context, user string) error {
+	_, err := a.pool.Exec(ctx, "UPDATE admins SET last_login=now() WHERE username=$1", user)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to record login", "user", user, "error", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// SetDisabled disables or enables an admin. Disabled admins cannot log in, and their existing
+// sessions and API tokens stop working. ErrNoUser is returned if the admin is not in the database.
+func (a *AdminTable) SetDisabled(ctx context.Context, user string, disabled bool) error {
+	tag, err := a.pool.Exec(ctx, "UPDATE admins SET disabled=$2 WHERE username=$1", user, disabled)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to set disabled", "user", user, "error", err)
+
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		a.log(ctx).Info("msg", "no user found to set disabled", "user", user)
+
+		return ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "set admin disabled", "user", user, "disabled", disabled)
+
+	return nil
+}
+
+// PatchAdmin changes the role of an admin and disables or enables them in one statement, and
+// returns the updated admin. Nil fields of the patch are not changed. ErrNoUser is returned if
+// the admin is not in the database.
+func (a *AdminTable) PatchAdmin(
+	ctx context.Context, user string, patch api.AdminPatch,
+) (*Admin, error) {
+	admin, err := scanAdmin(a.pool.QueryRow(ctx,
+		"UPDATE admins SET role=COALESCE($2, role), disabled=COALESCE($3, disabled) "+
+			"WHERE username=$1 RETURNING "+adminFields,
+		user, roleOrNil(patch.Role), patch.Disabled,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			a.log(ctx).Info("msg", "no user found to patch", "user", user)
+
+			return nil, ErrNoUser
+		}
+
+		a.log(ctx).Error("msg", "failed to patch admin", "user", user, "error", err)
+
+		return nil, err
+	}
+
+	a.log(ctx).Debug("msg", "patched admin", "user", user, "role", admin.Role,
+		"disabled", admin.Disabled)
+
+	return admin, nil
+}
+
+// roleOrNil returns the role as a string to be stored, or nil if it is nil.
+func roleOrNil(role *api.Role) *string {
+	if role == nil {
+		return nil
+	}
+	s := string(*role)
+
+	return &s
+}
+
+// SetRole changes the role of an admin. ErrNoUser is returned if the admin is not in the database.
+func (a *AdminTable) SetRole(ctx context.Context, user string, role api.Role) error {
+	tag, err := a.pool.Exec(ctx, "UPDATE admins SET role=$2 WHERE username=$1", user, string(role))
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to set role", "user", user, "error", err)
 
 		return err
 	}
 	if tag.RowsAffected() != 1 {
-		a.logger.Info("msg", "no user found to update password", "user", user)
+		a.log(ctx).Info("msg", "no user found to set role", "user", user)
 
 		return ErrNoUser
 	}
 
-	a.logger.Debug("msg", "password updated", "user", user)
+	a.log(ctx).Debug("msg", "set admin role", "user", user, "role", role)
 
 	return nil
 }
@@ -5,13 +5,26 @@ import (
 	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cobaltspeech/log/pkg/testinglog"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 	"github.com/pashagolub/pgxmock/v2"
 )
 
+var (
+	adminColumns = []string{
+		"id", "username", "role", "disabled", "created_at", "last_login", "totp_enabled",
+		"locked_at",
+	}
+	adminFields = strings.Join(adminColumns, ", ")
+)
+
 // AnyBcrypt satisfies pgxmock.Argument.
 type AnyBcrypt struct{}
 
@@ -40,17 +53,17 @@ func TestAdminTable(t *testing.T) { //nolint:cyclop,funlen,gocyclo // testing se
 
 	// create admin John and check password
 	mockDB.ExpectExec("INSERT INTO admins").
-		WithArgs("john", AnyBcrypt{}).
+		WithArgs("john", AnyBcrypt{}, "uploader").
 		WillReturnResult(pgxmock.NewResult("INSERT", 1))
-	err = table.AddAdmin(ctx, "john", "doe")
+	err = table.AddAdmin(ctx, "john", "doe", api.RoleUploader)
 	if err != nil {
 		t.Errorf("error from AddAdmin: %v", err)
 	}
 
-	mockDB.ExpectQuery("SELECT password FROM admins").
+	mockDB.ExpectQuery("SELECT password, disabled FROM admins").
 		WithArgs("john").
-		WillReturnRows(pgxmock.NewRows([]string{"password"}).
-			AddRow("$2a$10$mptpjWROiQEfYu2l1Og4y.o7yssk4y8mnQh2.vS1KXufsb5MW3Wf."),
+		WillReturnRows(pgxmock.NewRows([]string{"password", "disabled"}).
+			AddRow("$2a$10$mptpjWROiQEfYu2l1Og4y.o7yssk4y8mnQh2.vS1KXufsb5MW3Wf.", false),
 		)
 	success, err := table.CheckPassword(ctx, "john", "doe")
 	if err != nil {
@@ -61,7 +74,7 @@ func TestAdminTable(t *testing.T) { //nolint:cyclop,funlen,gocyclo // testing se
 	}
 
 	// check nonexistent Stephen's password
-	mockDB.ExpectQuery("SELECT password FROM admins").
+	mockDB.ExpectQuery("SELECT password, disabled FROM admins").
 		WithArgs("stephen").
 		WillReturnError(pgx.ErrNoRows)
 	success, err = table.CheckPassword(ctx, "stephen", "doe")
@@ -81,10 +94,10 @@ func TestAdminTable(t *testing.T) { //nolint:cyclop,funlen,gocyclo // testing se
 		t.Errorf("error from ChangePassword: %v", err)
 	}
 
-	mockDB.ExpectQuery("SELECT password FROM admins").
+	mockDB.ExpectQuery("SELECT password, disabled FROM admins").
 		WithArgs("john").
-		WillReturnRows(pgxmock.NewRows([]string{"password"}).
-			AddRow("$2a$10$4mHLLmN4VRKUnf0filfiT.d5I3qycV4tSRoKKIWirKXK52pVU1oWW"),
+		WillReturnRows(pgxmock.NewRows([]string{"password", "disabled"}).
+			AddRow("$2a$10$4mHLLmN4VRKUnf0filfiT.d5I3qycV4tSRoKKIWirKXK52pVU1oWW", false),
 		)
 	success, err = table.CheckPassword(ctx, "john", "doe")
 	if err != nil {
@@ -94,10 +107,10 @@ func TestAdminTable(t *testing.T) { //nolint:cyclop,funlen,gocyclo // testing se
 		t.Errorf("password check for john passed with old password")
 	}
 
-	mockDB.ExpectQuery("SELECT password FROM admins").
+	mockDB.ExpectQuery("SELECT password, disabled FROM admins").
 		WithArgs("john").
-		WillReturnRows(pgxmock.NewRows([]string{"password"}).
-			AddRow("$2a$10$4mHLLmN4VRKUnf0filfiT.d5I3qycV4tSRoKKIWirKXK52pVU1oWW"),
+		WillReturnRows(pgxmock.NewRows([]string{"password", "disabled"}).
+			AddRow("$2a$10$4mHLLmN4VRKUnf0filfiT.d5I3qycV4tSRoKKIWirKXK52pVU1oWW", false),
 		)
 	success, err = table.CheckPassword(ctx, "john", "password")
 	if err != nil {
@@ -107,6 +120,132 @@ func TestAdminTable(t *testing.T) { //nolint:cyclop,funlen,gocyclo // testing se
 		t.Errorf("password check for john failed")
 	}
 
+	// get John's account and promote him
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	john := db.Admin{ID: 1, Username: "john", Role: api.RoleUploader, CreatedAt: created}
+	mockDB.ExpectQuery("SELECT " + adminFields + " FROM admins WHERE username").
+		WithArgs("john").
+		WillReturnRows(pgxmock.NewRows(adminColumns).AddRow(
+			john.ID, john.Username, john.Role, john.Disabled, john.CreatedAt, john.LastLogin,
+			john.TOTPEnabled, john.LockedAt,
+		))
+	admin, err := table.GetAdmin(ctx, "john")
+	if err != nil {
+		t.Errorf("error from GetAdmin: %v", err)
+	}
+	if diff := cmp.Diff(&john, admin); diff != "" {
+		t.Error("unexpected admin (-want +got):\n" + diff)
+	}
+
+	mockDB.ExpectExec("UPDATE admins SET role").
+		WithArgs("john", "owner").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	err = table.SetRole(ctx, "john", api.RoleOwner)
+	if err != nil {
+		t.Errorf("error from SetRole: %v", err)
+	}
+	john.Role = api.RoleOwner
+
+	// patch John's role and status at once, and then undo the status
+	role, disabled := "viewer", true
+	mockDB.ExpectQuery("UPDATE admins SET role=COALESCE").
+		WithArgs("john", &role, &disabled).
+		WillReturnRows(pgxmock.NewRows(adminColumns).AddRow(
+			john.ID, john.Username, api.RoleViewer, true, john.CreatedAt, john.LastLogin,
+			john.TOTPEnabled, john.LockedAt,
+		))
+	viewer := api.RoleViewer
+	admin, err = table.PatchAdmin(ctx, "john", api.AdminPatch{Role: &viewer, Disabled: &disabled})
+	if err != nil {
+		t.Errorf("error from PatchAdmin: %v", err)
+	}
+	if admin == nil || admin.Role != api.RoleViewer || !admin.Disabled {
+		t.Errorf("expected John to be a disabled viewer, got %+v", admin)
+	}
+	enabled := false
+	mockDB.ExpectQuery("UPDATE admins SET role=COALESCE").
+		WithArgs("john", (*string)(nil), &enabled).
+		WillReturnRows(pgxmock.NewRows(adminColumns).AddRow(
+			john.ID, john.Username, api.RoleViewer, false, john.CreatedAt, john.LastLogin,
+			john.TOTPEnabled, john.LockedAt,
+		))
+	_, err = table.PatchAdmin(ctx, "john", api.AdminPatch{Disabled: &enabled})
+	if err != nil {
+		t.Errorf("error from PatchAdmin: %v", err)
+	}
+	john.Role = api.RoleViewer
+
+	mockDB.ExpectQuery("UPDATE admins SET role=COALESCE").
+		WithArgs("stephen", &role, (*bool)(nil)).
+		WillReturnError(pgx.ErrNoRows)
+	_, err = table.PatchAdmin(ctx, "stephen", api.AdminPatch{Role: &viewer})
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser patching Stephen, got %v", err)
+	}
+
+	// get nonexistent Stephen's account
+	mockDB.ExpectQuery("SELECT " + adminFields + " FROM admins WHERE id").
+		WithArgs(2).
+		WillReturnError(pgx.ErrNoRows)
+	_, err = table.GetAdminByID(ctx, 2)
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("unexpected error from GetAdminByID: %v", err)
+	}
+
+	// John can't be added twice
+	mockDB.ExpectExec("INSERT INTO admins").
+		WithArgs("john", AnyBcrypt{}, "viewer").
+		WillReturnError(&pgconn.PgError{Code: pgerrcode.UniqueViolation})
+	err = table.AddAdmin(ctx, "john", "doe", api.RoleViewer)
+	if !errors.Is(err, db.ErrAdminExists) {
+		t.Errorf("unexpected error from AddAdmin: %v", err)
+	}
+
+	// record John's login and list admins
+	mockDB.ExpectExec("UPDATE admins SET last_login").
+		WithArgs("john").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	err = table.RecordLogin(ctx, "john")
+	if err != nil {
+		t.Errorf("error from RecordLogin: %v", err)
+	}
+	john.LastLogin = &created
+
+	mockDB.ExpectQuery("SELECT " + adminFields + " FROM admins ORDER BY id").
+		WillReturnRows(pgxmock.NewRows(adminColumns).AddRow(
+			john.ID, john.Username, john.Role, john.Disabled, john.CreatedAt, john.LastLogin,
+			john.TOTPEnabled, john.LockedAt,
+		))
+	admins, err := table.ListAdmins(ctx)
+	if err != nil {
+		t.Errorf("error from ListAdmins: %v", err)
+	}
+	if diff := cmp.Diff([]*db.Admin{&john}, admins); diff != "" {
+		t.Error("unexpected admins (-want +got):\n" + diff)
+	}
+
+	// disable John, and then his password doesn't work
+	mockDB.ExpectExec("UPDATE admins SET disabled").
+		WithArgs("john", true).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	err = table.SetDisabled(ctx, "john", true)
+	if err != nil {
+		t.Errorf("error from SetDisabled: %v", err)
+	}
+
+	mockDB.ExpectQuery("SELECT password, disabled FROM admins").
+		WithArgs("john").
+		WillReturnRows(pgxmock.NewRows([]string{"password", "disabled"}).
+			AddRow("$2a$10$4mHLLmN4VRKUnf0filfiT.d5I3qycV4tSRoKKIWirKXK52pVU1oWW", true),
+		)
+	success, err = table.CheckPassword(ctx, "john", "password")
+	if err != nil {
+		t.Errorf("error from CheckPassword: %v", err)
+	}
+	if success {
+		t.Errorf("password check for disabled john passed")
+	}
+
 	// change Stephen's password (fail)
 	mockDB.ExpectExec("UPDATE admins").
 		WithArgs("stephen", AnyBcrypt{}).
@@ -116,6 +255,31 @@ func TestAdminTable(t *testing.T) { //nolint:cyclop,funlen,gocyclo // testing se
 		t.Errorf("unexpected error from ChangePassword: %v", err)
 	}
 
+	// reset John's password, which revokes his sessions in the same transaction
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("UPDATE admins SET password").
+		WithArgs("john", AnyBcrypt{}).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mockDB.ExpectExec("DELETE FROM admin_sessions").
+		WithArgs("john", "").
+		WillReturnResult(pgxmock.NewResult("DELETE", 2))
+	mockDB.ExpectCommit()
+	err = table.ResetPassword(ctx, "john", "password", "")
+	if err != nil {
+		t.Errorf("error from ResetPassword: %v", err)
+	}
+
+	// reset Stephen's password (fail), which leaves the sessions alone
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("UPDATE admins SET password").
+		WithArgs("stephen", AnyBcrypt{}).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	mockDB.ExpectRollback()
+	err = table.ResetPassword(ctx, "stephen", "password", "")
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("unexpected error from ResetPassword: %v", err)
+	}
+
 	// delete Stephen's account (fail)
 	mockDB.ExpectExec("DELETE FROM admins").
 		WithArgs("stephen").
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GetDiscordAdmin returns the admin linked to the Discord user ID. ErrNoUser is returned if no
+// admin is linked to it.
+func (a *AdminTable) GetDiscordAdmin(ctx context.Context, discordID string) (*Admin, error) {
+	admin, err := scanAdmin(a.pool.QueryRow(ctx,
+		"SELECT "+adminFields+" FROM admins "+
+			"WHERE id=(SELECT admin_id FROM discord_admins WHERE discord_id=$1)",
+		discordID,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			a.log(ctx).Info("msg", "no admin linked to Discord user", "discordID", discordID)
+
+			return nil, ErrNoUser
+		}
+
+		a.log(ctx).Error("msg", "failed to get Discord admin", "discordID", discordID, "error", err)
+
+		return nil, err
+	}
+
+	return admin, nil
+}
+
+// AddDiscordAdmin creates a new admin linked to the Discord user ID. The admin gets a random
+// password, so it can only log in with Discord until an owner resets the password.
+// ErrAdminExists is returned if the username is taken or the Discord user is already linked.
+func (a *AdminTable) AddDiscordAdmin(
+	ctx context.Context, discordID, user string, role api.Role,
+) error {
+	pass, err := newToken()
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to generate password for new admin", "user", user,
+			"error", err)
+
+		return err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to hash password for new admin", "user", user, "error", err)
+
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	_, err = a.pool.Exec(ctx,
+		"WITH new AS (INSERT INTO admins (username, password, role) VALUES ($2, $3, $4) "+
+			"RETURNING id) "+
+			"INSERT INTO discord_admins (discord_id, admin_id) SELECT $1, id FROM new",
+		discordID, user, string(hashed), string(role),
+	)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			a.log(ctx).Info("msg", "admin already exists", "user", user, "discordID", discordID)
+
+			return ErrAdminExists
+		}
+
+		a.log(ctx).Error("msg", "failed to store new Discord admin", "user", user, "error", err)
+
+		return err
+	}
+
+	a.log(ctx).Debug("msg", "stored new Discord admin", "user", user, "discordID", discordID,
+		"role", role)
+
+	return nil
+}
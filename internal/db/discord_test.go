@@ -0,0 +1,77 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cobaltspeech/log/pkg/testinglog"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestAdminTable_Discord(t *testing.T) {
+	t.Parallel()
+
+	mockDB, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("error opening mock db: %v", err)
+	}
+	defer mockDB.Close()
+
+	logger := testinglog.NewConvenientLogger(t)
+	table := db.NewAdminTable(logger, mockDB)
+	ctx := context.Background()
+
+	// Jane logs in with Discord for the first time
+	mockDB.ExpectQuery("SELECT " + adminFields + " FROM admins WHERE id=\\(SELECT admin_id").
+		WithArgs("1001").
+		WillReturnError(pgx.ErrNoRows)
+	_, err = table.GetDiscordAdmin(ctx, "1001")
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("unexpected error from GetDiscordAdmin: %v", err)
+	}
+	mockDB.ExpectExec("WITH new AS \\(INSERT INTO admins").
+		WithArgs("1001", "discord-jane", AnyBcrypt{}, "uploader").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	err = table.AddDiscordAdmin(ctx, "1001", "discord-jane", api.RoleUploader)
+	if err != nil {
+		t.Errorf("error from AddDiscordAdmin: %v", err)
+	}
+
+	// the username is taken by someone else
+	mockDB.ExpectExec("WITH new AS \\(INSERT INTO admins").
+		WithArgs("1002", "discord-jane", AnyBcrypt{}, "viewer").
+		WillReturnError(&pgconn.PgError{Code: pgerrcode.UniqueViolation})
+	err = table.AddDiscordAdmin(ctx, "1002", "discord-jane", api.RoleViewer)
+	if !errors.Is(err, db.ErrAdminExists) {
+		t.Errorf("unexpected error from AddDiscordAdmin: %v", err)
+	}
+
+	// Jane logs in again
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	jane := db.Admin{ID: 2, Username: "discord-jane", Role: api.RoleUploader, CreatedAt: created}
+	mockDB.ExpectQuery("SELECT " + adminFields + " FROM admins WHERE id=\\(SELECT admin_id").
+		WithArgs("1001").
+		WillReturnRows(pgxmock.NewRows(adminColumns).AddRow(
+			jane.ID, jane.Username, jane.Role, jane.Disabled, jane.CreatedAt, jane.LastLogin,
+			jane.TOTPEnabled, jane.LockedAt,
+		))
+	admin, err := table.GetDiscordAdmin(ctx, "1001")
+	if err != nil {
+		t.Errorf("error from GetDiscordAdmin: %v", err)
+	}
+	if diff := cmp.Diff(&jane, admin); diff != "" {
+		t.Error("unexpected admin (-want +got):\n" + diff)
+	}
+
+	if err = mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,326 @@
+package db
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// SnapshotFormat is the version of the Snapshot encoding written by Dump.
+const SnapshotFormat = 1
+
+// Snapshot is a portable copy of the data worth keeping in the database, written by Dump as JSON.
+// Admins are included without their passwords, two-factor secrets, tokens, or sessions. The
+// admissions made by the bot are audit events with the action "admit".
+type Snapshot struct {
+	Format int `json:"format"`
+
+	// Database is "sqlite" for snapshots of SQLite databases, and empty for Postgres. Snapshots can
+	// only be restored to the same kind of database, since their migrations are numbered
+	// separately.
+	Database string `json:"database,omitempty"`
+
+	// Migration is the version of the database schema the snapshot was taken from. It can only be
+	// restored to a database at the same version.
+	Migration int       `json:"migration"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Users       []*User           `json:"users"`
+	Admins      []*api.Admin      `json:"admins"`
+	AuditEvents []*api.AuditEvent `json:"audit_events"`
+}
+
+// Dump takes a snapshot of the database. The tables are read in one transaction, so the snapshot
+// is consistent even while the server is running.
+func Dump(ctx context.Context, pool PgxIface) (*Snapshot, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck // The transaction only reads.
+
+	_, err = tx.Exec(ctx, "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY")
+	if err != nil {
+		return nil, err
+	}
+
+	out := Snapshot{Format: SnapshotFormat, CreatedAt: time.Now().UTC()}
+	out.Migration, _, err = MigrationVersion(ctx, tx)
+	if err != nil {
+		return nil, fmt.Errorf("get migration version: %w", err)
+	}
+
+	out.Users, err = collect(ctx, tx, "SELECT id, "+selectFields+" FROM users ORDER BY id",
+		func(row pgx.Rows) (*User, error) {
+			var u User
+			err := row.Scan(&u.ID, &u.Name, &u.NameKeyHash, &u.FinishYear, &u.Professor, &u.TA,
+				&u.StudentLeadership, &u.AlumniBoard, &u.Version)
+
+			return &u, err
+		})
+	if err != nil {
+		return nil, fmt.Errorf("dump users: %w", err)
+	}
+
+	out.Admins, err = collect(ctx, tx, "SELECT "+adminFields+" FROM admins ORDER BY id",
+		func(row pgx.Rows) (*api.Admin, error) {
+			a, err := scanAdmin(row)
+			if err != nil {
+				return nil, err
+			}
+
+			return a.API(), nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("dump admins: %w", err)
+	}
+
+	out.AuditEvents, err = collect(ctx, tx,
+		"SELECT "+auditFields+" FROM audit_events ORDER BY id",
+		func(row pgx.Rows) (*api.AuditEvent, error) {
+			e, _, err := scanAuditEvent(row)
+			if err != nil {
+				return nil, err
+			}
+
+			return e.API(), nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("dump audit events: %w", err)
+	}
+
+	return &out, nil
+}
+
+// querier is the part of PgxIface shared with pgx.Tx.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func collect[T any](
+	ctx context.Context, q querier, sql string, scan func(pgx.Rows) (T, error),
+) ([]T, error) {
+	rows, err := q.Query(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []T{}
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+
+	return out, rows.Err()
+}
+
+// RestoreSummary counts what Restore changed.
+type RestoreSummary struct {
+	Users          int
+	AdminsCreated  int
+	AdminsUpdated  int
+	AuditEvents    int
+	AuditUnchanged int
+}
+
+// ErrSnapshotMismatch is returned by Restore if the snapshot can't be restored to the database.
+var ErrSnapshotMismatch = errors.New("snapshot does not match the database")
+
+// Restore returns the database to the snapshot, in one transaction:
+//   - The users are replaced with the users in the snapshot.
+//   - Admins in the snapshot get back their role and whether they were disabled. Admins missing
+//     from the database are created without a password, so they can't log in until one is set.
+//     Admins created after the snapshot are kept.
+//   - Audit events missing from the database are added back. The audit trail is never rolled
+//     back, so events recorded after the snapshot are kept. If an event in the database is
+//     different from the same event in the snapshot, nothing is restored.
+func Restore(ctx context.Context, pool PgxIface, s *Snapshot) (*RestoreSummary, error) {
+	if s.Format != SnapshotFormat {
+		return nil, fmt.Errorf("%w: unknown snapshot format %d", ErrSnapshotMismatch, s.Format)
+	}
+	if s.Database != "" {
+		return nil, fmt.Errorf("%w: the snapshot is from a %s database", ErrSnapshotMismatch,
+			s.Database)
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck // This does nothing after Commit.
+
+	version, dirty, err := MigrationVersion(ctx, tx)
+	if err != nil {
+		return nil, fmt.Errorf("get migration version: %w", err)
+	}
+	if version != s.Migration || dirty {
+		return nil, fmt.Errorf(
+			"%w: the snapshot is from migration %d, but the database is at migration %d",
+			ErrSnapshotMismatch, s.Migration, version)
+	}
+
+	var out RestoreSummary
+	err = restoreUsers(ctx, tx, s.Users, &out)
+	if err != nil {
+		return nil, fmt.Errorf("restore users: %w", err)
+	}
+	err = restoreAdmins(ctx, tx, s.Admins, &out)
+	if err != nil {
+		return nil, fmt.Errorf("restore admins: %w", err)
+	}
+	err = restoreAudit(ctx, tx, s.AuditEvents, &out)
+	if err != nil {
+		return nil, fmt.Errorf("restore audit events: %w", err)
+	}
+
+	return &out, tx.Commit(ctx)
+}
+
+func restoreUsers(ctx context.Context, tx pgx.Tx, users []*User, out *RestoreSummary) error {
+	_, err := tx.Exec(ctx, "DELETE FROM users")
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		_, err = tx.Exec(ctx,
+			"INSERT INTO users (id, "+selectFields+") "+
+				"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+			u.ID, u.Name, u.NameKeyHash, u.FinishYear, u.Professor, u.TA, u.StudentLeadership,
+			u.AlumniBoard, u.Version,
+		)
+		if err != nil {
+			return checkViolation(err)
+		}
+		out.Users++
+	}
+
+	return resetSequence(ctx, tx, "users")
+}
+
+func restoreAdmins(ctx context.Context, tx pgx.Tx, admins []*api.Admin, out *RestoreSummary) error {
+	for _, a := range admins {
+		// An empty password never matches a bcrypt hash.
+		var created bool
+		err := tx.QueryRow(ctx,
+			"INSERT INTO admins (username, password, role, disabled, created_at) "+
+				"VALUES ($1, '', $2, $3, $4) "+
+				"ON CONFLICT (username) DO UPDATE SET role=$2, disabled=$3 "+
+				"RETURNING xmax = 0",
+			a.Username, a.Role, a.Disabled, a.CreatedAt,
+		).Scan(&created)
+		if err != nil {
+			return err
+		}
+
+		if created {
+			out.AdminsCreated++
+		} else {
+			out.AdminsUpdated++
+		}
+	}
+
+	return nil
+}
+
+func restoreAudit(
+	ctx context.Context, tx pgx.Tx, events []*api.AuditEvent, out *RestoreSummary,
+) error {
+	// Events must not be chained by the server while they are restored.
+	_, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", auditChainLock)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range events {
+		e, changes, err := AuditEventFromAPI(a)
+		if err != nil {
+			return fmt.Errorf("event %d: %w", a.ID, err)
+		}
+
+		var hash []byte
+		err = tx.QueryRow(ctx, "SELECT hash FROM audit_events WHERE id=$1", e.ID).Scan(&hash)
+		if err == nil {
+			if hex.EncodeToString(hash) != a.Hash {
+				return fmt.Errorf("%w: audit event %d is different", ErrSnapshotMismatch, e.ID)
+			}
+			out.AuditUnchanged++
+
+			continue
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+
+		_, err = tx.Exec(ctx,
+			"INSERT INTO audit_events ("+auditFields+") "+
+				"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+			e.ID, e.CreatedAt, e.Actor, e.Token, e.Action, e.Target, changes, e.RequestID,
+			e.PrevHash, e.Hash,
+		)
+		if err != nil {
+			return err
+		}
+		out.AuditEvents++
+	}
+
+	return resetSequence(ctx, tx, "audit_events")
+}
+
+// AuditEventFromAPI reverses AuditEvent.API. The changes are also returned encoded for storage.
+func AuditEventFromAPI(a *api.AuditEvent) (*AuditEvent, []byte, error) {
+	e := AuditEvent{
+		ID:        a.ID,
+		CreatedAt: a.Time,
+		Actor:     a.Actor,
+		Token:     a.Token,
+		Action:    a.Action,
+		Target:    a.Target,
+		Changes:   a.Changes,
+		RequestID: a.RequestID,
+	}
+
+	// Events recorded before the chain was added have neither hash.
+	if a.Hash != "" {
+		var err error
+		e.Hash, err = hex.DecodeString(a.Hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decode hash: %w", err)
+		}
+		e.PrevHash, err = hex.DecodeString(a.PrevHash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decode previous hash: %w", err)
+		}
+	}
+
+	var changes []byte
+	if len(e.Changes) > 0 {
+		var err error
+		changes, err = json.Marshal(e.Changes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("encode changes: %w", err)
+		}
+	}
+
+	return &e, changes, nil
+}
+
+// resetSequence makes the next ID of the table one more than the largest ID in it.
+func resetSequence(ctx context.Context, tx pgx.Tx, table string) error {
+	_, err := tx.Exec(ctx,
+		"SELECT setval(pg_get_serial_sequence('"+table+"', 'id'), "+
+			"COALESCE(MAX(id), 0) + 1, false) FROM "+table)
+
+	return err
+}
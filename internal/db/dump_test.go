@@ -0,0 +1,160 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestDumpRestore(t *testing.T) { //nolint:funlen // testing sequential calls
+	t.Parallel()
+
+	mockDB, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("error opening mock db: %v", err)
+	}
+	defer mockDB.Close()
+	ctx := context.Background()
+
+	created := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	user := &db.User{
+		ID: 4, Name: "abcdef", NameKeyHash: "0123", FinishYear: "2026", TA: true, Version: 2,
+	}
+	admin := &api.Admin{ID: 1, Username: "jane", Role: api.RoleOwner, CreatedAt: created}
+	event := &api.AuditEvent{
+		ID: 7, Time: created, Actor: "bouncerbot", Action: "admit", Target: "1234",
+		PrevHash: "", Hash: "abcd",
+	}
+	migration := func(version int) *pgxmock.Rows {
+		return pgxmock.NewRows([]string{"version", "dirty"}).AddRow(version, false)
+	}
+
+	// dump
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY").
+		WillReturnResult(pgxmock.NewResult("SET", 0))
+	mockDB.ExpectQuery("SELECT version, dirty FROM schema_migrations").
+		WillReturnRows(migration(15))
+	mockDB.ExpectQuery("SELECT id, name, (.+) FROM users ORDER BY id").
+		WillReturnRows(pgxmock.NewRows([]string{
+			"id", "name", "name_key_hash", "finish_year", "professor", "ta", "student_leadership",
+			"alumni_board", "version",
+		}).AddRow(user.ID, user.Name, user.NameKeyHash, user.FinishYear, user.Professor, user.TA,
+			user.StudentLeadership, user.AlumniBoard, user.Version))
+	mockDB.ExpectQuery("SELECT " + adminFields + " FROM admins ORDER BY id").
+		WillReturnRows(pgxmock.NewRows(adminColumns).AddRow(
+			admin.ID, admin.Username, admin.Role, admin.Disabled, admin.CreatedAt,
+			(*time.Time)(nil), false, (*time.Time)(nil)))
+	mockDB.ExpectQuery("SELECT (.+) FROM audit_events ORDER BY id").
+		WillReturnRows(pgxmock.NewRows([]string{
+			"id", "created_at", "actor", "token", "action", "target", "changes", "request_id",
+			"prev_hash", "hash",
+		}).AddRow(event.ID, event.Time, event.Actor, "", event.Action, event.Target, []byte(nil),
+			"", []byte{}, []byte{0xab, 0xcd}))
+	mockDB.ExpectRollback()
+
+	s, err := db.Dump(ctx, mockDB)
+	if err != nil {
+		t.Fatalf("error from Dump: %v", err)
+	}
+	want := &db.Snapshot{
+		Format:      db.SnapshotFormat,
+		Migration:   15,
+		CreatedAt:   s.CreatedAt,
+		Users:       []*db.User{user},
+		Admins:      []*api.Admin{admin},
+		AuditEvents: []*api.AuditEvent{event},
+	}
+	if diff := cmp.Diff(want, s); diff != "" {
+		t.Error("unexpected snapshot (-want +got):\n" + diff)
+	}
+
+	// restore to a database that lost the event
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("SELECT version, dirty FROM schema_migrations").
+		WillReturnRows(migration(15))
+	mockDB.ExpectExec("DELETE FROM users").WillReturnResult(pgxmock.NewResult("DELETE", 3))
+	mockDB.ExpectExec("INSERT INTO users").
+		WithArgs(user.ID, user.Name, user.NameKeyHash, user.FinishYear, user.Professor, user.TA,
+			user.StudentLeadership, user.AlumniBoard, user.Version).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mockDB.ExpectExec("SELECT setval\\(pg_get_serial_sequence\\('users'").
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mockDB.ExpectQuery("INSERT INTO admins (.+) ON CONFLICT").
+		WithArgs(admin.Username, admin.Role, admin.Disabled, admin.CreatedAt).
+		WillReturnRows(pgxmock.NewRows([]string{"created"}).AddRow(false))
+	mockDB.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mockDB.ExpectQuery("SELECT hash FROM audit_events WHERE id").
+		WithArgs(event.ID).
+		WillReturnRows(pgxmock.NewRows([]string{"hash"}))
+	mockDB.ExpectExec("INSERT INTO audit_events").
+		WithArgs(event.ID, event.Time, event.Actor, "", event.Action, event.Target, []byte(nil),
+			"", []byte{}, []byte{0xab, 0xcd}).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mockDB.ExpectExec("SELECT setval\\(pg_get_serial_sequence\\('audit_events'").
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mockDB.ExpectCommit()
+
+	sum, err := db.Restore(ctx, mockDB, s)
+	if err != nil {
+		t.Errorf("error from Restore: %v", err)
+	}
+	wantSum := &db.RestoreSummary{Users: 1, AdminsUpdated: 1, AuditEvents: 1}
+	if diff := cmp.Diff(wantSum, sum); diff != "" {
+		t.Error("unexpected summary (-want +got):\n" + diff)
+	}
+
+	// a database at another migration is left alone
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("SELECT version, dirty FROM schema_migrations").
+		WillReturnRows(migration(14))
+	mockDB.ExpectRollback()
+	_, err = db.Restore(ctx, mockDB, s)
+	if !errors.Is(err, db.ErrSnapshotMismatch) {
+		t.Errorf("unexpected error from Restore: %v", err)
+	}
+
+	// so is a database whose audit trail differs from the snapshot
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery("SELECT version, dirty FROM schema_migrations").
+		WillReturnRows(migration(15))
+	mockDB.ExpectExec("DELETE FROM users").WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mockDB.ExpectExec("INSERT INTO users").
+		WithArgs(user.ID, user.Name, user.NameKeyHash, user.FinishYear, user.Professor, user.TA,
+			user.StudentLeadership, user.AlumniBoard, user.Version).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mockDB.ExpectExec("SELECT setval").WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mockDB.ExpectQuery("INSERT INTO admins").
+		WithArgs(admin.Username, admin.Role, admin.Disabled, admin.CreatedAt).
+		WillReturnRows(pgxmock.NewRows([]string{"created"}).AddRow(false))
+	mockDB.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mockDB.ExpectQuery("SELECT hash FROM audit_events WHERE id").
+		WithArgs(event.ID).
+		WillReturnRows(pgxmock.NewRows([]string{"hash"}).AddRow([]byte{0x12}))
+	mockDB.ExpectRollback()
+	_, err = db.Restore(ctx, mockDB, s)
+	if !errors.Is(err, db.ErrSnapshotMismatch) {
+		t.Errorf("unexpected error from Restore: %v", err)
+	}
+
+	// snapshots of SQLite databases are rejected without touching the database
+	s.Database = "sqlite"
+	_, err = db.Restore(ctx, mockDB, s)
+	if !errors.Is(err, db.ErrSnapshotMismatch) {
+		t.Errorf("unexpected error from Restore: %v", err)
+	}
+
+	if err = mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+)
+
+// LoginTable records failed logins, so that the server can slow down password guessing and lock
+// out admins whose passwords are under attack. Failures are counted per username and per IP
+// address, and each failure is also kept as an event for later review.
+type LoginTable struct {
+	logger log.Logger
+	pool   PgxIface
+}
+
+// NewLoginTable creates a new LoginTable backed by a Postgres connection pool.
+func NewLoginTable(l log.Logger, pool PgxIface) *LoginTable {
+	out := LoginTable{
+		logger: l,
+		pool:   pool,
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (t *LoginTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(t.logger, ctx)
+}
+
+// These are the reasons recorded for failed logins.
+const (
+	FailureBadPassword = "bad_password"
+	FailureBadTOTP     = "bad_totp"
+	FailureLocked      = "locked"
+)
+
+// FailureWindow is how long a failure counter is kept after the last failure. The next failure
+// after that starts counting from one again.
+const FailureWindow = 24 * time.Hour
+
+// FailureCount is the number of recent failed logins for a username or IP address.
+type FailureCount struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// LoginFailure is a failed login attempt.
+type LoginFailure struct {
+	ID        int64
+	Username  string
+	IP        string
+	Reason    string
+	CreatedAt time.Time
+}
+
+// GetFailures returns the recent failed logins for the username and for the IP address.
+func (t *LoginTable) GetFailures(
+	ctx context.Context, user, ip string,
+) (byUser, byIP FailureCount, err error) {
+	rows, err := t.pool.Query(ctx,
+		"SELECT kind, failures, last_failure FROM login_failure_counts "+
+			"WHERE ((kind='user' AND subject=$1) OR (kind='ip' AND subject=$2)) "+
+			"AND last_failure > now() - make_interval(secs => $3)",
+		user, ip, FailureWindow.Seconds(),
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to get login failures", "user", user, "ip", ip, "error", err)
+
+		return byUser, byIP, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind string
+		var count FailureCount
+		err = rows.Scan(&kind, &count.Failures, &count.LastFailure)
+		if err != nil {
+			t.log(ctx).Error("msg", "failed to scan login failures", "error", err)
+
+			return byUser, byIP, err
+		}
+
+		if kind == "user" {
+			byUser = count
+		} else {
+			byIP = count
+		}
+	}
+
+	return byUser, byIP, rows.Err()
+}
+
+// RecordFailure stores a failed login event and increments the failure counters for the username
+// and IP address. It returns the new number of recent failures for the username.
+func (t *LoginTable) RecordFailure(ctx context.Context, user, ip, reason string) (int, error) {
+	var failures int
+	err := t.pool.QueryRow(ctx,
+		"WITH event AS ("+
+			"INSERT INTO login_failures (username, ip, reason) VALUES ($1, $2, $3)"+
+			"), ip_count AS ("+
+			"INSERT INTO login_failure_counts AS c (kind, subject, failures, last_failure) "+
+			"VALUES ('ip', $2, 1, now()) ON CONFLICT (kind, subject) DO UPDATE SET "+
+			"failures=CASE WHEN c.last_failure > now() - make_interval(secs => $4) "+
+			"THEN c.failures+1 ELSE 1 END, last_failure=now()"+
+			") INSERT INTO login_failure_counts AS c (kind, subject, failures, last_failure) "+
+			"VALUES ('user', $1, 1, now()) ON CONFLICT (kind, subject) DO UPDATE SET "+
+			"failures=CASE WHEN c.last_failure > now() - make_interval(secs => $4) "+
+			"THEN c.failures+1 ELSE 1 END, last_failure=now() "+
+			"RETURNING failures",
+		user, ip, reason, FailureWindow.Seconds(),
+	).Scan(&failures)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to record login failure", "user", user, "ip", ip,
+			"error", err)
+
+		return 0, err
+	}
+
+	t.log(ctx).Info("msg", "recorded login failure", "user", user, "ip", ip, "reason", reason,
+		"failures", failures)
+
+	return failures, nil
+}
+
+// ClearFailures resets the failure counter for the username after a successful login. The counter
+// for the IP address is left alone, so that guessing across many accounts from one address is
+// still slowed down.
+func (t *LoginTable) ClearFailures(ctx context.Context, user string) error {
+	_, err := t.pool.Exec(ctx,
+		"DELETE FROM login_failure_counts WHERE kind='user' AND subject=$1", user)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to clear login failures", "user", user, "error", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Lock locks the admin out until Unlock is called. Nothing happens if the admin does not exist or
+// is already locked.
+func (t *LoginTable) Lock(ctx context.Context, user string) error {
+	tag, err := t.pool.Exec(ctx,
+		"UPDATE admins SET locked_at=now() WHERE username=$1 AND locked_at IS NULL", user)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to lock admin", "user", user, "error", err)
+
+		return err
+	}
+	if tag.RowsAffected() == 1 {
+		t.log(ctx).Info("msg", "locked admin after too many failed logins", "user", user)
+	}
+
+	return nil
+}
+
+// Unlock clears the lockout and failure counter for the admin. ErrNoUser is returned if the admin
+// is not in the database.
+func (t *LoginTable) Unlock(ctx context.Context, user string) error {
+	tag, err := t.pool.Exec(ctx,
+		"WITH cleared AS (DELETE FROM login_failure_counts WHERE kind='user' AND subject=$1) "+
+			"UPDATE admins SET locked_at=NULL WHERE username=$1",
+		user,
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to unlock admin", "user", user, "error", err)
+
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		t.log(ctx).Info("msg", "no user found to unlock", "user", user)
+
+		return ErrNoUser
+	}
+
+	t.log(ctx).Debug("msg", "unlocked admin", "user", user)
+
+	return nil
+}
+
+// ListFailures returns the most recent failed logins, newest first. If user is not empty, only
+// failures for that username are returned.
+func (t *LoginTable) ListFailures(
+	ctx context.Context, user string, limit int,
+) ([]*LoginFailure, error) {
+	rows, err := t.pool.Query(ctx,
+		"SELECT id, username, ip, reason, created_at FROM login_failures "+
+			"WHERE $1='' OR username=$1 ORDER BY id DESC LIMIT $2",
+		user, limit,
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to list login failures", "error", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*LoginFailure
+	for rows.Next() {
+		var f LoginFailure
+		err = rows.Scan(&f.ID, &f.Username, &f.IP, &f.Reason, &f.CreatedAt)
+		if err != nil {
+			t.log(ctx).Error("msg", "failed to scan login failure", "error", err)
+
+			return out, err
+		}
+
+		out = append(out, &f)
+	}
+
+	return out, rows.Err()
+}
@@ -0,0 +1,114 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cobaltspeech/log/pkg/testinglog"
+	"github.com/google/go-cmp/cmp"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestLoginTable(t *testing.T) { //nolint:funlen // testing sequential calls
+	t.Parallel()
+
+	mockDB, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("error opening mock db: %v", err)
+	}
+	defer mockDB.Close()
+
+	logger := testinglog.NewConvenientLogger(t)
+	table := db.NewLoginTable(logger, mockDB)
+	ctx := context.Background()
+	window := db.FailureWindow.Seconds()
+
+	// two failures for John
+	mockDB.ExpectQuery("WITH event AS").
+		WithArgs("john", "10.0.0.1", db.FailureBadPassword, window).
+		WillReturnRows(pgxmock.NewRows([]string{"failures"}).AddRow(1))
+	mockDB.ExpectQuery("WITH event AS").
+		WithArgs("john", "10.0.0.1", db.FailureBadPassword, window).
+		WillReturnRows(pgxmock.NewRows([]string{"failures"}).AddRow(2))
+	for want := 1; want <= 2; want++ {
+		failures, recordErr := table.RecordFailure(ctx, "john", "10.0.0.1", db.FailureBadPassword)
+		if recordErr != nil {
+			t.Errorf("error from RecordFailure: %v", recordErr)
+		}
+		if failures != want {
+			t.Errorf("expected %d failures, got %d", want, failures)
+		}
+	}
+
+	// get the counters
+	last := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	mockDB.ExpectQuery("SELECT kind, failures, last_failure FROM login_failure_counts").
+		WithArgs("john", "10.0.0.1", window).
+		WillReturnRows(pgxmock.NewRows([]string{"kind", "failures", "last_failure"}).
+			AddRow("user", 2, last).
+			AddRow("ip", 5, last))
+	byUser, byIP, err := table.GetFailures(ctx, "john", "10.0.0.1")
+	if err != nil {
+		t.Errorf("error from GetFailures: %v", err)
+	}
+	if diff := cmp.Diff(db.FailureCount{Failures: 2, LastFailure: last}, byUser); diff != "" {
+		t.Error("unexpected user failures (-want +got):\n" + diff)
+	}
+	if diff := cmp.Diff(db.FailureCount{Failures: 5, LastFailure: last}, byIP); diff != "" {
+		t.Error("unexpected IP failures (-want +got):\n" + diff)
+	}
+
+	// lock John out, and then list the failures
+	mockDB.ExpectExec("UPDATE admins SET locked_at=now()").
+		WithArgs("john").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	err = table.Lock(ctx, "john")
+	if err != nil {
+		t.Errorf("error from Lock: %v", err)
+	}
+
+	mockDB.ExpectQuery("SELECT id, username, ip, reason, created_at FROM login_failures").
+		WithArgs("john", 10).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "username", "ip", "reason", "created_at"}).
+			AddRow(int64(2), "john", "10.0.0.1", db.FailureBadPassword, last).
+			AddRow(int64(1), "john", "10.0.0.1", db.FailureBadPassword, last))
+	failures, err := table.ListFailures(ctx, "john", 10)
+	if err != nil {
+		t.Errorf("error from ListFailures: %v", err)
+	}
+	if len(failures) != 2 || failures[0].ID != 2 {
+		t.Errorf("unexpected failures: %+v", failures)
+	}
+
+	// unlock John, but not nonexistent Stephen
+	mockDB.ExpectExec("WITH cleared AS").
+		WithArgs("john").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	err = table.Unlock(ctx, "john")
+	if err != nil {
+		t.Errorf("error from Unlock: %v", err)
+	}
+	mockDB.ExpectExec("WITH cleared AS").
+		WithArgs("stephen").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	err = table.Unlock(ctx, "stephen")
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("unexpected error from Unlock: %v", err)
+	}
+
+	// a successful login clears the counter
+	mockDB.ExpectExec("DELETE FROM login_failure_counts").
+		WithArgs("john").
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	err = table.ClearFailures(ctx, "john")
+	if err != nil {
+		t.Errorf("error from ClearFailures: %v", err)
+	}
+
+	if err = mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,547 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// admin is the stored admin, with the fields that db.Admin leaves out.
+type admin struct {
+	db.Admin
+
+	password      []byte
+	totpSecret    string
+	totpLastStep  *int64
+	recoveryCodes []string
+}
+
+// AdminTable is the in-memory version of db.AdminTable. It handles password hashing, so all `pass`
+// method arguments are expected to be plaintext. Passwords are hashed with the lowest bcrypt cost,
+// since they don't outlive the process.
+type AdminTable struct {
+	logger log.Logger
+	d      *DB
+}
+
+var _ db.AdminStore = (*AdminTable)(nil)
+
+// NewAdminTable creates a new AdminTable in the database.
+func NewAdminTable(l log.Logger, d *DB) *AdminTable {
+	out := AdminTable{
+		logger: l,
+		d:      d,
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (a *AdminTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(a.logger, ctx)
+}
+
+// insert stores a new admin and returns its ID, or returns db.ErrAdminExists if the username is
+// taken. a.d.mu must be held.
+func (a *AdminTable) insert(user string, hashed []byte, role api.Role) (int, error) {
+	if a.d.adminByName(user) != nil {
+		return 0, db.ErrAdminExists
+	}
+
+	a.d.lastAdminID++
+	a.d.admins[a.d.lastAdminID] = &admin{
+		Admin: db.Admin{
+			ID:        a.d.lastAdminID,
+			Username:  user,
+			Role:      role,
+			CreatedAt: now(),
+		},
+		password: hashed,
+	}
+
+	return a.d.lastAdminID, nil
+}
+
+// AddAdmin creates a new admin account with the given role.
+func (a *AdminTable) AddAdmin(ctx context.Context, user, pass string, role api.Role) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.MinCost)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to hash password for new admin", "user", user, "error", err)
+
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	a.d.mu.Lock()
+	_, err = a.insert(user, hashed, role)
+	a.d.mu.Unlock()
+	if err != nil {
+		a.log(ctx).Info("msg", "admin already exists", "user", user)
+
+		return err
+	}
+
+	a.log(ctx).Debug("msg", "stored new admin", "user", user, "role", role)
+
+	return nil
+}
+
+// DeleteAdmin removes an admin account, along with their sessions and Discord link. It does not
+// affect any changes they may have made to the data.
+func (a *AdminTable) DeleteAdmin(ctx context.Context, user string) error {
+	a.d.mu.Lock()
+	found := a.d.adminByName(user)
+	if found != nil {
+		delete(a.d.admins, found.ID)
+		for id, adminID := range a.d.discord {
+			if adminID == found.ID {
+				delete(a.d.discord, id)
+			}
+		}
+		for key, s := range a.d.sessions {
+			if s.adminID == found.ID {
+				delete(a.d.sessions, key)
+			}
+		}
+	}
+	a.d.mu.Unlock()
+
+	if found == nil {
+		a.log(ctx).Info("msg", "no user found to delete", "user", user)
+
+		return db.ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "deleted admin", "user", user)
+
+	return nil
+}
+
+// CheckPassword returns true if the admin exists, is not disabled, and the password matches the
+// hash on file. Otherwise it returns false.
+func (a *AdminTable) CheckPassword(ctx context.Context, user, pass string) (bool, error) {
+	a.d.mu.Lock()
+	found := a.d.adminByName(user)
+	var hashed []byte
+	var disabled bool
+	if found != nil {
+		hashed, disabled = found.password, found.Disabled
+	}
+	a.d.mu.Unlock()
+
+	if found == nil {
+		a.log(ctx).Info("msg", "checked password for nonexistent user", "user", user)
+
+		return false, nil
+	}
+	if disabled {
+		a.log(ctx).Info("msg", "checked password for disabled admin", "user", user)
+
+		return false, nil
+	}
+
+	passed := bcrypt.CompareHashAndPassword(hashed, []byte(pass)) == nil
+
+	if passed {
+		a.log(ctx).Debug("msg", "successful password check", "user", user)
+	} else {
+		a.log(ctx).Debug("msg", "unsuccessful password check", "user", user)
+	}
+
+	return passed, nil
+}
+
+// ChangePassword updates the hashed password for an admin. db.ErrNoUser is returned if the admin
+// is not in the database.
+func (a *AdminTable) ChangePassword(ctx context.Context, user, pass string) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.MinCost)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to hash new password", "user", user, "error", err)
+
+		return err
+	}
+
+	found := a.update(user, func(found *admin) { found.password = hashed })
+	if !found {
+		a.log(ctx).Info("msg", "no user found to update password", "user", user)
+
+		return db.ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "password updated", "user", user)
+
+	return nil
+}
+
+// ResetPassword changes the password of the admin and revokes every session of theirs except the
+// one with the key, which may be empty to revoke them all, at once. db.ErrNoUser is returned if the
+// admin is not in the database.
+func (a *AdminTable) ResetPassword(ctx context.Context, user, pass, keep string) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.MinCost)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to hash new password", "user", user, "error", err)
+
+		return err
+	}
+
+	var revoked int
+	found := a.update(user, func(found *admin) {
+		found.password = hashed
+		for key, s := range a.d.sessions {
+			if s.adminID == found.ID && key != keep {
+				delete(a.d.sessions, key)
+				revoked++
+			}
+		}
+	})
+	if !found {
+		a.log(ctx).Info("msg", "no user found to update password", "user", user)
+
+		return db.ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "password reset", "user", user, "revoked", revoked)
+
+	return nil
+}
+
+// update calls f with the admin while holding the lock, and returns false if the admin is not in
+// the database.
+func (a *AdminTable) update(user string, f func(found *admin)) bool {
+	a.d.mu.Lock()
+	defer a.d.mu.Unlock()
+
+	found := a.d.adminByName(user)
+	if found == nil {
+		return false
+	}
+	f(found)
+
+	return true
+}
+
+// copyAdmin returns a copy of the public fields of the admin.
+func copyAdmin(found *admin) *db.Admin {
+	out := found.Admin
+	if found.LastLogin != nil {
+		t := *found.LastLogin
+		out.LastLogin = &t
+	}
+	if found.LockedAt != nil {
+		t := *found.LockedAt
+		out.LockedAt = &t
+	}
+
+	return &out
+}
+
+// ListAdmins returns every admin account, ordered by ID.
+func (a *AdminTable) ListAdmins(ctx context.Context) ([]*db.Admin, error) {
+	a.d.mu.Lock()
+	out := make([]*db.Admin, 0, len(a.d.admins))
+	for _, found := range a.d.admins {
+		out = append(out, copyAdmin(found))
+	}
+	a.d.mu.Unlock()
+
+	slices.SortFunc(out, func(x, y *db.Admin) int { return x.ID - y.ID })
+
+	a.log(ctx).Debug("msg", "listed admins", "count", len(out))
+
+	return out, nil
+}
+
+// GetAdmin returns the admin by username. db.ErrNoUser is returned if the admin is not in the
+// database.
+func (a *AdminTable) GetAdmin(ctx context.Context, user string) (*db.Admin, error) {
+	a.d.mu.Lock()
+	found := a.d.adminByName(user)
+	var out *db.Admin
+	if found != nil {
+		out = copyAdmin(found)
+	}
+	a.d.mu.Unlock()
+
+	if out == nil {
+		a.log(ctx).Info("msg", "admin not in database", "username", user)
+
+		return nil, db.ErrNoUser
+	}
+
+	return out, nil
+}
+
+// GetAdminByID returns the admin by ID. db.ErrNoUser is returned if the admin is not in the
+// database.
+func (a *AdminTable) GetAdminByID(ctx context.Context, id int) (*db.Admin, error) {
+	a.d.mu.Lock()
+	found, ok := a.d.admins[id]
+	var out *db.Admin
+	if ok {
+		out = copyAdmin(found)
+	}
+	a.d.mu.Unlock()
+
+	if out == nil {
+		a.log(ctx).Info("msg", "admin not in database", "id", id)
+
+		return nil, db.ErrNoUser
+	}
+
+	return out, nil
+}
+
+// RecordLogin sets the last login time of the admin to now.
+func (a *AdminTable) RecordLogin(_ context.Context, user string) error {
+	a.update(user, func(found *admin) {
+		t := now()
+		found.LastLogin = &t
+	})
+
+	return nil
+}
+
+// SetDisabled disables or enables an admin. db.ErrNoUser is returned if the admin is not in the
+// database.
+func (a *AdminTable) SetDisabled(ctx context.Context, user string, disabled bool) error {
+	if !a.update(user, func(found *admin) { found.Disabled = disabled }) {
+		a.log(ctx).Info("msg", "no user found to set disabled", "user", user)
+
+		return db.ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "set admin disabled", "user", user, "disabled", disabled)
+
+	return nil
+}
+
+// PatchAdmin changes the role of an admin and disables or enables them at once, and returns the
+// updated admin. Nil fields of the patch are not changed. db.ErrNoUser is returned if the admin is
+// not in the database.
+func (a *AdminTable) PatchAdmin(
+	ctx context.Context, user string, patch api.AdminPatch,
+) (*db.Admin, error) {
+	var out *db.Admin
+	found := a.update(user, func(found *admin) {
+		if patch.Role != nil {
+			found.Role = *patch.Role
+		}
+		if patch.Disabled != nil {
+			found.Disabled = *patch.Disabled
+		}
+		out = copyAdmin(found)
+	})
+	if !found {
+		a.log(ctx).Info("msg", "no user found to patch", "user", user)
+
+		return nil, db.ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "patched admin", "user", user, "role", out.Role,
+		"disabled", out.Disabled)
+
+	return out, nil
+}
+
+// SetRole changes the role of an admin. db.ErrNoUser is returned if the admin is not in the
+// database.
+func (a *AdminTable) SetRole(ctx context.Context, user string, role api.Role) error {
+	if !a.update(user, func(found *admin) { found.Role = role }) {
+		a.log(ctx).Info("msg", "no user found to set role", "user", user)
+
+		return db.ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "set admin role", "user", user, "role", role)
+
+	return nil
+}
+
+// GetTOTP returns the two-factor authentication state of the admin. db.ErrNoUser is returned if
+// the admin is not in the database.
+func (a *AdminTable) GetTOTP(ctx context.Context, user string) (*db.TOTP, error) {
+	var out db.TOTP
+	found := a.update(user, func(found *admin) {
+		out = db.TOTP{Secret: found.totpSecret, Enabled: found.TOTPEnabled}
+	})
+	if !found {
+		a.log(ctx).Info("msg", "no user found to get TOTP", "user", user)
+
+		return nil, db.ErrNoUser
+	}
+
+	return &out, nil
+}
+
+// StartTOTP stores a new encrypted secret for the admin, to be enabled by EnableTOTP.
+// db.ErrTOTPEnabled is returned if two-factor authentication is already enabled.
+func (a *AdminTable) StartTOTP(ctx context.Context, user, secret string) error {
+	var started bool
+	a.update(user, func(found *admin) {
+		if found.TOTPEnabled {
+			return
+		}
+		found.totpSecret, found.totpLastStep, found.recoveryCodes = secret, nil, nil
+		started = true
+	})
+	if !started {
+		a.log(ctx).Info("msg", "TOTP already enabled or no user found", "user", user)
+
+		return db.ErrTOTPEnabled
+	}
+
+	a.log(ctx).Debug("msg", "started TOTP enrollment", "user", user)
+
+	return nil
+}
+
+// EnableTOTP turns on two-factor authentication for the admin with the pending secret, and stores
+// the hashes of the recovery codes.
+func (a *AdminTable) EnableTOTP(ctx context.Context, user string, recoveryHashes []string) error {
+	var enabled bool
+	a.update(user, func(found *admin) {
+		if found.totpSecret == "" {
+			return
+		}
+		found.TOTPEnabled = true
+		found.recoveryCodes = slices.Clone(recoveryHashes)
+		enabled = true
+	})
+	if !enabled {
+		a.log(ctx).Info("msg", "no pending TOTP enrollment", "user", user)
+
+		return db.ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "enabled TOTP", "user", user)
+
+	return nil
+}
+
+// UseTOTPStep records that a code for the time step was accepted. It returns false if a code for
+// this step or a later one was already accepted, so that codes can't be replayed.
+func (a *AdminTable) UseTOTPStep(ctx context.Context, user string, step int64) (bool, error) {
+	var used bool
+	a.update(user, func(found *admin) {
+		if found.totpLastStep != nil && *found.totpLastStep >= step {
+			return
+		}
+		found.totpLastStep = &step
+		used = true
+	})
+	if !used {
+		a.log(ctx).Info("msg", "TOTP code reused", "user", user)
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// UseRecoveryCode removes the recovery code hash from the admin's unused codes. It returns false if
+// the hash was not one of them.
+func (a *AdminTable) UseRecoveryCode(ctx context.Context, user, hash string) (bool, error) {
+	var used bool
+	a.update(user, func(found *admin) {
+		i := slices.Index(found.recoveryCodes, hash)
+		if i < 0 {
+			return
+		}
+		found.recoveryCodes = slices.Delete(found.recoveryCodes, i, i+1)
+		used = true
+	})
+	if !used {
+		a.log(ctx).Info("msg", "invalid recovery code", "user", user)
+
+		return false, nil
+	}
+
+	a.log(ctx).Info("msg", "used recovery code", "user", user)
+
+	return true, nil
+}
+
+// ResetTOTP turns off two-factor authentication for the admin and forgets the secret and recovery
+// codes. db.ErrNoUser is returned if the admin is not in the database.
+func (a *AdminTable) ResetTOTP(ctx context.Context, user string) error {
+	found := a.update(user, func(found *admin) {
+		found.TOTPEnabled = false
+		found.totpSecret, found.totpLastStep, found.recoveryCodes = "", nil, nil
+	})
+	if !found {
+		a.log(ctx).Info("msg", "no user found to reset TOTP", "user", user)
+
+		return db.ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "reset TOTP", "user", user)
+
+	return nil
+}
+
+// GetDiscordAdmin returns the admin linked to the Discord user ID. db.ErrNoUser is returned if no
+// admin is linked to it.
+func (a *AdminTable) GetDiscordAdmin(ctx context.Context, discordID string) (*db.Admin, error) {
+	a.d.mu.Lock()
+	var out *db.Admin
+	if found, ok := a.d.admins[a.d.discord[discordID]]; ok {
+		out = copyAdmin(found)
+	}
+	a.d.mu.Unlock()
+
+	if out == nil {
+		a.log(ctx).Info("msg", "no admin linked to Discord user", "discordID", discordID)
+
+		return nil, db.ErrNoUser
+	}
+
+	return out, nil
+}
+
+// AddDiscordAdmin creates a new admin linked to the Discord user ID, with a random password.
+// db.ErrAdminExists is returned if the username is taken or the Discord user is already linked.
+func (a *AdminTable) AddDiscordAdmin(
+	ctx context.Context, discordID, user string, role api.Role,
+) error {
+	pass, err := newPassword()
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to generate password for new admin", "user", user,
+			"error", err)
+
+		return err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.MinCost)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to hash password for new admin", "user", user, "error", err)
+
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	a.d.mu.Lock()
+	_, linked := a.d.discord[discordID]
+	if linked {
+		err = db.ErrAdminExists
+	} else {
+		var id int
+		id, err = a.insert(user, hashed, role)
+		if err == nil {
+			a.d.discord[discordID] = id
+		}
+	}
+	a.d.mu.Unlock()
+	if err != nil {
+		a.log(ctx).Info("msg", "admin already exists", "user", user, "discordID", discordID)
+
+		return err
+	}
+
+	a.log(ctx).Debug("msg", "stored new Discord admin", "user", user, "discordID", discordID,
+		"role", role)
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+)
+
+// failureKey identifies a failure counter, which is either for a username or an IP address.
+type failureKey struct {
+	kind    string // "user" or "ip"
+	subject string
+}
+
+// LoginTable is the in-memory version of db.LoginTable.
+type LoginTable struct {
+	logger log.Logger
+	d      *DB
+}
+
+var _ db.LoginStore = (*LoginTable)(nil)
+
+// NewLoginTable creates a new LoginTable in the database.
+func NewLoginTable(l log.Logger, d *DB) *LoginTable {
+	out := LoginTable{
+		logger: l,
+		d:      d,
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (t *LoginTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(t.logger, ctx)
+}
+
+// GetFailures returns the recent failed logins for the username and for the IP address.
+func (t *LoginTable) GetFailures(
+	_ context.Context, user, ip string,
+) (byUser, byIP db.FailureCount, err error) {
+	windowStart := now().Add(-db.FailureWindow)
+
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+
+	if c, ok := t.d.counts[failureKey{"user", user}]; ok && c.LastFailure.After(windowStart) {
+		byUser = *c
+	}
+	if c, ok := t.d.counts[failureKey{"ip", ip}]; ok && c.LastFailure.After(windowStart) {
+		byIP = *c
+	}
+
+	return byUser, byIP, nil
+}
+
+// countFailure increments a failure counter, starting again from one if the last failure is older
+// than db.FailureWindow. t.d.mu must be held.
+func (t *LoginTable) countFailure(k failureKey) int {
+	failed := now()
+
+	c, ok := t.d.counts[k]
+	if !ok || !c.LastFailure.After(failed.Add(-db.FailureWindow)) {
+		c = &db.FailureCount{}
+		t.d.counts[k] = c
+	}
+	c.Failures++
+	c.LastFailure = failed
+
+	return c.Failures
+}
+
+// RecordFailure stores a failed login event and increments the failure counters for the username
+// and IP address. It returns the new number of recent failures for the username.
+func (t *LoginTable) RecordFailure(ctx context.Context, user, ip, reason string) (int, error) {
+	t.d.mu.Lock()
+	t.d.lastFailureID++
+	t.d.failures = append(t.d.failures, &db.LoginFailure{
+		ID:        t.d.lastFailureID,
+		Username:  user,
+		IP:        ip,
+		Reason:    reason,
+		CreatedAt: now(),
+	})
+	t.countFailure(failureKey{"ip", ip})
+	failures := t.countFailure(failureKey{"user", user})
+	t.d.mu.Unlock()
+
+	t.log(ctx).Info("msg", "recorded login failure", "user", user, "ip", ip, "reason", reason,
+		"failures", failures)
+
+	return failures, nil
+}
+
+// ClearFailures resets the failure counter for the username after a successful login. The counter
+// for the IP address is left alone.
+func (t *LoginTable) ClearFailures(_ context.Context, user string) error {
+	t.d.mu.Lock()
+	delete(t.d.counts, failureKey{"user", user})
+	t.d.mu.Unlock()
+
+	return nil
+}
+
+// Lock locks the admin out until Unlock is called. Nothing happens if the admin does not exist or
+// is already locked.
+func (t *LoginTable) Lock(ctx context.Context, user string) error {
+	t.d.mu.Lock()
+	a := t.d.adminByName(user)
+	locked := a != nil && a.LockedAt == nil
+	if locked {
+		lockedAt := now()
+		a.LockedAt = &lockedAt
+	}
+	t.d.mu.Unlock()
+
+	if locked {
+		t.log(ctx).Info("msg", "locked admin after too many failed logins", "user", user)
+	}
+
+	return nil
+}
+
+// Unlock clears the lockout and failure counter for the admin. db.ErrNoUser is returned if the
+// admin is not in the database.
+func (t *LoginTable) Unlock(ctx context.Context, user string) error {
+	t.d.mu.Lock()
+	delete(t.d.counts, failureKey{"user", user})
+	a := t.d.adminByName(user)
+	if a != nil {
+		a.LockedAt = nil
+	}
+	t.d.mu.Unlock()
+
+	if a == nil {
+		t.log(ctx).Info("msg", "no user found to unlock", "user", user)
+
+		return db.ErrNoUser
+	}
+
+	t.log(ctx).Debug("msg", "unlocked admin", "user", user)
+
+	return nil
+}
+
+// ListFailures returns the most recent failed logins, newest first. If user is not empty, only
+// failures for that username are returned.
+func (t *LoginTable) ListFailures(
+	_ context.Context, user string, limit int,
+) ([]*db.LoginFailure, error) {
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+
+	var out []*db.LoginFailure
+	for i := len(t.d.failures) - 1; i >= 0 && len(out) < limit; i-- {
+		f := *t.d.failures[i]
+		if user == "" || f.Username == user {
+			out = append(out, &f)
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,98 @@
+// Package memory stores users, admins, and sessions in memory, for tests that shouldn't need a
+// database server. The tables have the same methods and errors as the Postgres tables in package
+// db, and log the same messages. Everything is lost when the process exits.
+package memory
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/kylrth/disco-bouncer/internal/db"
+)
+
+// DB holds the contents of every table. The tables share it so that, as in the SQL databases,
+// deleting an admin also ends their sessions.
+type DB struct {
+	mu sync.Mutex
+
+	users      map[int]*db.User
+	lastUserID int
+
+	admins      map[int]*admin
+	lastAdminID int
+	discord     map[string]int // admin IDs by Discord user ID
+
+	sessions      map[string]*session // by session key
+	lastSessionID int
+
+	counts        map[failureKey]*db.FailureCount
+	failures      []*db.LoginFailure
+	lastFailureID int64
+
+	data map[string]entry // for SessionStorage
+}
+
+// New creates an empty database.
+func New() *DB {
+	return &DB{
+		users:    make(map[int]*db.User),
+		admins:   make(map[int]*admin),
+		discord:  make(map[string]int),
+		sessions: make(map[string]*session),
+		counts:   make(map[failureKey]*db.FailureCount),
+		data:     make(map[string]entry),
+	}
+}
+
+// adminByName returns the admin with the username, or nil. d.mu must be held.
+func (d *DB) adminByName(user string) *admin {
+	for _, a := range d.admins {
+		if a.Username == user {
+			return a
+		}
+	}
+
+	return nil
+}
+
+// These are the constraints on the users table in the SQL databases.
+var userConstraints = []struct {
+	name  string
+	check func(u *db.User) bool
+}{
+	{"users_name_ciphertext", func(u *db.User) bool {
+		return nameCiphertext.MatchString(u.Name)
+	}},
+	{"users_name_key_hash_hex", func(u *db.User) bool {
+		return keyHashHex.MatchString(u.NameKeyHash)
+	}},
+	{"users_finish_year_format", func(u *db.User) bool {
+		return u.FinishYear == "" || finishYear.MatchString(u.FinishYear)
+	}},
+}
+
+var (
+	nameCiphertext = regexp.MustCompile(`^([0-9a-fA-F]{2}){28,}$`)
+	keyHashHex     = regexp.MustCompile(`^([0-9a-fA-F]{2})+$`)
+	finishYear     = regexp.MustCompile(`^[0-9]{4}`)
+)
+
+// newPassword returns a random password that nobody knows.
+func newPassword() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// now returns the current time in UTC, without the monotonic clock reading, like times read back
+// from a database.
+func now() time.Time {
+	return time.Now().UTC().Round(0)
+}
@@ -0,0 +1,173 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+)
+
+// session is a stored session, with the admin it belongs to.
+type session struct {
+	db.Session
+
+	adminID int
+}
+
+// SessionTable is the in-memory version of db.SessionTable. The session data itself is kept by
+// SessionStorage.
+type SessionTable struct {
+	logger log.Logger
+	d      *DB
+}
+
+var _ db.SessionStore = (*SessionTable)(nil)
+
+// NewSessionTable creates a new SessionTable in the database.
+func NewSessionTable(l log.Logger, d *DB) *SessionTable {
+	out := SessionTable{
+		logger: l,
+		d:      d,
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (t *SessionTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(t.logger, ctx)
+}
+
+// CreateSession starts tracking a new session for the admin.
+func (t *SessionTable) CreateSession(
+	_ context.Context, key string, adminID int, ip, userAgent string,
+) error {
+	created := now()
+
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+
+	t.d.lastSessionID++
+	t.d.sessions[key] = &session{
+		Session: db.Session{
+			ID:        t.d.lastSessionID,
+			Key:       key,
+			CreatedAt: created,
+			LastSeen:  created,
+			IP:        ip,
+			UserAgent: userAgent,
+		},
+		adminID: adminID,
+	}
+
+	return nil
+}
+
+// TouchSession records that the session was used from the IP address. It returns false if the
+// session does not belong to the admin, was revoked, has been idle for longer than idle, or was
+// created more than maxAge ago.
+func (t *SessionTable) TouchSession(
+	_ context.Context, key string, adminID int, ip string, idle, maxAge time.Duration,
+) (bool, error) {
+	seen := now()
+
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+
+	s, ok := t.d.sessions[key]
+	if !ok || s.adminID != adminID || !s.LastSeen.After(seen.Add(-idle)) ||
+		!s.CreatedAt.After(seen.Add(-maxAge)) {
+		return false, nil
+	}
+	s.LastSeen, s.IP = seen, ip
+
+	return true, nil
+}
+
+// DeleteSession stops tracking the session. Nothing happens if the session does not exist.
+func (t *SessionTable) DeleteSession(_ context.Context, key string) error {
+	t.d.mu.Lock()
+	delete(t.d.sessions, key)
+	t.d.mu.Unlock()
+
+	return nil
+}
+
+// sessionsOf returns the sessions of the admin. t.d.mu must be held.
+func (t *SessionTable) sessionsOf(user string) []*session {
+	a := t.d.adminByName(user)
+	if a == nil {
+		return nil
+	}
+
+	var out []*session
+	for _, s := range t.d.sessions {
+		if s.adminID == a.ID {
+			out = append(out, s)
+		}
+	}
+
+	return out
+}
+
+// ListSessions returns the sessions of the admin, most recently used first.
+func (t *SessionTable) ListSessions(ctx context.Context, user string) ([]*db.Session, error) {
+	t.d.mu.Lock()
+	var out []*db.Session
+	for _, s := range t.sessionsOf(user) {
+		c := s.Session
+		out = append(out, &c)
+	}
+	t.d.mu.Unlock()
+
+	slices.SortFunc(out, func(a, b *db.Session) int { return b.LastSeen.Compare(a.LastSeen) })
+
+	t.log(ctx).Debug("msg", "listed sessions", "user", user, "count", len(out))
+
+	return out, nil
+}
+
+// RevokeSession revokes the admin's session by ID. db.ErrNoSession is returned if the admin has no
+// session with that ID.
+func (t *SessionTable) RevokeSession(ctx context.Context, user string, id int) error {
+	t.d.mu.Lock()
+	var found bool
+	for _, s := range t.sessionsOf(user) {
+		if s.ID == id {
+			delete(t.d.sessions, s.Key)
+			found = true
+		}
+	}
+	t.d.mu.Unlock()
+
+	if !found {
+		t.log(ctx).Info("msg", "no session found to revoke", "user", user, "id", id)
+
+		return db.ErrNoSession
+	}
+
+	t.log(ctx).Debug("msg", "revoked session", "user", user, "id", id)
+
+	return nil
+}
+
+// RevokeOtherSessions revokes every session of the admin except the one with the key, which may be
+// empty to revoke them all.
+func (t *SessionTable) RevokeOtherSessions(ctx context.Context, user, keep string) error {
+	t.d.mu.Lock()
+	var n int
+	for _, s := range t.sessionsOf(user) {
+		if s.Key != keep {
+			delete(t.d.sessions, s.Key)
+			n++
+		}
+	}
+	t.d.mu.Unlock()
+
+	t.log(ctx).Debug("msg", "revoked sessions", "user", user, "count", n)
+
+	return nil
+}
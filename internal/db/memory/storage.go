@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// entry is a value in SessionStorage. expires is zero if the value doesn't expire.
+type entry struct {
+	value   []byte
+	expires time.Time
+}
+
+// SessionStorage keeps the session data of the session middleware in the database.
+type SessionStorage struct {
+	d *DB
+}
+
+var _ fiber.Storage = (*SessionStorage)(nil)
+
+// NewSessionStorage creates a SessionStorage in the database.
+func NewSessionStorage(d *DB) *SessionStorage {
+	return &SessionStorage{d: d}
+}
+
+// Get returns the value for the key, or nil if there is none or it expired.
+func (s *SessionStorage) Get(key string) ([]byte, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	e, ok := s.d.data[key]
+	if !ok || (!e.expires.IsZero() && !time.Now().Before(e.expires)) {
+		return nil, nil
+	}
+
+	return bytes.Clone(e.value), nil
+}
+
+// Set stores the value for the key. If exp is not zero, the value expires after exp. Expired
+// values are deleted here, since sessions are set on every login.
+func (s *SessionStorage) Set(key string, val []byte, exp time.Duration) error {
+	if key == "" || len(val) == 0 {
+		return nil
+	}
+
+	t := time.Now()
+	e := entry{value: bytes.Clone(val)}
+	if exp != 0 {
+		e.expires = t.Add(exp)
+	}
+
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+
+	for k, old := range s.d.data {
+		if !old.expires.IsZero() && !t.Before(old.expires) {
+			delete(s.d.data, k)
+		}
+	}
+	s.d.data[key] = e
+
+	return nil
+}
+
+// Delete removes the value for the key.
+func (s *SessionStorage) Delete(key string) error {
+	s.d.mu.Lock()
+	delete(s.d.data, key)
+	s.d.mu.Unlock()
+
+	return nil
+}
+
+// Reset removes every value.
+func (s *SessionStorage) Reset() error {
+	s.d.mu.Lock()
+	clear(s.d.data)
+	s.d.mu.Unlock()
+
+	return nil
+}
+
+// Close does nothing.
+func (s *SessionStorage) Close() error {
+	return nil
+}
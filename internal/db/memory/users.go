@@ -0,0 +1,262 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+)
+
+// UserTable is the in-memory version of db.UserTable.
+type UserTable struct {
+	logger log.Logger
+	d      *DB
+}
+
+var _ db.UserStore = (*UserTable)(nil)
+
+// NewUserTable creates a new UserTable in the database.
+func NewUserTable(l log.Logger, d *DB) *UserTable {
+	out := UserTable{
+		logger: l,
+		d:      d,
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (t *UserTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(t.logger, ctx)
+}
+
+// validate returns db.ErrInvalidUser if u violates a constraint of the users table.
+func validate(u *db.User) error {
+	for _, c := range userConstraints {
+		if !c.check(u) {
+			return fmt.Errorf("%w: violates %s", db.ErrInvalidUser, c.name)
+		}
+	}
+
+	return nil
+}
+
+// GetUsers returns all users in the database, possibly filtered by the provided options.
+func (t *UserTable) GetUsers(ctx context.Context, opts ...db.FilterOption) ([]*db.User, error) {
+	f := db.ReadFilters(opts)
+
+	t.d.mu.Lock()
+	var out []*db.User
+	for _, u := range t.d.users {
+		if f.KeyHash != "" && u.NameKeyHash != f.KeyHash {
+			continue
+		}
+		if u.ID <= f.AfterID {
+			continue
+		}
+
+		c := *u
+		out = append(out, &c)
+	}
+	t.d.mu.Unlock()
+
+	slices.SortFunc(out, func(a, b *db.User) int { return a.ID - b.ID })
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[:f.Limit]
+	}
+
+	logInfo := []any{"msg", "got all users", "count", len(out)}
+	logInfo = append(logInfo, f.LogInfo()...)
+	t.log(ctx).Debug(logInfo...)
+
+	return out, nil
+}
+
+// GetUser returns the user by ID, if present. If not present, db.ErrNoUser is returned.
+func (t *UserTable) GetUser(ctx context.Context, id int) (*db.User, error) {
+	t.d.mu.Lock()
+	u, ok := t.d.users[id]
+	var out db.User
+	if ok {
+		out = *u
+	}
+	t.d.mu.Unlock()
+
+	if !ok {
+		t.log(ctx).Info("msg", "user not in database", "id", id)
+
+		return &db.User{ID: id}, db.ErrNoUser
+	}
+
+	t.log(ctx).Debug("msg", "found user info", "id", id)
+
+	return &out, nil
+}
+
+// CreateUser creates a new user (ignoring the ID and Version fields) and returns the new ID.
+func (t *UserTable) CreateUser(ctx context.Context, u *db.User) (int, error) {
+	err := validate(u)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to create user", "error", err)
+
+		return 0, err
+	}
+
+	t.d.mu.Lock()
+	t.d.lastUserID++
+	stored := *u
+	stored.ID = t.d.lastUserID
+	stored.Version = 1
+	t.d.users[stored.ID] = &stored
+	t.d.mu.Unlock()
+
+	t.log(ctx).Debug("msg", "created new user", "id", stored.ID)
+
+	return stored.ID, nil
+}
+
+// write finds the user to change, checking the version in w. t.d.mu must be held.
+func (t *UserTable) write(
+	ctx context.Context, action string, id int, w db.WriteOptions,
+) (*db.User, error) {
+	u, ok := t.d.users[id]
+	if !ok {
+		t.log(ctx).Info("msg", "no matching user to "+action, "id", id)
+
+		return nil, db.ErrNoUser
+	}
+	if w.Version > 0 && u.Version != w.Version {
+		t.log(ctx).Info("msg", "user version mismatch", "id", id, "action", action,
+			"expected", w.Version, "current", u.Version)
+
+		return nil, db.ErrVersionMismatch
+	}
+
+	return u, nil
+}
+
+// UpdateUser inserts the information in u into the row identified by u.ID, and sets u.Version to
+// the new version of the row. If that row does not exist, db.ErrNoUser is returned.
+func (t *UserTable) UpdateUser(ctx context.Context, u *db.User, opts ...db.WriteOption) error {
+	w := db.ReadWriteOptions(opts)
+
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+
+	current, err := t.write(ctx, "update", u.ID, w)
+	if err != nil {
+		return err
+	}
+	err = validate(u)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to update user", "id", u.ID, "error", err)
+
+		return err
+	}
+
+	stored := *u
+	stored.Version = current.Version + 1
+	t.d.users[u.ID] = &stored
+	u.Version = stored.Version
+
+	t.log(ctx).Debug("msg", "updated user", "id", u.ID)
+
+	return nil
+}
+
+// PatchUser changes only the fields set in p for the user by ID, and returns the updated user. If
+// the user does not exist, db.ErrNoUser is returned.
+func (t *UserTable) PatchUser(
+	ctx context.Context, id int, p *db.UserPatch, opts ...db.WriteOption,
+) (*db.User, error) {
+	w := db.ReadWriteOptions(opts)
+
+	columns, _ := p.Columns()
+	if len(columns) == 0 {
+		return nil, db.ErrEmptyPatch
+	}
+
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+
+	current, err := t.write(ctx, "patch", id, w)
+	if err != nil {
+		return nil, err
+	}
+
+	u := *current
+	apply(&u, p)
+	err = validate(&u)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to patch user", "id", id, "error", err)
+
+		return nil, err
+	}
+	u.Version++
+	stored := u
+	t.d.users[id] = &stored
+
+	t.log(ctx).Debug("msg", "patched user", "id", id, "fields", strings.Join(columns, ","))
+
+	return &u, nil
+}
+
+// apply sets the fields of u that are set in p.
+func apply(u *db.User, p *db.UserPatch) {
+	for _, s := range []struct {
+		from *string
+		to   *string
+	}{{p.Name, &u.Name}, {p.NameKeyHash, &u.NameKeyHash}, {p.FinishYear, &u.FinishYear}} {
+		if s.from != nil {
+			*s.to = *s.from
+		}
+	}
+	for _, b := range []struct {
+		from *bool
+		to   *bool
+	}{
+		{p.Professor, &u.Professor}, {p.TA, &u.TA}, {p.StudentLeadership, &u.StudentLeadership},
+		{p.AlumniBoard, &u.AlumniBoard},
+	} {
+		if b.from != nil {
+			*b.to = *b.from
+		}
+	}
+}
+
+// DeleteUser removes the user by ID. If the user did not exist, returns db.ErrNoUser.
+func (t *UserTable) DeleteUser(ctx context.Context, id int, opts ...db.WriteOption) error {
+	w := db.ReadWriteOptions(opts)
+
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+
+	_, err := t.write(ctx, "delete", id, w)
+	if err != nil {
+		return err
+	}
+	delete(t.d.users, id)
+
+	t.log(ctx).Debug("msg", "deleted user", "id", id)
+
+	return nil
+}
+
+// CountByYear returns the number of users for each finish year.
+func (t *UserTable) CountByYear(ctx context.Context) (map[string]int, error) {
+	out := make(map[string]int)
+
+	t.d.mu.Lock()
+	for _, u := range t.d.users {
+		out[u.FinishYear]++
+	}
+	t.d.mu.Unlock()
+
+	t.log(ctx).Debug("msg", "counted users by year", "years", len(out))
+
+	return out, nil
+}
@@ -1,12 +1,16 @@
 package db
 
 import (
+	"context"
 	"embed"
 	"errors"
 	"fmt"
+	"io/fs"
 
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5"
 )
 
 //go:embed migrations
@@ -14,6 +18,72 @@ var migrationsFS embed.FS
 
 // ApplyMigrations ensures all migrations are applied to the database at dbURI.
 func ApplyMigrations(dbURI string) error {
+	return runMigrations(dbURI, func(m *migrate.Migrate, _ source.Driver) error {
+		return m.Up()
+	})
+}
+
+// MigrateTo migrates the database at dbURI up or down to the version. Version 0 removes every
+// table.
+func MigrateTo(dbURI string, version int) error {
+	latest, err := LatestMigration()
+	if err != nil {
+		return err
+	}
+	if version < 0 || version > latest {
+		return fmt.Errorf("%w: %d is not between 0 and %d", ErrNoMigration, version, latest)
+	}
+
+	return runMigrations(dbURI, func(m *migrate.Migrate, _ source.Driver) error {
+		return migrateTo(m, uint(version)) //nolint:gosec // The version is checked above.
+	})
+}
+
+func migrateTo(m *migrate.Migrate, version uint) error {
+	if version == 0 {
+		return m.Down()
+	}
+
+	return m.Migrate(version)
+}
+
+// MigrateDown reverts the newest n migrations applied to the database at dbURI.
+func MigrateDown(dbURI string, n int) error {
+	if n <= 0 {
+		return fmt.Errorf("%w: can't revert %d migrations", ErrNoMigration, n)
+	}
+
+	return runMigrations(dbURI, func(m *migrate.Migrate, d source.Driver) error {
+		// The target is found first, because Steps reverts everything before finding out that there
+		// are fewer than n migrations.
+		v, _, err := m.Version()
+		if errors.Is(err, migrate.ErrNilVersion) {
+			return fmt.Errorf("%w: no migrations are applied", ErrNoMigration)
+		}
+		if err != nil {
+			return err
+		}
+
+		target := v
+		for i := range n {
+			target, err = d.Prev(target)
+			if errors.Is(err, fs.ErrNotExist) && i == n-1 {
+				target = 0
+			} else if errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("%w: only %d migrations are applied", ErrNoMigration, i+1)
+			} else if err != nil {
+				return fmt.Errorf("read migrations: %w", err)
+			}
+		}
+
+		return migrateTo(m, target)
+	})
+}
+
+// ErrNoMigration is returned when asked to migrate to a version that does not exist.
+var ErrNoMigration = errors.New("no such migration")
+
+func runMigrations(dbURI string, f func(m *migrate.Migrate, d source.Driver) error) error {
 	d, err := iofs.New(migrationsFS, "migrations")
 	if err != nil {
 		return fmt.Errorf("load migrations: %w", err)
@@ -23,11 +93,47 @@ func ApplyMigrations(dbURI string) error {
 	if err != nil {
 		return fmt.Errorf("connect: %w", err)
 	}
+	defer m.Close()
 
-	err = m.Up()
+	err = f(m, d)
 	if errors.Is(err, migrate.ErrNoChange) {
 		return nil
 	}
 
 	return err
 }
+
+// LatestMigration returns the version of the newest migration, which ApplyMigrations brings the
+// database to.
+func LatestMigration() (int, error) {
+	d, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return 0, fmt.Errorf("load migrations: %w", err)
+	}
+
+	v, err := d.First()
+	for err == nil {
+		var next uint
+		next, err = d.Next(v)
+		if err == nil {
+			v = next
+		}
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("read migrations: %w", err)
+	}
+
+	return int(v), nil //nolint:gosec // Migration versions are small.
+}
+
+// MigrationVersion returns the migration the database is at, and whether a migration failed
+// partway through.
+func MigrationVersion(ctx context.Context, pool querier) (version int, dirty bool, err error) {
+	err = pool.QueryRow(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").
+		Scan(&version, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
@@ -0,0 +1,4 @@
+ALTER TABLE users
+    DROP CONSTRAINT users_name_ciphertext,
+    DROP CONSTRAINT users_name_key_hash_hex,
+    DROP CONSTRAINT users_finish_year_format;
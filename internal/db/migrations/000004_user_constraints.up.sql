@@ -0,0 +1,10 @@
+-- These match the validation done by the server before storing users. They are NOT VALID so that
+-- rows stored before validation existed don't block the migration; new and updated rows are still
+-- checked.
+ALTER TABLE users
+    ADD CONSTRAINT users_name_ciphertext
+        CHECK (name ~ '^([0-9a-fA-F]{2}){28,}$') NOT VALID,
+    ADD CONSTRAINT users_name_key_hash_hex
+        CHECK (name_key_hash ~ '^([0-9a-fA-F]{2})+$') NOT VALID,
+    ADD CONSTRAINT users_finish_year_format
+        CHECK (finish_year = '' OR finish_year ~ '^[0-9]{4}') NOT VALID;
@@ -0,0 +1,3 @@
+-- Existing admins keep full access.
+ALTER TABLE admins ADD COLUMN role TEXT NOT NULL DEFAULT 'owner'
+    CONSTRAINT admins_role_known CHECK (role IN ('viewer', 'uploader', 'migrator', 'owner'));
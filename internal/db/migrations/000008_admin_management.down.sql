@@ -0,0 +1,4 @@
+ALTER TABLE admins
+    DROP COLUMN disabled,
+    DROP COLUMN created_at,
+    DROP COLUMN last_login;
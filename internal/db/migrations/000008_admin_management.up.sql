@@ -0,0 +1,4 @@
+ALTER TABLE admins
+    ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN last_login TIMESTAMPTZ;
@@ -0,0 +1,5 @@
+ALTER TABLE admins
+    DROP COLUMN totp_secret,
+    DROP COLUMN totp_enabled,
+    DROP COLUMN totp_last_step,
+    DROP COLUMN totp_recovery;
@@ -0,0 +1,7 @@
+-- totp_secret is encrypted by the server. It is set but not enabled while enrollment is pending.
+-- totp_recovery holds SHA-256 hashes of the unused recovery codes.
+ALTER TABLE admins
+    ADD COLUMN totp_secret TEXT,
+    ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN totp_last_step BIGINT,
+    ADD COLUMN totp_recovery TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,3 @@
+DROP TABLE login_failures;
+DROP TABLE login_failure_counts;
+ALTER TABLE admins DROP COLUMN locked_at;
@@ -0,0 +1,19 @@
+ALTER TABLE admins ADD COLUMN locked_at TIMESTAMPTZ;
+
+CREATE TABLE login_failure_counts (
+    kind TEXT NOT NULL CHECK (kind IN ('user', 'ip')),
+    subject TEXT NOT NULL,
+    failures INT NOT NULL,
+    last_failure TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (kind, subject)
+);
+
+CREATE TABLE login_failures (
+    id BIGSERIAL PRIMARY KEY,
+    username TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX login_failures_username ON login_failures (username, created_at);
@@ -0,0 +1,9 @@
+CREATE TABLE admin_sessions (
+    id SERIAL PRIMARY KEY,
+    session_key TEXT UNIQUE NOT NULL,
+    admin_id INTEGER NOT NULL REFERENCES admins (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ip TEXT NOT NULL,
+    user_agent TEXT NOT NULL
+);
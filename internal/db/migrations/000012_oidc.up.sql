@@ -0,0 +1,15 @@
+CREATE TABLE oidc_identities (
+    id SERIAL PRIMARY KEY,
+    kind TEXT NOT NULL CHECK (kind IN ('subject', 'email')),
+    value TEXT NOT NULL,
+    username TEXT NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('viewer', 'uploader', 'migrator', 'owner')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (kind, value)
+);
+
+CREATE TABLE login_codes (
+    hash TEXT PRIMARY KEY,
+    admin_id INTEGER NOT NULL REFERENCES admins (id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1 @@
+DROP TABLE discord_admins;
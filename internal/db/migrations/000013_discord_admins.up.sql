@@ -0,0 +1,5 @@
+CREATE TABLE discord_admins (
+    discord_id TEXT PRIMARY KEY,
+    admin_id INTEGER UNIQUE NOT NULL REFERENCES admins (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
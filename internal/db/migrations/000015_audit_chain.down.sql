@@ -0,0 +1,5 @@
+DROP INDEX audit_events_prev_hash_idx;
+
+ALTER TABLE audit_events
+    DROP COLUMN prev_hash,
+    DROP COLUMN hash;
@@ -0,0 +1,7 @@
+-- Events recorded before the chain was added have no hashes. The first chained event has an empty
+-- prev_hash, and the unique index keeps the chain from forking.
+ALTER TABLE audit_events
+    ADD COLUMN prev_hash BYTEA,
+    ADD COLUMN hash BYTEA;
+
+CREATE UNIQUE INDEX audit_events_prev_hash_idx ON audit_events (prev_hash);
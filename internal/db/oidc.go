@@ -0,0 +1,230 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// OIDCTable holds the allowlist of identities that can log in with single sign-on, and the
+// one-time codes that hand a single sign-on login from the browser to the client.
+type OIDCTable struct {
+	logger log.Logger
+	pool   PgxIface
+}
+
+// NewOIDCTable creates a new OIDCTable backed by a Postgres connection pool.
+func NewOIDCTable(l log.Logger, pool PgxIface) *OIDCTable {
+	out := OIDCTable{
+		logger: l,
+		pool:   pool,
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (t *OIDCTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(t.logger, ctx)
+}
+
+// These are the kinds of identity that can be allowed to log in.
+const (
+	// IdentitySubject matches the subject claim, which the identity provider never reuses.
+	IdentitySubject = "subject"
+
+	// IdentityEmail matches a verified email address, compared case-insensitively.
+	IdentityEmail = "email"
+)
+
+// Identity allows someone with a matching ID token to log in as the admin with Username. If the
+// admin does not exist yet, it is created with Role on the first login.
+type Identity struct {
+	ID        int
+	Kind      string
+	Value     string
+	Username  string
+	Role      api.Role
+	CreatedAt time.Time
+}
+
+var (
+	// ErrNoIdentity is returned when no identity on the allowlist matches.
+	ErrNoIdentity = errors.New("identity not allowed")
+
+	// ErrIdentityExists is returned by AddIdentity if the identity is already on the allowlist.
+	ErrIdentityExists = errors.New("identity already allowed")
+
+	// ErrNoLoginCode is returned by UseLoginCode if the code does not exist or has expired.
+	ErrNoLoginCode = errors.New("invalid or expired login code")
+)
+
+const identityFields = "id, kind, value, username, role, created_at"
+
+func scanIdentity(row pgx.Row) (*Identity, error) {
+	var out Identity
+	err := row.Scan(&out.ID, &out.Kind, &out.Value, &out.Username, &out.Role, &out.CreatedAt)
+
+	return &out, err
+}
+
+// AddIdentity allows the identity to log in as the admin, and returns the ID of the new allowlist
+// entry. ErrIdentityExists is returned if the identity is already allowed.
+func (t *OIDCTable) AddIdentity(
+	ctx context.Context, kind, value, user string, role api.Role,
+) (int, error) {
+	if kind == IdentityEmail {
+		value = strings.ToLower(value)
+	}
+
+	var id int
+	err := t.pool.QueryRow(ctx,
+		"INSERT INTO oidc_identities (kind, value, username, role) VALUES ($1, $2, $3, $4) "+
+			"RETURNING id",
+		kind, value, user, string(role),
+	).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			t.log(ctx).Info("msg", "OIDC identity already allowed", "kind", kind, "value", value)
+
+			return 0, ErrIdentityExists
+		}
+
+		t.log(ctx).Error("msg", "failed to add OIDC identity", "kind", kind, "value", value,
+			"error", err)
+
+		return 0, err
+	}
+
+	t.log(ctx).Debug("msg", "added OIDC identity", "kind", kind, "value", value, "user", user,
+		"role", role)
+
+	return id, nil
+}
+
+// ListIdentities returns the allowlist, ordered by ID.
+func (t *OIDCTable) ListIdentities(ctx context.Context) ([]*Identity, error) {
+	rows, err := t.pool.Query(ctx, "SELECT "+identityFields+" FROM oidc_identities ORDER BY id")
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to list OIDC identities", "error", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Identity
+	for rows.Next() {
+		identity, scanErr := scanIdentity(rows)
+		if scanErr != nil {
+			t.log(ctx).Error("msg", "failed to scan OIDC identity", "error", scanErr)
+
+			return out, scanErr
+		}
+
+		out = append(out, identity)
+	}
+
+	return out, rows.Err()
+}
+
+// RemoveIdentity removes the allowlist entry by ID. ErrNoIdentity is returned if it does not exist.
+// Admins created by the entry are not removed.
+func (t *OIDCTable) RemoveIdentity(ctx context.Context, id int) error {
+	tag, err := t.pool.Exec(ctx, "DELETE FROM oidc_identities WHERE id=$1", id)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to remove OIDC identity", "id", id, "error", err)
+
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		t.log(ctx).Info("msg", "no OIDC identity found to remove", "id", id)
+
+		return ErrNoIdentity
+	}
+
+	t.log(ctx).Debug("msg", "removed OIDC identity", "id", id)
+
+	return nil
+}
+
+// MatchIdentity returns the allowlist entry for the subject, or else for the email address if it
+// is not empty. ErrNoIdentity is returned if neither is allowed.
+func (t *OIDCTable) MatchIdentity(ctx context.Context, subject, email string) (*Identity, error) {
+	identity, err := scanIdentity(t.pool.QueryRow(ctx,
+		"SELECT "+identityFields+" FROM oidc_identities "+
+			"WHERE (kind='subject' AND value=$1) OR (kind='email' AND $2<>'' AND value=$2) "+
+			"ORDER BY kind DESC LIMIT 1",
+		subject, strings.ToLower(email),
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNoIdentity
+		}
+
+		t.log(ctx).Error("msg", "failed to match OIDC identity", "subject", subject, "error", err)
+
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// CreateLoginCode returns a one-time code that can be exchanged for a session as the admin until
+// the TTL passes. Expired codes are cleaned up at the same time.
+func (t *OIDCTable) CreateLoginCode(
+	ctx context.Context, adminID int, ttl time.Duration,
+) (string, error) {
+	code, err := newToken()
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to generate login code", "error", err)
+
+		return "", err
+	}
+	code = strings.TrimPrefix(code, TokenPrefix)
+
+	_, err = t.pool.Exec(ctx,
+		"WITH expired AS (DELETE FROM login_codes WHERE expires_at <= now()) "+
+			"INSERT INTO login_codes (hash, admin_id, expires_at) "+
+			"VALUES ($1, $2, now() + make_interval(secs => $3))",
+		HashToken(code), adminID, ttl.Seconds(),
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to store login code", "adminID", adminID, "error", err)
+
+		return "", err
+	}
+
+	return code, nil
+}
+
+// UseLoginCode deletes the login code and returns the ID of the admin it was created for.
+// ErrNoLoginCode is returned if the code does not exist or has expired.
+func (t *OIDCTable) UseLoginCode(ctx context.Context, code string) (int, error) {
+	var adminID int
+	err := t.pool.QueryRow(ctx,
+		"DELETE FROM login_codes WHERE hash=$1 AND expires_at > now() RETURNING admin_id",
+		HashToken(code),
+	).Scan(&adminID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			t.log(ctx).Info("msg", "invalid or expired login code")
+
+			return 0, ErrNoLoginCode
+		}
+
+		t.log(ctx).Error("msg", "failed to use login code", "error", err)
+
+		return 0, err
+	}
+
+	return adminID, nil
+}
@@ -0,0 +1,128 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cobaltspeech/log/pkg/testinglog"
+	"github.com/google/go-cmp/cmp"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestOIDCTable(t *testing.T) { //nolint:funlen // testing sequential calls
+	t.Parallel()
+
+	mockDB, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("error opening mock db: %v", err)
+	}
+	defer mockDB.Close()
+
+	logger := testinglog.NewConvenientLogger(t)
+	table := db.NewOIDCTable(logger, mockDB)
+	ctx := context.Background()
+
+	// allow Jane by email
+	mockDB.ExpectQuery("INSERT INTO oidc_identities").
+		WithArgs(db.IdentityEmail, "jane@example.com", "jane", string(api.RoleUploader)).
+		WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(1))
+	id, err := table.AddIdentity(ctx, db.IdentityEmail, "Jane@Example.com", "jane", api.RoleUploader)
+	if err != nil {
+		t.Errorf("error from AddIdentity: %v", err)
+	}
+	if id != 1 {
+		t.Errorf("expected ID 1, got %d", id)
+	}
+
+	// Jane logs in
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := db.Identity{
+		ID: 1, Kind: db.IdentityEmail, Value: "jane@example.com", Username: "jane",
+		Role: api.RoleUploader, CreatedAt: created,
+	}
+	identityRows := func() *pgxmock.Rows {
+		return pgxmock.NewRows(
+			[]string{"id", "kind", "value", "username", "role", "created_at"},
+		).AddRow(want.ID, want.Kind, want.Value, want.Username, want.Role, want.CreatedAt)
+	}
+	mockDB.ExpectQuery("SELECT id, kind, value, username, role, created_at FROM oidc_identities").
+		WithArgs("sub-1", "jane@example.com").
+		WillReturnRows(identityRows())
+	identity, err := table.MatchIdentity(ctx, "sub-1", "JANE@example.com")
+	if err != nil {
+		t.Errorf("error from MatchIdentity: %v", err)
+	}
+	if diff := cmp.Diff(&want, identity); diff != "" {
+		t.Error("unexpected identity (-want +got):\n" + diff)
+	}
+
+	// someone else tries to log in
+	mockDB.ExpectQuery("SELECT id, kind, value, username, role, created_at FROM oidc_identities").
+		WithArgs("sub-2", "").
+		WillReturnRows(pgxmock.NewRows(
+			[]string{"id", "kind", "value", "username", "role", "created_at"},
+		))
+	_, err = table.MatchIdentity(ctx, "sub-2", "")
+	if !errors.Is(err, db.ErrNoIdentity) {
+		t.Errorf("unexpected error from MatchIdentity: %v", err)
+	}
+
+	// the login is handed to the client
+	mockDB.ExpectExec("WITH expired AS").
+		WithArgs(pgxmock.AnyArg(), 1, time.Minute.Seconds()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	code, err := table.CreateLoginCode(ctx, 1, time.Minute)
+	if err != nil {
+		t.Errorf("error from CreateLoginCode: %v", err)
+	}
+	mockDB.ExpectQuery("DELETE FROM login_codes").
+		WithArgs(db.HashToken(code)).
+		WillReturnRows(pgxmock.NewRows([]string{"admin_id"}).AddRow(1))
+	adminID, err := table.UseLoginCode(ctx, code)
+	if err != nil {
+		t.Errorf("error from UseLoginCode: %v", err)
+	}
+	if adminID != 1 {
+		t.Errorf("expected admin ID 1, got %d", adminID)
+	}
+	mockDB.ExpectQuery("DELETE FROM login_codes").
+		WithArgs(db.HashToken(code)).
+		WillReturnRows(pgxmock.NewRows([]string{"admin_id"}))
+	_, err = table.UseLoginCode(ctx, code)
+	if !errors.Is(err, db.ErrNoLoginCode) {
+		t.Errorf("unexpected error from UseLoginCode: %v", err)
+	}
+
+	// list and remove identities
+	mockDB.ExpectQuery("SELECT id, kind, value, username, role, created_at FROM oidc_identities").
+		WillReturnRows(identityRows())
+	identities, err := table.ListIdentities(ctx)
+	if err != nil {
+		t.Errorf("error from ListIdentities: %v", err)
+	}
+	if diff := cmp.Diff([]*db.Identity{&want}, identities); diff != "" {
+		t.Error("unexpected identities (-want +got):\n" + diff)
+	}
+	mockDB.ExpectExec("DELETE FROM oidc_identities").
+		WithArgs(1).
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	err = table.RemoveIdentity(ctx, 1)
+	if err != nil {
+		t.Errorf("error from RemoveIdentity: %v", err)
+	}
+	mockDB.ExpectExec("DELETE FROM oidc_identities").
+		WithArgs(1).
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	err = table.RemoveIdentity(ctx, 1)
+	if !errors.Is(err, db.ErrNoIdentity) {
+		t.Errorf("unexpected error from RemoveIdentity: %v", err)
+	}
+
+	if err = mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
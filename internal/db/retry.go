@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/cobaltspeech/log"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// RetryPolicy says how long to keep retrying database operations that fail with transient errors.
+type RetryPolicy struct {
+	// InitialInterval is the wait before the first retry. The wait grows after each attempt, up to
+	// MaxInterval. If either is zero, the default of github.com/cenkalti/backoff is used.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+
+	// Timeout is how long to keep retrying. If it is zero, operations are only tried once.
+	Timeout time.Duration
+}
+
+// Do calls f until it succeeds, returns an error that isn't transient, or the policy's timeout
+// passes. Each retry is logged with the description of the operation.
+func (p RetryPolicy) Do(ctx context.Context, l log.Logger, what string, f func() error) error {
+	if p.Timeout <= 0 {
+		return f()
+	}
+
+	b := backoff.NewExponentialBackOff()
+	if p.InitialInterval > 0 {
+		b.InitialInterval = p.InitialInterval
+	}
+	if p.MaxInterval > 0 {
+		b.MaxInterval = p.MaxInterval
+	}
+	b.MaxElapsedTime = p.Timeout
+
+	op := func() error {
+		err := f()
+		if err != nil && !IsTransient(err) {
+			return backoff.Permanent(err)
+		}
+
+		return err
+	}
+	notify := func(err error, wait time.Duration) {
+		l.Info("msg", "retrying after transient database error", "operation", what, "error", err,
+			"wait", wait)
+	}
+
+	return backoff.RetryNotify(op, backoff.WithContext(b, ctx), notify)
+}
+
+// transientMessages are found in errors that don't keep their cause, such as those from
+// golang-migrate.
+var transientMessages = []string{
+	"connection refused",
+	"connection reset by peer",
+	"the database system is starting up",
+	"the database system is shutting down",
+	"connect: EOF",
+	"database is locked", // SQLite's SQLITE_BUSY
+}
+
+// IsTransient returns whether the error could go away if the operation is tried again, for
+// example because the database is still starting up or a connection was dropped.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgerrcode.CannotConnectNow, pgerrcode.AdminShutdown, pgerrcode.CrashShutdown,
+			pgerrcode.TooManyConnections, pgerrcode.SerializationFailure,
+			pgerrcode.DeadlockDetected:
+			return true
+		}
+
+		return pgerrcode.IsConnectionException(pgErr.Code)
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) || pgconn.SafeToRetry(err) || pgconn.Timeout(err) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	s := err.Error()
+	for _, search := range transientMessages {
+		if strings.Contains(s, search) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,119 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/cobaltspeech/log/pkg/testinglog"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kylrth/disco-bouncer/internal/db"
+)
+
+func TestIsTransient(t *testing.T) {
+	t.Parallel()
+
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+	tests := map[string]struct {
+		err  error
+		want bool
+	}{
+		"connection refused": {fmt.Errorf("get users: %w", refused), true},
+		"starting up":        {&pgconn.PgError{Code: pgerrcode.CannotConnectNow}, true},
+		"connection failure": {&pgconn.PgError{Code: pgerrcode.ConnectionFailure}, true},
+		"deadlock":           {&pgconn.PgError{Code: pgerrcode.DeadlockDetected}, true},
+		"migrate message": {
+			errors.New("failed to connect: FATAL: the database system is starting up"), true,
+		},
+		"sqlite busy":      {errors.New("database is locked (5) (SQLITE_BUSY)"), true},
+		"unique violation": {&pgconn.PgError{Code: pgerrcode.UniqueViolation}, false},
+		"no rows":          {pgx.ErrNoRows, false},
+		"no user":          {db.ErrNoUser, false},
+		"canceled":         {context.Canceled, false},
+		"nil":              {nil, false},
+	}
+	for name, tc := range tests {
+		if got := db.IsTransient(tc.err); got != tc.want {
+			t.Errorf("%s: expected %t, got %t", name, tc.want, got)
+		}
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	t.Parallel()
+
+	l := testinglog.NewConvenientLogger(t, testinglog.WithFieldIgnoreFunc(
+		func(map[string]string) []string { return []string{"wait"} },
+	))
+	t.Cleanup(l.Done)
+
+	ctx := context.Background()
+	p := db.RetryPolicy{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+		Timeout:         time.Second,
+	}
+	transient := &pgconn.PgError{
+		Severity: "FATAL",
+		Code:     pgerrcode.CannotConnectNow,
+		Message:  "the database system is starting up",
+	}
+
+	// transient errors are retried until f succeeds
+	var calls int
+	err := p.Do(ctx, l, "flaky query", func() error {
+		calls++
+		if calls < 3 {
+			return transient
+		}
+
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("expected success after 3 calls, got %v after %d", err, calls)
+	}
+
+	// other errors are returned right away
+	calls = 0
+	err = p.Do(ctx, l, "bad query", func() error {
+		calls++
+
+		return db.ErrNoUser
+	})
+	if !errors.Is(err, db.ErrNoUser) || calls != 1 {
+		t.Errorf("expected ErrNoUser after 1 call, got %v after %d", err, calls)
+	}
+
+	// without a timeout, there is only one attempt
+	calls = 0
+	err = db.RetryPolicy{}.Do(ctx, l, "once", func() error {
+		calls++
+
+		return transient
+	})
+	if !errors.Is(err, transient) || calls != 1 {
+		t.Errorf("expected transient error after 1 call, got %v after %d", err, calls)
+	}
+
+	// retries stop when the timeout passes
+	p.Timeout = 20 * time.Millisecond
+	start := time.Now()
+	// The number of retries varies, so they aren't logged.
+	err = p.Do(ctx, log.NewDiscardLogger(), "down", func() error {
+		return transient
+	})
+	if !errors.Is(err, transient) {
+		t.Errorf("expected transient error after timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected retries to stop after the timeout, took %v", elapsed)
+	}
+}
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+)
+
+// SessionTable tracks the logged-in sessions of admins, so that they can be listed and revoked. The
+// session data itself is kept by the session store; a session is only valid while it has a row in
+// this table.
+type SessionTable struct {
+	logger log.Logger
+	pool   PgxIface
+}
+
+// NewSessionTable creates a new SessionTable backed by a Postgres connection pool.
+func NewSessionTable(l log.Logger, pool PgxIface) *SessionTable {
+	out := SessionTable{
+		logger: l,
+		pool:   pool,
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (t *SessionTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(t.logger, ctx)
+}
+
+// Session describes a logged-in session of an admin.
+type Session struct {
+	ID        int
+	Key       string // the session ID used in the cookie
+	CreatedAt time.Time
+	LastSeen  time.Time
+	IP        string
+	UserAgent string
+}
+
+// ErrNoSession is returned when the session does not exist.
+var ErrNoSession = errors.New("session not found")
+
+// CreateSession starts tracking a new session for the admin.
+func (t *SessionTable) CreateSession(
+	ctx context.Context, key string, adminID int, ip, userAgent string,
+) error {
+	_, err := t.pool.Exec(ctx,
+		"INSERT INTO admin_sessions (session_key, admin_id, ip, user_agent) VALUES ($1, $2, $3, $4)",
+		key, adminID, ip, userAgent,
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to create session", "adminID", adminID, "error", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// TouchSession records that the session was used from the IP address. It returns false if the
+// session does not belong to the admin, was revoked, has been idle for longer than idle, or was
+// created more than maxAge ago.
+func (t *SessionTable) TouchSession(
+	ctx context.Context, key string, adminID int, ip string, idle, maxAge time.Duration,
+) (bool, error) {
+	tag, err := t.pool.Exec(ctx,
+		"UPDATE admin_sessions SET last_seen=now(), ip=$3 "+
+			"WHERE session_key=$1 AND admin_id=$2 "+
+			"AND last_seen > now() - make_interval(secs => $4) "+
+			"AND created_at > now() - make_interval(secs => $5)",
+		key, adminID, ip, idle.Seconds(), maxAge.Seconds(),
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to touch session", "adminID", adminID, "error", err)
+
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// DeleteSession stops tracking the session, for example after the admin logs out. Nothing happens
+// if the session does not exist.
+func (t *SessionTable) DeleteSession(ctx context.Context, key string) error {
+	_, err := t.pool.Exec(ctx, "DELETE FROM admin_sessions WHERE session_key=$1", key)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to delete session", "error", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// ListSessions returns the sessions of the admin, most recently used first.
+func (t *SessionTable) ListSessions(ctx context.Context, user string) ([]*Session, error) {
+	rows, err := t.pool.Query(ctx,
+		"SELECT s.id, s.session_key, s.created_at, s.last_seen, s.ip, s.user_agent "+
+			"FROM admin_sessions s JOIN admins a ON a.id=s.admin_id "+
+			"WHERE a.username=$1 ORDER BY s.last_seen DESC",
+		user,
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to list sessions", "user", user, "error", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Session
+	for rows.Next() {
+		var s Session
+		err = rows.Scan(&s.ID, &s.Key, &s.CreatedAt, &s.LastSeen, &s.IP, &s.UserAgent)
+		if err != nil {
+			t.log(ctx).Error("msg", "failed to scan session", "error", err)
+
+			return out, err
+		}
+
+		out = append(out, &s)
+	}
+
+	t.log(ctx).Debug("msg", "listed sessions", "user", user, "count", len(out))
+
+	return out, rows.Err()
+}
+
+// RevokeSession revokes the admin's session by ID. ErrNoSession is returned if the admin has no
+// session with that ID.
+func (t *SessionTable) RevokeSession(ctx context.Context, user string, id int) error {
+	tag, err := t.pool.Exec(ctx,
+		"DELETE FROM admin_sessions WHERE id=$2 "+
+			"AND admin_id=(SELECT id FROM admins WHERE username=$1)",
+		user, id,
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to revoke session", "user", user, "id", id, "error", err)
+
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		t.log(ctx).Info("msg", "no session found to revoke", "user", user, "id", id)
+
+		return ErrNoSession
+	}
+
+	t.log(ctx).Debug("msg", "revoked session", "user", user, "id", id)
+
+	return nil
+}
+
+// RevokeOtherSessions revokes every session of the admin except the one with the key, which may be
+// empty to revoke them all.
+func (t *SessionTable) RevokeOtherSessions(ctx context.Context, user, keep string) error {
+	tag, err := t.pool.Exec(ctx,
+		"DELETE FROM admin_sessions WHERE session_key<>$2 "+
+			"AND admin_id=(SELECT id FROM admins WHERE username=$1)",
+		user, keep,
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to revoke sessions", "user", user, "error", err)
+
+		return err
+	}
+
+	t.log(ctx).Debug("msg", "revoked sessions", "user", user, "count", tag.RowsAffected())
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cobaltspeech/log/pkg/testinglog"
+	"github.com/google/go-cmp/cmp"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestSessionTable(t *testing.T) { //nolint:funlen // testing sequential calls
+	t.Parallel()
+
+	mockDB, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("error opening mock db: %v", err)
+	}
+	defer mockDB.Close()
+
+	logger := testinglog.NewConvenientLogger(t)
+	table := db.NewSessionTable(logger, mockDB)
+	ctx := context.Background()
+
+	// John logs in
+	mockDB.ExpectExec("INSERT INTO admin_sessions").
+		WithArgs("abc", 1, "10.0.0.1", "curl").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	err = table.CreateSession(ctx, "abc", 1, "10.0.0.1", "curl")
+	if err != nil {
+		t.Errorf("error from CreateSession: %v", err)
+	}
+
+	// the session is used, and then it times out
+	idle, maxAge := time.Hour, 24*time.Hour
+	mockDB.ExpectExec("UPDATE admin_sessions SET last_seen").
+		WithArgs("abc", 1, "10.0.0.2", idle.Seconds(), maxAge.Seconds()).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mockDB.ExpectExec("UPDATE admin_sessions SET last_seen").
+		WithArgs("abc", 1, "10.0.0.2", idle.Seconds(), maxAge.Seconds()).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	for _, want := range []bool{true, false} {
+		ok, touchErr := table.TouchSession(ctx, "abc", 1, "10.0.0.2", idle, maxAge)
+		if touchErr != nil {
+			t.Errorf("error from TouchSession: %v", touchErr)
+		}
+		if ok != want {
+			t.Errorf("expected TouchSession to return %t", want)
+		}
+	}
+
+	// list John's sessions
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := db.Session{
+		ID: 1, Key: "abc", CreatedAt: created, LastSeen: created, IP: "10.0.0.2", UserAgent: "curl",
+	}
+	mockDB.ExpectQuery("SELECT s.id, s.session_key").
+		WithArgs("john").
+		WillReturnRows(pgxmock.NewRows(
+			[]string{"id", "session_key", "created_at", "last_seen", "ip", "user_agent"},
+		).AddRow(want.ID, want.Key, want.CreatedAt, want.LastSeen, want.IP, want.UserAgent))
+	sessions, err := table.ListSessions(ctx, "john")
+	if err != nil {
+		t.Errorf("error from ListSessions: %v", err)
+	}
+	if diff := cmp.Diff([]*db.Session{&want}, sessions); diff != "" {
+		t.Error("unexpected sessions (-want +got):\n" + diff)
+	}
+
+	// revoke sessions
+	mockDB.ExpectExec("DELETE FROM admin_sessions WHERE id").
+		WithArgs("john", 1).
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	err = table.RevokeSession(ctx, "john", 1)
+	if err != nil {
+		t.Errorf("error from RevokeSession: %v", err)
+	}
+	mockDB.ExpectExec("DELETE FROM admin_sessions WHERE id").
+		WithArgs("john", 1).
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	err = table.RevokeSession(ctx, "john", 1)
+	if !errors.Is(err, db.ErrNoSession) {
+		t.Errorf("unexpected error from RevokeSession: %v", err)
+	}
+	mockDB.ExpectExec("DELETE FROM admin_sessions WHERE session_key<>").
+		WithArgs("john", "def").
+		WillReturnResult(pgxmock.NewResult("DELETE", 2))
+	err = table.RevokeOtherSessions(ctx, "john", "def")
+	if err != nil {
+		t.Errorf("error from RevokeOtherSessions: %v", err)
+	}
+
+	// log out
+	mockDB.ExpectExec("DELETE FROM admin_sessions WHERE session_key=").
+		WithArgs("def").
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	err = table.DeleteSession(ctx, "def")
+	if err != nil {
+		t.Errorf("error from DeleteSession: %v", err)
+	}
+
+	if err = mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
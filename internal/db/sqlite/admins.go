@@ -0,0 +1,639 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AdminTable is the SQLite version of db.AdminTable. It handles password hashing, so all `pass`
+// method arguments are expected to be plaintext.
+type AdminTable struct {
+	logger log.Logger
+	conn   *tracedConn
+}
+
+var _ db.AdminStore = (*AdminTable)(nil)
+
+// NewAdminTable creates a new AdminTable in the SQLite database.
+func NewAdminTable(l log.Logger, conn *sql.DB) *AdminTable {
+	out := AdminTable{
+		logger: l,
+		conn:   traced(conn, "admins"),
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (a *AdminTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(a.logger, ctx)
+}
+
+// AddAdmin creates a new admin account with the given role.
+func (a *AdminTable) AddAdmin(ctx context.Context, user, pass string, role api.Role) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to hash password for new admin", "user", user, "error", err)
+
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	_, err = a.conn.ExecContext(ctx,
+		"INSERT INTO admins (username, password, role, created_at) VALUES ($1, $2, $3, $4)",
+		user, string(hashed), string(role), now(),
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			a.log(ctx).Info("msg", "admin already exists", "user", user)
+
+			return db.ErrAdminExists
+		}
+
+		a.log(ctx).Error("msg", "failed to store new admin", "user", user, "error", err)
+
+		return err
+	}
+
+	a.log(ctx).Debug("msg", "stored new admin", "user", user, "role", role)
+
+	return nil
+}
+
+// DeleteAdmin removes an admin account. It does not affect any changes they may have made to the
+// data.
+func (a *AdminTable) DeleteAdmin(ctx context.Context, user string) error {
+	res, err := a.conn.ExecContext(ctx, "DELETE FROM admins WHERE username=$1", user)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to delete admin", "user", user, "error", err)
+
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		a.log(ctx).Info("msg", "no user found to delete", "user", user)
+
+		return db.ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "deleted admin", "user", user)
+
+	return nil
+}
+
+// CheckPassword returns true if the admin exists, is not disabled, and the password matches the
+// hash on file. Otherwise it returns false.
+func (a *AdminTable) CheckPassword(ctx context.Context, user, pass string) (bool, error) {
+	var hashed string
+	var disabled bool
+	err := a.conn.QueryRowContext(ctx,
+		"SELECT password, disabled FROM admins WHERE username=$1", user,
+	).Scan(&hashed, &disabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			a.log(ctx).Info("msg", "checked password for nonexistent user", "user", user)
+
+			return false, nil
+		}
+
+		a.log(ctx).Error("msg", "failed to check password", "user", user, "error", err)
+
+		return false, err
+	}
+
+	if disabled {
+		a.log(ctx).Info("msg", "checked password for disabled admin", "user", user)
+
+		return false, nil
+	}
+
+	passed := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(pass)) == nil
+
+	if passed {
+		a.log(ctx).Debug("msg", "successful password check", "user", user)
+	} else {
+		a.log(ctx).Debug("msg", "unsuccessful password check", "user", user)
+	}
+
+	return passed, nil
+}
+
+// ChangePassword updates the hashed password for an admin. db.ErrNoUser is returned if the admin
+// is not in the database.
+func (a *AdminTable) ChangePassword(ctx context.Context, user, pass string) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to hash new password", "user", user, "error", err)
+
+		return err
+	}
+
+	res, err := a.conn.ExecContext(ctx,
+		"UPDATE admins SET password=$2 WHERE username=$1", user, string(hashed))
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to update password", "user", user, "error", err)
+
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		a.log(ctx).Info("msg", "no user found to update password", "user", user)
+
+		return db.ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "password updated", "user", user)
+
+	return nil
+}
+
+// ResetPassword changes the password of the admin and revokes every session of theirs except the
+// one with the key, which may be empty to revoke them all, in one transaction. db.ErrNoUser is
+// returned if the admin is not in the database.
+func (a *AdminTable) ResetPassword(ctx context.Context, user, pass, keep string) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to hash new password", "user", user, "error", err)
+
+		return err
+	}
+
+	var revoked int64
+	err = inTx(ctx, a.conn.DB, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			"UPDATE admins SET password=$2 WHERE username=$1", user, string(hashed))
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			return db.ErrNoUser
+		}
+
+		res, err = tx.ExecContext(ctx,
+			"DELETE FROM admin_sessions WHERE session_key<>$2 "+
+				"AND admin_id=(SELECT id FROM admins WHERE username=$1)",
+			user, keep,
+		)
+		if err != nil {
+			return err
+		}
+		revoked, _ = res.RowsAffected()
+
+		return nil
+	})
+	if errors.Is(err, db.ErrNoUser) {
+		a.log(ctx).Info("msg", "no user found to update password", "user", user)
+
+		return err
+	}
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to reset password", "user", user, "error", err)
+
+		return err
+	}
+
+	a.log(ctx).Debug("msg", "password reset", "user", user, "revoked", revoked)
+
+	return nil
+}
+
+const adminFields = "id, username, role, disabled, created_at, last_login, totp_enabled, " +
+	"locked_at"
+
+func scanAdmin(row interface{ Scan(dest ...any) error }) (*db.Admin, error) {
+	var out db.Admin
+	err := row.Scan(&out.ID, &out.Username, &out.Role, &out.Disabled, &out.CreatedAt, &out.LastLogin,
+		&out.TOTPEnabled, &out.LockedAt)
+
+	return &out, err
+}
+
+// ListAdmins returns every admin account, ordered by ID.
+func (a *AdminTable) ListAdmins(ctx context.Context) ([]*db.Admin, error) {
+	rows, err := a.conn.QueryContext(ctx, "SELECT "+adminFields+" FROM admins ORDER BY id")
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to list admins", "error", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*db.Admin
+	for rows.Next() {
+		admin, scanErr := scanAdmin(rows)
+		if scanErr != nil {
+			a.log(ctx).Error("msg", "failed to scan admin", "error", scanErr)
+
+			return out, scanErr
+		}
+
+		out = append(out, admin)
+	}
+
+	a.log(ctx).Debug("msg", "listed admins", "count", len(out))
+
+	return out, rows.Err()
+}
+
+// GetAdmin returns the admin by username. db.ErrNoUser is returned if the admin is not in the
+// database.
+func (a *AdminTable) GetAdmin(ctx context.Context, user string) (*db.Admin, error) {
+	return a.getAdmin(ctx, "username", user)
+}
+
+// GetAdminByID returns the admin by ID. db.ErrNoUser is returned if the admin is not in the
+// database.
+func (a *AdminTable) GetAdminByID(ctx context.Context, id int) (*db.Admin, error) {
+	return a.getAdmin(ctx, "id", id)
+}
+
+func (a *AdminTable) getAdmin(ctx context.Context, column string, value any) (*db.Admin, error) {
+	admin, err := scanAdmin(a.conn.QueryRowContext(ctx,
+		"SELECT "+adminFields+" FROM admins WHERE "+column+"=$1", value))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			a.log(ctx).Info("msg", "admin not in database", column, value)
+
+			return nil, db.ErrNoUser
+		}
+
+		a.log(ctx).Error("msg", "failed to get admin", column, value, "error", err)
+
+		return nil, err
+	}
+
+	return admin, nil
+}
+
+// RecordLogin sets the last login time of the admin to now.
+func (a *AdminTable) RecordLogin(ctx context.Context, user string) error {
+	_, err := a.conn.ExecContext(ctx,
+		"UPDATE admins SET last_login=$2 WHERE username=$1", user, now())
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to record login", "user", user, "error", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// SetDisabled disables or enables an admin. db.ErrNoUser is returned if the admin is not in the
+// database.
+func (a *AdminTable) SetDisabled(ctx context.Context, user string, disabled bool) error {
+	res, err := a.conn.ExecContext(ctx,
+		"UPDATE admins SET disabled=$2 WHERE username=$1", user, disabled)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to set disabled", "user", user, "error", err)
+
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		a.log(ctx).Info("msg", "no user found to set disabled", "user", user)
+
+		return db.ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "set admin disabled", "user", user, "disabled", disabled)
+
+	return nil
+}
+
+// PatchAdmin changes the role of an admin and disables or enables them in one statement, and
+// returns the updated admin. Nil fields of the patch are not changed. db.ErrNoUser is returned if
+// the admin is not in the database.
+func (a *AdminTable) PatchAdmin(
+	ctx context.Context, user string, patch api.AdminPatch,
+) (*db.Admin, error) {
+	var role any
+	if patch.Role != nil {
+		role = string(*patch.Role)
+	}
+	var disabled any
+	if patch.Disabled != nil {
+		disabled = *patch.Disabled
+	}
+
+	admin, err := scanAdmin(a.conn.QueryRowContext(ctx,
+		"UPDATE admins SET role=COALESCE($2, role), disabled=COALESCE($3, disabled) "+
+			"WHERE username=$1 RETURNING "+adminFields,
+		user, role, disabled,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			a.log(ctx).Info("msg", "no user found to patch", "user", user)
+
+			return nil, db.ErrNoUser
+		}
+
+		a.log(ctx).Error("msg", "failed to patch admin", "user", user, "error", err)
+
+		return nil, err
+	}
+
+	a.log(ctx).Debug("msg", "patched admin", "user", user, "role", admin.Role,
+		"disabled", admin.Disabled)
+
+	return admin, nil
+}
+
+// SetRole changes the role of an admin. db.ErrNoUser is returned if the admin is not in the
+// database.
+func (a *AdminTable) SetRole(ctx context.Context, user string, role api.Role) error {
+	res, err := a.conn.ExecContext(ctx,
+		"UPDATE admins SET role=$2 WHERE username=$1", user, string(role))
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to set role", "user", user, "error", err)
+
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		a.log(ctx).Info("msg", "no user found to set role", "user", user)
+
+		return db.ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "set admin role", "user", user, "role", role)
+
+	return nil
+}
+
+// GetTOTP returns the two-factor authentication state of the admin. db.ErrNoUser is returned if
+// the admin is not in the database.
+func (a *AdminTable) GetTOTP(ctx context.Context, user string) (*db.TOTP, error) {
+	var out db.TOTP
+	var secret sql.NullString
+	err := a.conn.QueryRowContext(ctx,
+		"SELECT totp_secret, totp_enabled FROM admins WHERE username=$1", user,
+	).Scan(&secret, &out.Enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			a.log(ctx).Info("msg", "no user found to get TOTP", "user", user)
+
+			return nil, db.ErrNoUser
+		}
+
+		a.log(ctx).Error("msg", "failed to get TOTP", "user", user, "error", err)
+
+		return nil, err
+	}
+
+	out.Secret = secret.String
+
+	return &out, nil
+}
+
+// clearRecoveryCodes deletes the unused recovery codes of the admin.
+const clearRecoveryCodes = "DELETE FROM totp_recovery_codes " +
+	"WHERE admin_id=(SELECT id FROM admins WHERE username=$1)"
+
+// StartTOTP stores a new encrypted secret for the admin, to be enabled by EnableTOTP.
+// db.ErrTOTPEnabled is returned if two-factor authentication is already enabled.
+func (a *AdminTable) StartTOTP(ctx context.Context, user, secret string) error {
+	var started bool
+	err := inTx(ctx, a.conn.DB, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			"UPDATE admins SET totp_secret=$2, totp_last_step=NULL "+
+				"WHERE username=$1 AND NOT totp_enabled",
+			user, secret,
+		)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			return nil
+		}
+		started = true
+
+		_, err = tx.ExecContext(ctx, clearRecoveryCodes, user)
+
+		return err
+	})
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to start TOTP enrollment", "user", user, "error", err)
+
+		return err
+	}
+	if !started {
+		a.log(ctx).Info("msg", "TOTP already enabled or no user found", "user", user)
+
+		return db.ErrTOTPEnabled
+	}
+
+	a.log(ctx).Debug("msg", "started TOTP enrollment", "user", user)
+
+	return nil
+}
+
+// EnableTOTP turns on two-factor authentication for the admin with the pending secret, and stores
+// the hashes of the recovery codes.
+func (a *AdminTable) EnableTOTP(ctx context.Context, user string, recoveryHashes []string) error {
+	var enabled bool
+	err := inTx(ctx, a.conn.DB, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			"UPDATE admins SET totp_enabled=true WHERE username=$1 AND totp_secret IS NOT NULL",
+			user,
+		)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			return nil
+		}
+		enabled = true
+
+		_, err = tx.ExecContext(ctx, clearRecoveryCodes, user)
+		if err != nil {
+			return err
+		}
+		for _, hash := range recoveryHashes {
+			_, err = tx.ExecContext(ctx,
+				"INSERT OR IGNORE INTO totp_recovery_codes (admin_id, hash) "+
+					"SELECT id, $2 FROM admins WHERE username=$1",
+				user, hash,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to enable TOTP", "user", user, "error", err)
+
+		return err
+	}
+	if !enabled {
+		a.log(ctx).Info("msg", "no pending TOTP enrollment", "user", user)
+
+		return db.ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "enabled TOTP", "user", user)
+
+	return nil
+}
+
+// UseTOTPStep records that a code for the time step was accepted. It returns false if a code for
+// this step or a later one was already accepted, so that codes can't be replayed.
+func (a *AdminTable) UseTOTPStep(ctx context.Context, user string, step int64) (bool, error) {
+	res, err := a.conn.ExecContext(ctx,
+		"UPDATE admins SET totp_last_step=$2 "+
+			"WHERE username=$1 AND (totp_last_step IS NULL OR totp_last_step < $2)",
+		user, step,
+	)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to record TOTP step", "user", user, "error", err)
+
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		a.log(ctx).Info("msg", "TOTP code reused", "user", user)
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// UseRecoveryCode removes the recovery code hash from the admin's unused codes. It returns false if
+// the hash was not one of them.
+func (a *AdminTable) UseRecoveryCode(ctx context.Context, user, hash string) (bool, error) {
+	res, err := a.conn.ExecContext(ctx, clearRecoveryCodes+" AND hash=$2", user, hash)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to use recovery code", "user", user, "error", err)
+
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		a.log(ctx).Info("msg", "invalid recovery code", "user", user)
+
+		return false, nil
+	}
+
+	a.log(ctx).Info("msg", "used recovery code", "user", user)
+
+	return true, nil
+}
+
+// ResetTOTP turns off two-factor authentication for the admin and forgets the secret and recovery
+// codes. db.ErrNoUser is returned if the admin is not in the database.
+func (a *AdminTable) ResetTOTP(ctx context.Context, user string) error {
+	var found bool
+	err := inTx(ctx, a.conn.DB, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			"UPDATE admins SET totp_secret=NULL, totp_enabled=false, totp_last_step=NULL "+
+				"WHERE username=$1",
+			user,
+		)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		found = n == 1
+
+		_, err = tx.ExecContext(ctx, clearRecoveryCodes, user)
+
+		return err
+	})
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to reset TOTP", "user", user, "error", err)
+
+		return err
+	}
+	if !found {
+		a.log(ctx).Info("msg", "no user found to reset TOTP", "user", user)
+
+		return db.ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "reset TOTP", "user", user)
+
+	return nil
+}
+
+// GetDiscordAdmin returns the admin linked to the Discord user ID. db.ErrNoUser is returned if no
+// admin is linked to it.
+func (a *AdminTable) GetDiscordAdmin(ctx context.Context, discordID string) (*db.Admin, error) {
+	admin, err := scanAdmin(a.conn.QueryRowContext(ctx,
+		"SELECT "+adminFields+" FROM admins "+
+			"WHERE id=(SELECT admin_id FROM discord_admins WHERE discord_id=$1)",
+		discordID,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			a.log(ctx).Info("msg", "no admin linked to Discord user", "discordID", discordID)
+
+			return nil, db.ErrNoUser
+		}
+
+		a.log(ctx).Error("msg", "failed to get Discord admin", "discordID", discordID, "error", err)
+
+		return nil, err
+	}
+
+	return admin, nil
+}
+
+// AddDiscordAdmin creates a new admin linked to the Discord user ID, with a random password.
+// db.ErrAdminExists is returned if the username is taken or the Discord user is already linked.
+func (a *AdminTable) AddDiscordAdmin(
+	ctx context.Context, discordID, user string, role api.Role,
+) error {
+	pass, err := newPassword()
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to generate password for new admin", "user", user,
+			"error", err)
+
+		return err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to hash password for new admin", "user", user, "error", err)
+
+		return fmt.Errorf("hash password: %w", err)
+	}
+
+	err = inTx(ctx, a.conn.DB, func(tx *sql.Tx) error {
+		created := now()
+
+		var id int
+		err := tx.QueryRowContext(ctx,
+			"INSERT INTO admins (username, password, role, created_at) VALUES ($1, $2, $3, $4) "+
+				"RETURNING id",
+			user, string(hashed), string(role), created,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO discord_admins (discord_id, admin_id, created_at) VALUES ($1, $2, $3)",
+			discordID, id, created,
+		)
+
+		return err
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			a.log(ctx).Info("msg", "admin already exists", "user", user, "discordID", discordID)
+
+			return db.ErrAdminExists
+		}
+
+		a.log(ctx).Error("msg", "failed to store new Discord admin", "user", user, "error", err)
+
+		return err
+	}
+
+	a.log(ctx).Debug("msg", "stored new Discord admin", "user", user, "discordID", discordID,
+		"role", role)
+
+	return nil
+}
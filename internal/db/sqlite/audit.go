@@ -0,0 +1,260 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// AuditTable is the SQLite version of db.AuditTable. Events are chained with the same hashes, so
+// a chain can be checked the same way after a dump is restored into either database.
+type AuditTable struct {
+	logger log.Logger
+	conn   *sql.DB
+}
+
+var _ db.AuditStore = (*AuditTable)(nil)
+
+// NewAuditTable creates a new AuditTable in the SQLite database.
+func NewAuditTable(l log.Logger, conn *sql.DB) *AuditTable {
+	out := AuditTable{
+		logger: l,
+		conn:   conn,
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (t *AuditTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(t.logger, ctx)
+}
+
+// Record adds the event to the end of the chain. The ID is set by the database, and the time and
+// hashes are set on e.
+func (t *AuditTable) Record(ctx context.Context, e *db.AuditEvent) error {
+	err := t.record(ctx, e)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to record audit event", "actor", e.Actor,
+			"action", e.Action, "target", e.Target, "error", err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (t *AuditTable) record(ctx context.Context, e *db.AuditEvent) error {
+	var changes []byte
+	if len(e.Changes) > 0 {
+		var err error
+		changes, err = json.Marshal(e.Changes)
+		if err != nil {
+			return fmt.Errorf("encode changes: %w", err)
+		}
+	}
+	// The database stores microseconds, and the hash must match what is read back.
+	e.CreatedAt = now().Truncate(time.Microsecond)
+
+	// The transaction takes the write lock when it begins, so concurrent events are not chained to
+	// the same head.
+	return inTx(ctx, t.conn, func(tx *sql.Tx) error {
+		var prev []byte
+		err := tx.QueryRowContext(ctx, "SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1").
+			Scan(&prev)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		// This is empty if it is the first chained event.
+		e.PrevHash = append([]byte{}, prev...)
+		e.Hash, err = db.ChainHash(e.PrevHash, e, changes)
+		if err != nil {
+			return err
+		}
+
+		var stored any
+		if changes != nil {
+			stored = string(changes)
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO audit_events "+
+				"(created_at, actor, token, action, target, changes, request_id, prev_hash, hash) "+
+				"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+			e.CreatedAt, e.Actor, e.Token, e.Action, e.Target, stored, e.RequestID, e.PrevHash,
+			e.Hash,
+		)
+
+		return err
+	})
+}
+
+// auditFields are the columns read by scanAuditEvent. The driver returns empty blobs as NULL, so
+// whether each hash is present is read separately.
+const auditFields = "id, created_at, actor, token, action, target, changes, request_id, " +
+	"prev_hash, prev_hash IS NOT NULL, hash, hash IS NOT NULL"
+
+// scanAuditEvent scans the auditFields of one row. The changes are also returned as stored.
+func scanAuditEvent(row interface{ Scan(dest ...any) error }) (*db.AuditEvent, []byte, error) {
+	var e db.AuditEvent
+	var changes sql.NullString
+	var hasPrev, hasHash bool
+	err := row.Scan(&e.ID, &e.CreatedAt, &e.Actor, &e.Token, &e.Action, &e.Target, &changes,
+		&e.RequestID, &e.PrevHash, &hasPrev, &e.Hash, &hasHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if hasPrev && e.PrevHash == nil {
+		e.PrevHash = []byte{}
+	}
+	if hasHash && e.Hash == nil {
+		e.Hash = []byte{}
+	}
+	if !changes.Valid {
+		return &e, nil, nil
+	}
+
+	err = json.Unmarshal([]byte(changes.String), &e.Changes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode changes of event %d: %w", e.ID, err)
+	}
+
+	return &e, []byte(changes.String), nil
+}
+
+// ListEvents returns the events selected by the filter, newest first.
+func (t *AuditTable) ListEvents(ctx context.Context, f *api.AuditFilter) ([]*db.AuditEvent, error) {
+	var conds []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, cond+"$"+strconv.Itoa(len(args)))
+	}
+
+	if f.Actor != "" {
+		add("actor=", f.Actor)
+	}
+	if f.Action != "" {
+		add("action=", f.Action)
+	}
+	if f.Target != "" {
+		add("target=", f.Target)
+	}
+	if !f.Since.IsZero() {
+		add("created_at>=", f.Since)
+	}
+	if f.BeforeID > 0 {
+		add("id<", f.BeforeID)
+	}
+
+	query := "SELECT " + auditFields + " FROM audit_events"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := t.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to list audit events", "error", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*db.AuditEvent
+	for rows.Next() {
+		e, _, err := scanAuditEvent(rows)
+		if err != nil {
+			t.log(ctx).Error("msg", "failed to scan audit event", "error", err)
+
+			return out, err
+		}
+
+		out = append(out, e)
+	}
+
+	t.log(ctx).Debug("msg", "listed audit events", "count", len(out))
+
+	return out, rows.Err()
+}
+
+// ChainHead returns the newest event in the chain, or db.ErrNoAuditEvents if there is none.
+func (t *AuditTable) ChainHead(ctx context.Context) (*db.AuditEvent, error) {
+	e, _, err := scanAuditEvent(t.conn.QueryRowContext(ctx,
+		"SELECT "+auditFields+" FROM audit_events WHERE hash IS NOT NULL ORDER BY id DESC LIMIT 1",
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, db.ErrNoAuditEvents
+		}
+		t.log(ctx).Error("msg", "failed to get audit chain head", "error", err)
+
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// VerifyChain checks the hash of every event, oldest first, and returns the first broken link, or
+// nil if the chain is intact. See db.AuditTable.VerifyChain.
+func (t *AuditTable) VerifyChain(
+	ctx context.Context,
+) (checked int, broken *db.ChainBreak, err error) {
+	rows, err := t.conn.QueryContext(ctx, "SELECT "+auditFields+" FROM audit_events ORDER BY id")
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to list audit events", "error", err)
+
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var prev *db.AuditEvent
+	for rows.Next() {
+		e, changes, err := scanAuditEvent(rows)
+		if err != nil {
+			t.log(ctx).Error("msg", "failed to scan audit event", "error", err)
+
+			return checked, nil, err
+		}
+
+		if reason := db.ChainError(prev, e, changes); reason != "" {
+			return checked, &db.ChainBreak{ID: e.ID, Reason: reason}, nil
+		}
+		if e.Hash != nil {
+			prev = e
+			checked++
+		}
+	}
+
+	return checked, nil, rows.Err()
+}
+
+// FindHash returns the ID of the event with the hash, or db.ErrNoAuditEvents if there is none.
+func (t *AuditTable) FindHash(ctx context.Context, hash []byte) (int, error) {
+	var id int
+	err := t.conn.QueryRowContext(ctx, "SELECT id FROM audit_events WHERE hash=$1", hash).
+		Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, db.ErrNoAuditEvents
+		}
+		t.log(ctx).Error("msg", "failed to find audit event by hash", "error", err)
+
+		return 0, err
+	}
+
+	return id, nil
+}
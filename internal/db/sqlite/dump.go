@@ -0,0 +1,246 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// Dump takes a snapshot of the database, like db.Dump. The tables are read in one transaction, so
+// the snapshot is consistent even while the server is running.
+func Dump(ctx context.Context, conn *sql.DB) (*db.Snapshot, error) {
+	out := db.Snapshot{Format: db.SnapshotFormat, Database: Scheme, CreatedAt: time.Now().UTC()}
+
+	err := inTx(ctx, conn, func(tx *sql.Tx) error {
+		var err error
+		out.Migration, _, err = MigrationVersion(ctx, tx)
+		if err != nil {
+			return fmt.Errorf("get migration version: %w", err)
+		}
+
+		out.Users, err = collect(ctx, tx, "SELECT id, "+selectFields+" FROM users ORDER BY id",
+			func(row *sql.Rows) (*db.User, error) {
+				var u db.User
+				err := row.Scan(&u.ID, &u.Name, &u.NameKeyHash, &u.FinishYear, &u.Professor, &u.TA,
+					&u.StudentLeadership, &u.AlumniBoard, &u.Version)
+
+				return &u, err
+			})
+		if err != nil {
+			return fmt.Errorf("dump users: %w", err)
+		}
+
+		out.Admins, err = collect(ctx, tx, "SELECT "+adminFields+" FROM admins ORDER BY id",
+			func(row *sql.Rows) (*api.Admin, error) {
+				a, err := scanAdmin(row)
+				if err != nil {
+					return nil, err
+				}
+
+				return a.API(), nil
+			})
+		if err != nil {
+			return fmt.Errorf("dump admins: %w", err)
+		}
+
+		out.AuditEvents, err = collect(ctx, tx,
+			"SELECT "+auditFields+" FROM audit_events ORDER BY id",
+			func(row *sql.Rows) (*api.AuditEvent, error) {
+				e, _, err := scanAuditEvent(row)
+				if err != nil {
+					return nil, err
+				}
+
+				return e.API(), nil
+			})
+		if err != nil {
+			return fmt.Errorf("dump audit events: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+func collect[T any](
+	ctx context.Context, q querier, query string, scan func(*sql.Rows) (T, error),
+) ([]T, error) {
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []T{}
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+
+	return out, rows.Err()
+}
+
+// Restore returns the database to the snapshot in one transaction, in the same way as db.Restore.
+// The snapshot must be from a SQLite database.
+func Restore(ctx context.Context, conn *sql.DB, s *db.Snapshot) (*db.RestoreSummary, error) {
+	if s.Format != db.SnapshotFormat {
+		return nil, fmt.Errorf("%w: unknown snapshot format %d", db.ErrSnapshotMismatch, s.Format)
+	}
+	if s.Database != Scheme {
+		return nil, fmt.Errorf("%w: the snapshot is not from a SQLite database",
+			db.ErrSnapshotMismatch)
+	}
+
+	var out db.RestoreSummary
+	err := inTx(ctx, conn, func(tx *sql.Tx) error {
+		version, dirty, err := MigrationVersion(ctx, tx)
+		if err != nil {
+			return fmt.Errorf("get migration version: %w", err)
+		}
+		if version != s.Migration || dirty {
+			return fmt.Errorf(
+				"%w: the snapshot is from migration %d, but the database is at migration %d",
+				db.ErrSnapshotMismatch, s.Migration, version)
+		}
+
+		err = restoreUsers(ctx, tx, s.Users, &out)
+		if err != nil {
+			return fmt.Errorf("restore users: %w", err)
+		}
+		err = restoreAdmins(ctx, tx, s.Admins, &out)
+		if err != nil {
+			return fmt.Errorf("restore admins: %w", err)
+		}
+		// The transaction holds the write lock, so the server can't chain events meanwhile.
+		err = restoreAudit(ctx, tx, s.AuditEvents, &out)
+		if err != nil {
+			return fmt.Errorf("restore audit events: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+func restoreUsers(ctx context.Context, tx *sql.Tx, users []*db.User, out *db.RestoreSummary) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM users")
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO users (id, "+selectFields+") "+
+				"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+			u.ID, u.Name, u.NameKeyHash, u.FinishYear, u.Professor, u.TA, u.StudentLeadership,
+			u.AlumniBoard, u.Version,
+		)
+		if err != nil {
+			return invalidUser(err)
+		}
+		out.Users++
+	}
+
+	return nil
+}
+
+func restoreAdmins(
+	ctx context.Context, tx *sql.Tx, admins []*api.Admin, out *db.RestoreSummary,
+) error {
+	for _, a := range admins {
+		res, err := tx.ExecContext(ctx,
+			"UPDATE admins SET role=$2, disabled=$3 WHERE username=$1",
+			a.Username, a.Role, a.Disabled)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			out.AdminsUpdated++
+
+			continue
+		}
+
+		// An empty password never matches a bcrypt hash.
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO admins (username, password, role, disabled, created_at) "+
+				"VALUES ($1, '', $2, $3, $4)",
+			a.Username, a.Role, a.Disabled, a.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		out.AdminsCreated++
+	}
+
+	return nil
+}
+
+func restoreAudit(
+	ctx context.Context, tx *sql.Tx, events []*api.AuditEvent, out *db.RestoreSummary,
+) error {
+	for _, a := range events {
+		e, changes, err := db.AuditEventFromAPI(a)
+		if err != nil {
+			return fmt.Errorf("event %d: %w", a.ID, err)
+		}
+
+		var hash []byte
+		err = tx.QueryRowContext(ctx, "SELECT hash FROM audit_events WHERE id=$1", e.ID).
+			Scan(&hash)
+		if err == nil {
+			if hex.EncodeToString(hash) != a.Hash {
+				return fmt.Errorf("%w: audit event %d is different", db.ErrSnapshotMismatch, e.ID)
+			}
+			out.AuditUnchanged++
+
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		var stored any
+		if changes != nil {
+			stored = string(changes)
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO audit_events "+
+				"(id, created_at, actor, token, action, target, changes, request_id, prev_hash, "+
+				"hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+			e.ID, e.CreatedAt, e.Actor, e.Token, e.Action, e.Target, stored, e.RequestID,
+			blob(e.PrevHash), blob(e.Hash),
+		)
+		if err != nil {
+			return err
+		}
+		out.AuditEvents++
+	}
+
+	return nil
+}
+
+// blob returns b to be stored as a BLOB, or nil to store NULL if b is nil.
+func blob(b []byte) any {
+	if b == nil {
+		return nil
+	}
+
+	return b
+}
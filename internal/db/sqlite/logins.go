@@ -0,0 +1,215 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+)
+
+// LoginTable is the SQLite version of db.LoginTable.
+type LoginTable struct {
+	logger log.Logger
+	conn   *sql.DB
+}
+
+var _ db.LoginStore = (*LoginTable)(nil)
+
+// NewLoginTable creates a new LoginTable in the SQLite database.
+func NewLoginTable(l log.Logger, conn *sql.DB) *LoginTable {
+	out := LoginTable{
+		logger: l,
+		conn:   conn,
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (t *LoginTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(t.logger, ctx)
+}
+
+// GetFailures returns the recent failed logins for the username and for the IP address.
+func (t *LoginTable) GetFailures(
+	ctx context.Context, user, ip string,
+) (byUser, byIP db.FailureCount, err error) {
+	rows, err := t.conn.QueryContext(ctx,
+		"SELECT kind, failures, last_failure FROM login_failure_counts "+
+			"WHERE ((kind='user' AND subject=$1) OR (kind='ip' AND subject=$2)) "+
+			"AND last_failure > $3",
+		user, ip, now().Add(-db.FailureWindow),
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to get login failures", "user", user, "ip", ip, "error", err)
+
+		return byUser, byIP, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind string
+		var count db.FailureCount
+		err = rows.Scan(&kind, &count.Failures, &count.LastFailure)
+		if err != nil {
+			t.log(ctx).Error("msg", "failed to scan login failures", "error", err)
+
+			return byUser, byIP, err
+		}
+
+		if kind == "user" {
+			byUser = count
+		} else {
+			byIP = count
+		}
+	}
+
+	return byUser, byIP, rows.Err()
+}
+
+// countFailure increments a failure counter, starting again from one if the last failure is older
+// than db.FailureWindow.
+const countFailure = "INSERT INTO login_failure_counts (kind, subject, failures, last_failure) " +
+	"VALUES ($1, $2, 1, $3) ON CONFLICT (kind, subject) DO UPDATE SET " +
+	"failures=CASE WHEN last_failure > $4 THEN failures+1 ELSE 1 END, last_failure=$3 " +
+	"RETURNING failures"
+
+// RecordFailure stores a failed login event and increments the failure counters for the username
+// and IP address. It returns the new number of recent failures for the username.
+func (t *LoginTable) RecordFailure(ctx context.Context, user, ip, reason string) (int, error) {
+	var failures int
+	err := inTx(ctx, t.conn, func(tx *sql.Tx) error {
+		failed := now()
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO login_failures (username, ip, reason, created_at) VALUES ($1, $2, $3, $4)",
+			user, ip, reason, failed,
+		)
+		if err != nil {
+			return err
+		}
+
+		var ipFailures int
+		windowStart := failed.Add(-db.FailureWindow)
+		err = tx.QueryRowContext(ctx, countFailure, "ip", ip, failed, windowStart).
+			Scan(&ipFailures)
+		if err != nil {
+			return err
+		}
+
+		return tx.QueryRowContext(ctx, countFailure, "user", user, failed, windowStart).
+			Scan(&failures)
+	})
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to record login failure", "user", user, "ip", ip,
+			"error", err)
+
+		return 0, err
+	}
+
+	t.log(ctx).Info("msg", "recorded login failure", "user", user, "ip", ip, "reason", reason,
+		"failures", failures)
+
+	return failures, nil
+}
+
+// ClearFailures resets the failure counter for the username after a successful login. The counter
+// for the IP address is left alone.
+func (t *LoginTable) ClearFailures(ctx context.Context, user string) error {
+	_, err := t.conn.ExecContext(ctx,
+		"DELETE FROM login_failure_counts WHERE kind='user' AND subject=$1", user)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to clear login failures", "user", user, "error", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Lock locks the admin out until Unlock is called. Nothing happens if the admin does not exist or
+// is already locked.
+func (t *LoginTable) Lock(ctx context.Context, user string) error {
+	res, err := t.conn.ExecContext(ctx,
+		"UPDATE admins SET locked_at=$2 WHERE username=$1 AND locked_at IS NULL", user, now())
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to lock admin", "user", user, "error", err)
+
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		t.log(ctx).Info("msg", "locked admin after too many failed logins", "user", user)
+	}
+
+	return nil
+}
+
+// Unlock clears the lockout and failure counter for the admin. db.ErrNoUser is returned if the
+// admin is not in the database.
+func (t *LoginTable) Unlock(ctx context.Context, user string) error {
+	var found bool
+	err := inTx(ctx, t.conn, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			"DELETE FROM login_failure_counts WHERE kind='user' AND subject=$1", user)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, "UPDATE admins SET locked_at=NULL WHERE username=$1", user)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		found = n == 1
+
+		return nil
+	})
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to unlock admin", "user", user, "error", err)
+
+		return err
+	}
+	if !found {
+		t.log(ctx).Info("msg", "no user found to unlock", "user", user)
+
+		return db.ErrNoUser
+	}
+
+	t.log(ctx).Debug("msg", "unlocked admin", "user", user)
+
+	return nil
+}
+
+// ListFailures returns the most recent failed logins, newest first. If user is not empty, only
+// failures for that username are returned.
+func (t *LoginTable) ListFailures(
+	ctx context.Context, user string, limit int,
+) ([]*db.LoginFailure, error) {
+	rows, err := t.conn.QueryContext(ctx,
+		"SELECT id, username, ip, reason, created_at FROM login_failures "+
+			"WHERE $1='' OR username=$1 ORDER BY id DESC LIMIT $2",
+		user, limit,
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to list login failures", "error", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*db.LoginFailure
+	for rows.Next() {
+		var f db.LoginFailure
+		err = rows.Scan(&f.ID, &f.Username, &f.IP, &f.Reason, &f.CreatedAt)
+		if err != nil {
+			t.log(ctx).Error("msg", "failed to scan login failure", "error", err)
+
+			return out, err
+		}
+
+		out = append(out, &f)
+	}
+
+	return out, rows.Err()
+}
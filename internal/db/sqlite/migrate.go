@@ -0,0 +1,151 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite" // registers sqlite:// for migrations
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/kylrth/disco-bouncer/internal/db"
+)
+
+//go:embed migrations
+var migrationsFS embed.FS
+
+// ApplyMigrations creates the database file in the URL if it doesn't exist, and ensures all
+// migrations are applied to it.
+func ApplyMigrations(dbURL string) error {
+	return runMigrations(dbURL, func(m *migrate.Migrate, _ source.Driver) error {
+		return m.Up()
+	})
+}
+
+// MigrateTo migrates the database in the URL up or down to the version. Version 0 removes every
+// table.
+func MigrateTo(dbURL string, version int) error {
+	latest, err := LatestMigration()
+	if err != nil {
+		return err
+	}
+	if version < 0 || version > latest {
+		return fmt.Errorf("%w: %d is not between 0 and %d", db.ErrNoMigration, version, latest)
+	}
+
+	return runMigrations(dbURL, func(m *migrate.Migrate, _ source.Driver) error {
+		return migrateTo(m, uint(version)) //nolint:gosec // The version is checked above.
+	})
+}
+
+func migrateTo(m *migrate.Migrate, version uint) error {
+	if version == 0 {
+		return m.Down()
+	}
+
+	return m.Migrate(version)
+}
+
+// MigrateDown reverts the newest n migrations applied to the database in the URL.
+func MigrateDown(dbURL string, n int) error {
+	if n <= 0 {
+		return fmt.Errorf("%w: can't revert %d migrations", db.ErrNoMigration, n)
+	}
+
+	return runMigrations(dbURL, func(m *migrate.Migrate, d source.Driver) error {
+		// The target is found first, because Steps reverts everything before finding out that there
+		// are fewer than n migrations.
+		v, _, err := m.Version()
+		if errors.Is(err, migrate.ErrNilVersion) {
+			return fmt.Errorf("%w: no migrations are applied", db.ErrNoMigration)
+		}
+		if err != nil {
+			return err
+		}
+
+		target := v
+		for i := range n {
+			target, err = d.Prev(target)
+			if errors.Is(err, fs.ErrNotExist) && i == n-1 {
+				target = 0
+			} else if errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("%w: only %d migrations are applied", db.ErrNoMigration, i+1)
+			} else if err != nil {
+				return fmt.Errorf("read migrations: %w", err)
+			}
+		}
+
+		return migrateTo(m, target)
+	})
+}
+
+func runMigrations(dbURL string, f func(m *migrate.Migrate, d source.Driver) error) error {
+	path, err := Path(dbURL)
+	if err != nil {
+		return err
+	}
+
+	d, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return fmt.Errorf("load migrations: %w", err)
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", d,
+		Scheme+"://"+path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer m.Close()
+
+	err = f(m, d)
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+
+	return err
+}
+
+// LatestMigration returns the version of the newest migration, which ApplyMigrations brings the
+// database to.
+func LatestMigration() (int, error) {
+	d, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return 0, fmt.Errorf("load migrations: %w", err)
+	}
+
+	v, err := d.First()
+	for err == nil {
+		var next uint
+		next, err = d.Next(v)
+		if err == nil {
+			v = next
+		}
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("read migrations: %w", err)
+	}
+
+	return int(v), nil //nolint:gosec // Migration versions are small.
+}
+
+// querier is the part of *sql.DB shared with *sql.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// MigrationVersion returns the migration the database is at, and whether a migration failed
+// partway through.
+func MigrationVersion(ctx context.Context, conn querier) (version int, dirty bool, err error) {
+	err = conn.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").
+		Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
@@ -0,0 +1,8 @@
+DROP TABLE sessions;
+DROP TABLE discord_admins;
+DROP TABLE admin_sessions;
+DROP TABLE login_failures;
+DROP TABLE login_failure_counts;
+DROP TABLE totp_recovery_codes;
+DROP TABLE admins;
+DROP TABLE users;
@@ -0,0 +1,84 @@
+-- This is the whole schema of the Postgres migrations up to the audit trail, except for the tables
+-- of API tokens, single sign-on, and the audit trail, which are added by the next migration. Times
+-- are stored as Unix microseconds.
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL
+        CONSTRAINT users_name_ciphertext CHECK (name REGEXP '^([0-9a-fA-F]{2}){28,}$'),
+    name_key_hash TEXT NOT NULL
+        CONSTRAINT users_name_key_hash_hex CHECK (name_key_hash REGEXP '^([0-9a-fA-F]{2})+$'),
+    finish_year TEXT NOT NULL
+        CONSTRAINT users_finish_year_format
+            CHECK (finish_year = '' OR finish_year REGEXP '^[0-9]{4}'),
+    professor BOOLEAN NOT NULL DEFAULT FALSE,
+    ta BOOLEAN NOT NULL DEFAULT FALSE,
+    student_leadership BOOLEAN NOT NULL DEFAULT FALSE,
+    alumni_board BOOLEAN NOT NULL DEFAULT FALSE,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX users_name_key_hash_idx ON users (name_key_hash);
+
+CREATE TABLE admins (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT UNIQUE NOT NULL,
+    password TEXT NOT NULL,
+    role TEXT NOT NULL DEFAULT 'owner'
+        CONSTRAINT admins_role_known CHECK (role IN ('viewer', 'uploader', 'migrator', 'owner')),
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at DATETIME NOT NULL,
+    last_login DATETIME,
+    totp_secret TEXT,
+    totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    totp_last_step INTEGER,
+    locked_at DATETIME
+);
+
+-- These are the SHA-256 hashes of the unused recovery codes, which Postgres keeps in an array.
+CREATE TABLE totp_recovery_codes (
+    admin_id INTEGER NOT NULL REFERENCES admins (id) ON DELETE CASCADE,
+    hash TEXT NOT NULL,
+    PRIMARY KEY (admin_id, hash)
+);
+
+CREATE TABLE login_failure_counts (
+    kind TEXT NOT NULL CHECK (kind IN ('user', 'ip')),
+    subject TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    last_failure DATETIME NOT NULL,
+    PRIMARY KEY (kind, subject)
+);
+
+CREATE TABLE login_failures (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE INDEX login_failures_username ON login_failures (username, created_at);
+
+CREATE TABLE admin_sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_key TEXT UNIQUE NOT NULL,
+    admin_id INTEGER NOT NULL REFERENCES admins (id) ON DELETE CASCADE,
+    created_at DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL,
+    ip TEXT NOT NULL,
+    user_agent TEXT NOT NULL
+);
+
+CREATE TABLE discord_admins (
+    discord_id TEXT PRIMARY KEY,
+    admin_id INTEGER UNIQUE NOT NULL REFERENCES admins (id) ON DELETE CASCADE,
+    created_at DATETIME NOT NULL
+);
+
+-- The session data, kept by the session middleware. The expiration is in Unix seconds, or 0 for
+-- none.
+CREATE TABLE sessions (
+    k TEXT PRIMARY KEY,
+    v BLOB NOT NULL,
+    e INTEGER NOT NULL DEFAULT 0
+);
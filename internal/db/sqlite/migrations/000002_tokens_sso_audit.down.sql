@@ -0,0 +1,4 @@
+DROP TABLE audit_events;
+DROP TABLE login_codes;
+DROP TABLE oidc_identities;
+DROP TABLE api_tokens;
@@ -0,0 +1,48 @@
+-- These are the tables of the Postgres migrations for API tokens, single sign-on, and the audit
+-- trail. The scopes of a token are a JSON array, which Postgres keeps in an array.
+CREATE TABLE api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    admin_id INTEGER NOT NULL REFERENCES admins (id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    hash TEXT UNIQUE NOT NULL,
+    scopes TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME,
+    last_used_at DATETIME,
+    UNIQUE (admin_id, name)
+);
+
+CREATE TABLE oidc_identities (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL CHECK (kind IN ('subject', 'email')),
+    value TEXT NOT NULL,
+    username TEXT NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('viewer', 'uploader', 'migrator', 'owner')),
+    created_at DATETIME NOT NULL,
+    UNIQUE (kind, value)
+);
+
+CREATE TABLE login_codes (
+    hash TEXT PRIMARY KEY,
+    admin_id INTEGER NOT NULL REFERENCES admins (id) ON DELETE CASCADE,
+    expires_at DATETIME NOT NULL
+);
+
+-- The first chained event has an empty prev_hash, and the unique index keeps the chain from
+-- forking.
+CREATE TABLE audit_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME NOT NULL,
+    actor TEXT NOT NULL,
+    token TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    target TEXT NOT NULL DEFAULT '',
+    changes TEXT,
+    request_id TEXT NOT NULL DEFAULT '',
+    prev_hash BLOB,
+    hash BLOB
+);
+
+CREATE INDEX audit_events_actor_idx ON audit_events (actor, id);
+CREATE INDEX audit_events_target_idx ON audit_events (target, id);
+CREATE UNIQUE INDEX audit_events_prev_hash_idx ON audit_events (prev_hash);
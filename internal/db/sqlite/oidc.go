@@ -0,0 +1,208 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// OIDCTable is the SQLite version of db.OIDCTable.
+type OIDCTable struct {
+	logger log.Logger
+	conn   *sql.DB
+}
+
+var _ db.OIDCStore = (*OIDCTable)(nil)
+
+// NewOIDCTable creates a new OIDCTable in the SQLite database.
+func NewOIDCTable(l log.Logger, conn *sql.DB) *OIDCTable {
+	out := OIDCTable{
+		logger: l,
+		conn:   conn,
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (t *OIDCTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(t.logger, ctx)
+}
+
+const identityFields = "id, kind, value, username, role, created_at"
+
+func scanIdentity(row interface{ Scan(dest ...any) error }) (*db.Identity, error) {
+	var out db.Identity
+	err := row.Scan(&out.ID, &out.Kind, &out.Value, &out.Username, &out.Role, &out.CreatedAt)
+
+	return &out, err
+}
+
+// AddIdentity allows the identity to log in as the admin, and returns the ID of the new allowlist
+// entry. db.ErrIdentityExists is returned if the identity is already allowed.
+func (t *OIDCTable) AddIdentity(
+	ctx context.Context, kind, value, user string, role api.Role,
+) (int, error) {
+	if kind == db.IdentityEmail {
+		value = strings.ToLower(value)
+	}
+
+	var id int
+	err := t.conn.QueryRowContext(ctx,
+		"INSERT INTO oidc_identities (kind, value, username, role, created_at) "+
+			"VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		kind, value, user, string(role), now(),
+	).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			t.log(ctx).Info("msg", "OIDC identity already allowed", "kind", kind, "value", value)
+
+			return 0, db.ErrIdentityExists
+		}
+
+		t.log(ctx).Error("msg", "failed to add OIDC identity", "kind", kind, "value", value,
+			"error", err)
+
+		return 0, err
+	}
+
+	t.log(ctx).Debug("msg", "added OIDC identity", "kind", kind, "value", value, "user", user,
+		"role", role)
+
+	return id, nil
+}
+
+// ListIdentities returns the allowlist, ordered by ID.
+func (t *OIDCTable) ListIdentities(ctx context.Context) ([]*db.Identity, error) {
+	rows, err := t.conn.QueryContext(ctx,
+		"SELECT "+identityFields+" FROM oidc_identities ORDER BY id")
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to list OIDC identities", "error", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*db.Identity
+	for rows.Next() {
+		identity, scanErr := scanIdentity(rows)
+		if scanErr != nil {
+			t.log(ctx).Error("msg", "failed to scan OIDC identity", "error", scanErr)
+
+			return out, scanErr
+		}
+
+		out = append(out, identity)
+	}
+
+	return out, rows.Err()
+}
+
+// RemoveIdentity removes the allowlist entry by ID. db.ErrNoIdentity is returned if it does not
+// exist. Admins created by the entry are not removed.
+func (t *OIDCTable) RemoveIdentity(ctx context.Context, id int) error {
+	res, err := t.conn.ExecContext(ctx, "DELETE FROM oidc_identities WHERE id=$1", id)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to remove OIDC identity", "id", id, "error", err)
+
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.log(ctx).Info("msg", "no OIDC identity found to remove", "id", id)
+
+		return db.ErrNoIdentity
+	}
+
+	t.log(ctx).Debug("msg", "removed OIDC identity", "id", id)
+
+	return nil
+}
+
+// MatchIdentity returns the allowlist entry for the subject, or else for the email address if it
+// is not empty. db.ErrNoIdentity is returned if neither is allowed.
+func (t *OIDCTable) MatchIdentity(
+	ctx context.Context, subject, email string,
+) (*db.Identity, error) {
+	identity, err := scanIdentity(t.conn.QueryRowContext(ctx,
+		"SELECT "+identityFields+" FROM oidc_identities "+
+			"WHERE (kind='subject' AND value=$1) OR (kind='email' AND $2<>'' AND value=$2) "+
+			"ORDER BY kind DESC LIMIT 1",
+		subject, strings.ToLower(email),
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, db.ErrNoIdentity
+		}
+
+		t.log(ctx).Error("msg", "failed to match OIDC identity", "subject", subject, "error", err)
+
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// CreateLoginCode returns a one-time code that can be exchanged for a session as the admin until
+// the TTL passes. Expired codes are cleaned up at the same time.
+func (t *OIDCTable) CreateLoginCode(
+	ctx context.Context, adminID int, ttl time.Duration,
+) (string, error) {
+	code, err := newPassword()
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to generate login code", "error", err)
+
+		return "", err
+	}
+
+	created := now()
+	err = inTx(ctx, t.conn, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM login_codes WHERE expires_at <= $1", created)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO login_codes (hash, admin_id, expires_at) VALUES ($1, $2, $3)",
+			db.HashToken(code), adminID, created.Add(ttl),
+		)
+
+		return err
+	})
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to store login code", "adminID", adminID, "error", err)
+
+		return "", err
+	}
+
+	return code, nil
+}
+
+// UseLoginCode deletes the login code and returns the ID of the admin it was created for.
+// db.ErrNoLoginCode is returned if the code does not exist or has expired.
+func (t *OIDCTable) UseLoginCode(ctx context.Context, code string) (int, error) {
+	var adminID int
+	err := t.conn.QueryRowContext(ctx,
+		"DELETE FROM login_codes WHERE hash=$1 AND expires_at > $2 RETURNING admin_id",
+		db.HashToken(code), now(),
+	).Scan(&adminID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			t.log(ctx).Info("msg", "invalid or expired login code")
+
+			return 0, db.ErrNoLoginCode
+		}
+
+		t.log(ctx).Error("msg", "failed to use login code", "error", err)
+
+		return 0, err
+	}
+
+	return adminID, nil
+}
@@ -0,0 +1,165 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+)
+
+// SessionTable is the SQLite version of db.SessionTable. The session data itself is kept by
+// SessionStorage.
+type SessionTable struct {
+	logger log.Logger
+	conn   *sql.DB
+}
+
+var _ db.SessionStore = (*SessionTable)(nil)
+
+// NewSessionTable creates a new SessionTable in the SQLite database.
+func NewSessionTable(l log.Logger, conn *sql.DB) *SessionTable {
+	out := SessionTable{
+		logger: l,
+		conn:   conn,
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (t *SessionTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(t.logger, ctx)
+}
+
+// CreateSession starts tracking a new session for the admin.
+func (t *SessionTable) CreateSession(
+	ctx context.Context, key string, adminID int, ip, userAgent string,
+) error {
+	created := now()
+	_, err := t.conn.ExecContext(ctx,
+		"INSERT INTO admin_sessions (session_key, admin_id, created_at, last_seen, ip, user_agent) "+
+			"VALUES ($1, $2, $3, $3, $4, $5)",
+		key, adminID, created, ip, userAgent,
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to create session", "adminID", adminID, "error", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// TouchSession records that the session was used from the IP address. It returns false if the
+// session does not belong to the admin, was revoked, has been idle for longer than idle, or was
+// created more than maxAge ago.
+func (t *SessionTable) TouchSession(
+	ctx context.Context, key string, adminID int, ip string, idle, maxAge time.Duration,
+) (bool, error) {
+	seen := now()
+	res, err := t.conn.ExecContext(ctx,
+		"UPDATE admin_sessions SET last_seen=$3, ip=$4 "+
+			"WHERE session_key=$1 AND admin_id=$2 AND last_seen > $5 AND created_at > $6",
+		key, adminID, seen, ip, seen.Add(-idle), seen.Add(-maxAge),
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to touch session", "adminID", adminID, "error", err)
+
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+
+	return n == 1, nil
+}
+
+// DeleteSession stops tracking the session. Nothing happens if the session does not exist.
+func (t *SessionTable) DeleteSession(ctx context.Context, key string) error {
+	_, err := t.conn.ExecContext(ctx, "DELETE FROM admin_sessions WHERE session_key=$1", key)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to delete session", "error", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// ListSessions returns the sessions of the admin, most recently used first.
+func (t *SessionTable) ListSessions(ctx context.Context, user string) ([]*db.Session, error) {
+	rows, err := t.conn.QueryContext(ctx,
+		"SELECT s.id, s.session_key, s.created_at, s.last_seen, s.ip, s.user_agent "+
+			"FROM admin_sessions s JOIN admins a ON a.id=s.admin_id "+
+			"WHERE a.username=$1 ORDER BY s.last_seen DESC",
+		user,
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to list sessions", "user", user, "error", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*db.Session
+	for rows.Next() {
+		var s db.Session
+		err = rows.Scan(&s.ID, &s.Key, &s.CreatedAt, &s.LastSeen, &s.IP, &s.UserAgent)
+		if err != nil {
+			t.log(ctx).Error("msg", "failed to scan session", "error", err)
+
+			return out, err
+		}
+
+		out = append(out, &s)
+	}
+
+	t.log(ctx).Debug("msg", "listed sessions", "user", user, "count", len(out))
+
+	return out, rows.Err()
+}
+
+// RevokeSession revokes the admin's session by ID. db.ErrNoSession is returned if the admin has no
+// session with that ID.
+func (t *SessionTable) RevokeSession(ctx context.Context, user string, id int) error {
+	res, err := t.conn.ExecContext(ctx,
+		"DELETE FROM admin_sessions WHERE id=$2 "+
+			"AND admin_id=(SELECT id FROM admins WHERE username=$1)",
+		user, id,
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to revoke session", "user", user, "id", id, "error", err)
+
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.log(ctx).Info("msg", "no session found to revoke", "user", user, "id", id)
+
+		return db.ErrNoSession
+	}
+
+	t.log(ctx).Debug("msg", "revoked session", "user", user, "id", id)
+
+	return nil
+}
+
+// RevokeOtherSessions revokes every session of the admin except the one with the key, which may be
+// empty to revoke them all.
+func (t *SessionTable) RevokeOtherSessions(ctx context.Context, user, keep string) error {
+	res, err := t.conn.ExecContext(ctx,
+		"DELETE FROM admin_sessions WHERE session_key<>$2 "+
+			"AND admin_id=(SELECT id FROM admins WHERE username=$1)",
+		user, keep,
+	)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to revoke sessions", "user", user, "error", err)
+
+		return err
+	}
+	n, _ := res.RowsAffected()
+
+	t.log(ctx).Debug("msg", "revoked sessions", "user", user, "count", n)
+
+	return nil
+}
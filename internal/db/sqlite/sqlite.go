@@ -0,0 +1,135 @@
+// Package sqlite stores everything the server keeps in an embedded SQLite database, for
+// communities that don't want to run Postgres. The tables have the same methods and errors as
+// the Postgres tables in package db.
+package sqlite
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Scheme is the scheme of SQLite database URLs. The rest of the URL is the path of the database
+// file, so "sqlite://bouncer.db" is relative to the working directory and
+// "sqlite:///var/lib/bouncer/bouncer.db" is absolute.
+const Scheme = "sqlite"
+
+// Path returns the path of the database file in the URL.
+func Path(dbURL string) (string, error) {
+	path, ok := strings.CutPrefix(dbURL, Scheme+"://")
+	if !ok {
+		return "", fmt.Errorf("not a %s:// URL", Scheme)
+	}
+	path, _, _ = strings.Cut(path, "?")
+	if path == "" {
+		return "", errors.New("missing database file path")
+	}
+
+	return path, nil
+}
+
+// The connection settings. Writers wait for each other instead of failing, and transactions take
+// the write lock when they begin, so that they never fail to upgrade a read lock. Times are stored
+// as integers so that they can be compared.
+const connParams = "_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)" +
+	"&_txlock=immediate&_time_integer_format=unix_micro&_inttotime=1"
+
+// Open opens the database file in the URL. The migrations must already be applied.
+func Open(dbURL string) (*sql.DB, error) {
+	path, err := Path(dbURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite", "file:"+path+"?"+connParams)
+}
+
+func init() {
+	// SQLite parses REGEXP but leaves it to the application to define. The constraints on the users
+	// table use it.
+	var cache sync.Map
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2,
+		func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			pattern, ok1 := args[0].(string)
+			s, ok2 := args[1].(string)
+			if !ok1 || !ok2 {
+				return nil, nil //nolint:nilnil // NULL doesn't match anything
+			}
+
+			re, ok := cache.Load(pattern)
+			if !ok {
+				compiled, err := regexp.Compile(pattern)
+				if err != nil {
+					return nil, err
+				}
+				re, _ = cache.LoadOrStore(pattern, compiled)
+			}
+
+			return re.(*regexp.Regexp).MatchString(s), nil //nolint:forcetypeassert // only *Regexp
+		})
+}
+
+// isUniqueViolation returns true if err was caused by a UNIQUE or PRIMARY KEY constraint.
+func isUniqueViolation(err error) bool {
+	var e *sqlite.Error
+
+	return errors.As(err, &e) && (e.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE ||
+		e.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY)
+}
+
+// checkViolation returns the name of the CHECK constraint that caused err, if any.
+func checkViolation(err error) (string, bool) {
+	var e *sqlite.Error
+	if !errors.As(err, &e) || e.Code() != sqlite3.SQLITE_CONSTRAINT_CHECK {
+		return "", false
+	}
+
+	// The message ends with "CHECK constraint failed: <name> (275)".
+	_, name, _ := strings.Cut(e.Error(), "CHECK constraint failed: ")
+	name, _, _ = strings.Cut(name, " ")
+
+	return name, true
+}
+
+// newPassword returns a random password that nobody knows.
+func newPassword() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// inTx runs f in a transaction, which is committed if f succeeds.
+func inTx(ctx context.Context, conn *sql.DB, f func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck // This does nothing after Commit.
+
+	err = f(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// now returns the current time as it is stored.
+func now() time.Time {
+	return time.Now().UTC()
+}
@@ -0,0 +1,87 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SessionStorage keeps the session data of the session middleware in the sessions table. It is
+// the SQLite version of the Postgres storage from gofiber/storage.
+type SessionStorage struct {
+	conn *sql.DB
+}
+
+var _ fiber.Storage = (*SessionStorage)(nil)
+
+// NewSessionStorage creates a SessionStorage in the SQLite database.
+func NewSessionStorage(conn *sql.DB) *SessionStorage {
+	return &SessionStorage{conn: conn}
+}
+
+// Get returns the value for the key, or nil if there is none or it expired.
+func (s *SessionStorage) Get(key string) ([]byte, error) {
+	var v []byte
+	err := s.conn.QueryRowContext(context.Background(),
+		"SELECT v FROM sessions WHERE k=$1 AND (e=0 OR e>$2)", key, time.Now().Unix(),
+	).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return v, err
+}
+
+// Set stores the value for the key. If exp is not zero, the value expires after exp. Expired
+// values are deleted here, since sessions are set on every login.
+func (s *SessionStorage) Set(key string, val []byte, exp time.Duration) error {
+	if key == "" || len(val) == 0 {
+		return nil
+	}
+
+	var expires int64
+	if exp != 0 {
+		expires = time.Now().Add(exp).Unix()
+	}
+
+	return inTx(context.Background(), s.conn, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM sessions WHERE e<>0 AND e<=$1", time.Now().Unix())
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			"INSERT INTO sessions (k, v, e) VALUES ($1, $2, $3) "+
+				"ON CONFLICT (k) DO UPDATE SET v=$2, e=$3",
+			key, val, expires,
+		)
+
+		return err
+	})
+}
+
+// Delete removes the value for the key.
+func (s *SessionStorage) Delete(key string) error {
+	if key == "" {
+		return nil
+	}
+
+	_, err := s.conn.Exec("DELETE FROM sessions WHERE k=$1", key)
+
+	return err
+}
+
+// Reset removes every value.
+func (s *SessionStorage) Reset() error {
+	_, err := s.conn.Exec("DELETE FROM sessions")
+
+	return err
+}
+
+// Close does nothing. The database is closed by its owner.
+func (s *SessionStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,193 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+)
+
+// TokenTable is the SQLite version of db.TokenTable.
+type TokenTable struct {
+	logger log.Logger
+	conn   *sql.DB
+}
+
+var _ db.TokenStore = (*TokenTable)(nil)
+
+// NewTokenTable creates a new TokenTable in the SQLite database.
+func NewTokenTable(l log.Logger, conn *sql.DB) *TokenTable {
+	out := TokenTable{
+		logger: l,
+		conn:   conn,
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (t *TokenTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(t.logger, ctx)
+}
+
+// newToken returns a new random API token.
+func newToken() (string, error) {
+	secret, err := newPassword()
+	if err != nil {
+		return "", err
+	}
+
+	return db.TokenPrefix + secret, nil
+}
+
+// CreateToken creates a new token for the admin with the given name and scopes, and returns the
+// plaintext token. If expires is nil, the token does not expire. db.ErrNoUser is returned if the
+// admin does not exist.
+func (t *TokenTable) CreateToken(
+	ctx context.Context, admin, name string, scopes []string, expires *time.Time,
+) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to generate token", "admin", admin, "error", err)
+
+		return "", err
+	}
+	encoded, err := json.Marshal(scopes)
+	if err != nil {
+		return "", err
+	}
+
+	var id int
+	err = t.conn.QueryRowContext(ctx,
+		"INSERT INTO api_tokens (admin_id, name, hash, scopes, created_at, expires_at) "+
+			"SELECT id, $2, $3, $4, $5, $6 FROM admins WHERE username=$1 RETURNING id",
+		admin, name, db.HashToken(token), string(encoded), now(), expires,
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		t.log(ctx).Info("msg", "no admin found to create token", "admin", admin)
+
+		return "", db.ErrNoUser
+	}
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to store new token", "admin", admin, "error", err)
+
+		return "", err
+	}
+
+	t.log(ctx).Debug("msg", "created token", "id", id, "admin", admin, "name", name,
+		"scopes", strings.Join(scopes, ","))
+
+	return token, nil
+}
+
+const tokenFields = "t.id, a.username, a.role, t.name, t.scopes, t.created_at, t.expires_at, " +
+	"t.last_used_at"
+
+func scanToken(row interface{ Scan(dest ...any) error }) (*db.Token, error) {
+	var tok db.Token
+	var scopes string
+	err := row.Scan(&tok.ID, &tok.Admin, &tok.AdminRole, &tok.Name, &scopes, &tok.CreatedAt,
+		&tok.ExpiresAt, &tok.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tok, json.Unmarshal([]byte(scopes), &tok.Scopes)
+}
+
+// ListTokens returns the tokens belonging to the admin, or to all admins if admin is empty.
+func (t *TokenTable) ListTokens(ctx context.Context, admin string) ([]*db.Token, error) {
+	query := "SELECT " + tokenFields + " FROM api_tokens t JOIN admins a ON a.id=t.admin_id"
+	var args []any
+	if admin != "" {
+		query += " WHERE a.username=$1"
+		args = append(args, admin)
+	}
+
+	rows, err := t.conn.QueryContext(ctx, query+" ORDER BY t.id", args...)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to list tokens", "error", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*db.Token
+	for rows.Next() {
+		tok, scanErr := scanToken(rows)
+		if scanErr != nil {
+			t.log(ctx).Error("msg", "failed to scan token", "error", scanErr)
+
+			return out, scanErr
+		}
+
+		out = append(out, tok)
+	}
+
+	t.log(ctx).Debug("msg", "listed tokens", "count", len(out))
+
+	return out, rows.Err()
+}
+
+// RevokeToken deletes the token by ID. If the token did not exist, db.ErrNoToken is returned.
+func (t *TokenTable) RevokeToken(ctx context.Context, id int) error {
+	res, err := t.conn.ExecContext(ctx, "DELETE FROM api_tokens WHERE id=$1", id)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to revoke token", "id", id, "error", err)
+
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		t.log(ctx).Info("msg", "no token found to revoke", "id", id)
+
+		return db.ErrNoToken
+	}
+
+	t.log(ctx).Debug("msg", "revoked token", "id", id)
+
+	return nil
+}
+
+// Authenticate looks up an unexpired token and records that it was used. If the token does not
+// exist, has expired, or belongs to a disabled admin, db.ErrNoToken is returned.
+func (t *TokenTable) Authenticate(ctx context.Context, token string) (*db.Token, error) {
+	var tok *db.Token
+	used := now()
+	// SQLite can't return the columns of the admin from the UPDATE, so the token is read first.
+	err := inTx(ctx, t.conn, func(tx *sql.Tx) error {
+		var err error
+		tok, err = scanToken(tx.QueryRowContext(ctx,
+			"SELECT "+tokenFields+" FROM api_tokens t JOIN admins a ON a.id=t.admin_id "+
+				"WHERE t.hash=$1 AND NOT a.disabled "+
+				"AND (t.expires_at IS NULL OR t.expires_at > $2)",
+			db.HashToken(token), used,
+		))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			"UPDATE api_tokens SET last_used_at=$2 WHERE id=$1", tok.ID, used)
+		tok.LastUsedAt = &used
+
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		t.log(ctx).Info("msg", "unknown or expired token")
+
+		return nil, db.ErrNoToken
+	}
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to check token", "error", err)
+
+		return nil, err
+	}
+
+	return tok, nil
+}
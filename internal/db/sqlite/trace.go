@@ -0,0 +1,50 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/kylrth/disco-bouncer/internal/tracing"
+)
+
+// tracedConn starts a span for each query made through it, like the Postgres tables do. The span of
+// QueryContext ends when the query returns, before the rows are read. Queries in transactions
+// started with BeginTx are not traced.
+type tracedConn struct {
+	*sql.DB
+
+	table string
+}
+
+func traced(conn *sql.DB, table string) *tracedConn {
+	return &tracedConn{DB: conn, table: table}
+}
+
+func (c *tracedConn) ExecContext(
+	ctx context.Context, query string, args ...any,
+) (sql.Result, error) {
+	ctx, span := tracing.StartQuery(ctx, Scheme, c.table, query)
+	res, err := c.DB.ExecContext(ctx, query, args...)
+	tracing.End(span, err)
+
+	return res, err
+}
+
+func (c *tracedConn) QueryContext(
+	ctx context.Context, query string, args ...any,
+) (*sql.Rows, error) {
+	ctx, span := tracing.StartQuery(ctx, Scheme, c.table, query)
+	rows, err := c.DB.QueryContext(ctx, query, args...)
+	tracing.End(span, err)
+
+	return rows, err
+}
+
+func (c *tracedConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := tracing.StartQuery(ctx, Scheme, c.table, query)
+	row := c.DB.QueryRowContext(ctx, query, args...)
+	// Not finding a row is only reported by Scan, so it isn't counted as a failure.
+	tracing.End(span, row.Err())
+
+	return row
+}
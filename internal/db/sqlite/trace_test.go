@@ -0,0 +1,63 @@
+package sqlite_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/cobaltspeech/log/pkg/testinglog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/db/sqlite"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+func TestTracing(t *testing.T) { //nolint:paralleltest // sets the global tracer provider
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+
+	dbURL := "sqlite://" + filepath.Join(t.TempDir(), "bouncer.db")
+	err := sqlite.ApplyMigrations(dbURL)
+	if err != nil {
+		t.Fatalf("error from ApplyMigrations: %v", err)
+	}
+	conn, err := sqlite.Open(dbURL)
+	if err != nil {
+		t.Fatalf("error from Open: %v", err)
+	}
+	defer conn.Close()
+
+	l := testinglog.NewConvenientLogger(t)
+	ctx := context.Background()
+	err = sqlite.NewAdminTable(l, conn).AddAdmin(ctx, "john", "secret", api.RoleOwner)
+	if err != nil {
+		t.Errorf("error from AddAdmin: %v", err)
+	}
+	_, err = sqlite.NewUserTable(l, conn).GetUser(ctx, 1)
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser, got %v", err)
+	}
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	for i, want := range []string{"INSERT admins", "SELECT users"} {
+		if spans[i].Name() != want {
+			t.Errorf("expected span %q, got %q", want, spans[i].Name())
+		}
+		attrs := attribute.NewSet(spans[i].Attributes()...)
+		if v, _ := attrs.Value("db.system.name"); v.AsString() != "sqlite" {
+			t.Errorf("expected db.system.name sqlite on %q, got %q", want, v.AsString())
+		}
+		if spans[i].Status().Code != codes.Unset {
+			t.Errorf("expected span %q not to fail, got %v", want, spans[i].Status())
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cobaltspeech/log"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+)
+
+// UserTable is the SQLite version of db.UserTable.
+type UserTable struct {
+	logger log.Logger
+	conn   *tracedConn
+}
+
+var _ db.UserStore = (*UserTable)(nil)
+
+// NewUserTable creates a new UserTable in the SQLite database.
+func NewUserTable(l log.Logger, conn *sql.DB) *UserTable {
+	out := UserTable{
+		logger: l,
+		conn:   traced(conn, "users"),
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (t *UserTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(t.logger, ctx)
+}
+
+const (
+	userFields   = "name, name_key_hash, finish_year, professor, ta, student_leadership, alumni_board"
+	selectFields = userFields + ", version"
+)
+
+// invalidUser wraps err with db.ErrInvalidUser if it was caused by a check constraint.
+func invalidUser(err error) error {
+	if name, ok := checkViolation(err); ok {
+		return fmt.Errorf("%w: violates %s", db.ErrInvalidUser, name)
+	}
+
+	return err
+}
+
+func scanUser(row interface{ Scan(dest ...any) error }, u *db.User) error {
+	return row.Scan(&u.Name, &u.NameKeyHash, &u.FinishYear, &u.Professor, &u.TA,
+		&u.StudentLeadership, &u.AlumniBoard, &u.Version)
+}
+
+// GetUsers returns all users in the database, possibly filtered by the provided options.
+func (t *UserTable) GetUsers(ctx context.Context, opts ...db.FilterOption) ([]*db.User, error) {
+	f := db.ReadFilters(opts)
+
+	var conds []string
+	var args []any
+	if f.KeyHash != "" {
+		args = append(args, f.KeyHash)
+		conds = append(conds, "name_key_hash=$"+strconv.Itoa(len(args)))
+	}
+	if f.AfterID > 0 {
+		args = append(args, f.AfterID)
+		conds = append(conds, "id>$"+strconv.Itoa(len(args)))
+	}
+
+	query := "SELECT id, " + selectFields + " FROM users"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY id"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := t.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to query db for users", "error", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*db.User
+	for rows.Next() {
+		var u db.User
+		err = rows.Scan(
+			&u.ID, &u.Name, &u.NameKeyHash, &u.FinishYear, &u.Professor, &u.TA,
+			&u.StudentLeadership, &u.AlumniBoard, &u.Version,
+		)
+		if err != nil {
+			t.log(ctx).Error("msg", "failed to scan user row", "error", err)
+
+			return out, err
+		}
+		out = append(out, &u)
+	}
+	if err = rows.Err(); err != nil {
+		t.log(ctx).Error("msg", "failed to query db for users", "error", err)
+
+		return out, err
+	}
+
+	logInfo := []any{"msg", "got all users", "count", len(out)}
+	logInfo = append(logInfo, f.LogInfo()...)
+	t.log(ctx).Debug(logInfo...)
+
+	return out, nil
+}
+
+// GetUser returns the user by ID, if present. If not present, db.ErrNoUser is returned.
+func (t *UserTable) GetUser(ctx context.Context, id int) (*db.User, error) {
+	u := db.User{ID: id}
+	err := scanUser(
+		t.conn.QueryRowContext(ctx, "SELECT "+selectFields+" FROM users WHERE id=$1", id), &u)
+	if errors.Is(err, sql.ErrNoRows) {
+		t.log(ctx).Info("msg", "user not in database", "id", id)
+
+		return &u, db.ErrNoUser
+	}
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to search for user", "id", id, "error", err)
+
+		return &u, err
+	}
+
+	t.log(ctx).Debug("msg", "found user info", "id", id)
+
+	return &u, nil
+}
+
+// CreateUser creates a new user (ignoring the ID and Version fields) and returns the new ID.
+func (t *UserTable) CreateUser(ctx context.Context, u *db.User) (int, error) {
+	var newID int
+
+	err := t.conn.QueryRowContext(
+		ctx, "INSERT INTO users ("+userFields+") VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		u.Name, u.NameKeyHash, u.FinishYear, u.Professor, u.TA, u.StudentLeadership, u.AlumniBoard,
+	).Scan(&newID)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to create user", "error", err)
+
+		return newID, invalidUser(err)
+	}
+
+	t.log(ctx).Debug("msg", "created new user", "id", newID)
+
+	return newID, nil
+}
+
+// missingOrStale determines why a write conditioned on w affected no rows.
+func (t *UserTable) missingOrStale(
+	ctx context.Context, action string, id int, w db.WriteOptions,
+) error {
+	if w.Version == 0 {
+		t.log(ctx).Info("msg", "no matching user to "+action, "id", id)
+
+		return db.ErrNoUser
+	}
+
+	var current int
+	err := t.conn.QueryRowContext(ctx, "SELECT version FROM users WHERE id=$1", id).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		t.log(ctx).Info("msg", "no matching user to "+action, "id", id)
+
+		return db.ErrNoUser
+	}
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to check user version", "id", id, "error", err)
+
+		return err
+	}
+
+	t.log(ctx).Info("msg", "user version mismatch", "id", id, "action", action,
+		"expected", w.Version, "current", current)
+
+	return db.ErrVersionMismatch
+}
+
+// UpdateUser inserts the information in u into the row identified by u.ID, and sets u.Version to
+// the new version of the row. If that row does not exist, db.ErrNoUser is returned.
+func (t *UserTable) UpdateUser(ctx context.Context, u *db.User, opts ...db.WriteOption) error {
+	w := db.ReadWriteOptions(opts)
+
+	query := "UPDATE users SET name=$2, name_key_hash=$3, finish_year=$4, professor=$5, ta=$6, " +
+		"student_leadership=$7, alumni_board=$8, version=version+1 WHERE id=$1"
+	args := []any{
+		u.ID, u.Name, u.NameKeyHash, u.FinishYear, u.Professor, u.TA, u.StudentLeadership,
+		u.AlumniBoard,
+	}
+	if w.Version > 0 {
+		query += " AND version=$9"
+		args = append(args, w.Version)
+	}
+
+	err := t.conn.QueryRowContext(ctx, query+" RETURNING version", args...).Scan(&u.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return t.missingOrStale(ctx, "update", u.ID, w)
+	}
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to update user", "id", u.ID, "error", err)
+
+		return invalidUser(err)
+	}
+
+	t.log(ctx).Debug("msg", "updated user", "id", u.ID)
+
+	return nil
+}
+
+// PatchUser changes only the fields set in p for the user by ID, and returns the updated user. If
+// the user does not exist, db.ErrNoUser is returned.
+func (t *UserTable) PatchUser(
+	ctx context.Context, id int, p *db.UserPatch, opts ...db.WriteOption,
+) (*db.User, error) {
+	w := db.ReadWriteOptions(opts)
+
+	columns, values := p.Columns()
+	if len(columns) == 0 {
+		return nil, db.ErrEmptyPatch
+	}
+
+	args := []any{id}
+	sets := make([]string, len(columns))
+	for i, column := range columns {
+		args = append(args, values[i])
+		sets[i] = column + "=$" + strconv.Itoa(len(args))
+	}
+	query := "UPDATE users SET " + strings.Join(sets, ", ") + ", version=version+1 WHERE id=$1"
+	if w.Version > 0 {
+		args = append(args, w.Version)
+		query += " AND version=$" + strconv.Itoa(len(args))
+	}
+
+	u := db.User{ID: id}
+	err := scanUser(t.conn.QueryRowContext(ctx, query+" RETURNING "+selectFields, args...), &u)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, t.missingOrStale(ctx, "patch", id, w)
+	}
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to patch user", "id", id, "error", err)
+
+		return nil, invalidUser(err)
+	}
+
+	t.log(ctx).Debug("msg", "patched user", "id", id, "fields", strings.Join(columns, ","))
+
+	return &u, nil
+}
+
+// DeleteUser removes the user by ID. If the user did not exist, returns db.ErrNoUser.
+func (t *UserTable) DeleteUser(ctx context.Context, id int, opts ...db.WriteOption) error {
+	w := db.ReadWriteOptions(opts)
+
+	query := "DELETE FROM users WHERE id=$1"
+	args := []any{id}
+	if w.Version > 0 {
+		query += " AND version=$2"
+		args = append(args, w.Version)
+	}
+
+	res, err := t.conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to delete user", "id", id, "error", err)
+
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return t.missingOrStale(ctx, "delete", id, w)
+	}
+
+	t.log(ctx).Debug("msg", "deleted user", "id", id)
+
+	return nil
+}
+
+// CountByYear returns the number of users for each finish year.
+func (t *UserTable) CountByYear(ctx context.Context) (map[string]int, error) {
+	rows, err := t.conn.QueryContext(ctx,
+		"SELECT finish_year, count(*) FROM users GROUP BY finish_year")
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to count users", "error", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]int)
+	for rows.Next() {
+		var year string
+		var n int
+		err = rows.Scan(&year, &n)
+		if err != nil {
+			t.log(ctx).Error("msg", "failed to scan user count", "error", err)
+
+			return nil, err
+		}
+		out[year] = n
+	}
+	if err = rows.Err(); err != nil {
+		t.log(ctx).Error("msg", "failed to count users", "error", err)
+
+		return nil, err
+	}
+
+	t.log(ctx).Debug("msg", "counted users by year", "years", len(out))
+
+	return out, nil
+}
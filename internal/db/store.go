@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// UserStore stores the users waiting to join the Discord server. It is implemented by *UserTable
+// for Postgres, and by the SQLite and in-memory backends.
+type UserStore interface {
+	GetUsers(ctx context.Context, opts ...FilterOption) ([]*User, error)
+	GetUser(ctx context.Context, id int) (*User, error)
+	CreateUser(ctx context.Context, u *User) (int, error)
+	UpdateUser(ctx context.Context, u *User, opts ...WriteOption) error
+	PatchUser(ctx context.Context, id int, p *UserPatch, opts ...WriteOption) (*User, error)
+	DeleteUser(ctx context.Context, id int, opts ...WriteOption) error
+	CountByYear(ctx context.Context) (map[string]int, error)
+}
+
+// AdminStore stores the admins permitted to use the service, including their two-factor
+// authentication state and linked Discord accounts. It is implemented by *AdminTable for Postgres,
+// and by the SQLite and in-memory backends.
+type AdminStore interface {
+	AddAdmin(ctx context.Context, user, pass string, role api.Role) error
+	DeleteAdmin(ctx context.Context, user string) error
+	CheckPassword(ctx context.Context, user, pass string) (bool, error)
+	ChangePassword(ctx context.Context, user, pass string) error
+	ResetPassword(ctx context.Context, user, pass, keep string) error
+	ListAdmins(ctx context.Context) ([]*Admin, error)
+	GetAdmin(ctx context.Context, user string) (*Admin, error)
+	GetAdminByID(ctx context.Context, id int) (*Admin, error)
+	RecordLogin(ctx context.Context, user string) error
+	SetDisabled(ctx context.Context, user string, disabled bool) error
+	SetRole(ctx context.Context, user string, role api.Role) error
+	PatchAdmin(ctx context.Context, user string, patch api.AdminPatch) (*Admin, error)
+
+	GetTOTP(ctx context.Context, user string) (*TOTP, error)
+	StartTOTP(ctx context.Context, user, secret string) error
+	EnableTOTP(ctx context.Context, user string, recoveryHashes []string) error
+	UseTOTPStep(ctx context.Context, user string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, user, hash string) (bool, error)
+	ResetTOTP(ctx context.Context, user string) error
+
+	GetDiscordAdmin(ctx context.Context, discordID string) (*Admin, error)
+	AddDiscordAdmin(ctx context.Context, discordID, user string, role api.Role) error
+}
+
+// SessionStore tracks the logged-in sessions of admins. It is implemented by *SessionTable for
+// Postgres, and by the SQLite and in-memory backends.
+type SessionStore interface {
+	CreateSession(ctx context.Context, key string, adminID int, ip, userAgent string) error
+	TouchSession(
+		ctx context.Context, key string, adminID int, ip string, idle, maxAge time.Duration,
+	) (bool, error)
+	DeleteSession(ctx context.Context, key string) error
+	ListSessions(ctx context.Context, user string) ([]*Session, error)
+	RevokeSession(ctx context.Context, user string, id int) error
+	RevokeOtherSessions(ctx context.Context, user, keep string) error
+}
+
+// LoginStore records failed logins and lockouts. It is implemented by *LoginTable for Postgres,
+// and by the SQLite and in-memory backends.
+type LoginStore interface {
+	GetFailures(ctx context.Context, user, ip string) (byUser, byIP FailureCount, err error)
+	RecordFailure(ctx context.Context, user, ip, reason string) (int, error)
+	ClearFailures(ctx context.Context, user string) error
+	Lock(ctx context.Context, user string) error
+	Unlock(ctx context.Context, user string) error
+	ListFailures(ctx context.Context, user string, limit int) ([]*LoginFailure, error)
+}
+
+// TokenStore stores the API tokens of admins. It is implemented by *TokenTable for Postgres, and
+// by the SQLite backend.
+type TokenStore interface {
+	CreateToken(
+		ctx context.Context, admin, name string, scopes []string, expires *time.Time,
+	) (string, error)
+	ListTokens(ctx context.Context, admin string) ([]*Token, error)
+	RevokeToken(ctx context.Context, id int) error
+	Authenticate(ctx context.Context, token string) (*Token, error)
+}
+
+// AuditStore keeps the hash-chained audit trail. It is implemented by *AuditTable for Postgres, and
+// by the SQLite backend.
+type AuditStore interface {
+	Record(ctx context.Context, e *AuditEvent) error
+	ListEvents(ctx context.Context, f *api.AuditFilter) ([]*AuditEvent, error)
+	ChainHead(ctx context.Context) (*AuditEvent, error)
+	VerifyChain(ctx context.Context) (checked int, broken *ChainBreak, err error)
+	FindHash(ctx context.Context, hash []byte) (int, error)
+}
+
+// OIDCStore holds the allowlist of single sign-on identities, and the one-time login codes used
+// by single sign-on and Discord login. It is implemented by *OIDCTable for Postgres, and by the
+// SQLite backend.
+type OIDCStore interface {
+	AddIdentity(ctx context.Context, kind, value, user string, role api.Role) (int, error)
+	ListIdentities(ctx context.Context) ([]*Identity, error)
+	RemoveIdentity(ctx context.Context, id int) error
+	MatchIdentity(ctx context.Context, subject, email string) (*Identity, error)
+	CreateLoginCode(ctx context.Context, adminID int, ttl time.Duration) (string, error)
+	UseLoginCode(ctx context.Context, code string) (int, error)
+}
+
+var (
+	_ UserStore    = (*UserTable)(nil)
+	_ AdminStore   = (*AdminTable)(nil)
+	_ SessionStore = (*SessionTable)(nil)
+	_ LoginStore   = (*LoginTable)(nil)
+	_ TokenStore   = (*TokenTable)(nil)
+	_ AuditStore   = (*AuditTable)(nil)
+	_ OIDCStore    = (*OIDCTable)(nil)
+)
@@ -0,0 +1,200 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/jackc/pgx/v5"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// TokenTable represents the table of API tokens that admins can use instead of logging in. Only a
+// hash of each token is stored, so the plaintext token is only available when it is created.
+type TokenTable struct {
+	logger log.Logger
+	pool   PgxIface
+}
+
+// NewTokenTable creates a new TokenTable backed by a Postgres connection pool.
+func NewTokenTable(l log.Logger, pool PgxIface) *TokenTable {
+	out := TokenTable{
+		logger: l,
+		pool:   pool,
+	}
+
+	return &out
+}
+
+// log returns the logger with the request ID in ctx, if any.
+func (t *TokenTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(t.logger, ctx)
+}
+
+// Token describes an API token. The token itself is not included. Requests made with the token are
+// limited to the scopes that are both granted to the token and allowed by the admin's role.
+type Token struct {
+	ID         int
+	Admin      string
+	AdminRole  api.Role
+	Name       string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+// TokenPrefix starts every API token, so that tokens are easy to recognize in config files and
+// secret scanners.
+const TokenPrefix = "bnc_"
+
+// HashToken returns the hash under which a token is stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateToken creates a new token for the admin with the given name and scopes, and returns the
+// plaintext token. If expires is nil, the token does not expire. ErrNoUser is returned if the admin
+// does not exist.
+func (t *TokenTable) CreateToken(
+	ctx context.Context, admin, name string, scopes []string, expires *time.Time,
+) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to generate token", "admin", admin, "error", err)
+
+		return "", err
+	}
+
+	var id int
+	err = t.pool.QueryRow(ctx,
+		"INSERT INTO api_tokens (admin_id, name, hash, scopes, expires_at) "+
+			"SELECT id, $2, $3, $4, $5 FROM admins WHERE username=$1 RETURNING id",
+		admin, name, HashToken(token), scopes, expires,
+	).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		t.log(ctx).Info("msg", "no admin found to create token", "admin", admin)
+
+		return "", ErrNoUser
+	}
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to store new token", "admin", admin, "error", err)
+
+		return "", err
+	}
+
+	t.log(ctx).Debug("msg", "created token", "id", id, "admin", admin, "name", name,
+		"scopes", strings.Join(scopes, ","))
+
+	return token, nil
+}
+
+const tokenFields = "t.id, a.username, a.role, t.name, t.scopes, t.created_at, t.expires_at, " +
+	"t.last_used_at"
+
+func scanToken(row pgx.Row) (*Token, error) {
+	var tok Token
+	err := row.Scan(&tok.ID, &tok.Admin, &tok.AdminRole, &tok.Name, &tok.Scopes, &tok.CreatedAt,
+		&tok.ExpiresAt, &tok.LastUsedAt)
+
+	return &tok, err
+}
+
+// ListTokens returns the tokens belonging to the admin, or to all admins if admin is empty.
+func (t *TokenTable) ListTokens(ctx context.Context, admin string) ([]*Token, error) {
+	query := "SELECT " + tokenFields + " FROM api_tokens t JOIN admins a ON a.id=t.admin_id"
+	var args []any
+	if admin != "" {
+		query += " WHERE a.username=$1"
+		args = append(args, admin)
+	}
+
+	rows, err := t.pool.Query(ctx, query+" ORDER BY t.id", args...)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to list tokens", "error", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Token
+	for rows.Next() {
+		tok, scanErr := scanToken(rows)
+		if scanErr != nil {
+			t.log(ctx).Error("msg", "failed to scan token", "error", scanErr)
+
+			return out, scanErr
+		}
+
+		out = append(out, tok)
+	}
+
+	t.log(ctx).Debug("msg", "listed tokens", "count", len(out))
+
+	return out, rows.Err()
+}
+
+// ErrNoToken is returned when the token does not exist.
+var ErrNoToken = errors.New("token not found")
+
+// RevokeToken deletes the token by ID. If the token did not exist, ErrNoToken is returned.
+func (t *TokenTable) RevokeToken(ctx context.Context, id int) error {
+	tag, err := t.pool.Exec(ctx, "DELETE FROM api_tokens WHERE id=$1", id)
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to revoke token", "id", id, "error", err)
+
+		return err
+	}
+	if tag.RowsAffected() < 1 {
+		t.log(ctx).Info("msg", "no token found to revoke", "id", id)
+
+		return ErrNoToken
+	}
+
+	t.log(ctx).Debug("msg", "revoked token", "id", id)
+
+	return nil
+}
+
+// Authenticate looks up an unexpired token and records that it was used. If the token does not
+// exist, has expired, or belongs to a disabled admin, ErrNoToken is returned.
+func (t *TokenTable) Authenticate(ctx context.Context, token string) (*Token, error) {
+	tok, err := scanToken(t.pool.QueryRow(ctx,
+		"UPDATE api_tokens t SET last_used_at=now() FROM admins a "+
+			"WHERE t.hash=$1 AND a.id=t.admin_id AND NOT a.disabled "+
+			"AND (t.expires_at IS NULL OR t.expires_at > now()) "+
+			"RETURNING "+tokenFields,
+		HashToken(token),
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		t.log(ctx).Info("msg", "unknown or expired token")
+
+		return nil, ErrNoToken
+	}
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to check token", "error", err)
+
+		return nil, err
+	}
+
+	return tok, nil
+}
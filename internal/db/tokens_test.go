@@ -0,0 +1,127 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cobaltspeech/log/pkg/testinglog"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jackc/pgx/v5"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+var tokenColumns = []string{
+	"id", "username", "role", "name", "scopes", "created_at", "expires_at", "last_used_at",
+}
+
+func TestTokenTable(t *testing.T) { //nolint:funlen // testing sequential calls
+	t.Parallel()
+
+	mockDB, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("error opening mock db: %v", err)
+	}
+	defer mockDB.Close()
+
+	logger := testinglog.NewConvenientLogger(t)
+	table := db.NewTokenTable(logger, mockDB)
+	ctx := context.Background()
+
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := db.Token{
+		ID:         1,
+		Admin:      "john",
+		AdminRole:  api.RoleViewer,
+		Name:       "cron",
+		Scopes:     []string{"users:read"},
+		CreatedAt:  created,
+		LastUsedAt: &created,
+	}
+
+	// create a token for John
+	mockDB.ExpectQuery("INSERT INTO api_tokens").
+		WithArgs("john", "cron", pgxmock.AnyArg(), want.Scopes, (*time.Time)(nil)).
+		WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(1))
+	token, err := table.CreateToken(ctx, "john", "cron", want.Scopes, nil)
+	if err != nil {
+		t.Errorf("error from CreateToken: %v", err)
+	}
+	if !strings.HasPrefix(token, db.TokenPrefix) {
+		t.Errorf("token missing prefix: %s", token)
+	}
+
+	// token for nonexistent admin
+	mockDB.ExpectQuery("INSERT INTO api_tokens").
+		WithArgs("stephen", "cron", pgxmock.AnyArg(), want.Scopes, (*time.Time)(nil)).
+		WillReturnError(pgx.ErrNoRows)
+	_, err = table.CreateToken(ctx, "stephen", "cron", want.Scopes, nil)
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("unexpected error from CreateToken: %v", err)
+	}
+
+	// authenticate with the token
+	mockDB.ExpectQuery("UPDATE api_tokens").
+		WithArgs(db.HashToken(token)).
+		WillReturnRows(pgxmock.NewRows(tokenColumns).AddRow(
+			want.ID, want.Admin, want.AdminRole, want.Name, want.Scopes, want.CreatedAt, want.ExpiresAt,
+			want.LastUsedAt,
+		))
+	got, err := table.Authenticate(ctx, token)
+	if err != nil {
+		t.Errorf("error from Authenticate: %v", err)
+	}
+	if diff := cmp.Diff(&want, got); diff != "" {
+		t.Error("unexpected token (-want +got):\n" + diff)
+	}
+
+	// list John's tokens
+	mockDB.ExpectQuery(`SELECT .* FROM api_tokens t JOIN admins a .* WHERE a.username=\$1`).
+		WithArgs("john").
+		WillReturnRows(pgxmock.NewRows(tokenColumns).AddRow(
+			want.ID, want.Admin, want.AdminRole, want.Name, want.Scopes, want.CreatedAt, want.ExpiresAt,
+			want.LastUsedAt,
+		))
+	tokens, err := table.ListTokens(ctx, "john")
+	if err != nil {
+		t.Errorf("error from ListTokens: %v", err)
+	}
+	if diff := cmp.Diff([]*db.Token{&want}, tokens); diff != "" {
+		t.Error("unexpected tokens (-want +got):\n" + diff)
+	}
+
+	// revoke the token, and then it doesn't work
+	mockDB.ExpectExec("DELETE FROM api_tokens").
+		WithArgs(1).
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	err = table.RevokeToken(ctx, 1)
+	if err != nil {
+		t.Errorf("error from RevokeToken: %v", err)
+	}
+
+	mockDB.ExpectQuery("UPDATE api_tokens").
+		WithArgs(db.HashToken(token)).
+		WillReturnError(pgx.ErrNoRows)
+	_, err = table.Authenticate(ctx, token)
+	if !errors.Is(err, db.ErrNoToken) {
+		t.Errorf("unexpected error from Authenticate: %v", err)
+	}
+
+	mockDB.ExpectExec("DELETE FROM api_tokens").
+		WithArgs(1).
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	err = table.RevokeToken(ctx, 1)
+	if !errors.Is(err, db.ErrNoToken) {
+		t.Errorf("unexpected error from RevokeToken: %v", err)
+	}
+
+	err = mockDB.ExpectationsWereMet()
+	if err != nil {
+		t.Errorf("unfulfilled DB expectations: %v", err)
+	}
+	logger.Done()
+}
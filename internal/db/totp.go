@@ -0,0 +1,166 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TOTP is the two-factor authentication state of an admin.
+type TOTP struct {
+	// Secret is the encrypted TOTP secret, or empty if the admin has never started enrollment.
+	Secret string
+
+	// Enabled is true once the admin has confirmed enrollment with a valid code.
+	Enabled bool
+}
+
+// ErrTOTPEnabled is returned by StartTOTP if two-factor authentication is already enabled.
+var ErrTOTPEnabled = errors.New("two-factor authentication already enabled")
+
+// GetTOTP returns the two-factor authentication state of the admin. ErrNoUser is returned if the
+// admin is not in the database.
+func (a *AdminTable) GetTOTP(ctx context.Context, user string) (*TOTP, error) {
+	var out TOTP
+	var secret *string
+	err := a.pool.QueryRow(ctx,
+		"SELECT totp_secret, totp_enabled FROM admins WHERE username=$1", user,
+	).Scan(&secret, &out.Enabled)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			a.log(ctx).Info("msg", "no user found to get TOTP", "user", user)
+
+			return nil, ErrNoUser
+		}
+
+		a.log(ctx).Error("msg", "failed to get TOTP", "user", user, "error", err)
+
+		return nil, err
+	}
+
+	if secret != nil {
+		out.Secret = *secret
+	}
+
+	return &out, nil
+}
+
+// StartTOTP stores a new encrypted secret for the admin, to be enabled by EnableTOTP once the admin
+// has shown they can generate codes with it. ErrTOTPEnabled is returned if two-factor
+// authentication is already enabled.
+func (a *AdminTable) StartTOTP(ctx context.Context, user, secret string) error {
+	tag, err := a.pool.Exec(ctx,
+		"UPDATE admins SET totp_secret=$2, totp_last_step=NULL, totp_recovery='{}' "+
+			"WHERE username=$1 AND NOT totp_enabled",
+		user, secret,
+	)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to start TOTP enrollment", "user", user, "error", err)
+
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		a.log(ctx).Info("msg", "TOTP already enabled or no user found", "user", user)
+
+		return ErrTOTPEnabled
+	}
+
+	a.log(ctx).Debug("msg", "started TOTP enrollment", "user", user)
+
+	return nil
+}
+
+// EnableTOTP turns on two-factor authentication for the admin with the pending secret, and stores
+// the hashes of the recovery codes.
+func (a *AdminTable) EnableTOTP(ctx context.Context, user string, recoveryHashes []string) error {
+	tag, err := a.pool.Exec(ctx,
+		"UPDATE admins SET totp_enabled=true, totp_recovery=$2 "+
+			"WHERE username=$1 AND totp_secret IS NOT NULL",
+		user, recoveryHashes,
+	)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to enable TOTP", "user", user, "error", err)
+
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		a.log(ctx).Info("msg", "no pending TOTP enrollment", "user", user)
+
+		return ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "enabled TOTP", "user", user)
+
+	return nil
+}
+
+// UseTOTPStep records that a code for the time step was accepted. It returns false if a code for
+// this step or a later one was already accepted, so that codes can't be replayed.
+func (a *AdminTable) UseTOTPStep(ctx context.Context, user string, step int64) (bool, error) {
+	tag, err := a.pool.Exec(ctx,
+		"UPDATE admins SET totp_last_step=$2 "+
+			"WHERE username=$1 AND (totp_last_step IS NULL OR totp_last_step < $2)",
+		user, step,
+	)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to record TOTP step", "user", user, "error", err)
+
+		return false, err
+	}
+	if tag.RowsAffected() != 1 {
+		a.log(ctx).Info("msg", "TOTP code reused", "user", user)
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// UseRecoveryCode removes the recovery code hash from the admin's unused codes. It returns false if
+// the hash was not one of them.
+func (a *AdminTable) UseRecoveryCode(ctx context.Context, user, hash string) (bool, error) {
+	tag, err := a.pool.Exec(ctx,
+		"UPDATE admins SET totp_recovery=array_remove(totp_recovery, $2) "+
+			"WHERE username=$1 AND $2=ANY(totp_recovery)",
+		user, hash,
+	)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to use recovery code", "user", user, "error", err)
+
+		return false, err
+	}
+	if tag.RowsAffected() != 1 {
+		a.log(ctx).Info("msg", "invalid recovery code", "user", user)
+
+		return false, nil
+	}
+
+	a.log(ctx).Info("msg", "used recovery code", "user", user)
+
+	return true, nil
+}
+
+// ResetTOTP turns off two-factor authentication for the admin and forgets the secret and recovery
+// codes. ErrNoUser is returned if the admin is not in the database.
+func (a *AdminTable) ResetTOTP(ctx context.Context, user string) error {
+	tag, err := a.pool.Exec(ctx,
+		"UPDATE admins SET totp_secret=NULL, totp_enabled=false, totp_last_step=NULL, "+
+			"totp_recovery='{}' WHERE username=$1",
+		user,
+	)
+	if err != nil {
+		a.log(ctx).Error("msg", "failed to reset TOTP", "user", user, "error", err)
+
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		a.log(ctx).Info("msg", "no user found to reset TOTP", "user", user)
+
+		return ErrNoUser
+	}
+
+	a.log(ctx).Debug("msg", "reset TOTP", "user", user)
+
+	return nil
+}
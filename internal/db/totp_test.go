@@ -0,0 +1,119 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cobaltspeech/log/pkg/testinglog"
+	"github.com/google/go-cmp/cmp"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/pashagolub/pgxmock/v2"
+)
+
+func TestAdminTable_TOTP(t *testing.T) { //nolint:funlen // testing sequential calls
+	t.Parallel()
+
+	mockDB, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("error opening mock db: %v", err)
+	}
+	defer mockDB.Close()
+
+	logger := testinglog.NewConvenientLogger(t)
+	table := db.NewAdminTable(logger, mockDB)
+	ctx := context.Background()
+
+	// not enrolled yet
+	mockDB.ExpectQuery("SELECT totp_secret, totp_enabled FROM admins").
+		WithArgs("john").
+		WillReturnRows(pgxmock.NewRows([]string{"totp_secret", "totp_enabled"}).
+			AddRow((*string)(nil), false))
+	state, err := table.GetTOTP(ctx, "john")
+	if err != nil {
+		t.Errorf("error from GetTOTP: %v", err)
+	}
+	if diff := cmp.Diff(&db.TOTP{}, state); diff != "" {
+		t.Error("unexpected TOTP state (-want +got):\n" + diff)
+	}
+
+	// enroll
+	mockDB.ExpectExec("UPDATE admins SET totp_secret").
+		WithArgs("john", "sealed").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	err = table.StartTOTP(ctx, "john", "sealed")
+	if err != nil {
+		t.Errorf("error from StartTOTP: %v", err)
+	}
+
+	mockDB.ExpectExec("UPDATE admins SET totp_enabled=true").
+		WithArgs("john", []string{"hash1", "hash2"}).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	err = table.EnableTOTP(ctx, "john", []string{"hash1", "hash2"})
+	if err != nil {
+		t.Errorf("error from EnableTOTP: %v", err)
+	}
+
+	// can't enroll again
+	mockDB.ExpectExec("UPDATE admins SET totp_secret").
+		WithArgs("john", "sealed2").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	err = table.StartTOTP(ctx, "john", "sealed2")
+	if !errors.Is(err, db.ErrTOTPEnabled) {
+		t.Errorf("unexpected error from StartTOTP: %v", err)
+	}
+
+	// codes can't be replayed
+	mockDB.ExpectExec("UPDATE admins SET totp_last_step").
+		WithArgs("john", int64(100)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	ok, err := table.UseTOTPStep(ctx, "john", 100)
+	if err != nil || !ok {
+		t.Errorf("step not accepted (err %v)", err)
+	}
+	mockDB.ExpectExec("UPDATE admins SET totp_last_step").
+		WithArgs("john", int64(100)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	ok, err = table.UseTOTPStep(ctx, "john", 100)
+	if err != nil || ok {
+		t.Errorf("step accepted twice (err %v)", err)
+	}
+
+	// recovery codes can only be used once
+	mockDB.ExpectExec("UPDATE admins SET totp_recovery=array_remove").
+		WithArgs("john", "hash1").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	ok, err = table.UseRecoveryCode(ctx, "john", "hash1")
+	if err != nil || !ok {
+		t.Errorf("recovery code not accepted (err %v)", err)
+	}
+	mockDB.ExpectExec("UPDATE admins SET totp_recovery=array_remove").
+		WithArgs("john", "hash1").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	ok, err = table.UseRecoveryCode(ctx, "john", "hash1")
+	if err != nil || ok {
+		t.Errorf("recovery code accepted twice (err %v)", err)
+	}
+
+	// reset
+	mockDB.ExpectExec("UPDATE admins SET totp_secret=NULL").
+		WithArgs("john").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	err = table.ResetTOTP(ctx, "john")
+	if err != nil {
+		t.Errorf("error from ResetTOTP: %v", err)
+	}
+	mockDB.ExpectExec("UPDATE admins SET totp_secret=NULL").
+		WithArgs("stephen").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	err = table.ResetTOTP(ctx, "stephen")
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("unexpected error from ResetTOTP: %v", err)
+	}
+
+	err = mockDB.ExpectationsWereMet()
+	if err != nil {
+		t.Errorf("unfulfilled DB expectations: %v", err)
+	}
+	logger.Done()
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/kylrth/disco-bouncer/internal/tracing"
+)
+
+// tracedPool starts a span for each query made through it. Queries in transactions started with
+// Begin are not traced.
+type tracedPool struct {
+	PgxIface
+
+	table string
+}
+
+func traced(pool PgxIface, table string) *tracedPool {
+	return &tracedPool{PgxIface: pool, table: table}
+}
+
+// start starts a span named like "SELECT users".
+func (p *tracedPool) start(ctx context.Context, sql string) (context.Context, trace.Span) {
+	return tracing.StartQuery(ctx, "postgresql", p.table, sql)
+}
+
+func (p *tracedPool) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	ctx, span := p.start(ctx, sql)
+	tag, err := p.PgxIface.Exec(ctx, sql, args...)
+	tracing.End(span, err)
+
+	return tag, err
+}
+
+func (p *tracedPool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	ctx, span := p.start(ctx, sql)
+	rows, err := p.PgxIface.Query(ctx, sql, args...)
+	if err != nil {
+		tracing.End(span, err)
+
+		return rows, err
+	}
+
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+func (p *tracedPool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	ctx, span := p.start(ctx, sql)
+
+	return &tracedRow{Row: p.PgxIface.QueryRow(ctx, sql, args...), span: span}
+}
+
+// tracedRows ends the span of the query when the rows are closed.
+type tracedRows struct {
+	pgx.Rows
+
+	span  trace.Span
+	ended bool
+}
+
+func (r *tracedRows) Close() {
+	r.Rows.Close()
+	if !r.ended {
+		r.ended = true
+		tracing.End(r.span, r.Err())
+	}
+}
+
+// tracedRow ends the span of the query when the row is scanned.
+type tracedRow struct {
+	pgx.Row
+
+	span trace.Span
+}
+
+func (r *tracedRow) Scan(dest ...any) error {
+	err := r.Row.Scan(dest...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Not finding a row is an answer, not a failure.
+		tracing.End(r.span, nil)
+	} else {
+		tracing.End(r.span, err)
+	}
+
+	return err
+}
@@ -3,11 +3,16 @@ package db
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 
 	"github.com/cobaltspeech/log"
+	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 )
 
 // UserTable represents the table of users that the bouncer will accept into the Discord server.
@@ -20,12 +25,17 @@ type UserTable struct {
 func NewUserTable(l log.Logger, pool PgxIface) *UserTable {
 	out := UserTable{
 		logger: l,
-		pool:   pool,
+		pool:   traced(pool, "users"),
 	}
 
 	return &out
 }
 
+// log returns the logger with the request ID in ctx, if any.
+func (t *UserTable) log(ctx context.Context) log.Logger {
+	return logging.FromContext(t.logger, ctx)
+}
+
 // User contains the information about a user necessary to admit them to the Discord server and
 // assign appropriate roles upon entry to the server.
 type User struct {
@@ -37,6 +47,34 @@ type User struct {
 	TA                bool   `json:"ta"`
 	StudentLeadership bool   `json:"student_leadership"`
 	AlumniBoard       bool   `json:"alumni_board"`
+	Version           int    `json:"version"`
+}
+
+// ErrInvalidUser is returned if the user violates a constraint on the users table.
+var ErrInvalidUser = errors.New("invalid user")
+
+// checkViolation wraps err with ErrInvalidUser if it was caused by a check constraint.
+func checkViolation(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.CheckViolation {
+		return fmt.Errorf("%w: violates %s", ErrInvalidUser, pgErr.ConstraintName)
+	}
+
+	return err
+}
+
+// UserFromAPI converts the wire representation of a user to a User.
+func UserFromAPI(u *api.User) *User {
+	out := User(*u)
+
+	return &out
+}
+
+// API converts the user to its wire representation.
+func (u *User) API() *api.User {
+	out := api.User(*u)
+
+	return &out
 }
 
 var (
@@ -49,7 +87,8 @@ var (
 		"student_leadership",
 		"alumni_board",
 	}, ", ")
-	userSets = strings.Join([]string{
+	selectFields = userFields + ", version"
+	userSets     = strings.Join([]string{
 		"name=$2",
 		"name_key_hash=$3",
 		"finish_year=$4",
@@ -60,61 +99,111 @@ var (
 	}, ", ")
 )
 
-type filters struct {
-	keyHash string
+// Filters are the values set by FilterOptions. Implementations of UserStore get them with
+// ReadFilters.
+type Filters struct {
+	KeyHash string
+	AfterID int
+	Limit   int
 }
 
 // FilterOption is a way to filter by particular values with GetUsers.
-type FilterOption = func(f *filters)
+type FilterOption = func(f *Filters)
+
+// ReadFilters returns the filters set by the options.
+func ReadFilters(opts []FilterOption) *Filters {
+	var f Filters
+	for _, opt := range opts {
+		opt(&f)
+	}
+
+	return &f
+}
 
 // WithKeyHash returns a FilterOption that filters by the provided MD5 key hash.
 func WithKeyHash(keyHash string) FilterOption {
-	return func(f *filters) { f.keyHash = keyHash }
+	return func(f *Filters) { f.KeyHash = keyHash }
 }
 
-func (f *filters) formatWhereString() string {
-	var out strings.Builder
+// WithAfterID returns a FilterOption that only includes users with an ID greater than id. Combined
+// with WithLimit, this can be used to page through the table.
+func WithAfterID(id int) FilterOption {
+	return func(f *Filters) { f.AfterID = id }
+}
+
+// WithLimit returns a FilterOption that returns at most n users. Users are always ordered by ID.
+func WithLimit(n int) FilterOption {
+	return func(f *Filters) { f.Limit = n }
+}
+
+func (f *Filters) formatWhereString() string {
+	var conds []string
 	currentIndex := 1
 
-	if f.keyHash != "" {
-		out.WriteString(" WHERE name_key_hash=$" + strconv.Itoa(currentIndex))
-		currentIndex++ //nolint:ineffassign,wastedassign // will be needed with more filter values
+	if f.KeyHash != "" {
+		conds = append(conds, "name_key_hash=$"+strconv.Itoa(currentIndex))
+		currentIndex++
+	}
+	if f.AfterID > 0 {
+		conds = append(conds, "id>$"+strconv.Itoa(currentIndex))
+		currentIndex++
+	}
+
+	var out strings.Builder
+	if len(conds) > 0 {
+		out.WriteString(" WHERE " + strings.Join(conds, " AND "))
+	}
+
+	out.WriteString(" ORDER BY id")
+
+	if f.Limit > 0 {
+		out.WriteString(" LIMIT $" + strconv.Itoa(currentIndex))
 	}
 
 	return out.String()
 }
 
-func (f *filters) queryList() []any {
+func (f *Filters) queryList() []any {
 	var out []any
 
-	if f.keyHash != "" {
-		out = append(out, f.keyHash)
+	if f.KeyHash != "" {
+		out = append(out, f.KeyHash)
+	}
+	if f.AfterID > 0 {
+		out = append(out, f.AfterID)
+	}
+	if f.Limit > 0 {
+		out = append(out, f.Limit)
 	}
 
 	return out
 }
 
-func (f *filters) logInfo() []any {
+// LogInfo returns the filters that are set, as key-value pairs for logging.
+func (f *Filters) LogInfo() []any {
 	var out []any
 
-	if f.keyHash != "" {
-		out = append(out, "keyHash", f.keyHash)
+	if f.KeyHash != "" {
+		out = append(out, "keyHash", f.KeyHash)
+	}
+	if f.AfterID > 0 {
+		out = append(out, "afterID", f.AfterID)
+	}
+	if f.Limit > 0 {
+		out = append(out, "limit", f.Limit)
 	}
 
 	return out
 }
 
-// GetUsers returns all users in the database.
+// GetUsers returns all users in the database, possibly filtered by the provided options.
 func (t *UserTable) GetUsers(ctx context.Context, opts ...FilterOption) ([]*User, error) {
-	var f filters
-	for _, opt := range opts {
-		opt(&f)
-	}
+	f := ReadFilters(opts)
 
-	query := "SELECT id, " + userFields + " FROM users" + f.formatWhereString()
+	query := "SELECT id, " + selectFields + " FROM users" + f.formatWhereString()
 	rows, err := t.pool.Query(ctx, query, f.queryList()...)
 	if err != nil {
-		t.logger.Error("msg", "failed to query db for users", "error", err)
+		t.log(ctx).Error("msg", "failed to query db for users", "error", err)
 
 		return nil, err
 	}
@@ -125,10 +214,10 @@ func (t *UserTable) GetUsers(ctx context.Context, opts ...FilterOption) ([]*User
 		var u User
 		err = rows.Scan(
 			&u.ID, &u.Name, &u.NameKeyHash, &u.FinishYear, &u.Professor, &u.TA,
-			&u.StudentLeadership, &u.AlumniBoard,
+			&u.StudentLeadership, &u.AlumniBoard, &u.Version,
 		)
 		if err != nil {
-			t.logger.Error("msg", "failed to scan user row", "error", err)
+			t.log(ctx).Error("msg", "failed to scan user row", "error", err)
 
 			return out, err
 		}
@@ -136,8 +225,8 @@ func (t *UserTable) GetUsers(ctx context.Context, opts ...FilterOption) ([]*User
 	}
 
 	logInfo := []any{"msg", "got all users", "count", len(out)}
-	logInfo = append(logInfo, f.logInfo()...)
-	t.logger.Debug(logInfo...)
+	logInfo = append(logInfo, f.LogInfo()...)
+	t.log(ctx).Debug(logInfo...)
 
 	return out, nil
 }
@@ -145,27 +234,27 @@ func (t *UserTable) GetUsers(ctx context.Context, opts ...FilterOption) ([]*User
 // GetUser returns the user by ID, if present. If not present, ErrNoUser is returned.
 func (t *UserTable) GetUser(ctx context.Context, id int) (*User, error) {
 	u := User{ID: id}
-	err := t.pool.QueryRow(ctx, "SELECT "+userFields+" FROM users WHERE id=$1", id).Scan(
+	err := t.pool.QueryRow(ctx, "SELECT "+selectFields+" FROM users WHERE id=$1", id).Scan(
 		&u.Name, &u.NameKeyHash, &u.FinishYear, &u.Professor, &u.TA, &u.StudentLeadership,
-		&u.AlumniBoard,
+		&u.AlumniBoard, &u.Version,
 	)
 	if errors.Is(err, pgx.ErrNoRows) {
-		t.logger.Info("msg", "user not in database", "id", id)
+		t.log(ctx).Info("msg", "user not in database", "id", id)
 
 		return &u, ErrNoUser
 	}
 	if err != nil {
-		t.logger.Error("msg", "failed to search for user", "id", id, "error", err)
+		t.log(ctx).Error("msg", "failed to search for user", "id", id, "error", err)
 
 		return &u, err
 	}
 
-	t.logger.Debug("msg", "found user info", "id", id)
+	t.log(ctx).Debug("msg", "found user info", "id", id)
 
 	return &u, nil
 }
 
-// CreateUser creates a new user (ignoring the ID field) and returns the new ID.
+// CreateUser creates a new user (ignoring the ID and Version fields) and returns the new ID.
 func (t *UserTable) CreateUser(ctx context.Context, u *User) (int, error) {
 	var newID int
 
@@ -174,55 +263,254 @@ func (t *UserTable) CreateUser(ctx context.Context, u *User) (int, error) {
 		u.Name, u.NameKeyHash, u.FinishYear, u.Professor, u.TA, u.StudentLeadership, u.AlumniBoard,
 	).Scan(&newID)
 	if err != nil {
-		t.logger.Error("msg", "failed to create user", "error", err)
+		t.log(ctx).Error("msg", "failed to create user", "error", err)
 
-		return newID, err
+		return newID, checkViolation(err)
 	}
 
-	t.logger.Debug("msg", "created new user", "id", newID)
+	t.log(ctx).Debug("msg", "created new user", "id", newID)
 
 	return newID, nil
 }
 
-// UpdateUser inserts the information in u into the row identified by u.ID. If that row does not
-// exist, ErrNoUser is returned.
-func (t *UserTable) UpdateUser(ctx context.Context, u *User) error {
-	tag, err := t.pool.Exec(ctx,
-		"UPDATE users SET "+userSets+" WHERE id=$1",
-		u.ID, u.Name, u.NameKeyHash, u.FinishYear, u.Professor, u.TA, u.StudentLeadership,
-		u.AlumniBoard,
-	)
+// ErrVersionMismatch is returned when a write was conditioned on a version of the row that is no
+// longer current.
+var ErrVersionMismatch = errors.New("user has been modified since it was read")
+
+// WriteOptions are the values set by each WriteOption. Implementations of UserStore get them with
+// ReadWriteOptions.
+type WriteOptions struct {
+	Version int
+}
+
+// WriteOption modifies how UpdateUser, PatchUser, and DeleteUser change a row.
+type WriteOption = func(w *WriteOptions)
+
+// IfVersion returns a WriteOption that only allows the write if the row is currently at the given
+// version. Otherwise ErrVersionMismatch is returned. A version of 0 allows any version.
+func IfVersion(version int) WriteOption {
+	return func(w *WriteOptions) { w.Version = version }
+}
+
+// ReadWriteOptions returns the options set by opts.
+func ReadWriteOptions(opts []WriteOption) WriteOptions {
+	var w WriteOptions
+	for _, opt := range opts {
+		opt(&w)
+	}
+
+	return w
+}
+
+// missingOrStale determines why a write conditioned on w affected no rows.
+func (t *UserTable) missingOrStale(
+	ctx context.Context, action string, id int, w WriteOptions,
+) error {
+	if w.Version == 0 {
+		t.log(ctx).Info("msg", "no matching user to "+action, "id", id)
+
+		return ErrNoUser
+	}
+
+	var current int
+	err := t.pool.QueryRow(ctx, "SELECT version FROM users WHERE id=$1", id).Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		t.log(ctx).Info("msg", "no matching user to "+action, "id", id)
+
+		return ErrNoUser
+	}
 	if err != nil {
-		t.logger.Error("msg", "failed to update user", "id", u.ID, "error", err)
+		t.log(ctx).Error("msg", "failed to check user version", "id", id, "error", err)
 
 		return err
 	}
-	if tag.RowsAffected() != 1 {
-		t.logger.Info("msg", "no matching user to update", "id", u.ID)
 
-		return ErrNoUser
+	t.log(ctx).Info("msg", "user version mismatch", "id", id, "action", action,
+		"expected", w.Version, "current", current)
+
+	return ErrVersionMismatch
+}
+
+// UpdateUser inserts the information in u into the row identified by u.ID, and sets u.Version to
+// the new version of the row. If that row does not exist, ErrNoUser is returned.
+func (t *UserTable) UpdateUser(ctx context.Context, u *User, opts ...WriteOption) error {
+	w := ReadWriteOptions(opts)
+
+	query := "UPDATE users SET " + userSets + ", version=version+1 WHERE id=$1"
+	args := []any{
+		u.ID, u.Name, u.NameKeyHash, u.FinishYear, u.Professor, u.TA, u.StudentLeadership,
+		u.AlumniBoard,
+	}
+	if w.Version > 0 {
+		query += " AND version=$9"
+		args = append(args, w.Version)
 	}
 
-	t.logger.Debug("msg", "updated user", "id", u.ID)
+	err := t.pool.QueryRow(ctx, query+" RETURNING version", args...).Scan(&u.Version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return t.missingOrStale(ctx, "update", u.ID, w)
+	}
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to update user", "id", u.ID, "error", err)
+
+		return checkViolation(err)
+	}
+
+	t.log(ctx).Debug("msg", "updated user", "id", u.ID)
 
 	return nil
 }
 
+// UserPatch contains new values for some of the fields of a user. Nil fields are not changed.
+type UserPatch struct {
+	Name              *string
+	NameKeyHash       *string
+	FinishYear        *string
+	Professor         *bool
+	TA                *bool
+	StudentLeadership *bool
+	AlumniBoard       *bool
+}
+
+// PatchFromAPI converts the wire representation of a patch to a UserPatch.
+func PatchFromAPI(p *api.UserPatch) *UserPatch {
+	out := UserPatch(*p)
+
+	return &out
+}
+
+// ErrEmptyPatch is returned by PatchUser if the patch doesn't change any fields.
+var ErrEmptyPatch = errors.New("no fields to update")
+
+// Columns returns the names of the columns being set and their new values.
+func (p *UserPatch) Columns() (columns []string, values []any) {
+	for _, field := range []struct {
+		column string
+		set    bool
+		value  any
+	}{
+		{"name", p.Name != nil, p.Name},
+		{"name_key_hash", p.NameKeyHash != nil, p.NameKeyHash},
+		{"finish_year", p.FinishYear != nil, p.FinishYear},
+		{"professor", p.Professor != nil, p.Professor},
+		{"ta", p.TA != nil, p.TA},
+		{"student_leadership", p.StudentLeadership != nil, p.StudentLeadership},
+		{"alumni_board", p.AlumniBoard != nil, p.AlumniBoard},
+	} {
+		if field.set {
+			columns = append(columns, field.column)
+			values = append(values, field.value)
+		}
+	}
+
+	return columns, values
+}
+
+// sets returns the assignments for the SET clause of an UPDATE query, with arguments numbered
+// starting after the first. It also returns the names of the columns being set.
+func (p *UserPatch) sets() (sets, columns []string, args []any) {
+	columns, args = p.Columns()
+	for i, column := range columns {
+		sets = append(sets, column+"=$"+strconv.Itoa(i+2))
+	}
+
+	return sets, columns, args
+}
+
+// PatchUser changes only the fields set in p for the user by ID, and returns the updated user. If
+// the user does not exist, ErrNoUser is returned.
+func (t *UserTable) PatchUser(
+	ctx context.Context, id int, p *UserPatch, opts ...WriteOption,
+) (*User, error) {
+	w := ReadWriteOptions(opts)
+
+	sets, columns, args := p.sets()
+	if len(sets) == 0 {
+		return nil, ErrEmptyPatch
+	}
+
+	query := "UPDATE users SET " + strings.Join(sets, ", ") + ", version=version+1 WHERE id=$1"
+	args = append([]any{id}, args...)
+	if w.Version > 0 {
+		args = append(args, w.Version)
+		query += " AND version=$" + strconv.Itoa(len(args))
+	}
+
+	u := User{ID: id}
+	err := t.pool.QueryRow(ctx, query+" RETURNING "+selectFields, args...).Scan(
+		&u.Name, &u.NameKeyHash, &u.FinishYear, &u.Professor, &u.TA, &u.StudentLeadership,
+		&u.AlumniBoard, &u.Version,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, t.missingOrStale(ctx, "patch", id, w)
+	}
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to patch user", "id", id, "error", err)
+
+		return nil, checkViolation(err)
+	}
+
+	t.log(ctx).Debug("msg", "patched user", "id", id, "fields", strings.Join(columns, ","))
+
+	return &u, nil
+}
+
 // DeleteUser removes the user by ID. If the user did not exist, returns ErrNoUser.
-func (t *UserTable) DeleteUser(ctx context.Context, id int) error {
-	tag, err := t.pool.Exec(ctx, "DELETE FROM users WHERE id=$1", id)
+func (t *UserTable) DeleteUser(ctx context.Context, id int, opts ...WriteOption) error {
+	w := ReadWriteOptions(opts)
+
+	query := "DELETE FROM users WHERE id=$1"
+	args := []any{id}
+	if w.Version > 0 {
+		query += " AND version=$2"
+		args = append(args, w.Version)
+	}
+
+	tag, err := t.pool.Exec(ctx, query, args...)
 	if err != nil {
-		t.logger.Error("msg", "failed to delete user", "id", id, "error", err)
+		t.log(ctx).Error("msg", "failed to delete user", "id", id, "error", err)
 
 		return err
 	}
 	if tag.RowsAffected() != 1 {
-		t.logger.Info("msg", "no matching user to delete", "id", id)
-
-		return ErrNoUser
+		return t.missingOrStale(ctx, "delete", id, w)
 	}
 
-	t.logger.Debug("msg", "deleted user", "id", id)
+	t.log(ctx).Debug("msg", "deleted user", "id", id)
 
 	return nil
 }
+
+// CountByYear returns the number of users for each finish year. Users are deleted once they are
+// admitted, so these are the users still waiting to join.
+func (t *UserTable) CountByYear(ctx context.Context) (map[string]int, error) {
+	rows, err := t.pool.Query(ctx, "SELECT finish_year, count(*) FROM users GROUP BY finish_year")
+	if err != nil {
+		t.log(ctx).Error("msg", "failed to count users", "error", err)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]int)
+	for rows.Next() {
+		var year string
+		var n int
+		err = rows.Scan(&year, &n)
+		if err != nil {
+			t.log(ctx).Error("msg", "failed to scan user count", "error", err)
+
+			return nil, err
+		}
+		out[year] = n
+	}
+	if err = rows.Err(); err != nil {
+		t.log(ctx).Error("msg", "failed to count users", "error", err)
+
+		return nil, err
+	}
+
+	t.log(ctx).Debug("msg", "counted users by year", "years", len(out))
+
+	return out, nil
+}
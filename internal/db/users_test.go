@@ -23,8 +23,10 @@ var (
 		"ta",
 		"student_leadership",
 		"alumni_board",
+		"version",
 	}
-	userFields = strings.Join(userColumns[1:], ", ")
+	userFields   = strings.Join(userColumns[1:len(userColumns)-1], ", ")
+	selectFields = userFields + ", version"
 )
 
 func TestUserTable(t *testing.T) { //nolint:cyclop,funlen,gocyclo // testing sequential calls
@@ -63,8 +65,9 @@ func TestUserTable(t *testing.T) { //nolint:cyclop,funlen,gocyclo // testing seq
 	if john.ID != 1 {
 		t.Errorf("wrong ID for John: %d", john.ID)
 	}
+	john.Version = 1
 
-	willReturnUsers(mockDB.ExpectQuery("SELECT "+userFields+" FROM users").WithArgs(1), false, &john)
+	willReturnUsers(mockDB.ExpectQuery("SELECT "+selectFields+" FROM users").WithArgs(1), false, &john)
 	newJohn, err := table.GetUser(ctx, john.ID)
 	if err != nil {
 		t.Errorf("error from GetUser: %v", err)
@@ -75,7 +78,7 @@ func TestUserTable(t *testing.T) { //nolint:cyclop,funlen,gocyclo // testing seq
 	}
 
 	// get nonexistent user
-	mockDB.ExpectQuery("SELECT " + userFields + " FROM users").
+	mockDB.ExpectQuery("SELECT " + selectFields + " FROM users").
 		WithArgs(2).
 		WillReturnError(pgx.ErrNoRows)
 	_, err = table.GetUser(ctx, 2)
@@ -97,8 +100,10 @@ func TestUserTable(t *testing.T) { //nolint:cyclop,funlen,gocyclo // testing seq
 	if stephen.ID != 2 {
 		t.Errorf("wrong ID for Stephen: %d", stephen.ID)
 	}
+	stephen.Version = 1
 
-	willReturnUsers(mockDB.ExpectQuery("SELECT id, "+userFields+" FROM users"), true, &john, &stephen)
+	willReturnUsers(
+		mockDB.ExpectQuery("SELECT id, "+selectFields+" FROM users"), true, &john, &stephen)
 	users, err := table.GetUsers(ctx)
 	if err != nil {
 		t.Errorf("unexpected error from GetUsers: %v", err)
@@ -110,14 +115,17 @@ func TestUserTable(t *testing.T) { //nolint:cyclop,funlen,gocyclo // testing seq
 
 	// modify Stephen and check
 	stephen.Name = "Stephen King"
-	withUserArgs(&stephen, mockDB.ExpectExec("UPDATE users"), true).
-		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	withUserArgs(&stephen, mockDB.ExpectQuery("UPDATE users"), true).
+		WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(2))
 	err = table.UpdateUser(ctx, &stephen)
 	if err != nil {
 		t.Errorf("unexpected error from UpdateUser: %v", err)
 	}
+	if stephen.Version != 2 {
+		t.Errorf("wrong version for Stephen after update: %d", stephen.Version)
+	}
 
-	willReturnUsers(mockDB.ExpectQuery("SELECT "+userFields+" FROM users").
+	willReturnUsers(mockDB.ExpectQuery("SELECT "+selectFields+" FROM users").
 		WithArgs(stephen.ID), false, &stephen)
 	newUser, err := table.GetUser(ctx, stephen.ID)
 	if err != nil {
@@ -130,7 +138,7 @@ func TestUserTable(t *testing.T) { //nolint:cyclop,funlen,gocyclo // testing seq
 
 	// get Stephen by hash
 	willReturnUsers(
-		mockDB.ExpectQuery("SELECT id, "+userFields+" FROM users").WithArgs(stephen.NameKeyHash),
+		mockDB.ExpectQuery("SELECT id, "+selectFields+" FROM users").WithArgs(stephen.NameKeyHash),
 		true, &stephen)
 	users, err = table.GetUsers(ctx, db.WithKeyHash(stephen.NameKeyHash))
 	if err != nil {
@@ -141,16 +149,81 @@ func TestUserTable(t *testing.T) { //nolint:cyclop,funlen,gocyclo // testing seq
 		t.Error("unexpected users (-want +got):\n" + diff)
 	}
 
+	// get a page of users after John
+	willReturnUsers(
+		mockDB.ExpectQuery(`SELECT id, `+selectFields+` FROM users WHERE id>\$1 ORDER BY id LIMIT \$2`).
+			WithArgs(john.ID, 1),
+		true, &stephen)
+	users, err = table.GetUsers(ctx, db.WithAfterID(john.ID), db.WithLimit(1))
+	if err != nil {
+		t.Errorf("unexpected error from GetUsers: %v", err)
+	}
+	diff = cmp.Diff([]*db.User{&stephen}, users)
+	if diff != "" {
+		t.Error("unexpected users (-want +got):\n" + diff)
+	}
+
+	// update Stephen with a stale version
+	withUserArgs(&stephen, mockDB.ExpectQuery(`UPDATE users .* AND version=\$9`), true, 1).
+		WillReturnRows(pgxmock.NewRows([]string{"version"}))
+	mockDB.ExpectQuery("SELECT version FROM users").
+		WithArgs(stephen.ID).
+		WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(2))
+	err = table.UpdateUser(ctx, &stephen, db.IfVersion(1))
+	if !errors.Is(err, db.ErrVersionMismatch) {
+		t.Errorf("unexpected error from UpdateUser: %v", err)
+	}
+
+	// patch Stephen's finish year
+	year := "2020"
+	mockDB.ExpectQuery(
+		`UPDATE users SET finish_year=\$2, version=version\+1 WHERE id=\$1 AND version=\$3 `+
+			`RETURNING `+selectFields).
+		WithArgs(stephen.ID, &year, 2).
+		WillReturnRows(pgxmock.NewRows(userColumns[1:]).AddRow(
+			stephen.Name, stephen.NameKeyHash, year, stephen.Professor, stephen.TA,
+			stephen.StudentLeadership, stephen.AlumniBoard, 3,
+		))
+	patched, err := table.PatchUser(ctx, stephen.ID, &db.UserPatch{FinishYear: &year},
+		db.IfVersion(2))
+	if err != nil {
+		t.Errorf("unexpected error from PatchUser: %v", err)
+	}
+	stephen.FinishYear = year
+	stephen.Version = 3
+	diff = cmp.Diff(&stephen, patched)
+	if diff != "" {
+		t.Error("unexpected patched Stephen (-want +got):\n" + diff)
+	}
+
+	// empty patch
+	_, err = table.PatchUser(ctx, stephen.ID, &db.UserPatch{})
+	if !errors.Is(err, db.ErrEmptyPatch) {
+		t.Errorf("unexpected error from PatchUser: %v", err)
+	}
+
 	// update nonexistent user
 	stephen.ID = 4
-	withUserArgs(&stephen, mockDB.ExpectExec("UPDATE users"), true).
-		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	withUserArgs(&stephen, mockDB.ExpectQuery("UPDATE users"), true).
+		WillReturnRows(pgxmock.NewRows([]string{"version"}))
 	err = table.UpdateUser(ctx, &stephen)
 	if !errors.Is(err, db.ErrNoUser) {
 		t.Errorf("unexpected error from UpdateUser: %v", err)
 	}
 	stephen.ID = 2
 
+	// delete Stephen with a stale version
+	mockDB.ExpectExec(`DELETE FROM users WHERE id=\$1 AND version=\$2`).
+		WithArgs(stephen.ID, 2).
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	mockDB.ExpectQuery("SELECT version FROM users").
+		WithArgs(stephen.ID).
+		WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(3))
+	err = table.DeleteUser(ctx, stephen.ID, db.IfVersion(2))
+	if !errors.Is(err, db.ErrVersionMismatch) {
+		t.Errorf("unexpected error from DeleteUser: %v", err)
+	}
+
 	// delete nonexistent user
 	mockDB.ExpectExec("DELETE FROM users").
 		WithArgs(4).
@@ -169,7 +242,7 @@ func TestUserTable(t *testing.T) { //nolint:cyclop,funlen,gocyclo // testing seq
 		t.Errorf("unexpected error from DeleteUser: %v", err)
 	}
 
-	mockDB.ExpectQuery("SELECT " + userFields + " FROM users").
+	mockDB.ExpectQuery("SELECT " + selectFields + " FROM users").
 		WithArgs(john.ID).
 		WillReturnError(pgx.ErrNoRows)
 	_, err = table.GetUser(ctx, john.ID)
@@ -188,13 +261,14 @@ type withArgser[T any] interface {
 	WithArgs(args ...any) T
 }
 
-func withUserArgs[T withArgser[T]](u *db.User, mdb T, withID bool) T {
-	args := make([]any, 0, 8)
+func withUserArgs[T withArgser[T]](u *db.User, mdb T, withID bool, extra ...any) T {
+	args := make([]any, 0, 8+len(extra))
 	if withID {
 		args = append(args, u.ID)
 	}
 	args = append(args,
 		u.Name, u.NameKeyHash, u.FinishYear, u.Professor, u.TA, u.StudentLeadership, u.AlumniBoard)
+	args = append(args, extra...)
 
 	return mdb.WithArgs(args...)
 }
@@ -202,13 +276,13 @@ func withUserArgs[T withArgser[T]](u *db.User, mdb T, withID bool) T {
 func willReturnUsers(mdb *pgxmock.ExpectedQuery, withID bool, users ...*db.User) {
 	rows := make([][]any, len(users))
 	for i, u := range users {
-		args := make([]any, 0, 8)
+		args := make([]any, 0, 9)
 		if withID {
 			args = append(args, u.ID)
 		}
 		args = append(args,
 			u.Name, u.NameKeyHash, u.FinishYear, u.Professor, u.TA, u.StudentLeadership,
-			u.AlumniBoard,
+			u.AlumniBoard, u.Version,
 		)
 
 		rows[i] = args
@@ -221,3 +295,31 @@ func willReturnUsers(mdb *pgxmock.ExpectedQuery, withID bool, users ...*db.User)
 
 	mdb.WillReturnRows(pgxmock.NewRows(columns).AddRows(rows...))
 }
+
+func TestUserTable_CountByYear(t *testing.T) {
+	t.Parallel()
+
+	mockDB, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("error opening mock db: %v", err)
+	}
+	defer mockDB.Close()
+
+	logger := testinglog.NewConvenientLogger(t)
+	table := db.NewUserTable(logger, mockDB)
+
+	mockDB.ExpectQuery("SELECT finish_year, count\\(\\*\\) FROM users GROUP BY finish_year").
+		WillReturnRows(pgxmock.NewRows([]string{"finish_year", "count"}).
+			AddRow("2019", 3).AddRow("", 1))
+	counts, err := table.CountByYear(context.Background())
+	if err != nil {
+		t.Errorf("error from CountByYear: %v", err)
+	}
+	if diff := cmp.Diff(map[string]int{"2019": 3, "": 1}, counts); diff != "" {
+		t.Error("unexpected counts (-want +got):\n" + diff)
+	}
+
+	if err = mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,85 @@
+// Package logging creates the loggers used by the server.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/cobaltspeech/log/pkg/level"
+)
+
+// New returns a logger that writes to w in the format, which is "text" or "json". The verbosity is
+// between 0 (errors only) and 4 (everything). The values of secret keys are redacted; see Redact.
+func New(w io.Writer, format string, verbosity int) (log.Logger, error) {
+	filter := level.Verbosity(verbosity)
+
+	switch format {
+	case "text":
+		return Redact(log.NewLeveledLogger(log.WithOutput(w), log.WithFilterLevel(filter))), nil
+	case "json":
+		return Redact(&JSONLogger{w: w, filter: filter, now: time.Now}), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+// JSONLogger writes each message as a JSON object on its own line, for log shippers. The object has
+// the time and level followed by the key-value pairs of the message, in order. Values are written
+// as strings, like in the text format.
+type JSONLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	filter level.Level
+	now    func() time.Time
+}
+
+func (l *JSONLogger) Error(keyvals ...any) { l.log(level.Error, keyvals) }
+func (l *JSONLogger) Info(keyvals ...any)  { l.log(level.Info, keyvals) }
+func (l *JSONLogger) Debug(keyvals ...any) { l.log(level.Debug, keyvals) }
+func (l *JSONLogger) Trace(keyvals ...any) { l.log(level.Trace, keyvals) }
+
+func (l *JSONLogger) log(lvl level.Level, keyvals []any) {
+	if l.filter&lvl == 0 {
+		return
+	}
+
+	buf := []byte(`{"time":`)
+	buf = appendString(buf, l.now().UTC().Format(time.RFC3339Nano))
+	buf = append(buf, `,"level":`...)
+	buf = appendString(buf, lvl.String())
+	for i := 0; i < len(keyvals); i += 2 {
+		v := any("(MISSING)")
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+
+		buf = append(buf, ',')
+		buf = appendString(buf, fmt.Sprint(keyvals[i]))
+		buf = append(buf, ':')
+		buf = appendString(buf, valueString(v))
+	}
+	buf = append(buf, "}\n"...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.w.Write(buf) //nolint:errcheck // There's nowhere else to report the error.
+}
+
+func valueString(v any) string {
+	if err, ok := v.(error); ok && err != nil {
+		return err.Error()
+	}
+
+	return fmt.Sprint(v)
+}
+
+func appendString(buf []byte, s string) []byte {
+	b, _ := json.Marshal(s) //nolint:errchkjson // Strings always encode.
+
+	return append(buf, b...)
+}
@@ -0,0 +1,60 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+)
+
+func TestNew_JSON(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l, err := logging.New(&buf, "json", 1)
+	if err != nil {
+		t.Fatalf("error from New: %v", err)
+	}
+
+	l.Info("msg", "admitted new user", "count", 2, "error", errors.New("oops"), "odd")
+	l.Debug("msg", "filtered out")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %q", lines)
+	}
+
+	var got map[string]string
+	err = json.Unmarshal([]byte(lines[0]), &got)
+	if err != nil {
+		t.Fatalf("line is not a JSON object: %v", err)
+	}
+	if got["time"] == "" {
+		t.Error("missing time")
+	}
+	delete(got, "time")
+
+	want := map[string]string{
+		"level": "info",
+		"msg":   "admitted new user",
+		"count": "2",
+		"error": "oops",
+		"odd":   "(MISSING)",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error("unexpected fields (-want +got):\n" + diff)
+	}
+}
+
+func TestNew_UnknownFormat(t *testing.T) {
+	t.Parallel()
+
+	_, err := logging.New(&bytes.Buffer{}, "xml", 2)
+	if err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"strings"
+
+	"github.com/cobaltspeech/log"
+)
+
+// Redacted replaces the values of secret keys in log messages.
+const Redacted = "[REDACTED]"
+
+// secretKeys are the log keys whose values are never written, compared without case. The bot logs
+// the keys sent to it under "key", for example.
+var secretKeys = map[string]bool{
+	"key":           true,
+	"password":      true,
+	"secret":        true,
+	"client_secret": true,
+	"code":          true,
+	"cookie":        true,
+	"authorization": true,
+}
+
+// Redact returns a logger that replaces the values of secret keys, like "key" and "password",
+// before passing messages to l. New already redacts its loggers.
+func Redact(l log.Logger) log.Logger {
+	if _, ok := l.(*redactor); ok {
+		return l
+	}
+
+	return &redactor{l}
+}
+
+type redactor struct {
+	l log.Logger
+}
+
+func (r *redactor) Error(keyvals ...any) { r.l.Error(redact(keyvals)...) }
+func (r *redactor) Info(keyvals ...any)  { r.l.Info(redact(keyvals)...) }
+func (r *redactor) Debug(keyvals ...any) { r.l.Debug(redact(keyvals)...) }
+func (r *redactor) Trace(keyvals ...any) { r.l.Trace(redact(keyvals)...) }
+
+// redact returns keyvals with the values of secret keys replaced. It only copies keyvals if there
+// is something to replace.
+func redact(keyvals []any) []any {
+	var out []any
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		k, ok := keyvals[i].(string)
+		if !ok || !secretKeys[strings.ToLower(k)] {
+			continue
+		}
+
+		if out == nil {
+			out = append([]any(nil), keyvals...)
+		}
+		out[i+1] = Redacted
+	}
+	if out == nil {
+		return keyvals
+	}
+
+	return out
+}
@@ -0,0 +1,40 @@
+package logging_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kylrth/disco-bouncer/internal/logging"
+)
+
+func TestRedact(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []string{"text", "json"} {
+		t.Run(format, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			l, err := logging.New(&buf, format, 4)
+			if err != nil {
+				t.Fatalf("error from New: %v", err)
+			}
+
+			keyvals := []any{"msg", "DM did not provide acceptable key", "key", "hunter2",
+				"Password", "hunter3", "user", "jane"}
+			l.Info(keyvals...)
+
+			out := buf.String()
+			if strings.Contains(out, "hunter") {
+				t.Errorf("secret was logged: %s", out)
+			}
+			if !strings.Contains(out, logging.Redacted) || !strings.Contains(out, "jane") {
+				t.Errorf("unexpected log line: %s", out)
+			}
+			if keyvals[3] != "hunter2" {
+				t.Error("redacting changed the caller's key-value pairs")
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"context"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// requestIDKey is the key of the request ID in a context, including the *fasthttp.RequestCtx of a
+// Fiber handler.
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID in ctx, or "" if there is none. ctx can be the c.Context() of a
+// Fiber handler after RequestIDMiddleware.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+
+	return id
+}
+
+// FromContext returns l with the request ID in ctx added to the end of every message, or l itself
+// if ctx has no request ID.
+func FromContext(l log.Logger, ctx context.Context) log.Logger {
+	id := RequestID(ctx)
+	if id == "" {
+		return l
+	}
+
+	return &suffixLogger{l: l, suffix: []any{"requestID", id}}
+}
+
+type suffixLogger struct {
+	l      log.Logger
+	suffix []any
+}
+
+func (s *suffixLogger) Error(keyvals ...any) { s.l.Error(s.with(keyvals)...) }
+func (s *suffixLogger) Info(keyvals ...any)  { s.l.Info(s.with(keyvals)...) }
+func (s *suffixLogger) Debug(keyvals ...any) { s.l.Debug(s.with(keyvals)...) }
+func (s *suffixLogger) Trace(keyvals ...any) { s.l.Trace(s.with(keyvals)...) }
+
+func (s *suffixLogger) with(keyvals []any) []any {
+	out := make([]any, 0, len(keyvals)+len(s.suffix))
+
+	return append(append(out, keyvals...), s.suffix...)
+}
+
+// maxRequestIDLen is the longest request ID accepted from a client.
+const maxRequestIDLen = 64
+
+// RequestIDMiddleware gives each request an ID, which is sent back in the X-Request-ID header and
+// added to logs by FromContext. An ID sent by the client in the same header is used if it is short
+// and only contains letters, digits, '.', '_', and '-', so that it can't forge log lines.
+func RequestIDMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Get(fiber.HeaderXRequestID)
+		if !validRequestID(id) {
+			id = utils.UUIDv4()
+		}
+
+		c.Set(fiber.HeaderXRequestID, id)
+		c.Context().SetUserValue(requestIDKey{}, id)
+		c.SetUserContext(ContextWithRequestID(c.UserContext(), id))
+
+		return c.Next()
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// AccessLog logs each request at the info level, with its request ID. Requests for which skip
+// returns true are not logged. It should be added after RequestIDMiddleware.
+func AccessLog(l log.Logger, skip func(c *fiber.Ctx) bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if skip != nil && skip(c) {
+			return c.Next()
+		}
+
+		start := time.Now()
+		err := c.Next()
+		if err != nil {
+			// The status is set by the error handler, which would otherwise run after this returns.
+			if err = c.App().ErrorHandler(c, err); err != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		FromContext(l, c.Context()).Info("msg", "request",
+			"method", c.Method(), "path", c.Path(), "status", c.Response().StatusCode(),
+			"latency", time.Since(start), "ip", c.IP())
+
+		return nil
+	}
+}
@@ -0,0 +1,129 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cobaltspeech/log"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kylrth/disco-bouncer/internal/logging"
+)
+
+func newApp(l log.Logger) *fiber.App {
+	app := fiber.New()
+	app.Use(logging.RequestIDMiddleware())
+	app.Use(logging.AccessLog(l, func(c *fiber.Ctx) bool { return c.Path() == "/healthz" }))
+	app.Get("/", func(c *fiber.Ctx) error {
+		// Tables get the ID from the Fiber context.
+		logging.FromContext(l, c.Context()).Info("msg", "handling")
+
+		return c.SendString(logging.RequestID(c.UserContext()))
+	})
+	app.Get("/healthz", func(c *fiber.Ctx) error { return c.SendString("OK") })
+
+	return app
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	t.Parallel()
+
+	app := newApp(log.NewDiscardLogger())
+
+	tests := map[string]struct {
+		sent    string
+		reuseID bool
+	}{
+		"generated": {},
+		"from client": {
+			sent:    "abc-123",
+			reuseID: true,
+		},
+		"unsafe from client": {
+			sent: "abc\nmsg=forged",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.sent != "" {
+				req.Header.Set(fiber.HeaderXRequestID, tc.sent)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("error from request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			id := resp.Header.Get(fiber.HeaderXRequestID)
+			if id == "" {
+				t.Fatal("no request ID in response")
+			}
+			if (id == tc.sent) != tc.reuseID {
+				t.Errorf("unexpected request ID %q for %q", id, tc.sent)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("error reading response: %v", err)
+			}
+			if string(body) != id {
+				t.Errorf("user context has request ID %q, but response has %q", body, id)
+			}
+		})
+	}
+
+}
+
+func TestAccessLog(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	app := newApp(log.NewLeveledLogger(log.WithOutput(&buf)))
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if err != nil {
+		t.Fatalf("error from request: %v", err)
+	}
+	resp.Body.Close()
+	if buf.Len() != 0 {
+		t.Errorf("skipped request was logged: %s", buf.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(fiber.HeaderXRequestID, "req-1")
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatalf("error from request: %v", err)
+	}
+	resp.Body.Close()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %q", lines)
+	}
+	for _, line := range lines {
+		if !strings.HasSuffix(line, `"requestID":"req-1"}`) {
+			t.Errorf("log line does not end with the request ID: %s", line)
+		}
+	}
+	if !strings.Contains(lines[1], `"msg":"request"`) ||
+		!strings.Contains(lines[1], `"status":"200"`) {
+		t.Errorf("unexpected access log: %s", lines[1])
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	t.Parallel()
+
+	l := log.NewDiscardLogger()
+	if logging.FromContext(l, context.Background()) != log.Logger(l) {
+		t.Error("expected the same logger without a request ID")
+	}
+}
@@ -0,0 +1,177 @@
+// Package metrics records Prometheus metrics about the API and the bouncer bot.
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/kylrth/disco-bouncer/pkg/bouncerbot"
+)
+
+const namespace = "bouncer"
+
+// Metrics holds the metrics of the server. It implements bouncerbot.Observer.
+type Metrics struct {
+	reg *prometheus.Registry
+
+	admissions *prometheus.CounterVec
+	decrypt    *prometheus.HistogramVec
+	discord    *prometheus.HistogramVec
+	requests   *prometheus.HistogramVec
+}
+
+// New creates the metrics, including the standard metrics about the Go runtime and the process.
+func New() *Metrics {
+	m := Metrics{
+		reg: prometheus.NewRegistry(),
+		admissions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "admissions_total",
+			Help:      "DMs with a key handled by the bot, by outcome.",
+		}, []string{"outcome"}),
+		decrypt: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "decrypt_duration_seconds",
+			Help:      "Time taken to find and decrypt the user for a key.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"result"}),
+		discord: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "discord_request_duration_seconds",
+			Help:      "Latency of requests to the Discord REST API, by route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		requests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of requests to the API, by route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+	}
+
+	// Start every outcome at zero, so that rates can be computed from the first failure.
+	for _, o := range bouncerbot.Outcomes {
+		m.admissions.WithLabelValues(string(o))
+	}
+
+	m.reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.admissions, m.decrypt, m.discord, m.requests,
+	)
+
+	return &m
+}
+
+// Handler serves the metrics in the Prometheus format.
+func (m *Metrics) Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{}))
+}
+
+// Middleware records the latency and status of each request, labeled with the route that handled
+// it, like "/api/users/:id". Requests that were not handled by a route are labeled with the path of
+// the last middleware they passed through. It should be added before all other handlers.
+func (m *Metrics) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		err := c.Next()
+		if err != nil {
+			// The status is set by the error handler, which would otherwise run after this returns.
+			if err = c.App().ErrorHandler(c, err); err != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		m.requests.WithLabelValues(
+			c.Method(), c.Route().Path, strconv.Itoa(c.Response().StatusCode()),
+		).Observe(time.Since(start).Seconds())
+
+		return nil
+	}
+}
+
+func (m *Metrics) ObserveAdmission(o bouncerbot.Outcome) {
+	m.admissions.WithLabelValues(string(o)).Inc()
+}
+
+func (m *Metrics) ObserveDecrypt(d time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.decrypt.WithLabelValues(result).Observe(d.Seconds())
+}
+
+func (m *Metrics) ObserveDiscord(method, route string, status int, d time.Duration) {
+	m.discord.WithLabelValues(method, route, strconv.Itoa(status)).Observe(d.Seconds())
+}
+
+// UserCounter counts the users waiting to join. It is implemented by every db.UserStore.
+type UserCounter interface {
+	CountByYear(ctx context.Context) (map[string]int, error)
+}
+
+// countTimeout limits how long a scrape waits for the database.
+const countTimeout = 5 * time.Second
+
+// WatchPendingUsers adds a gauge of the users waiting to join, by finish year. The users are
+// counted whenever the metrics are scraped.
+func (m *Metrics) WatchPendingUsers(l log.Logger, users UserCounter) {
+	desc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "pending_users"),
+		"Users waiting to join, by finish year.", []string{"finish_year"}, nil)
+
+	m.reg.MustRegister(&collector{desc: desc, collect: func(ch chan<- prometheus.Metric) {
+		ctx, cancel := context.WithTimeout(context.Background(), countTimeout)
+		defer cancel()
+
+		counts, err := users.CountByYear(ctx)
+		if err != nil {
+			l.Error("msg", "failed to count pending users for metrics", "error", err)
+
+			return
+		}
+		for year, n := range counts {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(n), year)
+		}
+	}})
+}
+
+// GuildInfoSource says when the guild info was last updated. It is implemented by
+// *bouncerbot.Bot.
+type GuildInfoSource interface {
+	GuildInfoUpdated() time.Time
+}
+
+// WatchGuildInfo adds a gauge of the time since the guild info was last updated. It is missing
+// until the guild info is discovered.
+func (m *Metrics) WatchGuildInfo(gi GuildInfoSource) {
+	desc := prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "guild_info_age_seconds"),
+		"Time since the guild info was last discovered or updated.", nil, nil)
+
+	m.reg.MustRegister(&collector{desc: desc, collect: func(ch chan<- prometheus.Metric) {
+		updated := gi.GuildInfoUpdated()
+		if updated.IsZero() {
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue,
+			time.Since(updated).Seconds())
+	}})
+}
+
+// collector collects a metric computed when it is scraped.
+type collector struct {
+	desc    *prometheus.Desc
+	collect func(ch chan<- prometheus.Metric)
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) { ch <- c.desc }
+func (c *collector) Collect(ch chan<- prometheus.Metric) { c.collect(ch) }
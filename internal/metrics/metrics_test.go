@@ -0,0 +1,109 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cobaltspeech/log/pkg/testinglog"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kylrth/disco-bouncer/internal/metrics"
+	"github.com/kylrth/disco-bouncer/pkg/bouncerbot"
+)
+
+type fakeUsers map[string]int
+
+func (f fakeUsers) CountByYear(context.Context) (map[string]int, error) {
+	return f, nil
+}
+
+type fakeGuildInfo time.Time
+
+func (f fakeGuildInfo) GuildInfoUpdated() time.Time {
+	return time.Time(f)
+}
+
+func scrape(t *testing.T, app *fiber.App) string {
+	t.Helper()
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if err != nil {
+		t.Fatalf("error scraping metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading metrics: %v", err)
+	}
+
+	return string(b)
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	m := metrics.New()
+	m.WatchPendingUsers(testinglog.NewConvenientLogger(t), fakeUsers{"2024": 3, "": 1})
+	m.WatchGuildInfo(fakeGuildInfo(time.Now().Add(-time.Minute)))
+
+	app := fiber.New()
+	app.Use(m.Middleware())
+	app.Get("/metrics", m.Handler())
+	app.Get("/api/users/:id", func(c *fiber.Ctx) error {
+		return fiber.NewError(http.StatusNotFound, "User not found")
+	})
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/users/12", nil))
+	if err != nil {
+		t.Fatalf("error from request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the handler's error status, got %d", resp.StatusCode)
+	}
+
+	m.ObserveAdmission(bouncerbot.OutcomeSuccess)
+	m.ObserveAdmission(bouncerbot.OutcomeSuccess)
+	m.ObserveDecrypt(time.Millisecond, errors.New("oops"))
+	m.ObserveDiscord(http.MethodPut, "/guilds/:id/members/:id/roles/:id", 204, time.Millisecond)
+
+	out := scrape(t, app)
+	for _, want := range []string{
+		`bouncer_http_request_duration_seconds_count{method="GET",route="/api/users/:id",` +
+			`status="404"} 1`,
+		`bouncer_admissions_total{outcome="success"} 2`,
+		`bouncer_admissions_total{outcome="bad_key"} 0`,
+		`bouncer_decrypt_duration_seconds_count{result="error"} 1`,
+		`bouncer_discord_request_duration_seconds_count{method="PUT",` +
+			`route="/guilds/:id/members/:id/roles/:id",status="204"} 1`,
+		`bouncer_pending_users{finish_year="2024"} 3`,
+		`bouncer_pending_users{finish_year=""} 1`,
+		`bouncer_guild_info_age_seconds 6`, // about a minute
+		`go_goroutines `,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected metrics to contain %q", want)
+		}
+	}
+}
+
+func TestMetrics_NoGuildInfo(t *testing.T) {
+	t.Parallel()
+
+	m := metrics.New()
+	m.WatchGuildInfo(fakeGuildInfo{})
+
+	app := fiber.New()
+	app.Get("/metrics", m.Handler())
+
+	if out := scrape(t, app); strings.Contains(out, "bouncer_guild_info_age_seconds") {
+		t.Error("expected no guild info age before the guild info is discovered")
+	}
+}
@@ -0,0 +1,212 @@
+// Package oidctest provides a stand-in OpenID Connect identity provider for tests. It approves
+// every login as the configured user without showing a login page.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// ClientID is the only client ID accepted by the Issuer.
+const ClientID = "disco-bouncer"
+
+// Issuer is an identity provider served on a local address.
+type Issuer struct {
+	// URL is the issuer URL, used for discovery.
+	URL string
+
+	srv    *httptest.Server
+	key    *rsa.PrivateKey
+	signer jose.Signer
+
+	mu      sync.Mutex
+	subject string
+	email   string
+	codes   map[string]grant
+}
+
+// grant is an authorization code waiting to be exchanged for tokens.
+type grant struct {
+	subject   string
+	email     string
+	nonce     string
+	challenge string
+}
+
+// NewIssuer starts an Issuer. Close must be called when finished.
+func NewIssuer() (*Issuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test"))
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Issuer{
+		key:    key,
+		signer: signer,
+		codes:  make(map[string]grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", out.discovery)
+	mux.HandleFunc("GET /authorize", out.authorize)
+	mux.HandleFunc("POST /token", out.token)
+	mux.HandleFunc("GET /jwks", out.jwks)
+	out.srv = httptest.NewServer(mux)
+	out.URL = out.srv.URL
+
+	return out, nil
+}
+
+// SetUser sets the identity of everyone who logs in from now on. The email is always reported as
+// verified, unless it is empty.
+func (iss *Issuer) SetUser(subject, email string) {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+
+	iss.subject = subject
+	iss.email = email
+}
+
+// Close shuts down the Issuer.
+func (iss *Issuer) Close() {
+	iss.srv.Close()
+}
+
+func (iss *Issuer) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]any{
+		"issuer":                                iss.URL,
+		"authorization_endpoint":                iss.URL + "/authorize",
+		"token_endpoint":                        iss.URL + "/token",
+		"jwks_uri":                              iss.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// authorize immediately redirects back to the client with an authorization code.
+func (iss *Issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != ClientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+
+		return
+	}
+
+	code := rand.Text()
+	iss.mu.Lock()
+	iss.codes[code] = grant{
+		subject:   iss.subject,
+		email:     iss.email,
+		nonce:     q.Get("nonce"),
+		challenge: q.Get("code_challenge"),
+	}
+	iss.mu.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token exchanges an authorization code for a signed ID token, after checking the PKCE verifier.
+func (iss *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+
+		return
+	}
+
+	iss.mu.Lock()
+	g, ok := iss.codes[r.PostForm.Get("code")]
+	delete(iss.codes, r.PostForm.Get("code"))
+	iss.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid_grant"})
+
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss":   iss.URL,
+		"sub":   g.subject,
+		"aud":   ClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": g.nonce,
+	}
+	if g.email != "" {
+		claims["email"] = g.email
+		claims["email_verified"] = true
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	signed, err := iss.signer.Sign(payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	idToken, err := signed.CompactSerialize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	writeJSON(w, map[string]any{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (iss *Issuer) jwks(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:       &iss.key.PublicKey,
+		KeyID:     "test",
+		Algorithm: string(jose.RS256),
+		Use:       "sig",
+	}}})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	json.NewEncoder(w).Encode(v) //nolint:errcheck,errchkjson // The client will see a bad response.
+}
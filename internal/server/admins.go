@@ -0,0 +1,198 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cobaltspeech/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AddAdminHandlers adds the routes for managing admin accounts. They require the admins:manage
+// scope, which only owners have.
+func AddAdminHandlers(l log.Logger, app *fiber.App, table db.AdminStore) {
+	manage := RequireScope(l, api.ScopeAdminsManage)
+
+	app.Get("/api/admins", manage, ListAdmins(l, table))
+	app.Post("/api/admins", manage, CreateAdmin(l, table))
+	app.Patch("/api/admins/:username", manage, PatchAdmin(l, table))
+	app.Put("/api/admins/:username/password", manage, ResetPassword(l, table))
+	app.Delete("/api/admins/:username", manage, DeleteAdmin(l, table))
+}
+
+// minPasswordLength is the shortest password accepted for an admin.
+const minPasswordLength = 8
+
+// ListAdmins sends every admin account.
+func ListAdmins(l log.Logger, table db.AdminStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		admins, err := table.ListAdmins(c.Context())
+		if err != nil {
+			return serverError(l, c, "Database error", err)
+		}
+
+		out := make([]*api.Admin, len(admins))
+		for i, a := range admins {
+			out[i] = a.API()
+		}
+
+		return c.JSON(out)
+	}
+}
+
+// CreateAdmin creates an admin with an initial password and sends the new admin.
+func CreateAdmin(l log.Logger, table db.AdminStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input api.NewAdmin
+		err := c.BodyParser(&input)
+		if err != nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, err.Error())
+		}
+
+		if input.Username == "" {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, "Username is required")
+		}
+		_, err = api.ParseRole(string(input.Role))
+		if err != nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, err.Error())
+		}
+		if len(input.Password) < minPasswordLength {
+			return sendError(c, http.StatusBadRequest, api.CodePasswordTooShort, "Password too short")
+		}
+
+		err = table.AddAdmin(c.Context(), input.Username, input.Password, input.Role)
+		if err != nil {
+			return adminWriteError(l, c, err)
+		}
+
+		admin, err := table.GetAdmin(c.Context(), input.Username)
+		if err != nil {
+			return serverError(l, c, "Database error", err)
+		}
+
+		setAudit(c, input.Username, nil, admin.API())
+
+		return c.Status(http.StatusCreated).JSON(admin.API())
+	}
+}
+
+// PatchAdmin changes the role of an admin or disables or enables them, and sends the updated admin.
+// Owners cannot change their own account this way, so that they can't lock themselves out.
+func PatchAdmin(l log.Logger, table db.AdminStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username := c.Params("username")
+
+		var patch api.AdminPatch
+		err := c.BodyParser(&patch)
+		if err != nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, err.Error())
+		}
+
+		if patch.Role == nil && patch.Disabled == nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+				"Patch must set at least one field")
+		}
+		if username == getPrincipal(c).username {
+			return sendError(c, http.StatusConflict, api.CodeConflict,
+				"You cannot change the role or status of your own account")
+		}
+
+		if patch.Role != nil {
+			_, err = api.ParseRole(string(*patch.Role))
+			if err != nil {
+				return sendError(c, http.StatusBadRequest, api.CodeBadRequest, err.Error())
+			}
+		}
+
+		before, err := table.GetAdmin(c.Context(), username)
+		if err != nil {
+			return adminWriteError(l, c, err)
+		}
+
+		// Both fields are changed at once, so that a failure can't leave the patch half-applied.
+		admin, err := table.PatchAdmin(c.Context(), username, patch)
+		if err != nil {
+			return adminWriteError(l, c, err)
+		}
+
+		setAudit(c, username, before.API(), admin.API())
+
+		return c.JSON(admin.API())
+	}
+}
+
+// ResetPassword sets a new password for an admin, without needing the old one, and revokes all of
+// their sessions in the same transaction.
+func ResetPassword(l log.Logger, table db.AdminStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username := c.Params("username")
+
+		var input struct {
+			Password string `json:"password"`
+		}
+		err := c.BodyParser(&input)
+		if err != nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, err.Error())
+		}
+
+		if len(input.Password) < minPasswordLength {
+			return sendError(c, http.StatusBadRequest, api.CodePasswordTooShort, "Password too short")
+		}
+
+		keep := ""
+		if p := getPrincipal(c); p.username == username {
+			keep = p.session
+		}
+		err = table.ResetPassword(c.Context(), username, input.Password, keep)
+		if err != nil {
+			return adminWriteError(l, c, err)
+		}
+		setAuditSecrets(c, username, "password")
+
+		return c.SendString("Password reset successfully")
+	}
+}
+
+// DeleteAdmin removes an admin. Their sessions stop working and their API tokens are deleted.
+// Owners cannot delete their own account.
+func DeleteAdmin(l log.Logger, table db.AdminStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username := c.Params("username")
+
+		if username == getPrincipal(c).username {
+			return sendError(c, http.StatusConflict, api.CodeConflict,
+				"You cannot delete your own account")
+		}
+
+		before, err := table.GetAdmin(c.Context(), username)
+		if err != nil {
+			return adminWriteError(l, c, err)
+		}
+
+		err = table.DeleteAdmin(c.Context(), username)
+		if err != nil {
+			return adminWriteError(l, c, err)
+		}
+
+		setAudit(c, username, before.API(), nil)
+
+		return c.SendString("Admin deleted successfully")
+	}
+}
+
+// adminWriteError sends the response for an error from a change to an admin.
+func adminWriteError(l log.Logger, c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, db.ErrNoUser):
+		return sendError(c, http.StatusNotFound, api.CodeNotFound, "Admin not found")
+	case errors.Is(err, db.ErrAdminExists):
+		return sendError(c, http.StatusConflict, api.CodeConflict, "Admin already exists")
+	case errors.Is(err, bcrypt.ErrPasswordTooLong):
+		return sendError(c, http.StatusBadRequest, api.CodePasswordTooLong, "Password too long")
+	default:
+		return serverError(l, c, "Database error", err)
+	}
+}
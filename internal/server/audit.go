@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/cobaltspeech/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// AddAuditHandlers adds the routes for reading the audit trail. Events are recorded by
+// AuthMiddleware.
+func AddAuditHandlers(l log.Logger, app *fiber.App, table db.AuditStore) {
+	read := RequireScope(l, api.ScopeAuditRead)
+
+	app.Get("/api/audit", read, ListAudit(l, table))
+	app.Get("/api/audit/head", read, AuditHead(l, table))
+}
+
+// ListAudit sends the audit events selected by the query parameters, newest first.
+func ListAudit(l log.Logger, table db.AuditStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query, err := url.ParseQuery(string(c.Request().URI().QueryString()))
+		if err != nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+				fmt.Sprintf("Invalid query: %v", err))
+		}
+		f, err := api.ParseAuditFilter(query)
+		if err != nil {
+			requestLogger(l, c).Debug("msg", "invalid query parameter", "error", err)
+
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+				fmt.Sprintf("Invalid query: %v", err))
+		}
+
+		events, err := table.ListEvents(c.Context(), f)
+		if err != nil {
+			return serverError(l, c, "Database error", err)
+		}
+
+		out := make([]*api.AuditEvent, len(events))
+		for i, e := range events {
+			out[i] = e.API()
+		}
+
+		return c.JSON(out)
+	}
+}
+
+// AuditHead sends the newest event in the audit chain, so that its hash can be recorded elsewhere.
+func AuditHead(l log.Logger, table db.AuditStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		e, err := table.ChainHead(c.Context())
+		if err != nil {
+			if errors.Is(err, db.ErrNoAuditEvents) {
+				return sendError(c, http.StatusNotFound, api.CodeNotFound, "No audit events")
+			}
+
+			return serverError(l, c, "Database error", err)
+		}
+
+		return c.JSON(api.AuditHead{ID: e.ID, Time: e.CreatedAt, Hash: hex.EncodeToString(e.Hash)})
+	}
+}
+
+// auditChange is set by handlers to describe what they changed, for the audit event recorded by
+// AuthMiddleware.
+type auditChange struct {
+	target string
+	before any
+	after  any
+
+	// secrets are the names of secret fields that changed.
+	secrets []string
+}
+
+const auditKey = "audit"
+
+// setAudit records what the handler changed. before is nil if the target was created, and after is
+// nil if it was deleted. Both must marshal to JSON objects.
+func setAudit(c *fiber.Ctx, target string, before, after any) {
+	c.Locals(auditKey, &auditChange{target: target, before: before, after: after})
+}
+
+// setAuditSecrets records that the handler changed secret fields of the target, like a password.
+func setAuditSecrets(c *fiber.Ctx, target string, fields ...string) {
+	c.Locals(auditKey, &auditChange{target: target, secrets: fields})
+}
+
+// secretFields are the JSON fields whose values are never recorded in the audit trail.
+var secretFields = map[string]bool{
+	"password":      true,
+	"name_key_hash": true,
+}
+
+// recordAudit records the event if the request changed something and succeeded.
+func recordAudit(l log.Logger, c *fiber.Ctx, table db.AuditStore, p *principal, action string) {
+	if table == nil {
+		return
+	}
+	switch c.Method() {
+	case fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete:
+	default:
+		return
+	}
+	if c.Response().StatusCode() >= http.StatusBadRequest {
+		return
+	}
+
+	e := db.AuditEvent{
+		Actor:     p.username,
+		Token:     p.token,
+		Action:    action,
+		Target:    c.Params("id", c.Params("username")),
+		RequestID: requestID(c),
+	}
+	if change, ok := c.Locals(auditKey).(*auditChange); ok {
+		e.Target = change.target
+
+		var err error
+		e.Changes, err = diffFields(change.before, change.after)
+		if err != nil {
+			requestLogger(l, c).Error("msg", "failed to compare audited fields", "action", action,
+				"error", err)
+			e.Changes = make(map[string]api.FieldChange)
+		}
+		for _, name := range change.secrets {
+			e.Changes[name] = api.FieldChange{Redacted: true}
+		}
+	}
+
+	// The response has already been written, so the error is only logged.
+	_ = table.Record(c.Context(), &e)
+}
+
+// diffFields compares the JSON fields of before and after, and returns the ones that are different.
+// The values of secret fields are redacted.
+func diffFields(before, after any) (map[string]api.FieldChange, error) {
+	b, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]api.FieldChange)
+	for name, old := range b {
+		if !bytes.Equal(old, a[name]) {
+			out[name] = api.FieldChange{Before: old, After: a[name]}
+		}
+	}
+	for name, v := range a {
+		if _, ok := b[name]; !ok {
+			out[name] = api.FieldChange{After: v}
+		}
+	}
+
+	for name := range out {
+		if secretFields[name] {
+			out[name] = api.FieldChange{Redacted: true}
+		}
+	}
+
+	return out, nil
+}
+
+func jsonFields(v any) (map[string]json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var out map[string]json.RawMessage
+	err = json.Unmarshal(b, &out)
+
+	return out, err
+}
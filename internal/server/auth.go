@@ -4,34 +4,46 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/cobaltspeech/log"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/session"
-	"github.com/gofiber/storage/postgres/v2"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func AddAuthHandlers(l log.Logger, app *fiber.App, pool *pgxpool.Pool, table *db.AdminTable) {
-	sessionStore := session.New(session.Config{
-		Storage: postgres.New(postgres.Config{
-			DB:    pool,
-			Table: "sessions",
-		}),
-	})
-
-	app.Post("/login", Login(l, table, sessionStore))
-	app.Post("/logout", Logout(l, sessionStore))
-
-	app.Use("/admin", AuthMiddleware(l, sessionStore))
-	app.Post("/admin/pass", ChangePassword(l, table, sessionStore))
-
-	app.Use("/api", AuthMiddleware(l, sessionStore))
+// AddAuthHandlers adds the routes for logging in and out and for admins to manage their own
+// credentials, and requires authentication for everything under /admin and /api. If box is nil,
+// admins can't enroll in two-factor authentication. The guard limits failed logins. Changes made
+// under /admin and /api are recorded in the audit table. tokens and audit may be nil if the
+// database doesn't support them.
+func AddAuthHandlers(
+	l log.Logger, app *fiber.App, table db.AdminStore, tokens db.TokenStore, box *SecretBox,
+	guard *LoginGuard, sessions *Sessions, audit db.AuditStore,
+) {
+	app.Post("/login", Login(l, table, guard, sessions))
+	app.Post("/login/totp", LoginTOTP(l, table, box, guard, sessions))
+	app.Post("/logout", Logout(l, sessions))
+
+	app.Use("/admin", AuthMiddleware(l, sessions, table, tokens, audit))
+	app.Post("/admin/pass", RequireSession(l), ChangePassword(l, table))
+	app.Get("/admin/sessions", RequireSession(l), ListSessions(l, sessions))
+	app.Delete("/admin/sessions/:id", RequireSession(l), RevokeSession(l, sessions))
+	app.Post("/admin/totp", RequireSession(l), EnrollTOTP(l, table, box))
+	app.Post("/admin/totp/confirm", RequireSession(l), ConfirmTOTP(l, table, box))
+	app.Delete("/admin/totp", RequireSession(l), DisableTOTP(l, table, box))
+
+	app.Use("/api", AuthMiddleware(l, sessions, table, tokens, audit))
 }
 
-func Login(l log.Logger, table *db.AdminTable, sessionStore *session.Store) fiber.Handler {
+// Login checks the admin's password and logs them in, or starts the two-factor challenge if they
+// have it enabled. Failed attempts are slowed down and eventually lock the admin out.
+func Login(
+	l log.Logger, table db.AdminStore, guard *LoginGuard, sessions *Sessions,
+) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var input struct {
 			Username string `json:"username"`
@@ -40,35 +52,81 @@ func Login(l log.Logger, table *db.AdminTable, sessionStore *session.Store) fibe
 
 		err := c.BodyParser(&input)
 		if err != nil {
-			return c.Status(http.StatusBadRequest).
-				SendString(fmt.Sprintf("Failed to parse body: %v", err))
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+				fmt.Sprintf("Failed to parse body: %v", err))
+		}
+
+		throttled, err := guard.throttle(c, input.Username)
+		if throttled {
+			return err
+		}
+
+		// Check for a lockout before the password, so that a locked account doesn't reveal whether
+		// guesses are correct.
+		admin, err := table.GetAdmin(c.Context(), input.Username)
+		if err != nil && !errors.Is(err, db.ErrNoUser) {
+			return serverError(l, c, "Failed to get admin", err)
+		}
+		if admin != nil && admin.LockedAt != nil {
+			err = guard.fail(c, input.Username, db.FailureLocked)
+			if err != nil {
+				return serverError(l, c, "Failed to record login failure", err)
+			}
+
+			return sendLocked(c)
 		}
 
 		success, err := table.CheckPassword(c.Context(), input.Username, input.Password)
 		if err != nil {
 			return serverError(l, c, "Failed to check password", err)
 		}
-		if !success {
-			return c.Status(http.StatusUnauthorized).SendString("Invalid credentials")
+		if !success || admin == nil {
+			err = guard.fail(c, input.Username, db.FailureBadPassword)
+			if err != nil {
+				return serverError(l, c, "Failed to record login failure", err)
+			}
+
+			return sendError(c, http.StatusUnauthorized, api.CodeInvalidCredentials, "Invalid credentials")
 		}
 
-		sess, err := sessionStore.Get(c)
+		sess, err := sessions.store.Get(c)
 		if err != nil {
 			return serverError(l, c, "Failed to initiate session", HiddenError{err})
 		}
-		sess.Set("username", input.Username)
-		err = sess.Save()
-		if err != nil {
-			return serverError(l, c, "Failed to save session", HiddenError{err})
+
+		if admin.TOTPEnabled {
+			return startTOTPChallenge(l, c, sess, admin)
 		}
 
-		return c.SendString("Login successful")
+		return completeLogin(l, c, table, guard, sessions, sess, admin)
+	}
+}
+
+// completeLogin records the login and marks the session as logged in as the admin.
+func completeLogin(
+	l log.Logger, c *fiber.Ctx, table db.AdminStore, guard *LoginGuard, sessions *Sessions,
+	sess *session.Session, admin *db.Admin,
+) error {
+	err := guard.succeed(c, admin.Username)
+	if err != nil {
+		return serverError(l, c, "Failed to clear login failures", err)
+	}
+	err = table.RecordLogin(c.Context(), admin.Username)
+	if err != nil {
+		return serverError(l, c, "Failed to record login", err)
+	}
+
+	err = sessions.start(c, sess, admin)
+	if err != nil {
+		return serverError(l, c, "Failed to save session", HiddenError{err})
 	}
+
+	return c.SendString("Login successful")
 }
 
-func Logout(l log.Logger, sessionStore *session.Store) fiber.Handler {
+func Logout(l log.Logger, sessions *Sessions) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		sess, err := sessionStore.Get(c)
+		sess, err := sessions.store.Get(c)
 		if err != nil {
 			return serverError(l, c, "Failed to get session", err)
 		}
@@ -81,18 +139,20 @@ func Logout(l log.Logger, sessionStore *session.Store) fiber.Handler {
 			username = "unknown"
 		}
 
-		err = sess.Destroy()
+		err = sessions.end(c, sess)
 		if err != nil {
 			return serverError(l, c, "Failed to remove session", HiddenError{err})
 		}
 
-		l.Debug("msg", "logged out", "user", username)
+		requestLogger(l, c).Debug("msg", "logged out", "user", username)
 
 		return c.SendString("Logout successful")
 	}
 }
 
-func ChangePassword(l log.Logger, table *db.AdminTable, sessionStore *session.Store) fiber.Handler {
+// ChangePassword changes the password of the logged-in admin, and revokes their other sessions in
+// the same transaction.
+func ChangePassword(l log.Logger, table db.AdminStore) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// get the password
 		var input struct {
@@ -101,26 +161,15 @@ func ChangePassword(l log.Logger, table *db.AdminTable, sessionStore *session.St
 		}
 		err := c.BodyParser(&input)
 		if err != nil {
-			return c.Status(http.StatusBadRequest).SendString("Failed to parse body")
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, "Failed to parse body")
 		}
 
-		// get the username from the logged in session
-		sess, err := sessionStore.Get(c)
-		if err != nil {
-			return c.Status(http.StatusUnauthorized).SendString("Not authenticated")
-		}
-		var username string
-		switch u := sess.Get("username").(type) {
-		case string:
-			username = u
-		default:
-			return c.Status(http.StatusUnauthorized).SendString("Invalid session data")
-		}
+		username := getPrincipal(c).username
 
-		if len(input.New) < 8 {
-			l.Info("msg", "password too short", "user", username)
+		if len(input.New) < minPasswordLength {
+			requestLogger(l, c).Info("msg", "password too short", "user", username)
 
-			return c.Status(http.StatusBadRequest).SendString("Password too short")
+			return sendError(c, http.StatusBadRequest, api.CodePasswordTooShort, "Password too short")
 		}
 
 		success, err := table.CheckPassword(c.Context(), username, input.Old)
@@ -128,50 +177,223 @@ func ChangePassword(l log.Logger, table *db.AdminTable, sessionStore *session.St
 			return serverError(l, c, "Failed to check password", HiddenError{err})
 		}
 		if !success {
-			l.Info("msg", "invalid credentials", "user", username)
+			requestLogger(l, c).Info("msg", "invalid credentials", "user", username)
 
-			return c.Status(http.StatusUnauthorized).SendString("Invalid credentials")
+			return sendError(c, http.StatusUnauthorized, api.CodeInvalidCredentials, "Invalid credentials")
 		}
 
-		err = table.ChangePassword(c.Context(), username, input.New)
+		err = table.ResetPassword(c.Context(), username, input.New, getPrincipal(c).session)
 		if err != nil {
 			if errors.Is(err, bcrypt.ErrPasswordTooLong) {
-				return c.Status(http.StatusBadRequest).SendString("Password too long")
+				return sendError(c, http.StatusBadRequest, api.CodePasswordTooLong, "Password too long")
 			}
 
 			return serverError(l, c, "Failed to save password", HiddenError{err})
 		}
+		setAuditSecrets(c, username, "password")
 
 		return c.SendString("Password updated successfully")
 	}
 }
 
-func AuthMiddleware(l log.Logger, sessionStore *session.Store) fiber.Handler {
+// principal is the admin making an authenticated request.
+type principal struct {
+	username string
+	role     api.Role
+
+	// token is the name of the API token used for the request, or empty if a session was used.
+	token string
+
+	// scopes are the scopes granted to the token. Sessions have every scope allowed by the role.
+	scopes []api.Scope
+
+	// session is the ID of the session used for the request, or empty if a token was used.
+	session string
+}
+
+func (p *principal) can(scope api.Scope) bool {
+	return p.role.Allows(scope) && (p.token == "" || slices.Contains(p.scopes, scope))
+}
+
+// logInfo returns key-value pairs identifying the principal in logs.
+func (p *principal) logInfo() []any {
+	out := []any{"user", p.username, "role", p.role}
+	if p.token != "" {
+		out = append(out, "token", p.token)
+	}
+
+	return out
+}
+
+const principalKey = "principal"
+
+// getPrincipal returns the principal stored by AuthMiddleware. It must only be called from routes
+// behind AuthMiddleware.
+func getPrincipal(c *fiber.Ctx) *principal {
+	p, _ := c.Locals(principalKey).(*principal)
+	if p == nil {
+		return &principal{}
+	}
+
+	return p
+}
+
+// AuthMiddleware only allows requests with a logged-in session or a valid API token in the
+// Authorization header. API tokens are rejected if tokens is nil. The admin is looked up for every
+// request, so sessions stop working as soon as the admin is disabled or deleted, and role changes
+// take effect immediately. Successful changes are recorded in the audit table, if it is not nil.
+func AuthMiddleware(
+	l log.Logger, sessions *Sessions, admins db.AdminStore, tokens db.TokenStore,
+	audit db.AuditStore,
+) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		sess, err := sessionStore.Get(c)
-		if err != nil {
-			l.Info("msg", "request not authenticated")
+		var p *principal
+		if bearer, ok := bearerToken(c); ok {
+			if tokens == nil {
+				return sendError(c, http.StatusUnauthorized, api.CodeUnauthenticated,
+					"API tokens are not supported by this server")
+			}
 
-			return c.Status(http.StatusUnauthorized).SendString("Not authenticated")
-		}
+			tok, err := tokens.Authenticate(c.Context(), bearer)
+			if err != nil {
+				if errors.Is(err, db.ErrNoToken) {
+					return sendError(c, http.StatusUnauthorized, api.CodeUnauthenticated,
+						"Invalid or expired token")
+				}
 
-		var username string
-		switch s := sess.Get("username").(type) {
-		case string:
-			username = s
-		default:
-			l.Info("msg", "invalid session data", "user", s)
+				return serverError(l, c, "Failed to check token", err)
+			}
 
-			return c.Status(http.StatusUnauthorized).SendString("Invalid session data")
+			p = &principal{username: tok.Admin, role: tok.AdminRole, token: tok.Name}
+			for _, s := range tok.Scopes {
+				p.scopes = append(p.scopes, api.Scope(s))
+			}
+		} else {
+			var err error
+			p, err = sessionPrincipal(l, c, sessions, admins)
+			if err != nil || p == nil {
+				return err
+			}
 		}
 
-		err = c.Next()
+		c.Locals(principalKey, p)
+
+		err := c.Next()
 
 		r := c.Route()
 		endpoint := r.Method + " " + r.Path
 
-		l.Debug("msg", "authenticated access", "user", username, "endpoint", endpoint)
+		requestLogger(l, c).Debug(append(append([]any{"msg", "authenticated access"}, p.logInfo()...),
+			"endpoint", endpoint)...)
+
+		if err == nil {
+			recordAudit(l, c, audit, p, endpoint)
+		}
 
 		return err
 	}
 }
+
+// sessionPrincipal checks that the session is still valid and belongs to an enabled admin. If not,
+// it sends the error response and returns a nil principal.
+func sessionPrincipal(
+	l log.Logger, c *fiber.Ctx, sessions *Sessions, admins db.AdminStore,
+) (*principal, error) {
+	sess, err := sessions.store.Get(c)
+	if err != nil {
+		requestLogger(l, c).Info("msg", "request not authenticated")
+
+		return nil, sendError(c, http.StatusUnauthorized, api.CodeUnauthenticated, "Not authenticated")
+	}
+
+	id, ok := sess.Get("admin_id").(int)
+	if !ok {
+		// Sessions from before admins were looked up by ID must log in again.
+		requestLogger(l, c).Info("msg", "invalid session data", "user", sess.Get("username"))
+
+		return nil, sendError(c, http.StatusUnauthorized, api.CodeUnauthenticated,
+			"Invalid session data")
+	}
+
+	admin, err := admins.GetAdminByID(c.Context(), id)
+	if err != nil && !errors.Is(err, db.ErrNoUser) {
+		return nil, serverError(l, c, "Failed to get admin", err)
+	}
+	if err != nil || admin.Disabled {
+		requestLogger(l, c).Info("msg", "session for deleted or disabled admin",
+			"user", sess.Get("username"))
+
+		err = sessions.end(c, sess)
+		if err != nil {
+			return nil, serverError(l, c, "Failed to remove session", HiddenError{err})
+		}
+
+		return nil, sendError(c, http.StatusUnauthorized, api.CodeUnauthenticated,
+			"Account deleted or disabled")
+	}
+
+	ok, err = sessions.touch(c, sess, admin.ID)
+	if err != nil {
+		return nil, serverError(l, c, "Failed to check session", err)
+	}
+	if !ok {
+		requestLogger(l, c).Info("msg", "session expired or revoked", "user", admin.Username)
+
+		err = sessions.end(c, sess)
+		if err != nil {
+			return nil, serverError(l, c, "Failed to remove session", HiddenError{err})
+		}
+
+		return nil, sendError(c, http.StatusUnauthorized, api.CodeUnauthenticated,
+			"Session expired or revoked")
+	}
+
+	return &principal{username: admin.Username, role: admin.Role, session: sess.ID()}, nil
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header, if present.
+func bearerToken(c *fiber.Ctx) (string, bool) {
+	scheme, token, ok := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	return strings.TrimSpace(token), true
+}
+
+// RequireScope only allows requests from principals granted the scope, either by their role or by
+// their role and API token together. It must be used on routes behind AuthMiddleware.
+func RequireScope(l log.Logger, scope api.Scope) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p := getPrincipal(c)
+		if p.can(scope) {
+			return c.Next()
+		}
+
+		requestLogger(l, c).Info(append(append([]any{"msg", "permission denied"}, p.logInfo()...),
+			"scope", scope)...)
+
+		msg := fmt.Sprintf("The %s role does not allow %s", p.role, scope)
+		if p.role.Allows(scope) {
+			msg = fmt.Sprintf("This API token does not have the %s scope", scope)
+		}
+
+		return sendError(c, http.StatusForbidden, api.CodeForbidden, msg)
+	}
+}
+
+// RequireSession only allows requests made with a logged-in session, not with an API token. It must
+// be used on routes behind AuthMiddleware.
+func RequireSession(l log.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p := getPrincipal(c)
+		if p.token == "" {
+			return c.Next()
+		}
+
+		requestLogger(l, c).Info(append([]any{"msg", "session required"}, p.logInfo()...)...)
+
+		return sendError(c, http.StatusForbidden, api.CodeForbidden,
+			"This request cannot be made with an API token")
+	}
+}
@@ -0,0 +1,108 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cobaltspeech/log/pkg/testinglog"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"github.com/kylrth/disco-bouncer/pkg/client"
+)
+
+func TestAuthFlows(t *testing.T) { //nolint:cyclop // tests each flow in turn
+	t.Parallel()
+
+	l := testinglog.NewConvenientLogger(t, testinglog.WithFieldIgnoreFunc(ignoreIDs))
+	t.Cleanup(l.Done)
+
+	ctx := context.Background()
+
+	b, baseURL := setupMemoryServer(t, l)
+
+	owner, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	err = owner.Admin.Login(ctx, testUser, "wrong password")
+	if !errors.Is(err, client.ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials with wrong password, got %v", err)
+	}
+	err = owner.Admin.Login(ctx, "nobody", testPass)
+	if !errors.Is(err, client.ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials for missing admin, got %v", err)
+	}
+	err = owner.Admin.Login(ctx, testUser, testPass)
+	if err != nil {
+		t.Fatalf("failed to login: %v", err)
+	}
+
+	// uploaders can't manage admins
+	_, err = owner.Admins.Create(ctx, "ta", testPass, api.RoleUploader)
+	if err != nil {
+		t.Fatalf("failed to create admin: %v", err)
+	}
+	c, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	err = c.Admin.Login(ctx, "ta", testPass)
+	if err != nil {
+		t.Fatalf("failed to login as uploader: %v", err)
+	}
+	_, err = c.Admins.List(ctx)
+	if !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected ErrForbidden listing admins as uploader, got %v", err)
+	}
+
+	// changing the password needs the old one, and logs out the other sessions
+	err = owner.Admin.ChangePassword(ctx, "wrong password", "newPass1")
+	if !errors.Is(err, client.ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials changing password, got %v", err)
+	}
+	other, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	err = other.Admin.Login(ctx, testUser, testPass)
+	if err != nil {
+		t.Fatalf("failed to login again: %v", err)
+	}
+	err = owner.Admin.ChangePassword(ctx, testPass, "newPass1")
+	if err != nil {
+		t.Fatalf("failed to change password: %v", err)
+	}
+	_, err = other.Users.GetAllUsers(ctx)
+	if !errors.Is(err, client.ErrNotLoggedIn) {
+		t.Errorf("expected ErrNotLoggedIn after password change, got %v", err)
+	}
+
+	err = owner.Admin.Logout(ctx)
+	if err != nil {
+		t.Fatalf("failed to logout: %v", err)
+	}
+	_, err = owner.Users.GetAllUsers(ctx)
+	if !errors.Is(err, client.ErrNotLoggedIn) {
+		t.Errorf("expected ErrNotLoggedIn after logout, got %v", err)
+	}
+
+	// the server is set up to lock admins out after three failures
+	for range 3 {
+		err = c.Admin.Login(ctx, "ta", "wrong password")
+		if !errors.Is(err, client.ErrInvalidCredentials) {
+			t.Errorf("expected ErrInvalidCredentials, got %v", err)
+		}
+	}
+	err = c.Admin.Login(ctx, "ta", testPass)
+	if !errors.Is(err, client.ErrAccountLocked) {
+		t.Errorf("expected ErrAccountLocked with correct password, got %v", err)
+	}
+	err = b.Logins.Unlock(ctx, "ta")
+	if err != nil {
+		t.Fatalf("failed to unlock admin: %v", err)
+	}
+	err = c.Admin.Login(ctx, "ta", testPass)
+	if err != nil {
+		t.Errorf("failed to login after unlock: %v", err)
+	}
+}
@@ -1,50 +1,268 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 
 	"github.com/cobaltspeech/log"
 	"github.com/gofiber/fiber/v2"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 	"github.com/kylrth/disco-bouncer/pkg/bouncerbot"
+	"golang.org/x/oauth2"
 )
 
 func AddDiscordHandlers(l log.Logger, app *fiber.App, dg *bouncerbot.Bot) {
-	app.Post("/api/discord/migrate", MigrateUser(l, dg))
-}
-
-// Migration defines a user that needs to be assigned a cohort role. The name should match the
-// display name of a current Discord user on the server.
-type Migration struct {
-	Name string `json:"name"`
-	Year string `json:"role"`
+	app.Post("/api/discord/migrate", RequireScope(l, api.ScopeDiscordMigrate), MigrateUser(l, dg))
 }
 
 // Migrator is something that can migrate a user to the new cohort by name.
 type Migrator interface {
-	Migrate(name, year string) error
+	Migrate(ctx context.Context, name, year string) error
 }
 
 func MigrateUser(l log.Logger, dg *bouncerbot.Bot) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var migration Migration
+		var migration api.Migration
 		err := c.BodyParser(&migration)
 		if err != nil {
-			return c.Status(http.StatusBadRequest).SendString(err.Error())
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, err.Error())
 		}
 
-		err = dg.Migrate(migration.Name, migration.Year)
+		err = dg.Migrate(c.Context(), migration.Name, migration.Year)
 		if err != nil {
 			if errors.Is(err, bouncerbot.ErrNoUser) {
-				return c.Status(http.StatusNotFound).SendString("User not found")
+				return sendError(c, http.StatusNotFound, api.CodeNotFound, "User not found")
 			}
 			if errors.Is(err, bouncerbot.ErrUnknownYear) {
-				return c.Status(http.StatusBadRequest).SendString("Cohort year not found")
+				return sendError(c, http.StatusBadRequest, api.CodeUnknownYear, "Cohort year not found")
 			}
 
 			return serverError(l, c, "Discord error", err)
 		}
 
+		setAudit(c, migration.Name, nil, &migration)
+
 		return nil
 	}
 }
+
+// DiscordLoginConfig configures logging in to the admin API with a Discord account.
+type DiscordLoginConfig struct {
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is the URL of /login/discord/callback on this server, as registered with the
+	// Discord application.
+	RedirectURL string
+
+	// Roles maps the names of guild roles to the admin role they grant. A member holding several
+	// of them gets the most powerful.
+	Roles map[string]api.Role
+
+	// Endpoint and APIURL point to Discord unless they are set.
+	Endpoint oauth2.Endpoint
+	APIURL   string
+}
+
+// DiscordEndpoint is the OAuth2 endpoint of Discord.
+var DiscordEndpoint = oauth2.Endpoint{
+	AuthURL:   "https://discord.com/oauth2/authorize",
+	TokenURL:  "https://discord.com/api/oauth2/token",
+	AuthStyle: oauth2.AuthStyleInParams,
+}
+
+// RoleChecker looks up the names of the guild roles held by a Discord user. bouncerbot.ErrNoUser
+// is returned if the user is not a member of the guild.
+type RoleChecker interface {
+	MemberRoleNames(userID string) ([]string, error)
+}
+
+// DiscordLogin logs in admins who hold one of the configured roles in the guild.
+type DiscordLogin struct {
+	oauth   oauth2.Config
+	apiURL  string
+	roles   map[string]api.Role
+	checker RoleChecker
+}
+
+// NewDiscordLogin creates a DiscordLogin that checks guild roles with the checker, usually the bot.
+func NewDiscordLogin(cfg DiscordLoginConfig, checker RoleChecker) *DiscordLogin {
+	out := DiscordLogin{
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     cfg.Endpoint,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       []string{"identify"},
+		},
+		apiURL:  cfg.APIURL,
+		roles:   cfg.Roles,
+		checker: checker,
+	}
+	if out.oauth.Endpoint.AuthURL == "" {
+		out.oauth.Endpoint = DiscordEndpoint
+	}
+	if out.apiURL == "" {
+		out.apiURL = "https://discord.com/api"
+	}
+
+	return &out
+}
+
+// AddDiscordLoginHandlers adds the routes for logging in with Discord. The admin is linked to the
+// Discord account on the first login, and its role is updated from the guild roles on every login.
+func AddDiscordLoginHandlers(
+	l log.Logger, app *fiber.App, login *DiscordLogin, admins db.AdminStore, codes db.OIDCStore,
+	guard *LoginGuard, sessions *Sessions,
+) {
+	app.Get("/login/discord", StartDiscordLogin(l, login, sessions))
+	app.Get("/login/discord/callback",
+		DiscordCallback(l, login, admins, codes, guard, sessions))
+	app.Post("/login/discord/exchange", ExchangeLoginCode(l, admins, codes, guard, sessions))
+}
+
+// StartDiscordLogin redirects to Discord to log in. Like StartOIDC, the return_to query parameter
+// hands the login to a program listening on a loopback address.
+func StartDiscordLogin(l log.Logger, login *DiscordLogin, sessions *Sessions) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return redirectToProvider(l, c, sessions, func(p *pendingLogin) string {
+			return login.oauth.AuthCodeURL(p.state)
+		})
+	}
+}
+
+// DiscordCallback finishes logging in after Discord redirects back. The Discord user must hold one
+// of the configured roles in the guild.
+func DiscordCallback(
+	l log.Logger, login *DiscordLogin, admins db.AdminStore, codes db.OIDCStore,
+	guard *LoginGuard, sessions *Sessions,
+) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sess, p, err := getPendingLogin(l, c, sessions)
+		if p == nil {
+			return err
+		}
+
+		user, err := login.user(c.Context(), c.Query("code"))
+		if err != nil {
+			requestLogger(l, c).Info("msg", "failed to verify Discord login", "error", err)
+
+			return p.fail(c, http.StatusUnauthorized, api.CodeInvalidCredentials,
+				"Failed to verify login with Discord")
+		}
+
+		names, err := login.checker.MemberRoleNames(user.ID)
+		if err != nil && !errors.Is(err, bouncerbot.ErrNoUser) {
+			return serverError(l, c, "Failed to check Discord roles", err)
+		}
+		role, ok := login.role(names)
+		if !ok {
+			requestLogger(l, c).Info("msg", "Discord user not allowed", "discordID", user.ID,
+				"username", user.Username)
+
+			return p.fail(c, http.StatusForbidden, api.CodeForbidden,
+				"Your Discord account does not have an admin role in the server")
+		}
+
+		admin, err := discordAdmin(c.Context(), admins, user, role)
+		if err != nil {
+			return serverError(l, c, "Failed to get admin", err)
+		}
+
+		requestLogger(l, c).Debug("msg", "logged in with Discord", "user", admin.Username,
+			"discordID", user.ID)
+
+		return finishLogin(l, c, admins, codes, guard, sessions, sess, p, admin)
+	}
+}
+
+// discordUser is the part of the Discord user object used here.
+type discordUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// user redeems the authorization code and returns the Discord user who logged in.
+func (d *DiscordLogin) user(ctx context.Context, code string) (*discordUser, error) {
+	tok, err := d.oauth.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.apiURL+"/users/@me", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.oauth.Client(ctx, tok).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get user: %s", resp.Status)
+	}
+
+	var out discordUser
+	err = json.NewDecoder(resp.Body).Decode(&out)
+	if err != nil {
+		return nil, fmt.Errorf("decode user: %w", err)
+	}
+	if out.ID == "" {
+		return nil, errors.New("no user ID in response")
+	}
+
+	return &out, nil
+}
+
+// role returns the most powerful admin role granted by the guild roles.
+func (d *DiscordLogin) role(names []string) (api.Role, bool) {
+	roles := api.AllRoles()
+	slices.Reverse(roles)
+	for _, r := range roles {
+		for _, name := range names {
+			if d.roles[name] == r {
+				return r, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// discordAdmin returns the admin linked to the Discord user, with its role set to role. If there
+// is no linked admin, it is created with the username "discord-" followed by the Discord username,
+// or the Discord user ID if that is taken.
+func discordAdmin(
+	ctx context.Context, admins db.AdminStore, user *discordUser, role api.Role,
+) (*db.Admin, error) {
+	admin, err := admins.GetDiscordAdmin(ctx, user.ID)
+	if errors.Is(err, db.ErrNoUser) {
+		err = admins.AddDiscordAdmin(ctx, user.ID, "discord-"+user.Username, role)
+		if errors.Is(err, db.ErrAdminExists) {
+			err = admins.AddDiscordAdmin(ctx, user.ID, "discord-"+user.ID, role)
+		}
+		if err != nil && !errors.Is(err, db.ErrAdminExists) {
+			return nil, err
+		}
+
+		admin, err = admins.GetDiscordAdmin(ctx, user.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if admin.Role != role {
+		err = admins.SetRole(ctx, admin.Username, role)
+		if err != nil {
+			return nil, err
+		}
+		admin.Role = role
+	}
+
+	return admin, nil
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// BotStatuser reports the state of the Discord bot. It is implemented by *bouncerbot.Bot.
+type BotStatuser interface {
+	Status() *api.DiscordStatus
+}
+
+// Database reports the state of the database. It is implemented by *storage.Backend.
+type Database interface {
+	Ping(ctx context.Context) error
+	MigrationVersion(ctx context.Context) (version int, dirty bool, err error)
+	LatestMigration() (int, error)
+}
+
+// AddHealthHandlers adds /healthz, which succeeds whenever the server is running, and /readyz,
+// which describes whether the server is ready to use. bot is nil if the bot is disabled. Neither
+// route needs authentication.
+func AddHealthHandlers(l log.Logger, app *fiber.App, database Database, bot BotStatuser) {
+	app.Get("/healthz", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+	app.Get("/readyz", Readiness(l, database, bot))
+}
+
+// readinessTimeout limits how long /readyz waits for the database.
+const readinessTimeout = 2 * time.Second
+
+// Readiness sends the readiness of the server. The status is 503 if it is not ready.
+func Readiness(l log.Logger, database Database, bot BotStatuser) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.Context(), readinessTimeout)
+		defer cancel()
+
+		var out api.Readiness
+		out.Database = databaseStatus(ctx, requestLogger(l, c), database)
+		if bot != nil {
+			out.Discord = bot.Status()
+		}
+
+		problems := readinessProblems(&out)
+		out.Ready = len(problems) == 0
+		if !out.Ready {
+			requestLogger(l, c).Debug("msg", "server is not ready", "problems", strings.Join(problems, "; "))
+			c.Status(http.StatusServiceUnavailable)
+		}
+
+		return c.JSON(out)
+	}
+}
+
+func readinessProblems(r *api.Readiness) []string {
+	var out []string
+
+	switch {
+	case r.Database.Error != "":
+		out = append(out, "database error: "+r.Database.Error)
+	case r.Database.Dirty:
+		out = append(out, fmt.Sprintf("migration %d failed", r.Database.MigrationVersion))
+	case r.Database.MigrationVersion != r.Database.LatestMigration:
+		out = append(out, fmt.Sprintf("database is at migration %d instead of %d",
+			r.Database.MigrationVersion, r.Database.LatestMigration))
+	}
+
+	if r.Discord != nil {
+		if !r.Discord.Connected {
+			out = append(out, "Discord bot is not connected")
+		}
+		if !r.Discord.GuildInfoDiscovered {
+			out = append(out, "guild info is not discovered")
+		}
+	}
+
+	return out
+}
+
+func databaseStatus(ctx context.Context, l log.Logger, database Database) api.DatabaseStatus {
+	var out api.DatabaseStatus
+
+	latest, err := database.LatestMigration()
+	if err != nil {
+		l.Debug("msg", "failed to read migrations", "error", err)
+		out.Error = api.DatabaseError
+
+		return out
+	}
+	out.LatestMigration = latest
+
+	err = database.Ping(ctx)
+	if err != nil {
+		l.Debug("msg", "failed to ping database", "error", err)
+		out.Error = api.DatabaseUnreachable
+
+		return out
+	}
+	out.Connected = true
+
+	out.MigrationVersion, out.Dirty, err = database.MigrationVersion(ctx)
+	if err != nil {
+		l.Debug("msg", "failed to get migration version", "error", err)
+		out.Error = api.DatabaseError
+	}
+
+	return out
+}
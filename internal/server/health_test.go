@@ -0,0 +1,149 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cobaltspeech/log/pkg/testinglog"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/go-cmp/cmp"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/server"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+type fakeBot api.DiscordStatus
+
+func (f *fakeBot) Status() *api.DiscordStatus {
+	s := api.DiscordStatus(*f)
+
+	return &s
+}
+
+type fakeDatabase struct {
+	pingErr error
+	version int
+	latest  int
+}
+
+func (f *fakeDatabase) Ping(context.Context) error {
+	return f.pingErr
+}
+
+func (f *fakeDatabase) MigrationVersion(context.Context) (int, bool, error) {
+	return f.version, false, nil
+}
+
+func (f *fakeDatabase) LatestMigration() (int, error) {
+	return f.latest, nil
+}
+
+func TestReadiness(t *testing.T) {
+	t.Parallel()
+
+	latest, err := db.LatestMigration()
+	if err != nil {
+		t.Fatalf("error from LatestMigration: %v", err)
+	}
+
+	type testCase struct {
+		pingErr error
+		version int
+		bot     *fakeBot
+		want    api.Readiness
+	}
+	tests := map[string]testCase{
+		"ready without bot": {
+			version: latest,
+			want: api.Readiness{
+				Ready: true,
+				Database: api.DatabaseStatus{
+					Connected: true, MigrationVersion: latest, LatestMigration: latest,
+				},
+			},
+		},
+		"database down": {
+			pingErr: errors.New("connection refused"),
+			want: api.Readiness{
+				Database: api.DatabaseStatus{
+					Error: api.DatabaseUnreachable, LatestMigration: latest,
+				},
+			},
+		},
+		"old migration": {
+			version: latest - 1,
+			want: api.Readiness{
+				Database: api.DatabaseStatus{
+					Connected: true, MigrationVersion: latest - 1, LatestMigration: latest,
+				},
+			},
+		},
+		"guild not discovered": {
+			version: latest,
+			bot:     &fakeBot{Connected: true},
+			want: api.Readiness{
+				Database: api.DatabaseStatus{
+					Connected: true, MigrationVersion: latest, LatestMigration: latest,
+				},
+				Discord: &api.DiscordStatus{Connected: true},
+			},
+		},
+		"ready with missing roles": {
+			version: latest,
+			bot: &fakeBot{
+				Connected: true, GuildInfoDiscovered: true, MissingRoles: []string{"TA"},
+			},
+			want: api.Readiness{
+				Ready: true,
+				Database: api.DatabaseStatus{
+					Connected: true, MigrationVersion: latest, LatestMigration: latest,
+				},
+				Discord: &api.DiscordStatus{
+					Connected: true, GuildInfoDiscovered: true, MissingRoles: []string{"TA"},
+				},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			database := &fakeDatabase{pingErr: tc.pingErr, version: tc.version, latest: latest}
+
+			app := fiber.New()
+			var bot server.BotStatuser
+			if tc.bot != nil {
+				bot = tc.bot
+			}
+			server.AddHealthHandlers(testinglog.NewConvenientLogger(t), app, database, bot)
+
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if err != nil {
+				t.Fatalf("error from /readyz: %v", err)
+			}
+			defer resp.Body.Close()
+
+			wantStatus := http.StatusOK
+			if !tc.want.Ready {
+				wantStatus = http.StatusServiceUnavailable
+			}
+			if resp.StatusCode != wantStatus {
+				t.Errorf("expected status %d, got %d", wantStatus, resp.StatusCode)
+			}
+
+			var got api.Readiness
+			err = json.NewDecoder(resp.Body).Decode(&got)
+			if err != nil {
+				t.Fatalf("failed to decode readiness: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Error("unexpected readiness (-want +got):\n" + diff)
+			}
+		})
+	}
+}
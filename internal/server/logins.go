@@ -0,0 +1,115 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// LoginLimits controls how the server slows down and locks out repeated failed logins.
+type LoginLimits struct {
+	// MaxFailures is the number of failed logins in a row after which the admin is locked out until
+	// the lockout is cleared with `bouncer admin unlock`. Zero means admins are never locked out.
+	MaxFailures int
+
+	// BaseDelay is how long a username or IP address must wait to try again after one failure. The
+	// delay doubles with each further failure, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultLoginLimits returns the limits used by the server unless configured otherwise.
+func DefaultLoginLimits() LoginLimits {
+	return LoginLimits{
+		MaxFailures: 10,
+		BaseDelay:   time.Second,
+		MaxDelay:    15 * time.Minute,
+	}
+}
+
+// Delay returns how long to wait after the given number of failures in a row before allowing
+// another attempt.
+func (lim LoginLimits) Delay(failures int) time.Duration {
+	if failures <= 0 || lim.BaseDelay <= 0 {
+		return 0
+	}
+
+	d := float64(lim.BaseDelay) * math.Pow(2, float64(failures-1))
+	if d > float64(lim.MaxDelay) {
+		return lim.MaxDelay
+	}
+
+	return time.Duration(d)
+}
+
+// LoginGuard applies LoginLimits to login attempts, using a LoginStore to count failures.
+type LoginGuard struct {
+	logger log.Logger
+	table  db.LoginStore
+	limits LoginLimits
+}
+
+// NewLoginGuard creates a LoginGuard that stores failures in the table.
+func NewLoginGuard(l log.Logger, table db.LoginStore, limits LoginLimits) *LoginGuard {
+	return &LoginGuard{
+		logger: l,
+		table:  table,
+		limits: limits,
+	}
+}
+
+// throttle sends a 429 response and returns true if the username or the client's IP address must
+// wait longer before trying again.
+func (g *LoginGuard) throttle(c *fiber.Ctx, user string) (bool, error) {
+	byUser, byIP, err := g.table.GetFailures(c.Context(), user, c.IP())
+	if err != nil {
+		return true, serverError(g.logger, c, "Failed to check login failures", err)
+	}
+
+	now := time.Now()
+	wait := max(
+		byUser.LastFailure.Add(g.limits.Delay(byUser.Failures)).Sub(now),
+		byIP.LastFailure.Add(g.limits.Delay(byIP.Failures)).Sub(now),
+	)
+	if wait <= 0 {
+		return false, nil
+	}
+
+	requestLogger(g.logger, c).Info("msg", "login throttled", "user", user, "ip", c.IP(), "wait", wait)
+
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+
+	return true, sendError(c, http.StatusTooManyRequests, api.CodeTooManyRequests,
+		"Too many failed logins; try again in "+wait.Round(time.Second).String())
+}
+
+// fail records a failed login and locks the admin out if there have been too many.
+func (g *LoginGuard) fail(c *fiber.Ctx, user, reason string) error {
+	failures, err := g.table.RecordFailure(c.Context(), user, c.IP(), reason)
+	if err != nil {
+		return err
+	}
+
+	if g.limits.MaxFailures > 0 && failures >= g.limits.MaxFailures {
+		return g.table.Lock(c.Context(), user)
+	}
+
+	return nil
+}
+
+// succeed resets the failure counter for the username.
+func (g *LoginGuard) succeed(c *fiber.Ctx, user string) error {
+	return g.table.ClearFailures(c.Context(), user)
+}
+
+// sendLocked sends the response for a login to a locked account.
+func sendLocked(c *fiber.Ctx) error {
+	return sendError(c, http.StatusLocked, api.CodeAccountLocked,
+		"Account locked after too many failed logins; ask an owner to unlock it")
+}
@@ -0,0 +1,34 @@
+package server_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kylrth/disco-bouncer/internal/server"
+)
+
+func TestLoginLimits_Delay(t *testing.T) {
+	t.Parallel()
+
+	limits := server.LoginLimits{BaseDelay: time.Second, MaxDelay: time.Minute}
+
+	tests := map[int]time.Duration{
+		0:  0,
+		1:  time.Second,
+		2:  2 * time.Second,
+		4:  8 * time.Second,
+		6:  32 * time.Second,
+		7:  time.Minute,
+		40: time.Minute,
+	}
+	for failures, want := range tests {
+		got := limits.Delay(failures)
+		if got != want {
+			t.Errorf("Delay(%d): expected %v, got %v", failures, want, got)
+		}
+	}
+
+	if d := (server.LoginLimits{MaxFailures: 3}).Delay(5); d != 0 {
+		t.Errorf("expected no delay without BaseDelay, got %v", d)
+	}
+}
@@ -0,0 +1,404 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"golang.org/x/oauth2"
+)
+
+// OIDCConfig configures single sign-on with an OpenID Connect identity provider.
+type OIDCConfig struct {
+	// Issuer is the URL of the identity provider, used to discover its endpoints.
+	Issuer string
+
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is the URL of /login/oidc/callback on this server, as registered with the
+	// identity provider.
+	RedirectURL string
+}
+
+// OIDCProvider logs admins in with an OpenID Connect identity provider.
+type OIDCProvider struct {
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCProvider discovers the endpoints of the identity provider.
+func NewOIDCProvider(ctx context.Context, cfg OIDCConfig) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discover OIDC provider: %w", err)
+	}
+
+	return &OIDCProvider{
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       []string{oidc.ScopeOpenID, "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+// AddOIDCHandlers adds the routes for logging in with single sign-on. Only identities on the
+// allowlist in the OIDCTable can log in.
+func AddOIDCHandlers(
+	l log.Logger, app *fiber.App, provider *OIDCProvider, admins db.AdminStore,
+	identities db.OIDCStore, guard *LoginGuard, sessions *Sessions,
+) {
+	app.Get("/login/oidc", StartOIDC(l, provider, sessions))
+	app.Get("/login/oidc/callback", OIDCCallback(l, provider, admins, identities, guard, sessions))
+	app.Post("/login/oidc/exchange", ExchangeLoginCode(l, admins, identities, guard, sessions))
+}
+
+const (
+	// ssoTimeout is how long the admin has to log in with the identity provider.
+	ssoTimeout = 10 * time.Minute
+
+	// loginCodeTTL is how long the client has to exchange a login code for a session.
+	loginCodeTTL = time.Minute
+)
+
+// StartOIDC redirects to the identity provider. If the return_to query parameter is set to a
+// loopback URL, the login is handed to the program listening there instead of logging in the
+// browser.
+func StartOIDC(l log.Logger, provider *OIDCProvider, sessions *Sessions) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return redirectToProvider(l, c, sessions, func(p *pendingLogin) string {
+			return provider.oauth.AuthCodeURL(p.state,
+				oidc.Nonce(p.nonce), oauth2.S256ChallengeOption(p.verifier))
+		})
+	}
+}
+
+// OIDCCallback finishes logging in after the identity provider redirects back. The ID token must
+// match an identity on the allowlist. If the admin does not exist yet, it is created.
+func OIDCCallback(
+	l log.Logger, provider *OIDCProvider, admins db.AdminStore, identities db.OIDCStore,
+	guard *LoginGuard, sessions *Sessions,
+) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sess, p, err := getPendingLogin(l, c, sessions)
+		if p == nil {
+			return err
+		}
+
+		claims, err := provider.exchange(c.Context(), c.Query("code"), p.verifier, p.nonce)
+		if err != nil {
+			requestLogger(l, c).Info("msg", "failed to verify OIDC login", "error", err)
+
+			return p.fail(c, http.StatusUnauthorized, api.CodeInvalidCredentials,
+				"Failed to verify login with identity provider")
+		}
+
+		email := ""
+		if claims.EmailVerified {
+			email = claims.Email
+		}
+		identity, err := identities.MatchIdentity(c.Context(), claims.Subject, email)
+		if err != nil {
+			if errors.Is(err, db.ErrNoIdentity) {
+				requestLogger(l, c).Info("msg", "OIDC identity not allowed", "subject", claims.Subject,
+					"email", email)
+
+				return p.fail(c, http.StatusForbidden, api.CodeForbidden,
+					"This identity is not allowed to log in")
+			}
+
+			return serverError(l, c, "Database error", err)
+		}
+
+		admin, err := oidcAdmin(c.Context(), admins, identity)
+		if err != nil {
+			return serverError(l, c, "Failed to get admin", err)
+		}
+
+		requestLogger(l, c).Debug("msg", "logged in with OIDC", "user", admin.Username,
+			"subject", claims.Subject)
+
+		return finishLogin(l, c, admins, identities, guard, sessions, sess, p, admin)
+	}
+}
+
+// ExchangeLoginCode logs in with a login code created for a loopback client after a single sign-on
+// login.
+func ExchangeLoginCode(
+	l log.Logger, admins db.AdminStore, identities db.OIDCStore, guard *LoginGuard,
+	sessions *Sessions,
+) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input struct {
+			Code string `json:"code"`
+		}
+		err := c.BodyParser(&input)
+		if err != nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, err.Error())
+		}
+
+		id, err := identities.UseLoginCode(c.Context(), input.Code)
+		if err != nil {
+			if errors.Is(err, db.ErrNoLoginCode) {
+				return sendError(c, http.StatusUnauthorized, api.CodeInvalidCredentials,
+					"Invalid or expired login code")
+			}
+
+			return serverError(l, c, "Database error", err)
+		}
+
+		admin, err := admins.GetAdminByID(c.Context(), id)
+		if err != nil {
+			return serverError(l, c, "Failed to get admin", err)
+		}
+		if admin.Disabled {
+			return sendError(c, http.StatusUnauthorized, api.CodeUnauthenticated, "Account disabled")
+		}
+
+		sess, err := sessions.store.Get(c)
+		if err != nil {
+			return serverError(l, c, "Failed to initiate session", HiddenError{err})
+		}
+
+		return completeLogin(l, c, admins, guard, sessions, sess, admin)
+	}
+}
+
+// idClaims are the claims used from the ID token.
+type idClaims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// exchange redeems the authorization code and verifies the ID token.
+func (p *OIDCProvider) exchange(
+	ctx context.Context, code, verifier, nonce string,
+) (*idClaims, error) {
+	tok, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+
+	raw, ok := tok.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("no ID token in response")
+	}
+	idToken, err := p.verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("verify ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	var claims idClaims
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return nil, fmt.Errorf("parse ID token claims: %w", err)
+	}
+
+	return &claims, nil
+}
+
+// oidcAdmin returns the admin for the identity, creating it if it does not exist. Admins created
+// this way get a random password, so they can only log in with single sign-on until an owner
+// resets it.
+func oidcAdmin(
+	ctx context.Context, admins db.AdminStore, identity *db.Identity,
+) (*db.Admin, error) {
+	admin, err := admins.GetAdmin(ctx, identity.Username)
+	if !errors.Is(err, db.ErrNoUser) {
+		return admin, err
+	}
+
+	pass, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	err = admins.AddAdmin(ctx, identity.Username, pass, identity.Role)
+	if err != nil && !errors.Is(err, db.ErrAdminExists) {
+		return nil, err
+	}
+
+	return admins.GetAdmin(ctx, identity.Username)
+}
+
+// pendingLogin is a single sign-on login waiting for the identity provider to redirect back.
+type pendingLogin struct {
+	state    string
+	nonce    string
+	verifier string
+
+	// returnTo is the loopback URL to hand the login to, or empty to log in the browser.
+	returnTo string
+}
+
+// redirectToProvider starts a pendingLogin in the session and redirects to the URL returned by
+// authURL.
+func redirectToProvider(
+	l log.Logger, c *fiber.Ctx, sessions *Sessions, authURL func(*pendingLogin) string,
+) error {
+	p := pendingLogin{
+		returnTo: c.Query("return_to"),
+		verifier: oauth2.GenerateVerifier(),
+	}
+	if p.returnTo != "" && !isLoopbackURL(p.returnTo) {
+		return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+			"return_to must be an http URL on a loopback address")
+	}
+
+	var err error
+	p.state, err = randomString()
+	if err != nil {
+		return serverError(l, c, "Failed to generate state", HiddenError{err})
+	}
+	p.nonce, err = randomString()
+	if err != nil {
+		return serverError(l, c, "Failed to generate nonce", HiddenError{err})
+	}
+
+	sess, err := sessions.store.Get(c)
+	if err != nil {
+		return serverError(l, c, "Failed to initiate session", HiddenError{err})
+	}
+	sess.Set("sso_state", p.state)
+	sess.Set("sso_nonce", p.nonce)
+	sess.Set("sso_verifier", p.verifier)
+	sess.Set("sso_return_to", p.returnTo)
+	sess.Set("sso_until", time.Now().Add(ssoTimeout).Unix())
+	err = sess.Save()
+	if err != nil {
+		return serverError(l, c, "Failed to save session", HiddenError{err})
+	}
+
+	return c.Redirect(authURL(&p))
+}
+
+// getPendingLogin returns the pendingLogin matching the state in the callback from the identity
+// provider. If there is none, or the identity provider returned an error, the response has
+// already been sent and the pendingLogin is nil.
+func getPendingLogin(
+	l log.Logger, c *fiber.Ctx, sessions *Sessions,
+) (*session.Session, *pendingLogin, error) {
+	sess, err := sessions.store.Get(c)
+	if err != nil {
+		return nil, nil, serverError(l, c, "Failed to get session", HiddenError{err})
+	}
+
+	var p pendingLogin
+	p.state, _ = sess.Get("sso_state").(string)
+	p.nonce, _ = sess.Get("sso_nonce").(string)
+	p.verifier, _ = sess.Get("sso_verifier").(string)
+	p.returnTo, _ = sess.Get("sso_return_to").(string)
+	until, _ := sess.Get("sso_until").(int64)
+	if p.state == "" || c.Query("state") != p.state || time.Now().Unix() > until {
+		return nil, nil, sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+			"No pending single sign-on login; start again")
+	}
+
+	if e := c.Query("error"); e != "" {
+		requestLogger(l, c).Info("msg", "identity provider returned error", "error", e,
+			"description", c.Query("error_description"))
+
+		return nil, nil, p.fail(c, http.StatusUnauthorized, api.CodeInvalidCredentials,
+			"Identity provider returned "+e)
+	}
+
+	return sess, &p, nil
+}
+
+// fail sends the error to the loopback URL if there is one, or else to the browser.
+func (p *pendingLogin) fail(c *fiber.Ctx, status int, code api.Code, msg string) error {
+	if p.returnTo == "" {
+		return sendError(c, status, code, msg)
+	}
+
+	return c.Redirect(withQuery(p.returnTo, url.Values{
+		"error": {string(code)}, "message": {msg},
+	}))
+}
+
+// finishLogin logs in the browser as the admin, or hands the login to the loopback URL with a
+// login code.
+func finishLogin(
+	l log.Logger, c *fiber.Ctx, admins db.AdminStore, codes db.OIDCStore, guard *LoginGuard,
+	sessions *Sessions, sess *session.Session, p *pendingLogin, admin *db.Admin,
+) error {
+	if admin.Disabled {
+		return p.fail(c, http.StatusUnauthorized, api.CodeUnauthenticated, "Account disabled")
+	}
+
+	if p.returnTo == "" {
+		return completeLogin(l, c, admins, guard, sessions, sess, admin)
+	}
+
+	err := sess.Destroy()
+	if err != nil {
+		return serverError(l, c, "Failed to remove session", HiddenError{err})
+	}
+	code, err := codes.CreateLoginCode(c.Context(), admin.ID, loginCodeTTL)
+	if err != nil {
+		return serverError(l, c, "Failed to create login code", err)
+	}
+
+	return c.Redirect(withQuery(p.returnTo, url.Values{"code": {code}}))
+}
+
+// isLoopbackURL returns true if u is an http URL on a loopback address, where only programs on the
+// same machine can receive the login.
+func isLoopbackURL(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Scheme != "http" {
+		return false
+	}
+	if parsed.Hostname() == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(parsed.Hostname())
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// withQuery adds the query parameters to the URL.
+func withQuery(u string, values url.Values) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+
+	q := parsed.Query()
+	for k, v := range values {
+		q[k] = v
+	}
+	parsed.RawQuery = q.Encode()
+
+	return parsed.String()
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -3,139 +3,171 @@ package server_test
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/cenkalti/backoff/v4"
 	"github.com/cobaltspeech/log"
 	"github.com/cobaltspeech/log/pkg/testinglog"
 	"github.com/gofiber/fiber/v2"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/db/sqlite"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+	"github.com/kylrth/disco-bouncer/internal/oidctest"
 	"github.com/kylrth/disco-bouncer/internal/server"
+	"github.com/kylrth/disco-bouncer/internal/storage"
+	"github.com/kylrth/disco-bouncer/internal/storagetest"
+	"github.com/kylrth/disco-bouncer/internal/totp"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 	"github.com/kylrth/disco-bouncer/pkg/bouncerbot"
 	"github.com/kylrth/disco-bouncer/pkg/client"
 	"github.com/kylrth/disco-bouncer/pkg/encrypt"
-	"github.com/ory/dockertest/v3"
-	"github.com/ory/dockertest/v3/docker"
+	"golang.org/x/oauth2"
 )
 
 func TestMain(m *testing.M) {
 	flag.Parse() // must be run before calling testing.Short()
 	if testing.Short() {
-		fmt.Println("skipping integration tests")
+		// Only the tests against the in-memory backend run, so there's nothing to set up.
+		os.Exit(m.Run())
+	}
+
+	done := func() {}
+	var err error
+	if slices.Contains(backends(), storage.Postgres) {
+		done, err = setupDBPool()
+		if err != nil {
+			fmt.Println("failed to set up database pool:", err)
+			done()
+			os.Exit(1)
+		}
+	}
 
-		return
+	removeSQLite, err := setupSQLite()
+	if err != nil {
+		fmt.Println("failed to set up SQLite database:", err)
+		removeSQLite()
+		done()
+		os.Exit(1)
 	}
 
-	done, err := setupDBPool()
+	issuer, err = oidctest.NewIssuer()
 	if err != nil {
-		fmt.Println("failed to set up database pool:", err)
+		fmt.Println("failed to start OIDC issuer:", err)
+		removeSQLite()
 		done()
 		os.Exit(1)
 	}
 
+	discord = newFakeDiscord()
+
 	code := m.Run()
 
+	discord.Close()
+	issuer.Close()
+	removeSQLite()
 	done() // This can't be deferred because os.Exit won't run deferred code.
 
 	os.Exit(code)
 }
 
-var dbPool *pgxpool.Pool
+var backendsFlag = flag.String("backends", storage.Postgres+","+storage.SQLite,
+	"comma-separated list of the databases to run the integration tests against")
 
-func setupDBPool() (done func(), err error) {
-	done = func() {}
-	// connect to Docker
-	pool, err := dockertest.NewPool("")
-	if err != nil {
-		return done, fmt.Errorf("could not construct docker pool: %w", err)
-	}
-	err = pool.Client.Ping()
-	if err != nil {
-		return done, fmt.Errorf("could not connect to docker: %w", err)
-	}
+func backends() []string {
+	return strings.Split(*backendsFlag, ",")
+}
 
-	const pgUser = "testuser"
-	const pgPass = "testpass"
-	const hardTimeout = 120 // seconds until hard shutdown
+var (
+	dbPool   *pgxpool.Pool
+	sqliteDB *sql.DB
+	issuer   *oidctest.Issuer
+	discord  *fakeDiscord
+)
 
-	// start Postgres container
-	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
-		Repository: "postgres",
-		Tag:        "18",
-		Env: []string{
-			"POSTGRES_USER=" + pgUser,
-			"POSTGRES_PASSWORD=" + pgPass,
-		},
-	}, func(config *docker.HostConfig) {
-		config.AutoRemove = true
-		config.RestartPolicy = docker.NeverRestart()
-	})
+func setupSQLite() (done func(), err error) {
+	done = func() {}
+	dir, err := os.MkdirTemp("", "bouncer")
 	if err != nil {
-		return done, fmt.Errorf("could not start postgres instance: %w", err)
+		return done, fmt.Errorf("could not create temporary directory: %w", err)
 	}
 	done = func() {
-		finalErr := pool.Purge(resource)
+		finalErr := os.RemoveAll(dir)
 		if finalErr != nil {
-			fmt.Println("could not purge postgres instance:", finalErr)
+			fmt.Println("could not remove SQLite database:", finalErr)
 		}
 	}
-	err = resource.Expire(hardTimeout)
-	if err != nil {
-		return done, fmt.Errorf("could not set expiration on postgres instance: %w", err)
-	}
-
-	// connect to Postgres
-	pool.MaxWait = hardTimeout * time.Second
-	pgURI := fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable",
-		pgUser, pgPass, resource.GetHostPort("5432/tcp"), pgUser)
-	err = pool.Retry(func() error {
-		// apply migrations to set up tables
-		newErr := db.ApplyMigrations(pgURI)
-		if newErr != nil && !isStartupErr(newErr) {
-			return backoff.Permanent(newErr)
-		}
 
-		return newErr
-	})
+	dbURL := "sqlite://" + filepath.Join(dir, "bouncer.db")
+	err = storage.Migrate(dbURL)
 	if err != nil {
-		return done, fmt.Errorf("could not connect to postgres instance: %w", err)
+		return done, fmt.Errorf("could not apply migrations: %w", err)
 	}
-
-	// open connection
-	dbPool, err = pgxpool.New(context.Background(), pgURI)
+	sqliteDB, err = sqlite.Open(dbURL)
 	if err != nil {
-		return done, fmt.Errorf("failed to connect after running migrations: %w", err)
+		return done, fmt.Errorf("failed to open database: %w", err)
+	}
+	removeDir := done
+	done = func() {
+		sqliteDB.Close() //nolint:errcheck,gosec // the directory is removed anyway
+		removeDir()
 	}
 
 	return done, nil
 }
 
-var startupErrors = []string{
-	"connection reset by peer",
-	"the database system is starting up",
-	"connect: EOF",
+// forEachBackend runs the test against each database in -backends, in subtests named by the kind
+// of database.
+func forEachBackend(t *testing.T, test func(t *testing.T, kind string)) {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	for _, kind := range backends() {
+		t.Run(kind, func(t *testing.T) { test(t, kind) })
+	}
+}
+
+func testBackend(l log.Logger, kind string) *storage.Backend {
+	if kind == storage.SQLite {
+		return storage.SQLiteBackend(l, sqliteDB)
+	}
+
+	return storage.PostgresBackend(l, dbPool)
 }
 
-func isStartupErr(err error) bool {
-	s := err.Error()
+func setupDBPool() (done func(), err error) {
+	dbURL, done, err := storagetest.StartPostgres()
+	if err != nil {
+		return done, err
+	}
 
-	for _, search := range startupErrors {
-		if strings.Contains(s, search) {
-			return true
-		}
+	// open connection
+	dbPool, err = pgxpool.New(context.Background(), dbURL)
+	if err != nil {
+		return done, fmt.Errorf("failed to connect after running migrations: %w", err)
 	}
 
-	return false
+	return done, nil
 }
 
 const (
@@ -144,22 +176,15 @@ const (
 	addr     = ":8321"
 )
 
-func setupServer(t *testing.T, l log.Logger) (done func()) {
-	t.Helper()
-
-	aTable := db.NewAdminTable(l, dbPool)
-	uTable := db.NewUserTable(l, dbPool)
+var testTOTPKey = strings.Repeat("ab", 32)
 
-	// create a new admin user
-	err := aTable.AddAdmin(context.Background(), testUser, testPass)
-	if err != nil {
-		t.Fatalf("failed to create admin user: %v", err)
-	}
+func setupServer(t *testing.T, l log.Logger, kind string) (b *storage.Backend, done func()) {
+	t.Helper()
 
-	// start server
-	app := fiber.New()
-	server.AddAuthHandlers(l, app, dbPool, aTable)
-	server.AddCRUDHandlers(l, app, uTable)
+	b = testBackend(l, kind)
+	aTable := b.Admins
+	uTable := b.Users
+	app := newApp(t, l, b)
 
 	go func() {
 		serveErr := app.Listen(addr)
@@ -172,7 +197,7 @@ func setupServer(t *testing.T, l log.Logger) (done func()) {
 	time.Sleep(time.Second / 2)
 
 	// Define a function to shut down the server and clean up the database.
-	return func() {
+	return b, func() {
 		finalErr := app.ShutdownWithTimeout(5 * time.Second)
 		if finalErr != nil {
 			t.Errorf("error shutting down server: %v", finalErr)
@@ -197,14 +222,109 @@ func setupServer(t *testing.T, l log.Logger) (done func()) {
 	}
 }
 
+// setupMemoryServer starts a server with the in-memory backend on a free port, returning the
+// backend and the base URL of the server. The server is shut down when the test finishes.
+func setupMemoryServer(t *testing.T, l log.Logger) (b *storage.Backend, baseURL string) {
+	t.Helper()
+
+	b = storage.MemoryBackend(l)
+	app := newApp(t, l, b)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	// The listener already accepts connections, so there's no need to wait for the server.
+	go func() {
+		serveErr := app.Listener(ln)
+		if serveErr != nil {
+			l.Error("msg", "error from server listener", "error", serveErr)
+		}
+	}()
+	t.Cleanup(func() {
+		err := app.ShutdownWithTimeout(5 * time.Second)
+		if err != nil {
+			t.Errorf("error shutting down server: %v", err)
+		}
+	})
+
+	return b, "http://" + ln.Addr().String()
+}
+
+// newApp creates the admin user and sets up a server with the same handlers as the real one.
+func newApp(t *testing.T, l log.Logger, b *storage.Backend) *fiber.App {
+	t.Helper()
+
+	err := b.Admins.AddAdmin(context.Background(), testUser, testPass, api.RoleOwner)
+	if err != nil {
+		t.Fatalf("failed to create admin user: %v", err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: server.ErrorHandler(l)})
+	app.Use(logging.RequestIDMiddleware())
+	box, err := server.NewSecretBox(testTOTPKey)
+	if err != nil {
+		t.Fatalf("failed to create secret box: %v", err)
+	}
+	// Don't make tests wait between failed logins, since every test logs in from the same address.
+	guard := server.NewLoginGuard(l, b.Logins, server.LoginLimits{MaxFailures: 3})
+	sessions := server.NewSessions(b.SessionStorage(), b.Sessions, server.DefaultSessionLimits())
+	// The memory backend doesn't have API tokens, the audit trail, or single sign-on.
+	server.AddAuthHandlers(l, app, b.Admins, b.Tokens, box, guard, sessions, b.Audit)
+	server.AddAdminHandlers(l, app, b.Admins)
+	if b.Audit != nil {
+		server.AddAuditHandlers(l, app, b.Audit)
+	}
+	server.AddCRUDHandlers(l, app, b.Users, &server.UserValidator{})
+	if b.Identities != nil {
+		addSSOHandlers(t, l, app, b, guard, sessions)
+	}
+
+	return app
+}
+
+func addSSOHandlers(
+	t *testing.T, l log.Logger, app *fiber.App, b *storage.Backend, guard *server.LoginGuard,
+	sessions *server.Sessions,
+) {
+	t.Helper()
+
+	provider, err := server.NewOIDCProvider(context.Background(), server.OIDCConfig{
+		Issuer:      issuer.URL,
+		ClientID:    oidctest.ClientID,
+		RedirectURL: "http://localhost" + addr + "/login/oidc/callback",
+	})
+	if err != nil {
+		t.Fatalf("failed to set up OIDC provider: %v", err)
+	}
+	server.AddOIDCHandlers(l, app, provider, b.Admins, b.Identities, guard, sessions)
+	discordLogin := server.NewDiscordLogin(server.DiscordLoginConfig{
+		ClientID:     "bouncer",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost" + addr + "/login/discord/callback",
+		Roles:        map[string]api.Role{"moderator": api.RoleUploader, "staff": api.RoleOwner},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  discord.URL + "/oauth2/authorize",
+			TokenURL: discord.URL + "/oauth2/token",
+		},
+		APIURL: discord.URL,
+	}, discord)
+	server.AddDiscordLoginHandlers(l, app, discordLogin, b.Admins, b.Identities, guard,
+		sessions)
+}
+
 //nolint:paralleltest // This test uses a database.
-func TestAll(t *testing.T) { //nolint:cyclop,funlen,gocyclo // long integration test
-	l := testinglog.NewConvenientLogger(t, testinglog.WithFieldIgnoreFunc(ignoreIDs))
+func TestAll(t *testing.T) {
+	forEachBackend(t, testAll)
+}
+
+func testAll(t *testing.T, kind string) { //nolint:cyclop,funlen,gocyclo // long integration test
+	l := testinglog.NewConvenientLogger(t, testinglog.WithFieldIgnoreFunc(ignoreKeyHash))
 	t.Cleanup(l.Done)
 
 	ctx := context.Background()
 
-	shutdown := setupServer(t, l)
+	_, shutdown := setupServer(t, l, kind)
 	t.Cleanup(shutdown)
 
 	// define client
@@ -242,17 +362,23 @@ func TestAll(t *testing.T) { //nolint:cyclop,funlen,gocyclo // long integration
 		t.Errorf("expected no users, got %d", len(users))
 	}
 
-	u1 := db.User{
-		Name:        "John Doe",
-		NameKeyHash: "asdfjkl",
-		FinishYear:  "2021",
+	u1 := encryptedUser(t, "John Doe", "2021")
+	u2 := encryptedUser(t, "Jason Mendoza", "2019")
+	u2.AlumniBoard = true
+
+	// invalid users are rejected
+	_, err = c.Users.CreateUser(ctx, &api.User{Name: "John Doe", FinishYear: "0"})
+	if !errors.Is(err, client.ErrValidation) {
+		t.Errorf("expected ErrValidation creating invalid user, got %v", err)
 	}
-	u2 := db.User{
-		Name:        "Jason Mendoza",
-		NameKeyHash: "lkjfdsa",
-		FinishYear:  "2019",
-		AlumniBoard: true,
+	var apiErr *api.Error
+	if errors.As(err, &apiErr) {
+		wantFields := []string{"name", "name_key_hash", "finish_year"}
+		if diff := cmp.Diff(wantFields, fields(apiErr)); diff != "" {
+			t.Error("unexpected invalid fields (-want +got):\n" + diff)
+		}
 	}
+
 	u1.ID, err = c.Users.CreateUser(ctx, &u1)
 	if err != nil {
 		t.Fatalf("failed to create user1: %v", err)
@@ -261,20 +387,42 @@ func TestAll(t *testing.T) { //nolint:cyclop,funlen,gocyclo // long integration
 	if err != nil {
 		t.Fatalf("failed to create user2: %v", err)
 	}
+	u1.Version, u2.Version = 1, 1
 
 	u1.TA = true
-	err = c.Users.UpdateUser(ctx, &u1)
+	err = c.Users.UpdateUser(ctx, &u1, client.IfMatch(u1.Version))
 	if err != nil {
 		t.Fatalf("failed to update user1: %v", err)
 	}
+	if u1.Version != 2 {
+		t.Errorf("expected version 2 after update, got %d", u1.Version)
+	}
+
+	year := "2020"
+	patched, err := c.Users.Patch(ctx, u2.ID, &api.UserPatch{FinishYear: &year},
+		client.IfMatch(u2.Version))
+	if err != nil {
+		t.Fatalf("failed to patch user2: %v", err)
+	}
+	u2.FinishYear = year
+	u2.Version = 2
+	if diff := cmp.Diff(&u2, patched); diff != "" {
+		t.Error("unexpected patched user (-want +got):\n" + diff)
+	}
+
+	// writes with a stale version are refused
+	_, err = c.Users.Patch(ctx, u2.ID, &api.UserPatch{FinishYear: &year}, client.IfMatch(1))
+	if !errors.Is(err, client.ErrPreconditionFailed) {
+		t.Errorf("expected ErrPreconditionFailed patching with stale version, got %v", err)
+	}
 
 	users, err = c.Users.GetAllUsers(ctx)
 	if err != nil {
 		t.Errorf("failed to get users: %v", err)
 	}
 	diff := cmp.Diff(
-		[]*db.User{&u1, &u2}, users,
-		cmpopts.SortSlices(func(x, y *db.User) bool { return x.ID < y.ID }),
+		[]*api.User{&u1, &u2}, users,
+		cmpopts.SortSlices(func(x, y *api.User) bool { return x.ID < y.ID }),
 	)
 	if diff != "" {
 		t.Error("unexpected users (-want +got):\n" + diff)
@@ -284,15 +432,37 @@ func TestAll(t *testing.T) { //nolint:cyclop,funlen,gocyclo // long integration
 	if err != nil {
 		t.Errorf("failed to get filtered users: %v", err)
 	}
-	if diff = cmp.Diff([]*db.User{&u1}, users); diff != "" {
+	if diff = cmp.Diff([]*api.User{&u1}, users); diff != "" {
 		t.Error("unexpected users (-want +got):\n" + diff)
 	}
 
-	err = c.Users.DeleteUser(ctx, u2.ID)
+	var paged []*api.User
+	for u, pageErr := range c.Users.All(ctx, client.WithLimit(1)) {
+		if pageErr != nil {
+			t.Fatalf("failed to page through users: %v", pageErr)
+		}
+		paged = append(paged, u)
+	}
+	if diff = cmp.Diff([]*api.User{&u1, &u2}, paged); diff != "" {
+		t.Error("unexpected paged users (-want +got):\n" + diff)
+	}
+
+	// pages can't be larger than the maximum
+	_, err = c.Users.GetAllUsers(ctx, client.WithLimit(api.MaxUserLimit+1))
+	if !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("expected ErrBadRequest getting too many users, got %v", err)
+	}
+
+	err = c.Users.DeleteUser(ctx, u2.ID, client.IfMatch(u2.Version))
 	if err != nil {
 		t.Fatalf("failed to delete user2: %v", err)
 	}
 
+	_, err = c.Users.GetUser(ctx, u2.ID)
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("expected ErrNotFound getting deleted user, got %v", err)
+	}
+
 	newU1, err := c.Users.GetUser(ctx, u1.ID)
 	if err != nil {
 		t.Errorf("failed to get user: %v", err)
@@ -305,19 +475,24 @@ func TestAll(t *testing.T) { //nolint:cyclop,funlen,gocyclo // long integration
 	if err != nil {
 		t.Errorf("failed to get users: %v", err)
 	}
-	if diff := cmp.Diff([]*db.User{&u1}, users); diff != "" {
+	if diff := cmp.Diff([]*api.User{&u1}, users); diff != "" {
 		t.Error("unexpected users (-want +got):\n" + diff)
 	}
 }
 
 //nolint:paralleltest // This test uses a database.
-func TestUploadAndDecrypt(t *testing.T) { //nolint:cyclop,funlen,gocyclo // long integration test
+func TestUploadAndDecrypt(t *testing.T) {
+	forEachBackend(t, testUploadAndDecrypt)
+}
+
+//nolint:cyclop,funlen,gocyclo // long integration test
+func testUploadAndDecrypt(t *testing.T, kind string) {
 	l := testinglog.NewConvenientLogger(t, testinglog.WithFieldIgnoreFunc(ignoreKeyHash))
 	t.Cleanup(l.Done)
 
 	ctx := context.Background()
 
-	shutdown := setupServer(t, l)
+	b, shutdown := setupServer(t, l, kind)
 	t.Cleanup(shutdown)
 
 	// define client
@@ -333,11 +508,11 @@ func TestUploadAndDecrypt(t *testing.T) { //nolint:cyclop,funlen,gocyclo // long
 
 	const u1Name = "John Doe"
 	const u2Name = "Jason Mendoza"
-	u1 := db.User{
+	u1 := api.User{
 		Name:       u1Name,
 		FinishYear: "2021",
 	}
-	u2 := db.User{
+	u2 := api.User{
 		Name:        u2Name,
 		FinishYear:  "2019",
 		AlumniBoard: true,
@@ -363,27 +538,28 @@ func TestUploadAndDecrypt(t *testing.T) { //nolint:cyclop,funlen,gocyclo // long
 	if err != nil {
 		t.Fatalf("failed to hash key: %v", err)
 	}
+	u1.Version, u2.Version = 1, 1 // new users start at version 1
 
 	// get by key hash
 	users, err := c.Users.GetAllUsers(ctx, client.WithKeyHash(u1.NameKeyHash))
 	if err != nil {
 		t.Errorf("failed to get filtered users: %v", err)
 	}
-	if diff := cmp.Diff([]*db.User{&u1}, users); diff != "" {
+	if diff := cmp.Diff([]*api.User{&u1}, users); diff != "" {
 		t.Error("unexpected users (-want +got):\n" + diff)
 	}
 	users, err = c.Users.GetAllUsers(ctx, client.WithKeyHash(u2.NameKeyHash))
 	if err != nil {
 		t.Errorf("failed to get filtered users: %v", err)
 	}
-	if diff := cmp.Diff([]*db.User{&u2}, users); diff != "" {
+	if diff := cmp.Diff([]*api.User{&u2}, users); diff != "" {
 		t.Error("unexpected users (-want +got):\n" + diff)
 	}
 
 	// decrypt on the server side
-	dec := bouncerbot.TableDecrypter{Table: db.NewUserTable(l, dbPool)}
+	dec := bouncerbot.TableDecrypter{Table: b.Users}
 
-	out, err := dec.Decrypt(u1Key)
+	out, err := dec.Decrypt(context.Background(), u1Key)
 	if err != nil {
 		t.Fatalf("failed to decrypt: %v", err)
 	}
@@ -391,18 +567,18 @@ func TestUploadAndDecrypt(t *testing.T) { //nolint:cyclop,funlen,gocyclo // long
 	if diff := cmp.Diff(&u1, out); diff != "" {
 		t.Error("unexpected decrypted info (-want +got):\n" + diff)
 	}
-	err = dec.Delete(u1.ID)
+	err = dec.Delete(context.Background(), u1.ID)
 	if err != nil {
 		t.Errorf("unexpected error from Decrypter.Delete: %v", err)
 	}
 
 	// (try again, should get ErrNotFound)
-	_, err = dec.Decrypt(u1Key)
+	_, err = dec.Decrypt(context.Background(), u1Key)
 	if !errors.Is(err, bouncerbot.ErrNotFound) {
 		t.Errorf("expected error decrypting u1 again, got %v", err)
 	}
 
-	out, err = dec.Decrypt(u2Key)
+	out, err = dec.Decrypt(context.Background(), u2Key)
 	if err != nil {
 		t.Fatalf("failed to decrypt: %v", err)
 	}
@@ -410,7 +586,7 @@ func TestUploadAndDecrypt(t *testing.T) { //nolint:cyclop,funlen,gocyclo // long
 	if diff := cmp.Diff(&u2, out); diff != "" {
 		t.Error("unexpected decrypted info (-want +got):\n" + diff)
 	}
-	err = dec.Delete(u2.ID)
+	err = dec.Delete(context.Background(), u2.ID)
 	if err != nil {
 		t.Errorf("unexpected error from Decrypter.Delete: %v", err)
 	}
@@ -425,8 +601,787 @@ func TestUploadAndDecrypt(t *testing.T) { //nolint:cyclop,funlen,gocyclo // long
 	}
 }
 
+//nolint:paralleltest // This test uses a database.
+func TestTokens(t *testing.T) {
+	forEachBackend(t, testTokens)
+}
+
+func testTokens(t *testing.T, kind string) {
+	l := testinglog.NewConvenientLogger(t, testinglog.WithFieldIgnoreFunc(ignoreIDs))
+	t.Cleanup(l.Done)
+
+	ctx := context.Background()
+
+	b, shutdown := setupServer(t, l, kind)
+	t.Cleanup(shutdown)
+
+	token, err := b.Tokens.CreateToken(
+		ctx, testUser, "reader", []string{string(api.ScopeUsersRead)}, nil)
+	if err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+
+	c, err := client.NewClient("http://localhost"+addr, client.WithToken(token))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// the token can read without logging in
+	users, err := c.Users.GetAllUsers(ctx)
+	if err != nil {
+		t.Fatalf("failed to get users with token: %v", err)
+	}
+	if len(users) != 0 {
+		t.Errorf("expected no users, got %d", len(users))
+	}
+
+	// but it can't do anything else
+	u := encryptedUser(t, "John Doe", "2021")
+	_, err = c.Users.CreateUser(ctx, &u)
+	if !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected ErrForbidden creating user with read-only token, got %v", err)
+	}
+	err = c.Admin.ChangePassword(ctx, testPass, "newPass1")
+	if !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected ErrForbidden changing password with token, got %v", err)
+	}
+
+	// unknown tokens are rejected
+	bad, err := client.NewClient("http://localhost"+addr, client.WithToken(db.TokenPrefix+"nope"))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	_, err = bad.Users.GetAllUsers(ctx)
+	if !errors.Is(err, client.ErrNotLoggedIn) {
+		t.Errorf("expected ErrNotLoggedIn with unknown token, got %v", err)
+	}
+}
+
+//nolint:paralleltest // This test uses a database.
+func TestRoles(t *testing.T) {
+	forEachBackend(t, testRoles)
+}
+
+func testRoles(t *testing.T, kind string) {
+	l := testinglog.NewConvenientLogger(t, testinglog.WithFieldIgnoreFunc(ignoreIDs))
+	t.Cleanup(l.Done)
+
+	ctx := context.Background()
+
+	b, shutdown := setupServer(t, l, kind)
+	t.Cleanup(shutdown)
+
+	// an uploader can read and create users, but not delete them
+	aTable := b.Admins
+	err := aTable.AddAdmin(ctx, "ta", testPass, api.RoleUploader)
+	if err != nil {
+		t.Fatalf("failed to create uploader: %v", err)
+	}
+	t.Cleanup(func() {
+		finalErr := aTable.DeleteAdmin(context.Background(), "ta")
+		if finalErr != nil {
+			t.Errorf("error deleting uploader: %v", finalErr)
+		}
+	})
+
+	c, err := client.NewClient("http://localhost" + addr)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	err = c.Admin.Login(ctx, "ta", testPass)
+	if err != nil {
+		t.Fatalf("failed to login: %v", err)
+	}
+
+	_, err = c.Users.GetAllUsers(ctx)
+	if err != nil {
+		t.Errorf("failed to get users as uploader: %v", err)
+	}
+
+	u := encryptedUser(t, "John Doe", "2021")
+	u.ID, err = c.Users.CreateUser(ctx, &u)
+	if err != nil {
+		t.Fatalf("failed to create user as uploader: %v", err)
+	}
+
+	err = c.Users.DeleteUser(ctx, u.ID)
+	if !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected ErrForbidden deleting user as uploader, got %v", err)
+	}
+}
+
+//nolint:paralleltest // This test uses a database.
+func TestAdmins(t *testing.T) {
+	forEachBackend(t, testAdmins)
+}
+
+func testAdmins(t *testing.T, kind string) { //nolint:cyclop // long integration test
+	l := testinglog.NewConvenientLogger(t, testinglog.WithFieldIgnoreFunc(ignoreIDs))
+	t.Cleanup(l.Done)
+
+	ctx := context.Background()
+
+	_, shutdown := setupServer(t, l, kind)
+	t.Cleanup(shutdown)
+
+	owner, err := client.NewClient("http://localhost" + addr)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	err = owner.Admin.Login(ctx, testUser, testPass)
+	if err != nil {
+		t.Fatalf("failed to login: %v", err)
+	}
+
+	// the owner creates an uploader
+	ta, err := owner.Admins.Create(ctx, "ta", testPass, api.RoleUploader)
+	if err != nil {
+		t.Fatalf("failed to create admin: %v", err)
+	}
+	if ta.Username != "ta" || ta.Role != api.RoleUploader || ta.Disabled {
+		t.Errorf("unexpected new admin: %+v", ta)
+	}
+
+	taClient, err := client.NewClient("http://localhost" + addr)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	err = taClient.Admin.Login(ctx, "ta", testPass)
+	if err != nil {
+		t.Fatalf("failed to login as new admin: %v", err)
+	}
+
+	admins, err := owner.Admins.List(ctx)
+	if err != nil {
+		t.Errorf("failed to list admins: %v", err)
+	}
+	if len(admins) != 2 || admins[1].LastLogin == nil {
+		t.Errorf("unexpected admins: %+v", admins)
+	}
+
+	// only owners can manage admins
+	_, err = taClient.Admins.List(ctx)
+	if !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected ErrForbidden listing admins as uploader, got %v", err)
+	}
+
+	// disabling the admin ends their session
+	disabled := true
+	_, err = owner.Admins.Patch(ctx, "ta", &api.AdminPatch{Disabled: &disabled})
+	if err != nil {
+		t.Errorf("failed to disable admin: %v", err)
+	}
+	_, err = taClient.Users.GetAllUsers(ctx)
+	if !errors.Is(err, client.ErrNotLoggedIn) {
+		t.Errorf("expected ErrNotLoggedIn after being disabled, got %v", err)
+	}
+
+	// owners can't delete themselves
+	err = owner.Admins.Delete(ctx, testUser)
+	if !errors.Is(err, client.ErrConflict) {
+		t.Errorf("expected ErrConflict deleting own account, got %v", err)
+	}
+
+	err = owner.Admins.Delete(ctx, "ta")
+	if err != nil {
+		t.Errorf("failed to delete admin: %v", err)
+	}
+}
+
+//nolint:paralleltest // This test uses a database.
+func TestTOTP(t *testing.T) {
+	forEachBackend(t, testTOTP)
+}
+
+func testTOTP(t *testing.T, kind string) { //nolint:cyclop // long integration test
+	l := testinglog.NewConvenientLogger(t, testinglog.WithFieldIgnoreFunc(ignoreIDs))
+	t.Cleanup(l.Done)
+
+	ctx := context.Background()
+
+	_, shutdown := setupServer(t, l, kind)
+	t.Cleanup(shutdown)
+
+	c, err := client.NewClient("http://localhost" + addr)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	err = c.Admin.Login(ctx, testUser, testPass)
+	if err != nil {
+		t.Fatalf("failed to login: %v", err)
+	}
+
+	enrollment, err := c.Admin.EnrollTOTP(ctx)
+	if err != nil {
+		t.Fatalf("failed to enroll: %v", err)
+	}
+	code, err := totp.Code(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatalf("failed to generate code: %v", err)
+	}
+	recovery, err := c.Admin.ConfirmTOTP(ctx, code)
+	if err != nil {
+		t.Fatalf("failed to confirm enrollment: %v", err)
+	}
+	if len(recovery) != 10 {
+		t.Fatalf("expected 10 recovery codes, got %d", len(recovery))
+	}
+
+	err = c.Admin.Logout(ctx)
+	if err != nil {
+		t.Errorf("failed to log out: %v", err)
+	}
+
+	// the password alone is no longer enough
+	err = c.Admin.Login(ctx, testUser, testPass)
+	if !errors.Is(err, client.ErrTOTPRequired) {
+		t.Fatalf("expected ErrTOTPRequired, got %v", err)
+	}
+	err = c.Admin.LoginTOTP(ctx, "wrong")
+	if !errors.Is(err, client.ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials with wrong code, got %v", err)
+	}
+	err = c.Admin.LoginTOTP(ctx, strings.ToUpper(recovery[0]))
+	if err != nil {
+		t.Fatalf("failed to log in with recovery code: %v", err)
+	}
+
+	// recovery codes only work once
+	err = c.Admin.DisableTOTP(ctx, recovery[0])
+	if !errors.Is(err, client.ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials reusing recovery code, got %v", err)
+	}
+	err = c.Admin.DisableTOTP(ctx, recovery[1])
+	if err != nil {
+		t.Errorf("failed to disable two-factor authentication: %v", err)
+	}
+}
+
+//nolint:paralleltest // This test uses a database.
+func TestLockout(t *testing.T) {
+	forEachBackend(t, testLockout)
+}
+
+func testLockout(t *testing.T, kind string) { //nolint:cyclop // long integration test
+	l := testinglog.NewConvenientLogger(t, testinglog.WithFieldIgnoreFunc(ignoreIDs))
+	t.Cleanup(l.Done)
+
+	ctx := context.Background()
+
+	b, shutdown := setupServer(t, l, kind)
+	t.Cleanup(shutdown)
+
+	owner, err := client.NewClient("http://localhost" + addr)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	err = owner.Admin.Login(ctx, testUser, testPass)
+	if err != nil {
+		t.Fatalf("failed to login: %v", err)
+	}
+	_, err = owner.Admins.Create(ctx, "ta", testPass, api.RoleUploader)
+	if err != nil {
+		t.Fatalf("failed to create admin: %v", err)
+	}
+
+	c, err := client.NewClient("http://localhost" + addr)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// the server is set up to lock admins out after three failures
+	for range 3 {
+		err = c.Admin.Login(ctx, "ta", "wrong password")
+		if !errors.Is(err, client.ErrInvalidCredentials) {
+			t.Errorf("expected ErrInvalidCredentials, got %v", err)
+		}
+	}
+	err = c.Admin.Login(ctx, "ta", testPass)
+	if !errors.Is(err, client.ErrAccountLocked) {
+		t.Errorf("expected ErrAccountLocked with correct password, got %v", err)
+	}
+
+	logins := b.Logins
+	failures, err := logins.ListFailures(ctx, "ta", 10)
+	if err != nil {
+		t.Errorf("failed to list failures: %v", err)
+	}
+	reasons := make([]string, len(failures))
+	for i, f := range failures {
+		reasons[i] = f.Reason
+	}
+	wantReasons := []string{
+		db.FailureLocked, db.FailureBadPassword, db.FailureBadPassword, db.FailureBadPassword,
+	}
+	if diff := cmp.Diff(wantReasons, reasons); diff != "" {
+		t.Error("unexpected failure reasons (-want +got):\n" + diff)
+	}
+
+	err = logins.Unlock(ctx, "ta")
+	if err != nil {
+		t.Fatalf("failed to unlock admin: %v", err)
+	}
+	err = c.Admin.Login(ctx, "ta", testPass)
+	if err != nil {
+		t.Errorf("failed to login after unlock: %v", err)
+	}
+
+	err = owner.Admins.Delete(ctx, "ta")
+	if err != nil {
+		t.Errorf("failed to delete admin: %v", err)
+	}
+}
+
+//nolint:paralleltest // This test uses a database.
+func TestSessions(t *testing.T) {
+	forEachBackend(t, testSessions)
+}
+
+func testSessions(t *testing.T, kind string) { //nolint:cyclop // long integration test
+	l := testinglog.NewConvenientLogger(t, testinglog.WithFieldIgnoreFunc(ignoreIDs))
+	t.Cleanup(l.Done)
+
+	ctx := context.Background()
+
+	_, shutdown := setupServer(t, l, kind)
+	t.Cleanup(shutdown)
+
+	// log in twice
+	clients := make([]*client.Client, 2)
+	for i := range clients {
+		c, err := client.NewClient("http://localhost" + addr)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		err = c.Admin.Login(ctx, testUser, testPass)
+		if err != nil {
+			t.Fatalf("failed to login: %v", err)
+		}
+		clients[i] = c
+	}
+	c1, c2 := clients[0], clients[1]
+
+	sessions, err := c1.Admin.Sessions(ctx)
+	if err != nil {
+		t.Fatalf("failed to list sessions: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].Current == sessions[1].Current {
+		t.Fatalf("expected two sessions with one current, got %+v", sessions)
+	}
+	other := sessions[0]
+	if other.Current {
+		other = sessions[1]
+	}
+
+	// revoking the other session logs it out
+	err = c1.Admin.RevokeSession(ctx, other.ID)
+	if err != nil {
+		t.Errorf("failed to revoke session: %v", err)
+	}
+	_, err = c2.Users.GetAllUsers(ctx)
+	if !errors.Is(err, client.ErrNotLoggedIn) {
+		t.Errorf("expected ErrNotLoggedIn with revoked session, got %v", err)
+	}
+
+	// so does changing the password
+	err = c2.Admin.Login(ctx, testUser, testPass)
+	if err != nil {
+		t.Fatalf("failed to login again: %v", err)
+	}
+	err = c1.Admin.ChangePassword(ctx, testPass, "newPass1")
+	if err != nil {
+		t.Fatalf("failed to change password: %v", err)
+	}
+	_, err = c2.Users.GetAllUsers(ctx)
+	if !errors.Is(err, client.ErrNotLoggedIn) {
+		t.Errorf("expected ErrNotLoggedIn after password change, got %v", err)
+	}
+	_, err = c1.Users.GetAllUsers(ctx)
+	if err != nil {
+		t.Errorf("session that changed the password was logged out: %v", err)
+	}
+}
+
+//nolint:paralleltest // This test uses a database.
+func TestAudit(t *testing.T) {
+	forEachBackend(t, testAudit)
+}
+
+func testAudit(t *testing.T, kind string) { //nolint:cyclop,funlen // long integration test
+	l := testinglog.NewConvenientLogger(t, testinglog.WithFieldIgnoreFunc(ignoreIDs))
+	t.Cleanup(l.Done)
+
+	ctx := context.Background()
+	start := time.Now()
+
+	b, shutdown := setupServer(t, l, kind)
+	t.Cleanup(shutdown)
+
+	owner, err := client.NewClient("http://localhost" + addr)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	err = owner.Admin.Login(ctx, testUser, testPass)
+	if err != nil {
+		t.Fatalf("failed to login: %v", err)
+	}
+
+	// make some changes
+	u := encryptedUser(t, "John Doe", "2021")
+	u.ID, err = owner.Users.CreateUser(ctx, &u)
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	ta := true
+	_, err = owner.Users.Patch(ctx, u.ID, &api.UserPatch{TA: &ta})
+	if err != nil {
+		t.Fatalf("failed to patch user: %v", err)
+	}
+	err = owner.Users.DeleteUser(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	_, err = owner.Admins.Create(ctx, "ta", testPass, api.RoleUploader)
+	if err != nil {
+		t.Fatalf("failed to create admin: %v", err)
+	}
+	t.Cleanup(func() {
+		finalErr := b.Admins.DeleteAdmin(context.Background(), "ta")
+		if finalErr != nil {
+			t.Errorf("error deleting admin: %v", finalErr)
+		}
+	})
+	err = owner.Admins.ResetPassword(ctx, "ta", "newPass1")
+	if err != nil {
+		t.Fatalf("failed to reset password: %v", err)
+	}
+
+	// the events are listed newest first
+	events, err := owner.Audit.List(ctx, &api.AuditFilter{Since: start})
+	if err != nil {
+		t.Fatalf("failed to list audit events: %v", err)
+	}
+	target := strconv.Itoa(u.ID)
+	want := []*api.AuditEvent{
+		{Actor: testUser, Action: "PUT /api/admins/:username/password", Target: "ta"},
+		{Actor: testUser, Action: "POST /api/admins", Target: "ta"},
+		{Actor: testUser, Action: "DELETE /api/users/:id", Target: target},
+		{Actor: testUser, Action: "PATCH /api/users/:id", Target: target},
+		{Actor: testUser, Action: "POST /api/users", Target: target},
+	}
+	diff := cmp.Diff(want, events,
+		cmpopts.IgnoreFields(api.AuditEvent{}, "ID", "Time", "Changes", "RequestID", "PrevHash",
+			"Hash"))
+	if diff != "" {
+		t.Fatal("unexpected audit events (-want +got):\n" + diff)
+	}
+	for _, e := range events {
+		if e.RequestID == "" {
+			t.Errorf("event %d has no request ID", e.ID)
+		}
+	}
+
+	// each event is chained to the one before it
+	for i := range len(events) - 1 {
+		if events[i].PrevHash != events[i+1].Hash {
+			t.Errorf("event %d is not chained to event %d", events[i].ID, events[i+1].ID)
+		}
+	}
+	head, err := owner.Audit.Head(ctx)
+	if err != nil {
+		t.Errorf("failed to get audit chain head: %v", err)
+	} else if head.ID != events[0].ID || head.Hash != events[0].Hash {
+		t.Errorf("unexpected chain head: %+v", head)
+	}
+
+	// secret fields are redacted
+	diff = cmp.Diff(map[string]api.FieldChange{"password": {Redacted: true}}, events[0].Changes)
+	if diff != "" {
+		t.Error("unexpected password reset changes (-want +got):\n" + diff)
+	}
+	if !events[4].Changes["name_key_hash"].Redacted {
+		t.Errorf("key hash was not redacted: %+v", events[4].Changes["name_key_hash"])
+	}
+
+	// only changed fields are recorded
+	wantPatch := map[string]api.FieldChange{
+		"ta":      {Before: json.RawMessage("false"), After: json.RawMessage("true")},
+		"version": {Before: json.RawMessage("1"), After: json.RawMessage("2")},
+	}
+	if diff = cmp.Diff(wantPatch, events[3].Changes); diff != "" {
+		t.Error("unexpected patch changes (-want +got):\n" + diff)
+	}
+
+	// events can be filtered
+	events, err = owner.Audit.List(ctx, &api.AuditFilter{
+		Target: target, Action: "PATCH /api/users/:id", Since: start,
+	})
+	if err != nil {
+		t.Errorf("failed to filter audit events: %v", err)
+	}
+	if len(events) != 1 || events[0].Action != "PATCH /api/users/:id" {
+		t.Errorf("unexpected filtered events: %+v", events)
+	}
+
+	// only owners can read the audit trail
+	taClient, err := client.NewClient("http://localhost" + addr)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	err = taClient.Admin.Login(ctx, "ta", "newPass1")
+	if err != nil {
+		t.Fatalf("failed to login as new admin: %v", err)
+	}
+	_, err = taClient.Audit.List(ctx, nil)
+	if !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected ErrForbidden reading audit trail as uploader, got %v", err)
+	}
+}
+
+//nolint:paralleltest // This test uses a database.
+func TestOIDC(t *testing.T) {
+	forEachBackend(t, testOIDC)
+}
+
+func testOIDC(t *testing.T, kind string) {
+	l := testinglog.NewConvenientLogger(t, testinglog.WithFieldIgnoreFunc(ignoreIDs))
+	t.Cleanup(l.Done)
+
+	ctx := context.Background()
+
+	b, shutdown := setupServer(t, l, kind)
+	t.Cleanup(shutdown)
+
+	// allow Jane to log in as a new uploader
+	identities := b.Identities
+	id, err := identities.AddIdentity(ctx, db.IdentityEmail, "jane@example.com", "jane",
+		api.RoleUploader)
+	if err != nil {
+		t.Fatalf("failed to allow identity: %v", err)
+	}
+	t.Cleanup(func() {
+		finalErr := identities.RemoveIdentity(ctx, id)
+		if finalErr != nil {
+			t.Errorf("error removing identity: %v", finalErr)
+		}
+		finalErr = b.Admins.DeleteAdmin(ctx, "jane")
+		if finalErr != nil {
+			t.Errorf("error deleting admin jane: %v", finalErr)
+		}
+	})
+
+	c, err := client.NewClient("http://localhost" + addr)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	issuer.SetUser("jane-subject", "Jane@example.com")
+	err = c.Admin.LoginOIDC(ctx, browse(t))
+	if err != nil {
+		t.Fatalf("failed to log in with OIDC: %v", err)
+	}
+	_, err = c.Users.GetAllUsers(ctx)
+	if err != nil {
+		t.Errorf("failed to get users after OIDC login: %v", err)
+	}
+	err = c.Admin.Logout(ctx)
+	if err != nil {
+		t.Errorf("failed to log out: %v", err)
+	}
+
+	// someone who isn't on the allowlist can't log in
+	issuer.SetUser("mallory-subject", "mallory@example.com")
+	err = c.Admin.LoginOIDC(ctx, browse(t))
+	if !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected ErrForbidden for unknown identity, got %v", err)
+	}
+}
+
+//nolint:paralleltest // This test uses a database.
+func TestDiscordLogin(t *testing.T) {
+	forEachBackend(t, testDiscordLogin)
+}
+
+func testDiscordLogin(t *testing.T, kind string) { //nolint:cyclop // long integration test
+	l := testinglog.NewConvenientLogger(t, testinglog.WithFieldIgnoreFunc(ignoreIDs))
+	t.Cleanup(l.Done)
+
+	ctx := context.Background()
+
+	b, shutdown := setupServer(t, l, kind)
+	t.Cleanup(shutdown)
+	t.Cleanup(func() {
+		finalErr := b.Admins.DeleteAdmin(ctx, "discord-jane")
+		if finalErr != nil {
+			t.Errorf("error deleting admin discord-jane: %v", finalErr)
+		}
+	})
+
+	c, err := client.NewClient("http://localhost" + addr)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// a moderator logs in as an uploader
+	discord.SetUser("1001", "jane", "moderator")
+	err = c.Admin.LoginDiscord(ctx, browse(t))
+	if err != nil {
+		t.Fatalf("failed to log in with Discord: %v", err)
+	}
+	_, err = c.Users.GetAllUsers(ctx)
+	if err != nil {
+		t.Errorf("failed to get users after Discord login: %v", err)
+	}
+	_, err = c.Admins.List(ctx)
+	if !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected ErrForbidden listing admins as uploader, got %v", err)
+	}
+	err = c.Admin.Logout(ctx)
+	if err != nil {
+		t.Errorf("failed to log out: %v", err)
+	}
+
+	// the role is updated from the guild on the next login
+	discord.SetUser("1001", "jane", "moderator", "staff")
+	err = c.Admin.LoginDiscord(ctx, browse(t))
+	if err != nil {
+		t.Fatalf("failed to log in with Discord again: %v", err)
+	}
+	admins, err := c.Admins.List(ctx)
+	if err != nil {
+		t.Errorf("failed to list admins as owner: %v", err)
+	}
+	if len(admins) != 2 {
+		t.Errorf("expected 2 admins, got %+v", admins)
+	}
+	err = c.Admin.Logout(ctx)
+	if err != nil {
+		t.Errorf("failed to log out: %v", err)
+	}
+
+	// someone without an admin role can't log in
+	discord.SetUser("1002", "mallory", "student")
+	err = c.Admin.LoginDiscord(ctx, browse(t))
+	if !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("expected ErrForbidden for member without admin role, got %v", err)
+	}
+}
+
+// fakeDiscord stands in for the OAuth2 endpoints and API of Discord, and for the bot checking the
+// roles of guild members. It approves every login as the configured user.
+type fakeDiscord struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	id       string
+	username string
+	roles    []string
+}
+
+func newFakeDiscord() *fakeDiscord {
+	var out fakeDiscord
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /oauth2/authorize", func(w http.ResponseWriter, r *http.Request) {
+		redirect, err := url.Parse(r.URL.Query().Get("redirect_uri"))
+		if err != nil {
+			http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+
+			return
+		}
+		q := redirect.Query()
+		q.Set("code", "code")
+		q.Set("state", r.URL.Query().Get("state"))
+		redirect.RawQuery = q.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("POST /oauth2/token", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"token","token_type":"Bearer"}`)
+	})
+	mux.HandleFunc("GET /users/@me", func(w http.ResponseWriter, _ *http.Request) {
+		out.mu.Lock()
+		defer out.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":%q,"username":%q}`, out.id, out.username)
+	})
+	out.Server = httptest.NewServer(mux)
+
+	return &out
+}
+
+// SetUser sets the Discord user who logs in from now on, and the guild roles they hold.
+func (d *fakeDiscord) SetUser(id, username string, roles ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.id = id
+	d.username = username
+	d.roles = roles
+}
+
+func (d *fakeDiscord) MemberRoleNames(string) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.roles, nil
+}
+
+// browse returns a function that follows redirects from the URL like a browser would, keeping
+// cookies along the way.
+func browse(t *testing.T) func(string) error {
+	t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("failed to create cookie jar: %v", err)
+	}
+	browser := &http.Client{Jar: jar}
+
+	return func(u string) error {
+		req, reqErr := http.NewRequestWithContext(context.Background(), http.MethodGet, u, nil)
+		if reqErr != nil {
+			return reqErr
+		}
+		resp, reqErr := browser.Do(req)
+		if reqErr != nil {
+			return reqErr
+		}
+
+		return resp.Body.Close()
+	}
+}
+
+// encryptedUser returns a user with the name encrypted as it would be by Upload.
+func encryptedUser(t *testing.T, name, year string) api.User {
+	t.Helper()
+
+	ciphertext, key, err := encrypt.Encrypt(name)
+	if err != nil {
+		t.Fatalf("failed to encrypt name: %v", err)
+	}
+	hash, err := encrypt.MD5Hash(key)
+	if err != nil {
+		t.Fatalf("failed to hash key: %v", err)
+	}
+
+	return api.User{Name: ciphertext, NameKeyHash: hash, FinishYear: year}
+}
+
+func fields(err *api.Error) []string {
+	out := make([]string, len(err.Details))
+	for i, d := range err.Details {
+		out[i] = d.Field
+	}
+
+	return out
+}
+
 func ignoreIDs(map[string]string) []string {
-	return []string{"id"}
+	// The client address may be IPv4 or IPv6 depending on how localhost resolves, and request IDs
+	// are random.
+	return []string{"id", "ip", "requestID"}
 }
 
 func ignoreKeyHash(fields map[string]string) []string {
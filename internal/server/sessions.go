@@ -0,0 +1,133 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// SessionLimits controls how long admin sessions last.
+type SessionLimits struct {
+	// IdleTimeout ends a session that hasn't been used for this long.
+	IdleTimeout time.Duration
+
+	// MaxAge ends a session this long after logging in, even if it is still being used.
+	MaxAge time.Duration
+}
+
+// DefaultSessionLimits returns the limits used by the server unless configured otherwise.
+func DefaultSessionLimits() SessionLimits {
+	return SessionLimits{
+		IdleTimeout: time.Hour,
+		MaxAge:      24 * time.Hour,
+	}
+}
+
+// Sessions stores admin sessions and tracks them in a SessionStore so that they can be listed and
+// revoked.
+type Sessions struct {
+	store  *session.Store
+	table  db.SessionStore
+	limits SessionLimits
+}
+
+// NewSessions creates a session store that keeps the session data in storage.
+func NewSessions(storage fiber.Storage, table db.SessionStore, limits SessionLimits) *Sessions {
+	return &Sessions{
+		store: session.New(session.Config{
+			Storage:    storage,
+			Expiration: limits.MaxAge,
+		}),
+		table:  table,
+		limits: limits,
+	}
+}
+
+// start logs in the session as the admin, with a new session ID so that an ID set before login
+// can't be used to hijack the session.
+func (s *Sessions) start(c *fiber.Ctx, sess *session.Session, admin *db.Admin) error {
+	err := sess.Reset()
+	if err != nil {
+		return err
+	}
+
+	// The session must not be used after it is saved.
+	key := sess.ID()
+	sess.Set("admin_id", admin.ID)
+	sess.Set("username", admin.Username)
+	err = sess.Save()
+	if err != nil {
+		return err
+	}
+
+	return s.table.CreateSession(c.Context(), key, admin.ID, c.IP(), c.Get(fiber.HeaderUserAgent))
+}
+
+// touch checks that the session is still valid for the admin and records that it was used.
+func (s *Sessions) touch(c *fiber.Ctx, sess *session.Session, adminID int) (bool, error) {
+	return s.table.TouchSession(c.Context(), sess.ID(), adminID, c.IP(),
+		s.limits.IdleTimeout, s.limits.MaxAge)
+}
+
+// end destroys the session and stops tracking it.
+func (s *Sessions) end(c *fiber.Ctx, sess *session.Session) error {
+	err := s.table.DeleteSession(c.Context(), sess.ID())
+	if err != nil {
+		return err
+	}
+
+	return sess.Destroy()
+}
+
+// ListSessions sends the sessions of the logged-in admin.
+func ListSessions(l log.Logger, sessions *Sessions) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p := getPrincipal(c)
+
+		list, err := sessions.table.ListSessions(c.Context(), p.username)
+		if err != nil {
+			return serverError(l, c, "Database error", err)
+		}
+
+		out := make([]api.Session, len(list))
+		for i, s := range list {
+			out[i] = api.Session{
+				ID:        s.ID,
+				CreatedAt: s.CreatedAt,
+				LastSeen:  s.LastSeen,
+				IP:        s.IP,
+				UserAgent: s.UserAgent,
+				Current:   s.Key == p.session,
+			}
+		}
+
+		return c.JSON(out)
+	}
+}
+
+// RevokeSession ends one of the sessions of the logged-in admin.
+func RevokeSession(l log.Logger, sessions *Sessions) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, "Invalid session ID")
+		}
+
+		err = sessions.table.RevokeSession(c.Context(), getPrincipal(c).username, id)
+		if err != nil {
+			if errors.Is(err, db.ErrNoSession) {
+				return sendError(c, http.StatusNotFound, api.CodeNotFound, "Session not found")
+			}
+
+			return serverError(l, c, "Database error", err)
+		}
+
+		return c.SendString("Session revoked")
+	}
+}
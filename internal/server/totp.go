@@ -0,0 +1,353 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/totp"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"github.com/kylrth/disco-bouncer/pkg/encrypt"
+)
+
+// SecretBox encrypts TOTP secrets before they are stored in the database, so that a leaked database
+// dump can't be used to generate codes.
+type SecretBox struct {
+	key []byte
+}
+
+// NewSecretBox returns a SecretBox using the hex-encoded 32-byte AES key.
+func NewSecretBox(hexKey string) (*SecretBox, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, fmt.Errorf("decode TOTP key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("TOTP key must be 32 bytes, not %d", len(key))
+	}
+
+	return &SecretBox{key: key}, nil
+}
+
+// Seal encrypts the secret.
+func (b *SecretBox) Seal(secret string) (string, error) {
+	return encrypt.WithKey(secret, b.key)
+}
+
+// Open decrypts a secret encrypted by Seal.
+func (b *SecretBox) Open(sealed string) (string, error) {
+	return encrypt.Decrypt(sealed, hex.EncodeToString(b.key))
+}
+
+const (
+	// totpIssuer is the name authenticator apps show next to the account.
+	totpIssuer = "disco-bouncer"
+
+	// totpChallengeTimeout is how long an admin has to enter a two-factor code after entering
+	// their password.
+	totpChallengeTimeout = 5 * time.Minute
+
+	// maxTOTPAttempts is the number of wrong two-factor codes allowed per password check.
+	maxTOTPAttempts = 5
+
+	recoveryCodeCount = 10
+)
+
+// startTOTPChallenge marks the session as waiting for a two-factor code from the admin, who has
+// already entered the correct password.
+func startTOTPChallenge(l log.Logger, c *fiber.Ctx, sess *session.Session, admin *db.Admin) error {
+	sess.Delete("admin_id")
+	sess.Delete("username")
+	sess.Set("pending_admin_id", admin.ID)
+	sess.Set("pending_until", time.Now().Add(totpChallengeTimeout).Unix())
+	sess.Set("pending_attempts", 0)
+	err := sess.Save()
+	if err != nil {
+		return serverError(l, c, "Failed to save session", HiddenError{err})
+	}
+
+	requestLogger(l, c).Debug("msg", "two-factor code required", "user", admin.Username)
+
+	return sendError(c, http.StatusUnauthorized, api.CodeTOTPRequired, "Two-factor code required")
+}
+
+// LoginTOTP finishes logging in an admin with two-factor authentication, after Login has checked
+// their password. The code can be from the authenticator app or a recovery code.
+func LoginTOTP(
+	l log.Logger, table db.AdminStore, box *SecretBox, guard *LoginGuard, sessions *Sessions,
+) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input api.TOTPCode
+		err := c.BodyParser(&input)
+		if err != nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+				fmt.Sprintf("Failed to parse body: %v", err))
+		}
+
+		sess, err := sessions.store.Get(c)
+		if err != nil {
+			return serverError(l, c, "Failed to get session", HiddenError{err})
+		}
+
+		id, ok := sess.Get("pending_admin_id").(int)
+		until, _ := sess.Get("pending_until").(int64)
+		attempts, _ := sess.Get("pending_attempts").(int)
+		if !ok || time.Now().Unix() > until || attempts >= maxTOTPAttempts {
+			return sendError(c, http.StatusUnauthorized, api.CodeUnauthenticated,
+				"No pending login; log in with your password again")
+		}
+
+		admin, err := table.GetAdminByID(c.Context(), id)
+		if err != nil {
+			if errors.Is(err, db.ErrNoUser) {
+				return sendError(c, http.StatusUnauthorized, api.CodeUnauthenticated,
+					"Account deleted")
+			}
+
+			return serverError(l, c, "Failed to get admin", err)
+		}
+		if admin.LockedAt != nil {
+			return sendLocked(c)
+		}
+
+		ok, err = checkSecondFactor(c, table, box, admin.Username, input.Code)
+		if err != nil {
+			return serverError(l, c, "Failed to check two-factor code", HiddenError{err})
+		}
+		if !ok {
+			requestLogger(l, c).Info("msg", "invalid two-factor code", "user", admin.Username)
+
+			err = guard.fail(c, admin.Username, db.FailureBadTOTP)
+			if err != nil {
+				return serverError(l, c, "Failed to record login failure", err)
+			}
+
+			sess.Set("pending_attempts", attempts+1)
+			err = sess.Save()
+			if err != nil {
+				return serverError(l, c, "Failed to save session", HiddenError{err})
+			}
+
+			return sendError(c, http.StatusUnauthorized, api.CodeInvalidCredentials,
+				"Invalid two-factor code")
+		}
+
+		return completeLogin(l, c, table, guard, sessions, sess, admin)
+	}
+}
+
+// checkSecondFactor checks a code from the admin's authenticator app, or one of their recovery
+// codes. Each code is only accepted once.
+func checkSecondFactor(
+	c *fiber.Ctx, table db.AdminStore, box *SecretBox, username, code string,
+) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != totp.Digits {
+		return table.UseRecoveryCode(c.Context(), username, hashRecoveryCode(code))
+	}
+	if box == nil {
+		return false, errors.New("no TOTP key configured")
+	}
+
+	state, err := table.GetTOTP(c.Context(), username)
+	if err != nil {
+		return false, err
+	}
+	secret, err := box.Open(state.Secret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok, err := totp.Verify(secret, code, time.Now())
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return table.UseTOTPStep(c.Context(), username, step)
+}
+
+// EnrollTOTP generates a new TOTP secret for the logged-in admin and sends it. Two-factor
+// authentication is not required until the admin sends a valid code to ConfirmTOTP.
+func EnrollTOTP(l log.Logger, table db.AdminStore, box *SecretBox) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if box == nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+				"Two-factor authentication is not configured on this server")
+		}
+
+		username := getPrincipal(c).username
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return serverError(l, c, "Failed to generate secret", HiddenError{err})
+		}
+		sealed, err := box.Seal(secret)
+		if err != nil {
+			return serverError(l, c, "Failed to encrypt secret", HiddenError{err})
+		}
+
+		err = table.StartTOTP(c.Context(), username, sealed)
+		if err != nil {
+			if errors.Is(err, db.ErrTOTPEnabled) {
+				return sendError(c, http.StatusConflict, api.CodeConflict,
+					"Two-factor authentication is already enabled")
+			}
+
+			return serverError(l, c, "Database error", err)
+		}
+
+		setAuditSecrets(c, username, "totp_secret")
+
+		return c.JSON(api.TOTPEnrollment{
+			Secret: secret,
+			URI:    totp.URI(totpIssuer, username, secret),
+		})
+	}
+}
+
+// ConfirmTOTP enables two-factor authentication for the logged-in admin once they send a valid code
+// for the secret from EnrollTOTP, and sends their recovery codes.
+func ConfirmTOTP(l log.Logger, table db.AdminStore, box *SecretBox) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if box == nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+				"Two-factor authentication is not configured on this server")
+		}
+
+		var input api.TOTPCode
+		err := c.BodyParser(&input)
+		if err != nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, err.Error())
+		}
+
+		username := getPrincipal(c).username
+
+		state, err := table.GetTOTP(c.Context(), username)
+		if err != nil {
+			return serverError(l, c, "Database error", err)
+		}
+		if state.Enabled {
+			return sendError(c, http.StatusConflict, api.CodeConflict,
+				"Two-factor authentication is already enabled")
+		}
+		if state.Secret == "" {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+				"Start enrollment before confirming it")
+		}
+
+		secret, err := box.Open(state.Secret)
+		if err != nil {
+			return serverError(l, c, "Failed to decrypt secret", HiddenError{err})
+		}
+		step, ok, err := totp.Verify(secret, input.Code, time.Now())
+		if err != nil {
+			return serverError(l, c, "Failed to check code", HiddenError{err})
+		}
+		if !ok {
+			return sendError(c, http.StatusBadRequest, api.CodeInvalidCredentials,
+				"Invalid two-factor code")
+		}
+
+		codes, hashes, err := generateRecoveryCodes()
+		if err != nil {
+			return serverError(l, c, "Failed to generate recovery codes", HiddenError{err})
+		}
+
+		err = table.EnableTOTP(c.Context(), username, hashes)
+		if err != nil {
+			return serverError(l, c, "Database error", err)
+		}
+		_, err = table.UseTOTPStep(c.Context(), username, step)
+		if err != nil {
+			return serverError(l, c, "Database error", err)
+		}
+
+		setAudit(c, username, map[string]bool{"totp_enabled": false},
+			map[string]bool{"totp_enabled": true})
+
+		return c.JSON(api.RecoveryCodes{Codes: codes})
+	}
+}
+
+// DisableTOTP turns off two-factor authentication for the logged-in admin, who must send a valid
+// code or recovery code.
+func DisableTOTP(l log.Logger, table db.AdminStore, box *SecretBox) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var input api.TOTPCode
+		err := c.BodyParser(&input)
+		if err != nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, err.Error())
+		}
+
+		username := getPrincipal(c).username
+
+		state, err := table.GetTOTP(c.Context(), username)
+		if err != nil {
+			return serverError(l, c, "Database error", err)
+		}
+		if !state.Enabled {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+				"Two-factor authentication is not enabled")
+		}
+
+		ok, err := checkSecondFactor(c, table, box, username, input.Code)
+		if err != nil {
+			return serverError(l, c, "Failed to check two-factor code", HiddenError{err})
+		}
+		if !ok {
+			requestLogger(l, c).Info("msg", "invalid two-factor code", "user", username)
+
+			return sendError(c, http.StatusBadRequest, api.CodeInvalidCredentials,
+				"Invalid two-factor code")
+		}
+
+		err = table.ResetTOTP(c.Context(), username)
+		if err != nil {
+			return serverError(l, c, "Database error", err)
+		}
+
+		setAudit(c, username, map[string]bool{"totp_enabled": true},
+			map[string]bool{"totp_enabled": false})
+
+		return c.SendString("Two-factor authentication disabled")
+	}
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateRecoveryCodes returns new recovery codes to show to the admin, and their hashes to store.
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	for range recoveryCodeCount {
+		b := make([]byte, 7)
+		_, err = rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		s := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		code := s[:5] + "-" + s[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode normalizes and hashes a recovery code. Recovery codes are random enough that a
+// fast hash is fine.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,53 @@
+package server_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kylrth/disco-bouncer/internal/server"
+)
+
+func TestSecretBox(t *testing.T) {
+	t.Parallel()
+
+	box, err := server.NewSecretBox(strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sealed, err := box.Seal("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatalf("unexpected error sealing: %v", err)
+	}
+	if strings.Contains(sealed, "JBSWY3DPEHPK3PXP") {
+		t.Errorf("sealed secret contains the plain secret: %s", sealed)
+	}
+
+	opened, err := box.Open(sealed)
+	if err != nil {
+		t.Fatalf("unexpected error opening: %v", err)
+	}
+	if opened != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("expected original secret, got %q", opened)
+	}
+
+	other, err := server.NewSecretBox(strings.Repeat("cd", 32))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = other.Open(sealed)
+	if err == nil {
+		t.Error("expected error opening with the wrong key")
+	}
+}
+
+func TestNewSecretBox_BadKey(t *testing.T) {
+	t.Parallel()
+
+	for _, key := range []string{"", "nothex", strings.Repeat("ab", 16)} {
+		_, err := server.NewSecretBox(key)
+		if err == nil {
+			t.Errorf("expected error for key %q", key)
+		}
+	}
+}
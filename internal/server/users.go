@@ -4,127 +4,298 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/cobaltspeech/log"
 	"github.com/gofiber/fiber/v2"
 	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 )
 
-func AddCRUDHandlers(l log.Logger, app *fiber.App, table *db.UserTable) {
-	app.Get("/api/users", GetAllUsers(l, table))
-	app.Get("/api/users/:id", GetUser(l, table))
-	app.Post("/api/users", CreateUser(l, table))
-	app.Put("/api/users/:id", UpdateUser(l, table))
-	app.Delete("/api/users/:id", DeleteUser(l, table))
+func AddCRUDHandlers(l log.Logger, app *fiber.App, table db.UserStore, v *UserValidator) {
+	read := RequireScope(l, api.ScopeUsersRead)
+	create := RequireScope(l, api.ScopeUsersCreate)
+	update := RequireScope(l, api.ScopeUsersUpdate)
+	del := RequireScope(l, api.ScopeUsersDelete)
+
+	app.Get("/api/users", read, GetAllUsers(l, table))
+	app.Get("/api/users/:id", read, GetUser(l, table))
+	app.Post("/api/users", create, CreateUser(l, table, v))
+	app.Put("/api/users/:id", update, UpdateUser(l, table, v))
+	app.Patch("/api/users/:id", update, PatchUser(l, table, v))
+	app.Delete("/api/users/:id", del, DeleteUser(l, table))
 }
 
-// GetAllUsers sends the entire users table, possibly filtered by provided query parameters.
-func GetAllUsers(l log.Logger, table *db.UserTable) fiber.Handler {
+// GetAllUsers sends the entire users table, possibly filtered by provided query parameters. The
+// "after" and "limit" parameters can be used to request the table one page at a time; a limit above
+// api.MaxUserLimit is rejected.
+func GetAllUsers(l log.Logger, table db.UserStore) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// get filter values
-		keyHash := c.Query("keyHash", "")
+		query, err := url.ParseQuery(string(c.Request().URI().QueryString()))
+		if err != nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+				fmt.Sprintf("Invalid query: %v", err))
+		}
+		f, err := api.ParseUserFilter(query)
+		if err != nil {
+			requestLogger(l, c).Debug("msg", "invalid query parameter", "error", err)
+
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+				fmt.Sprintf("Invalid query: %v", err))
+		}
 
-		users, err := table.GetUsers(c.Context(), db.WithKeyHash(keyHash))
+		users, err := table.GetUsers(c.Context(),
+			db.WithKeyHash(f.KeyHash),
+			db.WithAfterID(f.AfterID),
+			db.WithLimit(f.Limit),
+		)
 		if err != nil {
 			return serverError(l, c, "Database error", err)
 		}
 
-		return c.JSON(users)
+		out := make([]*api.User, len(users))
+		for i, u := range users {
+			out[i] = u.API()
+		}
+
+		return c.JSON(out)
 	}
 }
 
 // GetUser sends the information of the user with the specified ID.
-func GetUser(l log.Logger, table *db.UserTable) fiber.Handler {
+func GetUser(l log.Logger, table db.UserStore) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id, err := strconv.Atoi(c.Params("id"))
 		if err != nil {
-			l.Debug("msg", "invalid ID", "error", err, "id", c.Params("id"))
+			requestLogger(l, c).Debug("msg", "invalid ID", "error", err, "id", c.Params("id"))
 
-			return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("Invalid ID: %v", err))
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+				fmt.Sprintf("Invalid ID: %v", err))
 		}
 
 		u, err := table.GetUser(c.Context(), id)
 		if err != nil {
 			if errors.Is(err, db.ErrNoUser) {
-				return c.Status(http.StatusNotFound).SendString("User not found")
+				return sendError(c, http.StatusNotFound, api.CodeNotFound, "User not found")
 			}
 
 			return serverError(l, c, "Database error", err)
 		}
 
-		return c.JSON(u)
+		c.Set(fiber.HeaderETag, etag(u.Version))
+
+		return c.JSON(u.API())
 	}
 }
 
-// CreateUser creates a new user and returns the ID.
-func CreateUser(l log.Logger, table *db.UserTable) fiber.Handler {
+// etag formats a user version as an entity tag.
+func etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+var errBadIfMatch = errors.New(
+	`the If-Match header must be "*" or a single entity tag from a user`)
+
+// ifMatch returns the write options for the If-Match header of the request, if any. An entity tag
+// of "*" only requires that the user exists, which is already required for every write.
+func ifMatch(c *fiber.Ctx) ([]db.WriteOption, error) {
+	header := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+
+	tag, err := strconv.Unquote(strings.TrimPrefix(header, "W/"))
+	if err != nil {
+		return nil, errBadIfMatch
+	}
+	version, err := strconv.Atoi(tag)
+	if err != nil || version <= 0 {
+		return nil, errBadIfMatch
+	}
+
+	return []db.WriteOption{db.IfVersion(version)}, nil
+}
+
+// writeError sends the response for an error from a write to an existing user.
+func writeError(l log.Logger, c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, db.ErrNoUser):
+		return sendError(c, http.StatusNotFound, api.CodeNotFound, "User not found")
+	case errors.Is(err, db.ErrVersionMismatch):
+		return sendError(c, http.StatusPreconditionFailed, api.CodePreconditionFailed,
+			"User has been modified since it was read")
+	case errors.Is(err, db.ErrInvalidUser):
+		return sendError(c, http.StatusBadRequest, api.CodeValidation, err.Error())
+	default:
+		return serverError(l, c, "Database error", err)
+	}
+}
+
+// CreateUser validates and creates a new user and returns the ID.
+func CreateUser(l log.Logger, table db.UserStore, v *UserValidator) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var user db.User
+		var user api.User
 		err := c.BodyParser(&user)
 		if err != nil {
-			return c.Status(http.StatusBadRequest).SendString(err.Error())
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, err.Error())
 		}
 
-		user.ID, err = table.CreateUser(c.Context(), &user)
+		if problems := v.Validate(&user); len(problems) > 0 {
+			requestLogger(l, c).Debug("msg", "user failed validation", "fields", fieldNames(problems))
+
+			return sendValidationError(c, problems)
+		}
+
+		user.ID, err = table.CreateUser(c.Context(), db.UserFromAPI(&user))
 		if err != nil {
+			if errors.Is(err, db.ErrInvalidUser) {
+				return sendError(c, http.StatusBadRequest, api.CodeValidation, err.Error())
+			}
+
 			return serverError(l, c, "Database error", err)
 		}
 
+		setAudit(c, strconv.Itoa(user.ID), nil, &user)
+
 		return c.JSON(&user)
 	}
 }
 
-// UpdateUser updates the information for a user.
-func UpdateUser(l log.Logger, table *db.UserTable) fiber.Handler {
+// UpdateUser validates and replaces the information for a user. If the If-Match header is set, the
+// update only happens if the user has not changed since that version.
+func UpdateUser(l log.Logger, table db.UserStore, v *UserValidator) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id, err := strconv.Atoi(c.Params("id"))
 		if err != nil {
-			l.Debug("msg", "invalid ID", "error", err, "id", c.Params("id"))
+			requestLogger(l, c).Debug("msg", "invalid ID", "error", err, "id", c.Params("id"))
 
-			return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("Invalid ID: %v", err))
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+				fmt.Sprintf("Invalid ID: %v", err))
+		}
+		opts, err := ifMatch(c)
+		if err != nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, err.Error())
 		}
 
-		var user db.User
+		var user api.User
 		err = c.BodyParser(&user)
 		if err != nil {
-			return c.Status(http.StatusBadRequest).SendString(err.Error())
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, err.Error())
 		}
 
 		user.ID = id
 
-		err = table.UpdateUser(c.Context(), &user)
+		if problems := v.Validate(&user); len(problems) > 0 {
+			requestLogger(l, c).Debug("msg", "user failed validation", "id", id,
+				"fields", fieldNames(problems))
+
+			return sendValidationError(c, problems)
+		}
+
+		before, err := table.GetUser(c.Context(), id)
 		if err != nil {
-			if errors.Is(err, db.ErrNoUser) {
-				return c.Status(http.StatusNotFound).SendString("User not found")
-			}
+			return writeError(l, c, err)
+		}
 
-			return serverError(l, c, "Database error", err)
+		u := db.UserFromAPI(&user)
+		err = table.UpdateUser(c.Context(), u, opts...)
+		if err != nil {
+			return writeError(l, c, err)
 		}
 
-		return c.JSON(user)
+		setAudit(c, strconv.Itoa(id), before.API(), u.API())
+
+		c.Set(fiber.HeaderETag, etag(u.Version))
+
+		return c.JSON(u.API())
 	}
 }
 
-// DeleteUser removes a user by ID.
-func DeleteUser(l log.Logger, table *db.UserTable) fiber.Handler {
+// PatchUser validates and changes only the provided fields of a user, and sends the updated user.
+// If the If-Match header is set, the patch only happens if the user has not changed since that
+// version.
+func PatchUser(l log.Logger, table db.UserStore, v *UserValidator) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id, err := strconv.Atoi(c.Params("id"))
 		if err != nil {
-			l.Debug("msg", "invalid ID", "error", err, "id", c.Params("id"))
+			requestLogger(l, c).Debug("msg", "invalid ID", "error", err, "id", c.Params("id"))
 
-			return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("Invalid ID: %v", err))
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+				fmt.Sprintf("Invalid ID: %v", err))
+		}
+		opts, err := ifMatch(c)
+		if err != nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, err.Error())
 		}
 
-		err = table.DeleteUser(c.Context(), id)
+		var patch api.UserPatch
+		err = c.BodyParser(&patch)
 		if err != nil {
-			if errors.Is(err, db.ErrNoUser) {
-				return c.Status(http.StatusNotFound).SendString("User not found")
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, err.Error())
+		}
+
+		if problems := v.ValidatePatch(&patch); len(problems) > 0 {
+			requestLogger(l, c).Debug("msg", "patch failed validation", "id", id,
+				"fields", fieldNames(problems))
+
+			return sendValidationError(c, problems)
+		}
+
+		before, err := table.GetUser(c.Context(), id)
+		if err != nil {
+			return writeError(l, c, err)
+		}
+
+		u, err := table.PatchUser(c.Context(), id, db.PatchFromAPI(&patch), opts...)
+		if err != nil {
+			if errors.Is(err, db.ErrEmptyPatch) {
+				return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+					"Patch must set at least one field")
 			}
 
-			return serverError(l, c, "Database error", err)
+			return writeError(l, c, err)
 		}
 
+		setAudit(c, strconv.Itoa(id), before.API(), u.API())
+
+		c.Set(fiber.HeaderETag, etag(u.Version))
+
+		return c.JSON(u.API())
+	}
+}
+
+// DeleteUser removes a user by ID. If the If-Match header is set, the user is only removed if it
+// has not changed since that version.
+func DeleteUser(l log.Logger, table db.UserStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			requestLogger(l, c).Debug("msg", "invalid ID", "error", err, "id", c.Params("id"))
+
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest,
+				fmt.Sprintf("Invalid ID: %v", err))
+		}
+
+		opts, err := ifMatch(c)
+		if err != nil {
+			return sendError(c, http.StatusBadRequest, api.CodeBadRequest, err.Error())
+		}
+
+		before, err := table.GetUser(c.Context(), id)
+		if err != nil {
+			return writeError(l, c, err)
+		}
+
+		err = table.DeleteUser(c.Context(), id, opts...)
+		if err != nil {
+			return writeError(l, c, err)
+		}
+
+		setAudit(c, strconv.Itoa(id), before.API(), nil)
+
 		return c.SendString("User deleted successfully")
 	}
 }
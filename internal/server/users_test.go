@@ -0,0 +1,125 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cobaltspeech/log/pkg/testinglog"
+	"github.com/google/go-cmp/cmp"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"github.com/kylrth/disco-bouncer/pkg/client"
+)
+
+func TestCRUDHandlers(t *testing.T) { //nolint:cyclop,funlen // tests every handler in turn
+	t.Parallel()
+
+	l := testinglog.NewConvenientLogger(t, testinglog.WithFieldIgnoreFunc(ignoreIDs))
+	t.Cleanup(l.Done)
+
+	ctx := context.Background()
+
+	_, baseURL := setupMemoryServer(t, l)
+
+	c, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	_, err = c.Users.GetAllUsers(ctx)
+	if !errors.Is(err, client.ErrNotLoggedIn) {
+		t.Errorf("expected ErrNotLoggedIn before logging in, got %v", err)
+	}
+
+	err = c.Admin.Login(ctx, testUser, testPass)
+	if err != nil {
+		t.Fatalf("failed to login: %v", err)
+	}
+
+	_, err = c.Users.CreateUser(ctx, &api.User{Name: "John Doe", FinishYear: "0"})
+	if !errors.Is(err, client.ErrValidation) {
+		t.Errorf("expected ErrValidation creating invalid user, got %v", err)
+	}
+
+	u1 := encryptedUser(t, "John Doe", "2021")
+	u2 := encryptedUser(t, "Jason Mendoza", "2019")
+	u1.ID, err = c.Users.CreateUser(ctx, &u1)
+	if err != nil {
+		t.Fatalf("failed to create user1: %v", err)
+	}
+	u2.ID, err = c.Users.CreateUser(ctx, &u2)
+	if err != nil {
+		t.Fatalf("failed to create user2: %v", err)
+	}
+	u1.Version, u2.Version = 1, 1
+
+	got, err := c.Users.GetUser(ctx, u1.ID)
+	if err != nil {
+		t.Errorf("failed to get user1: %v", err)
+	}
+	if diff := cmp.Diff(&u1, got); diff != "" {
+		t.Error("unexpected user (-want +got):\n" + diff)
+	}
+
+	u1.TA = true
+	err = c.Users.UpdateUser(ctx, &u1, client.IfMatch(u1.Version))
+	if err != nil {
+		t.Fatalf("failed to update user1: %v", err)
+	}
+	if u1.Version != 2 {
+		t.Errorf("expected version 2 after update, got %d", u1.Version)
+	}
+	err = c.Users.UpdateUser(ctx, &u1, client.IfMatch(1))
+	if !errors.Is(err, client.ErrPreconditionFailed) {
+		t.Errorf("expected ErrPreconditionFailed updating with stale version, got %v", err)
+	}
+
+	year := "2020"
+	patched, err := c.Users.Patch(ctx, u2.ID, &api.UserPatch{FinishYear: &year})
+	if err != nil {
+		t.Fatalf("failed to patch user2: %v", err)
+	}
+	u2.FinishYear, u2.Version = year, 2
+	if diff := cmp.Diff(&u2, patched); diff != "" {
+		t.Error("unexpected patched user (-want +got):\n" + diff)
+	}
+
+	users, err := c.Users.GetAllUsers(ctx)
+	if err != nil {
+		t.Errorf("failed to get users: %v", err)
+	}
+	if diff := cmp.Diff([]*api.User{&u1, &u2}, users); diff != "" {
+		t.Error("unexpected users (-want +got):\n" + diff)
+	}
+
+	err = c.Users.DeleteUser(ctx, u2.ID, client.IfMatch(u2.Version))
+	if err != nil {
+		t.Fatalf("failed to delete user2: %v", err)
+	}
+
+	// every handler reports a missing user the same way
+	_, err = c.Users.GetUser(ctx, u2.ID)
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("expected ErrNotFound getting deleted user, got %v", err)
+	}
+	err = c.Users.UpdateUser(ctx, &u2)
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("expected ErrNotFound updating deleted user, got %v", err)
+	}
+	_, err = c.Users.Patch(ctx, u2.ID, &api.UserPatch{FinishYear: &year})
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("expected ErrNotFound patching deleted user, got %v", err)
+	}
+	err = c.Users.DeleteUser(ctx, u2.ID)
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting deleted user, got %v", err)
+	}
+
+	users, err = c.Users.GetAllUsers(ctx)
+	if err != nil {
+		t.Errorf("failed to get users: %v", err)
+	}
+	if diff := cmp.Diff([]*api.User{&u1}, users); diff != "" {
+		t.Error("unexpected users (-want +got):\n" + diff)
+	}
+}
@@ -1,10 +1,13 @@
 package server
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/cobaltspeech/log"
 	"github.com/gofiber/fiber/v2"
+	"github.com/kylrth/disco-bouncer/internal/logging"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 )
 
 // HiddenError signifies that an error should be logged but not reported to the client.
@@ -13,11 +16,50 @@ type HiddenError struct {
 }
 
 func serverError(l log.Logger, c *fiber.Ctx, msg string, err error) error {
-	l.Error("msg", "internal server error", "message", msg, "error", err)
+	requestLogger(l, c).Error("msg", "internal server error", "message", msg, "error", err)
 
 	if _, ok := err.(HiddenError); !ok { //nolint:errorlint // just checking top error type
 		msg += ": " + err.Error()
 	}
 
-	return c.Status(http.StatusInternalServerError).SendString(msg)
+	return sendError(c, http.StatusInternalServerError, api.CodeInternal, msg)
+}
+
+// sendError responds with the JSON error envelope.
+func sendError(c *fiber.Ctx, status int, code api.Code, msg string) error {
+	return c.Status(status).JSON(api.ErrorResponse{Error: &api.Error{
+		Code:      code,
+		Message:   msg,
+		RequestID: requestID(c),
+	}})
+}
+
+// requestID returns the ID assigned to this request by logging.RequestIDMiddleware, if it is in
+// use.
+func requestID(c *fiber.Ctx) string {
+	return logging.RequestID(c.Context())
+}
+
+// requestLogger returns l with the request ID added to every message, so that the messages logged
+// while handling a request can be found together.
+func requestLogger(l log.Logger, c *fiber.Ctx) log.Logger {
+	return logging.FromContext(l, c.Context())
+}
+
+// ErrorHandler returns a fiber.ErrorHandler that sends errors returned by handlers (for example,
+// when no route matches the request) in the JSON error envelope. Errors other than *fiber.Error are
+// logged and reported as a generic internal server error, because they may contain details the
+// client shouldn't see.
+func ErrorHandler(l log.Logger) fiber.ErrorHandler {
+	return func(c *fiber.Ctx, err error) error {
+		var fErr *fiber.Error
+		if errors.As(err, &fErr) {
+			return sendError(c, fErr.Code, api.CodeForStatus(fErr.Code), fErr.Message)
+		}
+
+		requestLogger(l, c).Error("msg", "unhandled error", "error", err)
+
+		return sendError(c, http.StatusInternalServerError, api.CodeInternal,
+			"Internal server error")
+	}
 }
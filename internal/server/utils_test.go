@@ -0,0 +1,68 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cobaltspeech/log/pkg/testinglog"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/go-cmp/cmp"
+	"github.com/kylrth/disco-bouncer/internal/server"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+func TestErrorHandler(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		err        error
+		wantStatus int
+		want       api.Error
+	}
+	tests := map[string]testCase{
+		"fiber error": {
+			err:        fiber.NewError(http.StatusTeapot, "short and stout"),
+			wantStatus: http.StatusTeapot,
+			want:       api.Error{Code: api.CodeForStatus(http.StatusTeapot), Message: "short and stout"},
+		},
+		"other error": {
+			err:        errors.New("dial tcp 10.0.0.5:5432: connection refused"),
+			wantStatus: http.StatusInternalServerError,
+			want:       api.Error{Code: api.CodeInternal, Message: "Internal server error"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			l := testinglog.NewConvenientLogger(t)
+			app := fiber.New(fiber.Config{ErrorHandler: server.ErrorHandler(l)})
+			app.Get("/", func(*fiber.Ctx) error { return tc.err })
+
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+			if err != nil {
+				t.Fatalf("failed to send request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.wantStatus {
+				t.Errorf("expected status %d, got %d", tc.wantStatus, resp.StatusCode)
+			}
+			var got api.ErrorResponse
+			err = json.NewDecoder(resp.Body).Decode(&got)
+			if err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if got.Error == nil {
+				t.Fatal("expected an error in the response")
+			}
+			if diff := cmp.Diff(tc.want, *got.Error); diff != "" {
+				t.Errorf("unexpected error (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"github.com/kylrth/disco-bouncer/pkg/encrypt"
+)
+
+// CohortChecker reports whether a finish year has a cohort role on Discord. ok is false if the
+// check can't be performed yet, for example because the bot hasn't discovered the guild info.
+type CohortChecker interface {
+	KnownCohort(year string) (known, ok bool)
+}
+
+// UserValidator checks user records before they are stored.
+type UserValidator struct {
+	// Cohorts is used to check that finish years match a cohort role. If nil, only the format of
+	// the finish year is checked.
+	Cohorts CohortChecker
+}
+
+var finishYearMatcher = regexp.MustCompile(`^\d{4}`)
+
+// Validate returns the problems with the user's fields. The ID and version are not checked.
+func (v *UserValidator) Validate(u *api.User) []api.FieldError {
+	var out []api.FieldError
+
+	out = append(out, validateName(u.Name)...)
+	out = append(out, validateKeyHash(u.NameKeyHash)...)
+	out = append(out, v.validateFinishYear(u.FinishYear)...)
+
+	return out
+}
+
+// ValidatePatch returns the problems with the fields set in the patch.
+func (v *UserValidator) ValidatePatch(p *api.UserPatch) []api.FieldError {
+	var out []api.FieldError
+
+	if p.Name != nil {
+		out = append(out, validateName(*p.Name)...)
+	}
+	if p.NameKeyHash != nil {
+		out = append(out, validateKeyHash(*p.NameKeyHash)...)
+	}
+	if p.FinishYear != nil {
+		out = append(out, v.validateFinishYear(*p.FinishYear)...)
+	}
+
+	return out
+}
+
+func validateName(name string) []api.FieldError {
+	const field = "name"
+
+	switch {
+	case name == "":
+		return []api.FieldError{{Field: field, Message: "must not be empty"}}
+	case encrypt.CheckCiphertext(name) != nil:
+		return []api.FieldError{{
+			Field: field, Message: "must be a hex-encoded ciphertext produced by encrypting the name",
+		}}
+	}
+
+	return nil
+}
+
+func validateKeyHash(hash string) []api.FieldError {
+	const field = "name_key_hash"
+
+	switch {
+	case hash == "":
+		return []api.FieldError{{Field: field, Message: "must not be empty"}}
+	case !isHex(hash):
+		return []api.FieldError{{Field: field, Message: "must be hex-encoded"}}
+	}
+
+	return nil
+}
+
+func (v *UserValidator) validateFinishYear(year string) []api.FieldError {
+	const field = "finish_year"
+
+	switch {
+	case year == "":
+		// pre-core or professor
+		return nil
+	case year == "0" || year == "-1":
+		return []api.FieldError{{
+			Field: field, Message: "pre-core users should have an empty finish year",
+		}}
+	case !finishYearMatcher.MatchString(year):
+		return []api.FieldError{{Field: field, Message: "must start with 4 digits"}}
+	}
+
+	if v.Cohorts == nil {
+		return nil
+	}
+	if known, ok := v.Cohorts.KnownCohort(year); ok && !known {
+		return []api.FieldError{{Field: field, Message: "does not match a cohort role on Discord"}}
+	}
+
+	return nil
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+
+	return err == nil
+}
+
+// fieldNames lists the fields with problems, for logging.
+func fieldNames(problems []api.FieldError) string {
+	names := make([]string, len(problems))
+	for i, p := range problems {
+		names[i] = p.Field
+	}
+
+	return strings.Join(names, ",")
+}
+
+// sendValidationError responds with the field errors in the JSON error envelope.
+func sendValidationError(c *fiber.Ctx, problems []api.FieldError) error {
+	msgs := make([]string, len(problems))
+	for i, p := range problems {
+		msgs[i] = p.Error()
+	}
+
+	return c.Status(http.StatusBadRequest).JSON(api.ErrorResponse{Error: &api.Error{
+		Code:      api.CodeValidation,
+		Message:   "Invalid user: " + strings.Join(msgs, "; "),
+		RequestID: requestID(c),
+		Details:   problems,
+	}})
+}
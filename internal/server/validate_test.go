@@ -0,0 +1,92 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/kylrth/disco-bouncer/internal/server"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+type fakeCohorts map[string]bool
+
+func (f fakeCohorts) KnownCohort(year string) (known, ok bool) {
+	if f == nil {
+		return false, false
+	}
+
+	return f[year], true
+}
+
+func TestUserValidator(t *testing.T) {
+	t.Parallel()
+
+	valid := encryptedUser(t, "Jane Doe", "2022")
+
+	type testCase struct {
+		cohorts fakeCohorts
+		mod     func(u *api.User)
+		want    []string
+	}
+	tests := map[string]testCase{
+		"valid":         {nil, func(*api.User) {}, nil},
+		"preCore":       {nil, func(u *api.User) { u.FinishYear = "" }, nil},
+		"winterCohort":  {fakeCohorts{"2026w": true}, func(u *api.User) { u.FinishYear = "2026w" }, nil},
+		"noBot":         {nil, func(u *api.User) { u.FinishYear = "2099" }, nil},
+		"unknownCohort": {fakeCohorts{}, func(*api.User) {}, []string{"finish_year"}},
+		"zeroYear":      {nil, func(u *api.User) { u.FinishYear = "0" }, []string{"finish_year"}},
+		"shortYear":     {nil, func(u *api.User) { u.FinishYear = "22" }, []string{"finish_year"}},
+		"plainName":     {nil, func(u *api.User) { u.Name = "Jane Doe" }, []string{"name"}},
+		"emptyName":     {nil, func(u *api.User) { u.Name = "" }, []string{"name"}},
+		"badHash":       {nil, func(u *api.User) { u.NameKeyHash = "xyz" }, []string{"name_key_hash"}},
+		"oddHash":       {nil, func(u *api.User) { u.NameKeyHash = "abc" }, []string{"name_key_hash"}},
+		"everything": {
+			nil,
+			func(u *api.User) { *u = api.User{FinishYear: "-1"} },
+			[]string{"name", "name_key_hash", "finish_year"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			u := valid
+			tc.mod(&u)
+
+			v := server.UserValidator{}
+			if tc.cohorts != nil {
+				v.Cohorts = tc.cohorts
+			}
+
+			got := make([]string, 0)
+			for _, p := range v.Validate(&u) {
+				got = append(got, p.Field)
+			}
+			if diff := cmp.Diff(tc.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Error("unexpected invalid fields (-want +got):\n" + diff)
+			}
+		})
+	}
+}
+
+func TestUserValidator_ValidatePatch(t *testing.T) {
+	t.Parallel()
+
+	v := server.UserValidator{Cohorts: fakeCohorts{"2022": true}}
+
+	year := "2022"
+	if problems := v.ValidatePatch(&api.UserPatch{FinishYear: &year}); len(problems) != 0 {
+		t.Errorf("unexpected problems with valid patch: %v", problems)
+	}
+
+	name, badYear := "Jane Doe", "1999"
+	got := make([]string, 0)
+	for _, p := range v.ValidatePatch(&api.UserPatch{Name: &name, FinishYear: &badYear}) {
+		got = append(got, p.Field)
+	}
+	if diff := cmp.Diff([]string{"name", "finish_year"}, got); diff != "" {
+		t.Error("unexpected invalid fields (-want +got):\n" + diff)
+	}
+}
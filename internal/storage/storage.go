@@ -0,0 +1,301 @@
+// Package storage opens the database named by the database URL. Postgres is used for postgres://
+// and postgresql:// URLs, and an embedded SQLite file for sqlite:// URLs.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cobaltspeech/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/storage/postgres/v2"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres" // registers postgres:// for migrations
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/db/memory"
+	"github.com/kylrth/disco-bouncer/internal/db/sqlite"
+)
+
+// These are the kinds of database. Memory is only used by tests, so it has no URL scheme.
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+	Memory   = "memory"
+)
+
+// ErrUnknownScheme is returned for database URLs that aren't for Postgres or SQLite.
+var ErrUnknownScheme = errors.New("unknown database URL scheme")
+
+// Kind returns Postgres or SQLite, depending on the scheme of the database URL.
+func Kind(dbURL string) (string, error) {
+	scheme, _, ok := strings.Cut(dbURL, "://")
+	if !ok {
+		return "", fmt.Errorf("%w: the URL must start with postgres:// or sqlite://",
+			ErrUnknownScheme)
+	}
+
+	switch strings.ToLower(scheme) {
+	case "postgres", "postgresql":
+		return Postgres, nil
+	case sqlite.Scheme:
+		return SQLite, nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnknownScheme, scheme)
+	}
+}
+
+// Migrate ensures all migrations are applied to the database. A SQLite database file is created if
+// it doesn't exist.
+func Migrate(dbURL string) error {
+	kind, err := Kind(dbURL)
+	if err != nil {
+		return err
+	}
+
+	if kind == SQLite {
+		return sqlite.ApplyMigrations(dbURL)
+	}
+
+	return db.ApplyMigrations(dbURL)
+}
+
+// MigrateTo migrates the database up or down to the version. Version 0 removes every table.
+func MigrateTo(dbURL string, version int) error {
+	kind, err := Kind(dbURL)
+	if err != nil {
+		return err
+	}
+
+	if kind == SQLite {
+		return sqlite.MigrateTo(dbURL, version)
+	}
+
+	return db.MigrateTo(dbURL, version)
+}
+
+// MigrateDown reverts the newest n migrations applied to the database.
+func MigrateDown(dbURL string, n int) error {
+	kind, err := Kind(dbURL)
+	if err != nil {
+		return err
+	}
+
+	if kind == SQLite {
+		return sqlite.MigrateDown(dbURL, n)
+	}
+
+	return db.MigrateDown(dbURL, n)
+}
+
+// Backend holds the tables of the database.
+type Backend struct {
+	Users    db.UserStore
+	Admins   db.AdminStore
+	Sessions db.SessionStore
+	Logins   db.LoginStore
+
+	// These are nil for the memory backend, which tests use for the handlers that don't need them.
+	Tokens     db.TokenStore
+	Audit      db.AuditStore
+	Identities db.OIDCStore
+
+	kind        string
+	sessionData func() fiber.Storage
+	ping        func(ctx context.Context) error
+	version     func(ctx context.Context) (int, bool, error)
+	latest      func() (int, error)
+	dump        func(ctx context.Context) (*db.Snapshot, error)
+	restore     func(ctx context.Context, s *db.Snapshot) (*db.RestoreSummary, error)
+	close       func()
+}
+
+// Open connects to the database. The migrations must already be applied.
+func Open(ctx context.Context, l log.Logger, dbURL string) (*Backend, error) {
+	kind, err := Kind(dbURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if kind == SQLite {
+		conn, err := sqlite.Open(dbURL)
+		if err != nil {
+			return nil, fmt.Errorf("open database: %w", err)
+		}
+
+		return SQLiteBackend(l, conn), nil
+	}
+
+	pool, err := pgxpool.New(ctx, dbURL)
+	if err != nil {
+		return nil, fmt.Errorf("connect to database: %w", err)
+	}
+
+	return PostgresBackend(l, pool), nil
+}
+
+// Connect applies the migrations, opens the database, and checks that it can be reached. Transient
+// errors, like those from a database that is still starting up, are retried according to p.
+func Connect(ctx context.Context, l log.Logger, dbURL string, p db.RetryPolicy) (*Backend, error) {
+	err := p.Do(ctx, l, "apply database migrations", func() error {
+		return Migrate(dbURL)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("apply database migrations: %w", err)
+	}
+
+	b, err := Open(ctx, l, dbURL)
+	if err != nil {
+		return nil, err
+	}
+	err = p.Do(ctx, l, "connect to database", func() error {
+		return b.Ping(ctx)
+	})
+	if err != nil {
+		b.Close()
+
+		return nil, fmt.Errorf("connect to database: %w", err)
+	}
+
+	return b, nil
+}
+
+// PostgresBackend uses the tables in the Postgres database. Closing the backend closes the pool.
+func PostgresBackend(l log.Logger, pool *pgxpool.Pool) *Backend {
+	return &Backend{
+		Users:    db.NewUserTable(l, pool),
+		Admins:   db.NewAdminTable(l, pool),
+		Sessions: db.NewSessionTable(l, pool),
+		Logins:   db.NewLoginTable(l, pool),
+
+		Tokens:     db.NewTokenTable(l, pool),
+		Audit:      db.NewAuditTable(l, pool),
+		Identities: db.NewOIDCTable(l, pool),
+
+		kind: Postgres,
+		sessionData: func() fiber.Storage {
+			return postgres.New(postgres.Config{
+				DB:    pool,
+				Table: "sessions",
+			})
+		},
+		ping: pool.Ping,
+		version: func(ctx context.Context) (int, bool, error) {
+			return db.MigrationVersion(ctx, pool)
+		},
+		latest: db.LatestMigration,
+		dump: func(ctx context.Context) (*db.Snapshot, error) {
+			return db.Dump(ctx, pool)
+		},
+		restore: func(ctx context.Context, s *db.Snapshot) (*db.RestoreSummary, error) {
+			return db.Restore(ctx, pool, s)
+		},
+		close: pool.Close,
+	}
+}
+
+// SQLiteBackend uses the tables in the SQLite database. Closing the backend closes conn.
+func SQLiteBackend(l log.Logger, conn *sql.DB) *Backend {
+	return &Backend{
+		Users:    sqlite.NewUserTable(l, conn),
+		Admins:   sqlite.NewAdminTable(l, conn),
+		Sessions: sqlite.NewSessionTable(l, conn),
+		Logins:   sqlite.NewLoginTable(l, conn),
+
+		Tokens:     sqlite.NewTokenTable(l, conn),
+		Audit:      sqlite.NewAuditTable(l, conn),
+		Identities: sqlite.NewOIDCTable(l, conn),
+
+		kind: SQLite,
+		sessionData: func() fiber.Storage {
+			return sqlite.NewSessionStorage(conn)
+		},
+		ping: conn.PingContext,
+		version: func(ctx context.Context) (int, bool, error) {
+			return sqlite.MigrationVersion(ctx, conn)
+		},
+		latest: sqlite.LatestMigration,
+		dump: func(ctx context.Context) (*db.Snapshot, error) {
+			return sqlite.Dump(ctx, conn)
+		},
+		restore: func(ctx context.Context, s *db.Snapshot) (*db.RestoreSummary, error) {
+			return sqlite.Restore(ctx, conn, s)
+		},
+		close: func() { conn.Close() }, //nolint:errcheck,gosec // nothing to do on shutdown
+	}
+}
+
+// MemoryBackend keeps the tables in memory, for tests. Its migration version is always zero, and
+// it can't be dumped or restored.
+func MemoryBackend(l log.Logger) *Backend {
+	d := memory.New()
+
+	return &Backend{
+		Users:    memory.NewUserTable(l, d),
+		Admins:   memory.NewAdminTable(l, d),
+		Sessions: memory.NewSessionTable(l, d),
+		Logins:   memory.NewLoginTable(l, d),
+
+		kind: Memory,
+		sessionData: func() fiber.Storage {
+			return memory.NewSessionStorage(d)
+		},
+		ping: func(context.Context) error { return nil },
+		version: func(context.Context) (int, bool, error) {
+			return 0, false, nil
+		},
+		latest: func() (int, error) { return 0, nil },
+		dump: func(context.Context) (*db.Snapshot, error) {
+			return nil, errors.ErrUnsupported
+		},
+		restore: func(context.Context, *db.Snapshot) (*db.RestoreSummary, error) {
+			return nil, errors.ErrUnsupported
+		},
+		close: func() {},
+	}
+}
+
+// Kind returns Postgres, SQLite, or Memory.
+func (b *Backend) Kind() string {
+	return b.kind
+}
+
+// SessionStorage returns storage for the contents of the admins' sessions, for the session
+// middleware.
+func (b *Backend) SessionStorage() fiber.Storage {
+	return b.sessionData()
+}
+
+// Ping checks that the database can be reached.
+func (b *Backend) Ping(ctx context.Context) error {
+	return b.ping(ctx)
+}
+
+// MigrationVersion returns the migration the database is at, and whether a migration failed
+// partway through.
+func (b *Backend) MigrationVersion(ctx context.Context) (version int, dirty bool, err error) {
+	return b.version(ctx)
+}
+
+// LatestMigration returns the version of the newest migration for this kind of database.
+func (b *Backend) LatestMigration() (int, error) {
+	return b.latest()
+}
+
+// Dump takes a snapshot of the database. See db.Dump.
+func (b *Backend) Dump(ctx context.Context) (*db.Snapshot, error) {
+	return b.dump(ctx)
+}
+
+// Restore returns the database to a snapshot taken from the same kind of database. See
+// db.Restore.
+func (b *Backend) Restore(ctx context.Context, s *db.Snapshot) (*db.RestoreSummary, error) {
+	return b.restore(ctx, s)
+}
+
+// Close closes the connections to the database.
+func (b *Backend) Close() {
+	b.close()
+}
@@ -0,0 +1,75 @@
+package storagetest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+)
+
+// StartPostgres starts a Postgres container with Docker and applies the migrations. done removes
+// the container, and must be called even if err is not nil.
+func StartPostgres() (dbURL string, done func(), err error) {
+	done = func() {}
+	// connect to Docker
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		return "", done, fmt.Errorf("could not construct docker pool: %w", err)
+	}
+	err = pool.Client.Ping()
+	if err != nil {
+		return "", done, fmt.Errorf("could not connect to docker: %w", err)
+	}
+
+	const pgUser = "testuser"
+	const pgPass = "testpass"
+	const hardTimeout = 120 // seconds until hard shutdown
+
+	// start Postgres container
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "postgres",
+		Tag:        "18",
+		Env: []string{
+			"POSTGRES_USER=" + pgUser,
+			"POSTGRES_PASSWORD=" + pgPass,
+		},
+	}, func(config *docker.HostConfig) {
+		config.AutoRemove = true
+		config.RestartPolicy = docker.NeverRestart()
+	})
+	if err != nil {
+		return "", done, fmt.Errorf("could not start postgres instance: %w", err)
+	}
+	done = func() {
+		finalErr := pool.Purge(resource)
+		if finalErr != nil {
+			fmt.Println("could not purge postgres instance:", finalErr)
+		}
+	}
+	err = resource.Expire(hardTimeout)
+	if err != nil {
+		return "", done, fmt.Errorf("could not set expiration on postgres instance: %w", err)
+	}
+
+	// connect to Postgres
+	pool.MaxWait = hardTimeout * time.Second
+	dbURL = fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable",
+		pgUser, pgPass, resource.GetHostPort("5432/tcp"), pgUser)
+	err = pool.Retry(func() error {
+		// apply migrations to set up tables
+		newErr := db.ApplyMigrations(dbURL)
+		if newErr != nil && !db.IsTransient(newErr) {
+			return backoff.Permanent(newErr)
+		}
+
+		return newErr
+	})
+	if err != nil {
+		return "", done, fmt.Errorf("could not connect to postgres instance: %w", err)
+	}
+
+	return dbURL, done, nil
+}
@@ -0,0 +1,914 @@
+// Package storagetest checks that a storage backend behaves like the others. The same tests are run
+// against every backend, so that the server can't tell which one it is using.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/storage"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// Run tests the tables of the backend, which must be empty. The subtests run in order, since they
+// share the database.
+func Run(t *testing.T, b *storage.Backend) {
+	t.Helper()
+
+	t.Run("Users", func(t *testing.T) { testUsers(t, b.Users) })
+	t.Run("Admins", func(t *testing.T) { testAdmins(t, b.Admins) })
+	t.Run("TOTP", func(t *testing.T) { testTOTP(t, b.Admins) })
+	t.Run("Discord", func(t *testing.T) { testDiscord(t, b.Admins) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, b.Admins, b.Sessions) })
+	t.Run("Logins", func(t *testing.T) { testLogins(t, b.Admins, b.Logins) })
+	t.Run("SessionStorage", func(t *testing.T) { testSessionStorage(t, b) })
+
+	// The memory backend doesn't have these.
+	if b.Tokens != nil {
+		t.Run("Tokens", func(t *testing.T) { testTokens(t, b.Admins, b.Tokens) })
+	}
+	if b.Audit != nil {
+		t.Run("Audit", func(t *testing.T) { testAudit(t, b.Audit) })
+	}
+	if b.Identities != nil {
+		t.Run("Identities", func(t *testing.T) { testIdentities(t, b.Admins, b.Identities) })
+	}
+}
+
+// name returns a valid encrypted name, which is just hex of the right length.
+func name(c byte) string {
+	return strings.Repeat(string([]byte{c}), 64)
+}
+
+func testUsers(t *testing.T, users db.UserStore) { //nolint:cyclop,funlen // sequential calls
+	ctx := context.Background()
+
+	john := db.User{
+		Name: name('a'), NameKeyHash: "0123", FinishYear: "2019", AlumniBoard: true,
+	}
+	jane := db.User{
+		Name: name('b'), NameKeyHash: "4567", FinishYear: "2020", TA: true,
+	}
+	var err error
+	john.ID, err = users.CreateUser(ctx, &john)
+	if err != nil {
+		t.Fatalf("error from CreateUser: %v", err)
+	}
+	jane.ID, err = users.CreateUser(ctx, &jane)
+	if err != nil {
+		t.Fatalf("error from CreateUser: %v", err)
+	}
+	john.Version, jane.Version = 1, 1
+
+	_, err = users.CreateUser(ctx, &db.User{Name: "John Doe", NameKeyHash: "0123"})
+	if !errors.Is(err, db.ErrInvalidUser) {
+		t.Errorf("expected ErrInvalidUser for an unencrypted name, got %v", err)
+	}
+
+	got, err := users.GetUser(ctx, john.ID)
+	if err != nil {
+		t.Errorf("error from GetUser: %v", err)
+	}
+	if diff := cmp.Diff(&john, got); diff != "" {
+		t.Error("unexpected user (-want +got):\n" + diff)
+	}
+	_, err = users.GetUser(ctx, jane.ID+100)
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser for a missing user, got %v", err)
+	}
+
+	all, err := users.GetUsers(ctx)
+	if err != nil {
+		t.Errorf("error from GetUsers: %v", err)
+	}
+	if diff := cmp.Diff([]*db.User{&john, &jane}, all); diff != "" {
+		t.Error("unexpected users (-want +got):\n" + diff)
+	}
+	all, err = users.GetUsers(ctx, db.WithKeyHash("4567"))
+	if err != nil {
+		t.Errorf("error from GetUsers: %v", err)
+	}
+	if diff := cmp.Diff([]*db.User{&jane}, all); diff != "" {
+		t.Error("unexpected users by key hash (-want +got):\n" + diff)
+	}
+	all, err = users.GetUsers(ctx, db.WithLimit(1))
+	if err != nil {
+		t.Errorf("error from GetUsers: %v", err)
+	}
+	if diff := cmp.Diff([]*db.User{&john}, all); diff != "" {
+		t.Error("unexpected first page (-want +got):\n" + diff)
+	}
+	all, err = users.GetUsers(ctx, db.WithAfterID(john.ID), db.WithLimit(1))
+	if err != nil {
+		t.Errorf("error from GetUsers: %v", err)
+	}
+	if diff := cmp.Diff([]*db.User{&jane}, all); diff != "" {
+		t.Error("unexpected second page (-want +got):\n" + diff)
+	}
+
+	counts, err := users.CountByYear(ctx)
+	if err != nil {
+		t.Errorf("error from CountByYear: %v", err)
+	}
+	if diff := cmp.Diff(map[string]int{"2019": 1, "2020": 1}, counts); diff != "" {
+		t.Error("unexpected counts (-want +got):\n" + diff)
+	}
+
+	// updates are conditional on the version
+	john.Professor = true
+	err = users.UpdateUser(ctx, &john, db.IfVersion(1))
+	if err != nil {
+		t.Errorf("error from UpdateUser: %v", err)
+	}
+	if john.Version != 2 {
+		t.Errorf("expected version 2 after update, got %d", john.Version)
+	}
+	err = users.UpdateUser(ctx, &john, db.IfVersion(1))
+	if !errors.Is(err, db.ErrVersionMismatch) {
+		t.Errorf("expected ErrVersionMismatch for a stale update, got %v", err)
+	}
+	missing := db.User{ID: jane.ID + 100, Name: name('c'), NameKeyHash: "89"}
+	err = users.UpdateUser(ctx, &missing)
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser updating a missing user, got %v", err)
+	}
+
+	year := "2021"
+	patched, err := users.PatchUser(ctx, jane.ID, &db.UserPatch{FinishYear: &year})
+	if err != nil {
+		t.Errorf("error from PatchUser: %v", err)
+	}
+	jane.FinishYear, jane.Version = year, 2
+	if diff := cmp.Diff(&jane, patched); diff != "" {
+		t.Error("unexpected patched user (-want +got):\n" + diff)
+	}
+	_, err = users.PatchUser(ctx, jane.ID, &db.UserPatch{})
+	if !errors.Is(err, db.ErrEmptyPatch) {
+		t.Errorf("expected ErrEmptyPatch, got %v", err)
+	}
+	_, err = users.PatchUser(ctx, jane.ID, &db.UserPatch{FinishYear: &year}, db.IfVersion(1))
+	if !errors.Is(err, db.ErrVersionMismatch) {
+		t.Errorf("expected ErrVersionMismatch for a stale patch, got %v", err)
+	}
+	_, err = users.PatchUser(ctx, missing.ID, &db.UserPatch{FinishYear: &year})
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser patching a missing user, got %v", err)
+	}
+
+	err = users.DeleteUser(ctx, john.ID, db.IfVersion(1))
+	if !errors.Is(err, db.ErrVersionMismatch) {
+		t.Errorf("expected ErrVersionMismatch for a stale delete, got %v", err)
+	}
+	for _, id := range []int{john.ID, jane.ID} {
+		err = users.DeleteUser(ctx, id)
+		if err != nil {
+			t.Errorf("error from DeleteUser: %v", err)
+		}
+	}
+	err = users.DeleteUser(ctx, john.ID)
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser deleting twice, got %v", err)
+	}
+}
+
+// ignoreTimes compares admins without the times they were created or logged in.
+var ignoreTimes = cmpopts.IgnoreFields(db.Admin{}, "CreatedAt", "LastLogin", "LockedAt")
+
+func testAdmins(t *testing.T, admins db.AdminStore) { //nolint:cyclop,funlen // sequential calls
+	ctx := context.Background()
+
+	err := admins.AddAdmin(ctx, "john", "secret", api.RoleOwner)
+	if err != nil {
+		t.Fatalf("error from AddAdmin: %v", err)
+	}
+	t.Cleanup(func() { _ = admins.DeleteAdmin(ctx, "john") })
+	err = admins.AddAdmin(ctx, "jane", "secret", api.RoleViewer)
+	if err != nil {
+		t.Fatalf("error from AddAdmin: %v", err)
+	}
+	err = admins.AddAdmin(ctx, "jane", "other", api.RoleOwner)
+	if !errors.Is(err, db.ErrAdminExists) {
+		t.Errorf("expected ErrAdminExists for a taken username, got %v", err)
+	}
+
+	list, err := admins.ListAdmins(ctx)
+	if err != nil {
+		t.Errorf("error from ListAdmins: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected 2 admins, got %d", len(list))
+	}
+	want := []*db.Admin{
+		{ID: list[0].ID, Username: "john", Role: api.RoleOwner},
+		{ID: list[1].ID, Username: "jane", Role: api.RoleViewer},
+	}
+	if diff := cmp.Diff(want, list, ignoreTimes); diff != "" {
+		t.Error("unexpected admins (-want +got):\n" + diff)
+	}
+	if list[0].CreatedAt.IsZero() {
+		t.Error("expected creation time to be set")
+	}
+
+	got, err := admins.GetAdminByID(ctx, want[1].ID)
+	if err != nil {
+		t.Errorf("error from GetAdminByID: %v", err)
+	}
+	if diff := cmp.Diff(want[1], got, ignoreTimes); diff != "" {
+		t.Error("unexpected admin (-want +got):\n" + diff)
+	}
+	_, err = admins.GetAdmin(ctx, "nobody")
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser for a missing admin, got %v", err)
+	}
+
+	checkPassword := func(user, pass string, want bool) {
+		t.Helper()
+
+		ok, checkErr := admins.CheckPassword(ctx, user, pass)
+		if checkErr != nil {
+			t.Errorf("error from CheckPassword: %v", checkErr)
+		}
+		if ok != want {
+			t.Errorf("expected CheckPassword(%q, %q) to be %t", user, pass, want)
+		}
+	}
+	checkPassword("jane", "secret", true)
+	checkPassword("jane", "wrong", false)
+	checkPassword("nobody", "secret", false)
+
+	err = admins.ChangePassword(ctx, "jane", "changed")
+	if err != nil {
+		t.Errorf("error from ChangePassword: %v", err)
+	}
+	checkPassword("jane", "secret", false)
+	checkPassword("jane", "changed", true)
+	err = admins.ChangePassword(ctx, "nobody", "changed")
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser changing a missing admin's password, got %v", err)
+	}
+
+	err = admins.SetDisabled(ctx, "jane", true)
+	if err != nil {
+		t.Errorf("error from SetDisabled: %v", err)
+	}
+	checkPassword("jane", "changed", false)
+	err = admins.SetDisabled(ctx, "jane", false)
+	if err != nil {
+		t.Errorf("error from SetDisabled: %v", err)
+	}
+	checkPassword("jane", "changed", true)
+
+	err = admins.SetRole(ctx, "jane", api.RoleUploader)
+	if err != nil {
+		t.Errorf("error from SetRole: %v", err)
+	}
+	err = admins.RecordLogin(ctx, "jane")
+	if err != nil {
+		t.Errorf("error from RecordLogin: %v", err)
+	}
+	got, err = admins.GetAdmin(ctx, "jane")
+	if err != nil {
+		t.Errorf("error from GetAdmin: %v", err)
+	}
+	if got.Role != api.RoleUploader {
+		t.Errorf("expected role %s, got %s", api.RoleUploader, got.Role)
+	}
+	if got.LastLogin == nil || time.Since(*got.LastLogin) > time.Minute {
+		t.Errorf("expected last login to be now, got %v", got.LastLogin)
+	}
+
+	role, disabled := api.RoleMigrator, true
+	got, err = admins.PatchAdmin(ctx, "jane", api.AdminPatch{Role: &role, Disabled: &disabled})
+	if err != nil {
+		t.Errorf("error from PatchAdmin: %v", err)
+	}
+	if got == nil || got.Role != api.RoleMigrator || !got.Disabled {
+		t.Errorf("expected jane to be a disabled migrator, got %+v", got)
+	}
+	disabled = false
+	got, err = admins.PatchAdmin(ctx, "jane", api.AdminPatch{Disabled: &disabled})
+	if err != nil {
+		t.Errorf("error from PatchAdmin: %v", err)
+	}
+	if got == nil || got.Role != api.RoleMigrator || got.Disabled {
+		t.Errorf("expected only jane's status to change, got %+v", got)
+	}
+	_, err = admins.PatchAdmin(ctx, "nobody", api.AdminPatch{Role: &role})
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser patching a missing admin, got %v", err)
+	}
+
+	for _, err = range []error{
+		admins.SetDisabled(ctx, "nobody", true),
+		admins.SetRole(ctx, "nobody", api.RoleOwner),
+		admins.DeleteAdmin(ctx, "nobody"),
+	} {
+		if !errors.Is(err, db.ErrNoUser) {
+			t.Errorf("expected ErrNoUser for a missing admin, got %v", err)
+		}
+	}
+
+	err = admins.DeleteAdmin(ctx, "jane")
+	if err != nil {
+		t.Errorf("error from DeleteAdmin: %v", err)
+	}
+	_, err = admins.GetAdmin(ctx, "jane")
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser after deleting, got %v", err)
+	}
+}
+
+func testTOTP(t *testing.T, admins db.AdminStore) { //nolint:cyclop // sequential calls
+	ctx := context.Background()
+
+	err := admins.AddAdmin(ctx, "john", "secret", api.RoleOwner)
+	if err != nil {
+		t.Fatalf("error from AddAdmin: %v", err)
+	}
+	t.Cleanup(func() { _ = admins.DeleteAdmin(ctx, "john") })
+
+	checkTOTP := func(want db.TOTP) {
+		t.Helper()
+
+		got, getErr := admins.GetTOTP(ctx, "john")
+		if getErr != nil {
+			t.Errorf("error from GetTOTP: %v", getErr)
+
+			return
+		}
+		if diff := cmp.Diff(&want, got); diff != "" {
+			t.Error("unexpected TOTP state (-want +got):\n" + diff)
+		}
+	}
+	checkTOTP(db.TOTP{})
+
+	err = admins.StartTOTP(ctx, "john", "encrypted")
+	if err != nil {
+		t.Errorf("error from StartTOTP: %v", err)
+	}
+	checkTOTP(db.TOTP{Secret: "encrypted"})
+	err = admins.EnableTOTP(ctx, "john", []string{"hash1", "hash2"})
+	if err != nil {
+		t.Errorf("error from EnableTOTP: %v", err)
+	}
+	checkTOTP(db.TOTP{Secret: "encrypted", Enabled: true})
+	err = admins.StartTOTP(ctx, "john", "other")
+	if !errors.Is(err, db.ErrTOTPEnabled) {
+		t.Errorf("expected ErrTOTPEnabled, got %v", err)
+	}
+
+	// codes can't be replayed
+	for _, step := range []struct {
+		step int64
+		want bool
+	}{{100, true}, {100, false}, {99, false}, {101, true}} {
+		ok, useErr := admins.UseTOTPStep(ctx, "john", step.step)
+		if useErr != nil {
+			t.Errorf("error from UseTOTPStep: %v", useErr)
+		}
+		if ok != step.want {
+			t.Errorf("expected UseTOTPStep(%d) to be %t", step.step, step.want)
+		}
+	}
+
+	for _, code := range []struct {
+		hash string
+		want bool
+	}{{"hash1", true}, {"hash1", false}, {"hash3", false}, {"hash2", true}} {
+		ok, useErr := admins.UseRecoveryCode(ctx, "john", code.hash)
+		if useErr != nil {
+			t.Errorf("error from UseRecoveryCode: %v", useErr)
+		}
+		if ok != code.want {
+			t.Errorf("expected UseRecoveryCode(%q) to be %t", code.hash, code.want)
+		}
+	}
+
+	err = admins.ResetTOTP(ctx, "john")
+	if err != nil {
+		t.Errorf("error from ResetTOTP: %v", err)
+	}
+	checkTOTP(db.TOTP{})
+	_, err = admins.GetTOTP(ctx, "nobody")
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser for a missing admin, got %v", err)
+	}
+	err = admins.ResetTOTP(ctx, "nobody")
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser for a missing admin, got %v", err)
+	}
+}
+
+func testDiscord(t *testing.T, admins db.AdminStore) {
+	ctx := context.Background()
+
+	_, err := admins.GetDiscordAdmin(ctx, "1001")
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser for an unlinked Discord user, got %v", err)
+	}
+
+	err = admins.AddDiscordAdmin(ctx, "1001", "jane", api.RoleUploader)
+	if err != nil {
+		t.Fatalf("error from AddDiscordAdmin: %v", err)
+	}
+	t.Cleanup(func() { _ = admins.DeleteAdmin(ctx, "jane") })
+
+	got, err := admins.GetDiscordAdmin(ctx, "1001")
+	if err != nil {
+		t.Errorf("error from GetDiscordAdmin: %v", err)
+	} else if got.Username != "jane" || got.Role != api.RoleUploader {
+		t.Errorf("expected uploader jane, got %s %s", got.Role, got.Username)
+	}
+
+	// the random password can't be guessed
+	ok, err := admins.CheckPassword(ctx, "jane", "")
+	if err != nil || ok {
+		t.Errorf("expected an empty password to fail, got %t, %v", ok, err)
+	}
+
+	err = admins.AddDiscordAdmin(ctx, "1001", "jane2", api.RoleUploader)
+	if !errors.Is(err, db.ErrAdminExists) {
+		t.Errorf("expected ErrAdminExists for a linked Discord user, got %v", err)
+	}
+	err = admins.AddDiscordAdmin(ctx, "1002", "jane", api.RoleUploader)
+	if !errors.Is(err, db.ErrAdminExists) {
+		t.Errorf("expected ErrAdminExists for a taken username, got %v", err)
+	}
+}
+
+func testSessions( //nolint:cyclop // sequential calls
+	t *testing.T, admins db.AdminStore, sessions db.SessionStore,
+) {
+	ctx := context.Background()
+
+	err := admins.AddAdmin(ctx, "john", "secret", api.RoleOwner)
+	if err != nil {
+		t.Fatalf("error from AddAdmin: %v", err)
+	}
+	t.Cleanup(func() { _ = admins.DeleteAdmin(ctx, "john") })
+	john, err := admins.GetAdmin(ctx, "john")
+	if err != nil {
+		t.Fatalf("error from GetAdmin: %v", err)
+	}
+
+	for _, key := range []string{"abc", "def"} {
+		err = sessions.CreateSession(ctx, key, john.ID, "10.0.0.1", "curl")
+		if err != nil {
+			t.Errorf("error from CreateSession: %v", err)
+		}
+	}
+
+	touch := func(key string, adminID int, idle time.Duration, want bool) {
+		t.Helper()
+
+		ok, touchErr := sessions.TouchSession(ctx, key, adminID, "10.0.0.2", idle, 24*time.Hour)
+		if touchErr != nil {
+			t.Errorf("error from TouchSession: %v", touchErr)
+		}
+		if ok != want {
+			t.Errorf("expected TouchSession(%q) to be %t", key, want)
+		}
+	}
+	touch("abc", john.ID, time.Hour, true)
+	touch("abc", john.ID+1, time.Hour, false)
+	touch("abc", john.ID, 0, false)
+
+	list, err := sessions.ListSessions(ctx, "john")
+	if err != nil {
+		t.Errorf("error from ListSessions: %v", err)
+	}
+	if len(list) != 2 || list[0].Key != "abc" || list[0].IP != "10.0.0.2" {
+		t.Fatalf("expected the touched session first, got %+v", list)
+	}
+
+	err = sessions.RevokeSession(ctx, "john", list[0].ID+list[1].ID)
+	if !errors.Is(err, db.ErrNoSession) {
+		t.Errorf("expected ErrNoSession for a missing session, got %v", err)
+	}
+	err = sessions.RevokeSession(ctx, "john", list[1].ID)
+	if err != nil {
+		t.Errorf("error from RevokeSession: %v", err)
+	}
+	touch("def", john.ID, time.Hour, false)
+
+	err = sessions.CreateSession(ctx, "ghi", john.ID, "10.0.0.1", "curl")
+	if err != nil {
+		t.Errorf("error from CreateSession: %v", err)
+	}
+	err = sessions.RevokeOtherSessions(ctx, "john", "abc")
+	if err != nil {
+		t.Errorf("error from RevokeOtherSessions: %v", err)
+	}
+	touch("ghi", john.ID, time.Hour, false)
+	touch("abc", john.ID, time.Hour, true)
+
+	err = sessions.CreateSession(ctx, "jkl", john.ID, "10.0.0.1", "curl")
+	if err != nil {
+		t.Errorf("error from CreateSession: %v", err)
+	}
+	err = admins.ResetPassword(ctx, "john", "changed", "abc")
+	if err != nil {
+		t.Errorf("error from ResetPassword: %v", err)
+	}
+	touch("jkl", john.ID, time.Hour, false)
+	touch("abc", john.ID, time.Hour, true)
+	ok, err := admins.CheckPassword(ctx, "john", "changed")
+	if err != nil || !ok {
+		t.Errorf("expected the reset password to work, got %t, %v", ok, err)
+	}
+	err = admins.ResetPassword(ctx, "nobody", "changed", "")
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser resetting a missing admin's password, got %v", err)
+	}
+
+	err = sessions.DeleteSession(ctx, "abc")
+	if err != nil {
+		t.Errorf("error from DeleteSession: %v", err)
+	}
+	list, err = sessions.ListSessions(ctx, "john")
+	if err != nil {
+		t.Errorf("error from ListSessions: %v", err)
+	}
+	if len(list) != 0 {
+		t.Errorf("expected no sessions, got %d", len(list))
+	}
+}
+
+func testLogins( //nolint:cyclop,funlen // sequential calls
+	t *testing.T, admins db.AdminStore, logins db.LoginStore,
+) {
+	ctx := context.Background()
+
+	err := admins.AddAdmin(ctx, "john", "secret", api.RoleOwner)
+	if err != nil {
+		t.Fatalf("error from AddAdmin: %v", err)
+	}
+	t.Cleanup(func() { _ = admins.DeleteAdmin(ctx, "john") })
+
+	for i, reason := range []string{db.FailureBadPassword, db.FailureBadTOTP} {
+		n, recordErr := logins.RecordFailure(ctx, "john", "10.0.0.1", reason)
+		if recordErr != nil {
+			t.Errorf("error from RecordFailure: %v", recordErr)
+		}
+		if n != i+1 {
+			t.Errorf("expected %d failures, got %d", i+1, n)
+		}
+	}
+	_, err = logins.RecordFailure(ctx, "jane", "10.0.0.1", db.FailureBadPassword)
+	if err != nil {
+		t.Errorf("error from RecordFailure: %v", err)
+	}
+
+	byUser, byIP, err := logins.GetFailures(ctx, "john", "10.0.0.1")
+	if err != nil {
+		t.Errorf("error from GetFailures: %v", err)
+	}
+	if byUser.Failures != 2 || byIP.Failures != 3 {
+		t.Errorf("expected 2 failures for the user and 3 for the IP, got %d and %d",
+			byUser.Failures, byIP.Failures)
+	}
+	if time.Since(byUser.LastFailure) > time.Minute {
+		t.Errorf("expected the last failure to be now, got %v", byUser.LastFailure)
+	}
+
+	list, err := logins.ListFailures(ctx, "john", 10)
+	if err != nil {
+		t.Errorf("error from ListFailures: %v", err)
+	}
+	reasons := make([]string, len(list))
+	for i, f := range list {
+		reasons[i] = f.Reason
+	}
+	if diff := cmp.Diff([]string{db.FailureBadTOTP, db.FailureBadPassword}, reasons); diff != "" {
+		t.Error("unexpected failures, newest first (-want +got):\n" + diff)
+	}
+	list, err = logins.ListFailures(ctx, "", 1)
+	if err != nil {
+		t.Errorf("error from ListFailures: %v", err)
+	}
+	if len(list) != 1 || list[0].Username != "jane" {
+		t.Errorf("expected only the newest failure, got %+v", list)
+	}
+
+	err = logins.ClearFailures(ctx, "john")
+	if err != nil {
+		t.Errorf("error from ClearFailures: %v", err)
+	}
+	byUser, byIP, err = logins.GetFailures(ctx, "john", "10.0.0.1")
+	if err != nil {
+		t.Errorf("error from GetFailures: %v", err)
+	}
+	if byUser.Failures != 0 || byIP.Failures != 3 {
+		t.Errorf("expected only the user's failures to be cleared, got %d and %d",
+			byUser.Failures, byIP.Failures)
+	}
+
+	err = logins.Lock(ctx, "john")
+	if err != nil {
+		t.Errorf("error from Lock: %v", err)
+	}
+	john, err := admins.GetAdmin(ctx, "john")
+	if err != nil {
+		t.Fatalf("error from GetAdmin: %v", err)
+	}
+	if john.LockedAt == nil {
+		t.Error("expected admin to be locked")
+	}
+	err = logins.Unlock(ctx, "john")
+	if err != nil {
+		t.Errorf("error from Unlock: %v", err)
+	}
+	john, err = admins.GetAdmin(ctx, "john")
+	if err != nil {
+		t.Fatalf("error from GetAdmin: %v", err)
+	}
+	if john.LockedAt != nil {
+		t.Error("expected admin to be unlocked")
+	}
+	err = logins.Unlock(ctx, "nobody")
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser unlocking a missing admin, got %v", err)
+	}
+}
+
+func testSessionStorage(t *testing.T, b *storage.Backend) {
+	s := b.SessionStorage()
+
+	got, err := s.Get("abc")
+	if err != nil || got != nil {
+		t.Errorf("expected nothing for a missing key, got %q, %v", got, err)
+	}
+
+	err = s.Set("abc", []byte("data"), time.Hour)
+	if err != nil {
+		t.Errorf("error from Set: %v", err)
+	}
+	err = s.Set("def", []byte("more"), 0)
+	if err != nil {
+		t.Errorf("error from Set: %v", err)
+	}
+	got, err = s.Get("abc")
+	if err != nil || string(got) != "data" {
+		t.Errorf("expected stored data, got %q, %v", got, err)
+	}
+
+	err = s.Delete("abc")
+	if err != nil {
+		t.Errorf("error from Delete: %v", err)
+	}
+	got, err = s.Get("abc")
+	if err != nil || got != nil {
+		t.Errorf("expected nothing after Delete, got %q, %v", got, err)
+	}
+
+	err = s.Reset()
+	if err != nil {
+		t.Errorf("error from Reset: %v", err)
+	}
+	got, err = s.Get("def")
+	if err != nil || got != nil {
+		t.Errorf("expected nothing after Reset, got %q, %v", got, err)
+	}
+}
+
+func testTokens( //nolint:cyclop // sequential calls
+	t *testing.T, admins db.AdminStore, tokens db.TokenStore,
+) {
+	ctx := context.Background()
+
+	err := admins.AddAdmin(ctx, "john", "secret", api.RoleUploader)
+	if err != nil {
+		t.Fatalf("error from AddAdmin: %v", err)
+	}
+	t.Cleanup(func() { _ = admins.DeleteAdmin(ctx, "john") })
+
+	scopes := []string{string(api.ScopeUsersRead), string(api.ScopeUsersCreate)}
+	token, err := tokens.CreateToken(ctx, "john", "ci", scopes, nil)
+	if err != nil {
+		t.Fatalf("error from CreateToken: %v", err)
+	}
+	if !strings.HasPrefix(token, db.TokenPrefix) {
+		t.Errorf("expected token to start with %q, got %q", db.TokenPrefix, token)
+	}
+	expired := time.Now().Add(-time.Minute)
+	old, err := tokens.CreateToken(ctx, "john", "old", scopes[:1], &expired)
+	if err != nil {
+		t.Errorf("error from CreateToken: %v", err)
+	}
+	_, err = tokens.CreateToken(ctx, "nobody", "ci", scopes, nil)
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser for a missing admin, got %v", err)
+	}
+
+	tok, err := tokens.Authenticate(ctx, token)
+	if err != nil {
+		t.Fatalf("error from Authenticate: %v", err)
+	}
+	want := &db.Token{
+		ID: tok.ID, Admin: "john", AdminRole: api.RoleUploader, Name: "ci", Scopes: scopes,
+	}
+	if diff := cmp.Diff(want, tok,
+		cmpopts.IgnoreFields(db.Token{}, "CreatedAt", "LastUsedAt")); diff != "" {
+		t.Error("unexpected token (-want +got):\n" + diff)
+	}
+	if tok.LastUsedAt == nil || time.Since(*tok.LastUsedAt) > time.Minute {
+		t.Errorf("expected the token to be used now, got %v", tok.LastUsedAt)
+	}
+	for _, bad := range []string{old, db.TokenPrefix + "nope"} {
+		_, err = tokens.Authenticate(ctx, bad)
+		if !errors.Is(err, db.ErrNoToken) {
+			t.Errorf("expected ErrNoToken for an expired or unknown token, got %v", err)
+		}
+	}
+
+	list, err := tokens.ListTokens(ctx, "john")
+	if err != nil {
+		t.Errorf("error from ListTokens: %v", err)
+	}
+	if len(list) != 2 || list[0].Name != "ci" || list[1].ExpiresAt == nil {
+		t.Errorf("unexpected tokens: %+v", list)
+	}
+
+	err = tokens.RevokeToken(ctx, tok.ID)
+	if err != nil {
+		t.Errorf("error from RevokeToken: %v", err)
+	}
+	err = tokens.RevokeToken(ctx, tok.ID)
+	if !errors.Is(err, db.ErrNoToken) {
+		t.Errorf("expected ErrNoToken revoking a revoked token, got %v", err)
+	}
+	_, err = tokens.Authenticate(ctx, token)
+	if !errors.Is(err, db.ErrNoToken) {
+		t.Errorf("expected ErrNoToken for a revoked token, got %v", err)
+	}
+}
+
+func testAudit(t *testing.T, audit db.AuditStore) { //nolint:cyclop // sequential calls
+	ctx := context.Background()
+
+	_, err := audit.ChainHead(ctx)
+	if !errors.Is(err, db.ErrNoAuditEvents) {
+		t.Errorf("expected ErrNoAuditEvents before any events, got %v", err)
+	}
+
+	events := []*db.AuditEvent{
+		{
+			Actor: "john", Action: "POST /api/users", Target: "1", RequestID: "r1",
+			Changes: map[string]api.FieldChange{"ta": {After: json.RawMessage("true")}},
+		},
+		{Actor: "jane", Token: "ci", Action: "DELETE /api/users/:id", Target: "1"},
+		{Actor: "bot", Action: "admit", Target: "2"},
+	}
+	for _, e := range events {
+		err = audit.Record(ctx, e)
+		if err != nil {
+			t.Fatalf("error from Record: %v", err)
+		}
+	}
+	if len(events[0].PrevHash) != 0 || !bytes.Equal(events[1].PrevHash, events[0].Hash) {
+		t.Error("expected the events to be chained")
+	}
+
+	head, err := audit.ChainHead(ctx)
+	if err != nil {
+		t.Errorf("error from ChainHead: %v", err)
+	} else if !bytes.Equal(head.Hash, events[2].Hash) {
+		t.Errorf("expected the newest event to be the head, got %+v", head)
+	}
+
+	// Record doesn't set the IDs.
+	all, err := audit.ListEvents(ctx, &api.AuditFilter{})
+	if err != nil || len(all) != len(events) {
+		t.Fatalf("expected %d events, got %d (error: %v)", len(events), len(all), err)
+	}
+	for i, e := range events {
+		e.ID = all[len(all)-1-i].ID
+	}
+
+	checked, broken, err := audit.VerifyChain(ctx)
+	if err != nil || broken != nil || checked != len(events) {
+		t.Errorf("expected %d valid events, got %d (broken: %v, error: %v)", len(events),
+			checked, broken, err)
+	}
+	id, err := audit.FindHash(ctx, events[1].Hash)
+	if err != nil || id != events[1].ID {
+		t.Errorf("expected event %d, got %d (error: %v)", events[1].ID, id, err)
+	}
+	_, err = audit.FindHash(ctx, []byte("nope"))
+	if !errors.Is(err, db.ErrNoAuditEvents) {
+		t.Errorf("expected ErrNoAuditEvents for an unknown hash, got %v", err)
+	}
+
+	got, err := audit.ListEvents(ctx, &api.AuditFilter{Target: "1"})
+	if err != nil {
+		t.Errorf("error from ListEvents: %v", err)
+	}
+	if diff := cmp.Diff([]*db.AuditEvent{events[1], events[0]}, got,
+		cmpopts.EquateApproxTime(0)); diff != "" {
+		t.Error("unexpected events, newest first (-want +got):\n" + diff)
+	}
+	got, err = audit.ListEvents(ctx, &api.AuditFilter{BeforeID: events[2].ID, Limit: 1})
+	if err != nil {
+		t.Errorf("error from ListEvents: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != events[1].ID {
+		t.Errorf("expected only event %d, got %+v", events[1].ID, got)
+	}
+}
+
+func testIdentities( //nolint:cyclop // sequential calls
+	t *testing.T, admins db.AdminStore, identities db.OIDCStore,
+) {
+	ctx := context.Background()
+
+	emailID, err := identities.AddIdentity(ctx, db.IdentityEmail, "Jane@Example.com", "jane",
+		api.RoleUploader)
+	if err != nil {
+		t.Fatalf("error from AddIdentity: %v", err)
+	}
+	subjectID, err := identities.AddIdentity(ctx, db.IdentitySubject, "jane-sub", "jane-sso",
+		api.RoleOwner)
+	if err != nil {
+		t.Fatalf("error from AddIdentity: %v", err)
+	}
+	_, err = identities.AddIdentity(ctx, db.IdentityEmail, "jane@example.com", "other",
+		api.RoleViewer)
+	if !errors.Is(err, db.ErrIdentityExists) {
+		t.Errorf("expected ErrIdentityExists for a duplicate email, got %v", err)
+	}
+
+	list, err := identities.ListIdentities(ctx)
+	if err != nil {
+		t.Errorf("error from ListIdentities: %v", err)
+	}
+	want := []*db.Identity{
+		{ID: emailID, Kind: db.IdentityEmail, Value: "jane@example.com", Username: "jane",
+			Role: api.RoleUploader},
+		{ID: subjectID, Kind: db.IdentitySubject, Value: "jane-sub", Username: "jane-sso",
+			Role: api.RoleOwner},
+	}
+	if diff := cmp.Diff(want, list, cmpopts.IgnoreFields(db.Identity{}, "CreatedAt")); diff != "" {
+		t.Error("unexpected identities (-want +got):\n" + diff)
+	}
+
+	// the subject is matched before the email address
+	match, err := identities.MatchIdentity(ctx, "jane-sub", "JANE@example.com")
+	if err != nil || match.ID != subjectID {
+		t.Errorf("expected identity %d, got %+v (error: %v)", subjectID, match, err)
+	}
+	match, err = identities.MatchIdentity(ctx, "other-sub", "JANE@example.com")
+	if err != nil || match.ID != emailID {
+		t.Errorf("expected identity %d, got %+v (error: %v)", emailID, match, err)
+	}
+	_, err = identities.MatchIdentity(ctx, "other-sub", "")
+	if !errors.Is(err, db.ErrNoIdentity) {
+		t.Errorf("expected ErrNoIdentity for an unknown subject, got %v", err)
+	}
+
+	err = identities.RemoveIdentity(ctx, emailID)
+	if err != nil {
+		t.Errorf("error from RemoveIdentity: %v", err)
+	}
+	err = identities.RemoveIdentity(ctx, emailID)
+	if !errors.Is(err, db.ErrNoIdentity) {
+		t.Errorf("expected ErrNoIdentity removing a removed identity, got %v", err)
+	}
+	t.Cleanup(func() { _ = identities.RemoveIdentity(ctx, subjectID) })
+
+	err = admins.AddAdmin(ctx, "jane", "secret", api.RoleUploader)
+	if err != nil {
+		t.Fatalf("error from AddAdmin: %v", err)
+	}
+	t.Cleanup(func() { _ = admins.DeleteAdmin(ctx, "jane") })
+	jane, err := admins.GetAdmin(ctx, "jane")
+	if err != nil {
+		t.Fatalf("error from GetAdmin: %v", err)
+	}
+
+	code, err := identities.CreateLoginCode(ctx, jane.ID, time.Minute)
+	if err != nil {
+		t.Fatalf("error from CreateLoginCode: %v", err)
+	}
+	expired, err := identities.CreateLoginCode(ctx, jane.ID, -time.Minute)
+	if err != nil {
+		t.Errorf("error from CreateLoginCode: %v", err)
+	}
+	adminID, err := identities.UseLoginCode(ctx, code)
+	if err != nil || adminID != jane.ID {
+		t.Errorf("expected admin %d, got %d (error: %v)", jane.ID, adminID, err)
+	}
+	for _, bad := range []string{code, expired} {
+		_, err = identities.UseLoginCode(ctx, bad)
+		if !errors.Is(err, db.ErrNoLoginCode) {
+			t.Errorf("expected ErrNoLoginCode for a used or expired code, got %v", err)
+		}
+	}
+}
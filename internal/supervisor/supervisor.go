@@ -0,0 +1,167 @@
+// Package supervisor runs the long-lived parts of the server, restarting the ones that fail and
+// stopping all of them in order when the server shuts down.
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/cobaltspeech/log"
+)
+
+// Component is a long-lived part of the server, like the HTTP server or the Discord bot.
+type Component struct {
+	Name string
+
+	// Run runs the component until ctx is canceled, and then stops it and returns. If Run returns
+	// before ctx is canceled, the component has failed.
+	Run func(ctx context.Context) error
+
+	// Restart makes the supervisor restart the component after it fails. Otherwise a failure shuts
+	// down the whole supervisor.
+	Restart bool
+}
+
+// Supervisor runs a set of components.
+type Supervisor struct {
+	l          log.Logger
+	components []Component
+
+	// MinBackoff is how long the supervisor waits before restarting a failed component. The wait
+	// doubles with each failure in a row, up to MaxBackoff. A component that ran for longer than
+	// MaxBackoff before failing is restarted after MinBackoff again.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// ShutdownTimeout is how long the components have to stop, all together. Each component finds
+	// out how much of it is left with Deadline.
+	ShutdownTimeout time.Duration
+}
+
+// New returns a supervisor with no components.
+func New(l log.Logger) *Supervisor {
+	return &Supervisor{
+		l:               l,
+		MinBackoff:      time.Second,
+		MaxBackoff:      time.Minute,
+		ShutdownTimeout: 10 * time.Second,
+	}
+}
+
+// Add adds a component. Components are started in the order they are added, and stopped in reverse.
+func (s *Supervisor) Add(c Component) {
+	s.components = append(s.components, c)
+}
+
+// Run starts the components and blocks until ctx is canceled or a component that is not restarted
+// fails. Then it stops the components one at a time, waiting for each to return before stopping the
+// next. They share one deadline, ShutdownTimeout after the shutdown starts. The error is the
+// failure that stopped the supervisor, if any.
+func (s *Supervisor) Run(ctx context.Context) error {
+	failed := make(chan error, len(s.components))
+	cancels := make([]context.CancelFunc, len(s.components))
+	stopped := make([]chan struct{}, len(s.components))
+	var deadline atomic.Pointer[time.Time]
+
+	for i, c := range s.components {
+		var cctx context.Context
+		cctx, cancels[i] = context.WithCancel(
+			context.WithValue(context.Background(), deadlineKey{}, &deadline))
+		stopped[i] = make(chan struct{})
+
+		go func() {
+			defer close(stopped[i])
+
+			err := s.supervise(cctx, c)
+			if err != nil {
+				failed <- fmt.Errorf("%s: %w", c.Name, err)
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		s.l.Info("msg", "shutting down")
+	case err = <-failed:
+		s.l.Error("msg", "component failed; shutting down", "error", err)
+	}
+
+	d := time.Now().Add(s.ShutdownTimeout)
+	deadline.Store(&d)
+	for i := len(s.components) - 1; i >= 0; i-- {
+		cancels[i]()
+		<-stopped[i]
+		s.l.Debug("msg", "stopped component", "component", s.components[i].Name)
+	}
+
+	return err
+}
+
+type deadlineKey struct{}
+
+// Deadline returns the time by which a component must have stopped. ctx is the one passed to the
+// component's Run, and the deadline is set by the time ctx is canceled. It is shared by all the
+// components, so the ones stopped last get whatever time the others left. Deadline returns the zero
+// time if ctx did not come from a Supervisor.
+func Deadline(ctx context.Context) time.Time {
+	d, ok := ctx.Value(deadlineKey{}).(*atomic.Pointer[time.Time])
+	if !ok {
+		return time.Time{}
+	}
+	if t := d.Load(); t != nil {
+		return *t
+	}
+
+	return time.Time{}
+}
+
+// supervise runs the component until ctx is canceled, restarting it if it should be. The error is
+// the failure of a component that is not restarted.
+func (s *Supervisor) supervise(ctx context.Context, c Component) error {
+	backoff := s.MinBackoff
+	for {
+		start := time.Now()
+		err := run(ctx, c)
+		if ctx.Err() != nil {
+			if err != nil {
+				s.l.Error("msg", "error stopping component", "component", c.Name, "error", err)
+			}
+
+			return nil
+		}
+		if err == nil {
+			err = errors.New("stopped unexpectedly")
+		}
+		if !c.Restart {
+			return err
+		}
+
+		if time.Since(start) > s.MaxBackoff {
+			backoff = s.MinBackoff
+		}
+		s.l.Error("msg", "component failed; restarting", "component", c.Name, "error", err,
+			"wait", backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, s.MaxBackoff)
+	}
+}
+
+// run runs the component, turning a panic into an error.
+func run(ctx context.Context, c Component) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return c.Run(ctx)
+}
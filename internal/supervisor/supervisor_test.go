@@ -0,0 +1,171 @@
+package supervisor_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cobaltspeech/log/pkg/testinglog"
+
+	"github.com/kylrth/disco-bouncer/internal/supervisor"
+)
+
+func newSupervisor(t *testing.T) *supervisor.Supervisor {
+	t.Helper()
+
+	s := supervisor.New(testinglog.NewConvenientLogger(t))
+	s.MinBackoff = time.Millisecond
+	s.MaxBackoff = 4 * time.Millisecond
+
+	return s
+}
+
+// recorder records the order components are stopped in.
+type recorder struct {
+	mu      sync.Mutex
+	stopped []string
+}
+
+func (r *recorder) component(name string) supervisor.Component {
+	return supervisor.Component{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			<-ctx.Done()
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.stopped = append(r.stopped, name)
+
+			return nil
+		},
+	}
+}
+
+func TestSupervisor_Shutdown(t *testing.T) {
+	t.Parallel()
+
+	s := newSupervisor(t)
+	var r recorder
+	s.Add(r.component("bot"))
+	s.Add(r.component("http"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	err := s.Run(ctx)
+	if err != nil {
+		t.Errorf("unexpected error from Run: %v", err)
+	}
+	if len(r.stopped) != 2 || r.stopped[0] != "http" || r.stopped[1] != "bot" {
+		t.Errorf("expected components stopped in reverse order, got %v", r.stopped)
+	}
+}
+
+func TestSupervisor_Restart(t *testing.T) {
+	t.Parallel()
+
+	s := newSupervisor(t)
+	var r recorder
+	s.Add(r.component("http"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var runs int
+	s.Add(supervisor.Component{
+		Name:    "bot",
+		Restart: true,
+		Run: func(ctx context.Context) error {
+			runs++
+			switch runs {
+			case 1:
+				return errors.New("gateway unavailable")
+			case 2:
+				panic("oops")
+			default:
+				cancel()
+				<-ctx.Done()
+
+				return nil
+			}
+		},
+	})
+
+	err := s.Run(ctx)
+	if err != nil {
+		t.Errorf("unexpected error from Run: %v", err)
+	}
+	if runs != 3 {
+		t.Errorf("expected the bot to run 3 times, got %d", runs)
+	}
+	if len(r.stopped) != 1 {
+		t.Errorf("expected the HTTP server to keep running until shutdown, got %v", r.stopped)
+	}
+}
+
+func TestSupervisor_Failure(t *testing.T) {
+	t.Parallel()
+
+	s := newSupervisor(t)
+	var r recorder
+	s.Add(r.component("bot"))
+
+	failure := errors.New("address in use")
+	s.Add(supervisor.Component{
+		Name: "http",
+		Run:  func(context.Context) error { return failure },
+	})
+
+	err := s.Run(context.Background())
+	if !errors.Is(err, failure) {
+		t.Errorf("expected the failure from Run, got %v", err)
+	}
+	if len(r.stopped) != 1 || r.stopped[0] != "bot" {
+		t.Errorf("expected the other components to be stopped, got %v", r.stopped)
+	}
+}
+
+func TestSupervisor_Deadline(t *testing.T) {
+	t.Parallel()
+
+	s := newSupervisor(t)
+	s.ShutdownTimeout = time.Hour
+
+	var mu sync.Mutex
+	deadlines := map[string]time.Time{}
+	for _, name := range []string{"bot", "http"} {
+		s.Add(supervisor.Component{
+			Name: name,
+			Run: func(ctx context.Context) error {
+				<-ctx.Done()
+				time.Sleep(time.Millisecond) // the deadline doesn't move while components stop
+
+				mu.Lock()
+				defer mu.Unlock()
+				deadlines[name] = supervisor.Deadline(ctx)
+
+				return nil
+			},
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+
+	err := s.Run(ctx)
+	if err != nil {
+		t.Errorf("unexpected error from Run: %v", err)
+	}
+	if !deadlines["bot"].Equal(deadlines["http"]) {
+		t.Errorf("expected one deadline for both components, got %v", deadlines)
+	}
+	if d := deadlines["bot"].Sub(start); d < time.Hour || d > time.Hour+time.Second {
+		t.Errorf("expected the deadline an hour after shutdown, got %v after", d)
+	}
+	if !supervisor.Deadline(context.Background()).IsZero() {
+		t.Error("expected no deadline outside the supervisor")
+	}
+}
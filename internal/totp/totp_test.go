@@ -0,0 +1,71 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/kylrth/disco-bouncer/internal/totp"
+)
+
+// rfcSecret is the SHA1 seed from the test vectors in RFC 6238 appendix B.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	t.Parallel()
+
+	// The RFC vectors have 8 digits; these are the last 6.
+	tests := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, want := range tests {
+		got, err := totp.Code(rfcSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != want {
+			t.Errorf("wrong code at %d: want %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("failed to generate secret: %v", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	code, err := totp.Code(secret, now.Add(-totp.Period))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the previous step is accepted
+	step, ok, err := totp.Verify(secret, code, now)
+	if err != nil || !ok {
+		t.Fatalf("code from previous step not accepted: %v", err)
+	}
+	if step != totp.Step(now)-1 {
+		t.Errorf("wrong step: want %d, got %d", totp.Step(now)-1, step)
+	}
+
+	// but not two steps later
+	_, ok, err = totp.Verify(secret, code, now.Add(totp.Period))
+	if err != nil || ok {
+		t.Errorf("stale code accepted (err %v)", err)
+	}
+
+	_, _, err = totp.Verify("not base32!", code, now)
+	if err == nil {
+		t.Error("expected error for invalid secret")
+	}
+}
@@ -0,0 +1,159 @@
+// Package tracing sets up OpenTelemetry tracing and starts spans for the server.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters are the names of the exporters accepted by Setup.
+var Exporters = []string{"none", "stdout", "otlp"}
+
+// Setup sends spans to the exporter, which is one of Exporters, and propagates trace context in
+// HTTP headers. The "stdout" exporter writes spans to stderr, and the "otlp" exporter is configured
+// with the standard OTEL_EXPORTER_OTLP_* environment variables. The returned function flushes the
+// spans that haven't been exported yet, and should be called before exiting.
+func Setup(ctx context.Context, exporter, service string) (func(context.Context) error, error) {
+	var exp sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+	case "otlp":
+		exp, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create %s trace exporter: %w", exporter, err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	return tp.Shutdown, nil
+}
+
+const instrumentationName = "github.com/kylrth/disco-bouncer"
+
+// spanKey is the key of the request span in the *fasthttp.RequestCtx of a Fiber handler.
+type spanKey struct{}
+
+// Start starts a span as a child of the span in ctx. ctx can be the c.Context() of a Fiber handler,
+// in which case the parent is the span of the request started by Middleware.
+func Start(
+	ctx context.Context, name string, opts ...trace.SpanStartOption,
+) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		if span, ok := ctx.Value(spanKey{}).(trace.Span); ok {
+			ctx = trace.ContextWithSpan(ctx, span)
+		}
+	}
+
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// StartQuery starts a span for a database query, named like "SELECT users" after the first word of
+// the query and the table. system is the kind of database, like "postgresql" or "sqlite".
+func StartQuery(ctx context.Context, system, table, query string) (context.Context, trace.Span) {
+	op, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+
+	return Start(ctx, op+" "+table,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", system),
+			attribute.String("db.collection.name", table),
+			attribute.String("db.operation.name", op),
+			attribute.String("db.query.text", query),
+		),
+	)
+}
+
+// End ends the span, marking it as failed if err is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Middleware starts a span for each request, continuing the trace of the client if the request has
+// trace headers. The span is named after the route that handled the request, like
+// "GET /api/users/:id". It should be added before all other handlers.
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), requestHeaders{c})
+		ctx, span := otel.Tracer(instrumentationName).Start(ctx, c.Method()+" "+c.Path(),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Method()),
+				attribute.String("url.path", c.Path()),
+			),
+		)
+		defer span.End()
+
+		c.SetUserContext(ctx)
+		c.Context().SetUserValue(spanKey{}, span)
+
+		err := c.Next()
+		if err != nil {
+			// The status is set by the error handler, which would otherwise run after this returns.
+			if err = c.App().ErrorHandler(c, err); err != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		status := c.Response().StatusCode()
+		span.SetName(c.Method() + " " + c.Route().Path)
+		span.SetAttributes(
+			attribute.String("http.route", c.Route().Path),
+			attribute.Int("http.response.status_code", status),
+		)
+		if status >= fiber.StatusInternalServerError {
+			span.SetStatus(codes.Error, "")
+		}
+
+		return nil
+	}
+}
+
+// requestHeaders reads trace context from the headers of a Fiber request.
+type requestHeaders struct {
+	c *fiber.Ctx
+}
+
+func (h requestHeaders) Get(key string) string {
+	return h.c.Get(key)
+}
+
+func (requestHeaders) Set(string, string) {}
+
+func (h requestHeaders) Keys() []string {
+	var out []string
+	h.c.Request().Header.VisitAll(func(key, _ []byte) {
+		out = append(out, string(key))
+	})
+
+	return out
+}
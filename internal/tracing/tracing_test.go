@@ -0,0 +1,98 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/kylrth/disco-bouncer/internal/tracing"
+)
+
+func TestMiddleware(t *testing.T) { //nolint:paralleltest // sets the global tracer provider
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	app := fiber.New()
+	app.Use(tracing.Middleware())
+	app.Get("/api/users/:id", func(c *fiber.Ctx) error {
+		_, span := tracing.Start(c.Context(), "SELECT users")
+		span.End()
+
+		return c.SendString("OK")
+	})
+	app.Get("/fail", func(*fiber.Ctx) error {
+		return fiber.NewError(http.StatusServiceUnavailable, "Database error")
+	})
+
+	// the client's trace is continued
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/api/users/7", nil)
+	req.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("error from request: %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/fail", nil))
+	if err != nil {
+		t.Fatalf("error from request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 from the error handler, got %d", resp.StatusCode)
+	}
+
+	spans := rec.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	query, request, failed := spans[0], spans[1], spans[2]
+
+	if request.Name() != "GET /api/users/:id" {
+		t.Errorf("unexpected request span name %q", request.Name())
+	}
+	if request.SpanKind() != trace.SpanKindServer {
+		t.Errorf("unexpected request span kind %v", request.SpanKind())
+	}
+	if got := request.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("request span did not continue the client's trace: %s", got)
+	}
+	if query.Parent().SpanID() != request.SpanContext().SpanID() {
+		t.Error("query span is not a child of the request span")
+	}
+
+	if failed.Name() != "GET /fail" {
+		t.Errorf("unexpected failed span name %q", failed.Name())
+	}
+	if failed.Status().Code != codes.Error {
+		t.Errorf("expected failed request to have error status, got %v", failed.Status())
+	}
+	if failed.Parent().IsValid() {
+		t.Error("request without trace headers should start a new trace")
+	}
+}
+
+func TestSetup(t *testing.T) { //nolint:paralleltest // may set the global tracer provider
+	shutdown, err := tracing.Setup(context.Background(), "none", "test")
+	if err != nil {
+		t.Errorf("error from Setup with no exporter: %v", err)
+	} else if err = shutdown(context.Background()); err != nil {
+		t.Errorf("error from shutdown: %v", err)
+	}
+
+	_, err = tracing.Setup(context.Background(), "zipkin", "test")
+	if err == nil {
+		t.Error("expected error for unknown exporter")
+	}
+}
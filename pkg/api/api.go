@@ -0,0 +1,188 @@
+// Package api defines the types sent over the wire by the disco-bouncer REST API, so that programs
+// outside this module can use pkg/client.
+package api
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// User contains the information about a user necessary to admit them to the Discord server and
+// assign appropriate roles upon entry to the server. When stored on the server, Name is encrypted.
+type User struct {
+	ID                int    `json:"id"`
+	Name              string `json:"name"`
+	NameKeyHash       string `json:"name_key_hash"`
+	FinishYear        string `json:"finish_year"`
+	Professor         bool   `json:"professor"`
+	TA                bool   `json:"ta"`
+	StudentLeadership bool   `json:"student_leadership"`
+	AlumniBoard       bool   `json:"alumni_board"`
+
+	// Version is incremented every time the user is changed. The server also sends it in the ETag
+	// header, and it can be used in the If-Match header to avoid overwriting concurrent changes.
+	Version int `json:"version"`
+}
+
+// UserPatch contains new values for some of the fields of a user. Nil fields are not changed.
+type UserPatch struct {
+	Name              *string `json:"name,omitempty"`
+	NameKeyHash       *string `json:"name_key_hash,omitempty"`
+	FinishYear        *string `json:"finish_year,omitempty"`
+	Professor         *bool   `json:"professor,omitempty"`
+	TA                *bool   `json:"ta,omitempty"`
+	StudentLeadership *bool   `json:"student_leadership,omitempty"`
+	AlumniBoard       *bool   `json:"alumni_board,omitempty"`
+}
+
+// Migration defines a user that needs to be assigned a cohort role. The name should match the
+// display name of a current Discord user on the server.
+type Migration struct {
+	Name string `json:"name"`
+	Year string `json:"role"`
+}
+
+// Admin describes an account that can use the API.
+type Admin struct {
+	ID        int        `json:"id"`
+	Username  string     `json:"username"`
+	Role      Role       `json:"role"`
+	Disabled  bool       `json:"disabled"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastLogin *time.Time `json:"last_login,omitempty"`
+
+	// TOTPEnabled is true if the admin must enter a two-factor code to log in.
+	TOTPEnabled bool `json:"totp_enabled"`
+
+	// LockedAt is set if the admin is locked out after too many failed logins.
+	LockedAt *time.Time `json:"locked_at,omitempty"`
+}
+
+// NewAdmin is the request body for creating an admin.
+type NewAdmin struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     Role   `json:"role"`
+}
+
+// AdminPatch contains new values for some of the settings of an admin. Nil fields are not changed.
+type AdminPatch struct {
+	Role     *Role `json:"role,omitempty"`
+	Disabled *bool `json:"disabled,omitempty"`
+}
+
+// Session describes a logged-in session of an admin.
+type Session struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+
+	// Current is true for the session used to make the request.
+	Current bool `json:"current"`
+}
+
+// TOTPEnrollment is sent when an admin starts enrolling in two-factor authentication. The secret
+// must be added to an authenticator app, either directly or by making a QR code of the URI.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TOTPCode is the request body for endpoints that take a two-factor code. A recovery code can be
+// used instead of a code from an authenticator app, except when confirming enrollment.
+type TOTPCode struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodes is sent when two-factor authentication is enabled. Each code can be used once in
+// place of a code from the authenticator app.
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// AuditEvent records a change made through the API.
+type AuditEvent struct {
+	ID   int       `json:"id"`
+	Time time.Time `json:"time"`
+
+	// Actor is the username of the admin who made the change, and Token is the name of the API
+	// token they used, if any.
+	Actor string `json:"actor"`
+	Token string `json:"token,omitempty"`
+
+	// Action is the method and route of the request, like "PATCH /api/users/:id".
+	Action string `json:"action"`
+
+	// Target identifies what was changed, like the ID of a user or the username of an admin.
+	Target string `json:"target,omitempty"`
+
+	// Changes has the old and new values of each field that changed, by JSON field name.
+	Changes map[string]FieldChange `json:"changes,omitempty"`
+
+	RequestID string `json:"request_id,omitempty"`
+
+	// Hash is the hex-encoded SHA-256 hash chaining the event to the one before it, whose hash is
+	// PrevHash. See AuditHead.
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// AuditHead is the newest event in the audit chain. Each event's hash covers the hash of the event
+// before it, so the chain can't be changed without changing the head.
+type AuditHead struct {
+	ID   int       `json:"id"`
+	Time time.Time `json:"time"`
+	Hash string    `json:"hash"`
+}
+
+// FieldChange is the value of a field before and after a change. Before is empty if the field was
+// created, and After is empty if it was deleted. The values of secret fields are not recorded, so
+// Redacted is set instead.
+type FieldChange struct {
+	Before   json.RawMessage `json:"before,omitempty"`
+	After    json.RawMessage `json:"after,omitempty"`
+	Redacted bool            `json:"redacted,omitempty"`
+}
+
+// Readiness is sent by /readyz. The server is ready if the database is reachable and fully
+// migrated, and the Discord bot (if it is enabled) is connected and has discovered the guild.
+type Readiness struct {
+	Ready    bool           `json:"ready"`
+	Database DatabaseStatus `json:"database"`
+
+	// Discord is nil if the bot is disabled.
+	Discord *DiscordStatus `json:"discord,omitempty"`
+}
+
+// DatabaseStatus describes the connection to the database.
+type DatabaseStatus struct {
+	Connected bool `json:"connected"`
+
+	// Error is DatabaseUnreachable or DatabaseError if something went wrong. The details are only
+	// logged by the server, because /readyz doesn't need authentication.
+	Error string `json:"error,omitempty"`
+
+	// MigrationVersion is the migration the database is at, and LatestMigration is the one the
+	// server expects. Dirty is set if a migration failed partway through.
+	MigrationVersion int  `json:"migration_version"`
+	LatestMigration  int  `json:"latest_migration"`
+	Dirty            bool `json:"dirty,omitempty"`
+}
+
+// These are the values of DatabaseStatus.Error.
+const (
+	DatabaseUnreachable = "unreachable"
+	DatabaseError       = "error"
+)
+
+// DiscordStatus describes the state of the Discord bot.
+type DiscordStatus struct {
+	Connected           bool `json:"connected"`
+	GuildInfoDiscovered bool `json:"guild_info_discovered"`
+
+	// MissingRoles are the names of the roles used by the bot that don't exist in the guild. Users
+	// who should get them are admitted without them.
+	MissingRoles []string `json:"missing_roles,omitempty"`
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Code is a stable, machine-readable identifier for the kind of error the server encountered.
+type Code string
+
+// These are the error codes sent by the server.
+const (
+	CodeBadRequest         Code = "bad_request"
+	CodeUnauthenticated    Code = "unauthenticated"
+	CodeInvalidCredentials Code = "invalid_credentials"
+	CodeTOTPRequired       Code = "totp_required"
+	CodeAccountLocked      Code = "account_locked"
+	CodeTooManyRequests    Code = "too_many_requests"
+	CodeForbidden          Code = "forbidden"
+	CodeNotFound           Code = "not_found"
+	CodeConflict           Code = "conflict"
+	CodePasswordTooShort   Code = "password_too_short"
+	CodePasswordTooLong    Code = "password_too_long"
+	CodeUnknownYear        Code = "unknown_year"
+	CodeValidation         Code = "validation_failed"
+	CodePreconditionFailed Code = "precondition_failed"
+	CodeInternal           Code = "internal"
+)
+
+// These sentinel errors correspond to error codes. An *Error with one of these codes will match
+// the sentinel with errors.Is.
+var (
+	ErrBadRequest         = errors.New("bad request")
+	ErrUnauthenticated    = errors.New("not logged in")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrTOTPRequired       = errors.New("two-factor code required")
+	ErrAccountLocked      = errors.New("account locked")
+	ErrTooManyRequests    = errors.New("too many requests")
+	ErrForbidden          = errors.New("permission denied")
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrPasswordTooShort   = errors.New("password too short")
+	ErrPasswordTooLong    = errors.New("password too long")
+	ErrUnknownYear        = errors.New("unknown cohort year")
+	ErrValidation         = errors.New("validation failed")
+	ErrPreconditionFailed = errors.New("resource was modified since it was read")
+	ErrInternal           = errors.New("internal server error")
+)
+
+var codeErrors = map[Code]error{
+	CodeBadRequest:         ErrBadRequest,
+	CodeUnauthenticated:    ErrUnauthenticated,
+	CodeInvalidCredentials: ErrInvalidCredentials,
+	CodeTOTPRequired:       ErrTOTPRequired,
+	CodeAccountLocked:      ErrAccountLocked,
+	CodeTooManyRequests:    ErrTooManyRequests,
+	CodeForbidden:          ErrForbidden,
+	CodeNotFound:           ErrNotFound,
+	CodeConflict:           ErrConflict,
+	CodePasswordTooShort:   ErrPasswordTooShort,
+	CodePasswordTooLong:    ErrPasswordTooLong,
+	CodeUnknownYear:        ErrUnknownYear,
+	CodeValidation:         ErrValidation,
+	CodePreconditionFailed: ErrPreconditionFailed,
+	CodeInternal:           ErrInternal,
+}
+
+// CodeForStatus chooses a generic error code for an HTTP status, for responses that did not
+// include one.
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusPreconditionFailed:
+		return CodePreconditionFailed
+	case http.StatusLocked:
+		return CodeAccountLocked
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	default:
+		return CodeInternal
+	}
+}
+
+// ErrorResponse is the JSON body of every non-successful response from the server.
+type ErrorResponse struct {
+	Error *Error `json:"error"`
+}
+
+// Error is a non-successful response from the server.
+type Error struct {
+	// Status is the HTTP status code of the response.
+	Status int `json:"-"`
+
+	// Code identifies the kind of error.
+	Code Code `json:"code"`
+
+	// Message is a human-readable explanation.
+	Message string `json:"message"`
+
+	// RequestID identifies the request in the server logs.
+	RequestID string `json:"request_id,omitempty"`
+
+	// Details lists the problems with individual fields of the request, if the code is
+	// CodeValidation.
+	Details []FieldError `json:"details,omitempty"`
+}
+
+// FieldError describes a problem with a single field of a request body.
+type FieldError struct {
+	// Field is the JSON name of the field.
+	Field string `json:"field"`
+
+	// Message explains what is wrong with the value.
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		if e.Code != "" {
+			return string(e.Code)
+		}
+
+		return "HTTP " + strconv.Itoa(e.Status)
+	}
+
+	return e.Message
+}
+
+// Unwrap returns the sentinel error matching the error code, so that errors.Is can be used to check
+// the kind of error.
+func (e *Error) Unwrap() error {
+	return codeErrors[e.Code]
+}
@@ -0,0 +1,35 @@
+package api_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+func TestError_Is(t *testing.T) {
+	t.Parallel()
+
+	err := fmt.Errorf("get user: %w", &api.Error{
+		Status: 404, Code: api.CodeNotFound, Message: "User not found",
+	})
+
+	if !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("error did not match ErrNotFound: %v", err)
+	}
+	if errors.Is(err, api.ErrConflict) {
+		t.Errorf("error unexpectedly matched ErrConflict: %v", err)
+	}
+
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) || apiErr.Status != 404 {
+		t.Errorf("failed to get status from error: %v", err)
+	}
+
+	// unknown codes don't match anything
+	err = &api.Error{Code: "something_new"}
+	if errors.Is(err, api.ErrInternal) {
+		t.Errorf("error with unknown code unexpectedly matched ErrInternal: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// MaxUserLimit is the largest UserFilter.Limit that can be requested.
+const MaxUserLimit = 1000
+
+// UserFilter selects a subset of the users table. The zero value selects every user.
+type UserFilter struct {
+	// KeyHash selects users whose name was encrypted with a key with this MD5 hash.
+	KeyHash string
+
+	// AfterID selects users with an ID greater than this one.
+	AfterID int
+
+	// Limit is the maximum number of users to return, at most MaxUserLimit. Users are always ordered
+	// by ID.
+	Limit int
+}
+
+// Values encodes the filter as URL query parameters.
+func (f *UserFilter) Values() url.Values {
+	out := url.Values{}
+
+	if f.KeyHash != "" {
+		out.Set("keyHash", f.KeyHash)
+	}
+	if f.AfterID > 0 {
+		out.Set("after", strconv.Itoa(f.AfterID))
+	}
+	if f.Limit > 0 {
+		out.Set("limit", strconv.Itoa(f.Limit))
+	}
+
+	return out
+}
+
+// ParseUserFilter decodes a UserFilter from URL query parameters, as encoded by Values.
+func ParseUserFilter(v url.Values) (*UserFilter, error) {
+	var out UserFilter
+	var err error
+
+	out.KeyHash = v.Get("keyHash")
+
+	out.AfterID, err = parseNonNegative(v, "after")
+	if err != nil {
+		return &out, err
+	}
+	out.Limit, err = parseNonNegative(v, "limit")
+	if err != nil {
+		return &out, err
+	}
+	if out.Limit > MaxUserLimit {
+		return &out, fmt.Errorf("limit must be at most %d", MaxUserLimit)
+	}
+
+	return &out, nil
+}
+
+// AuditFilter selects a subset of the audit trail. The zero value selects every event.
+type AuditFilter struct {
+	// Actor selects events caused by this admin.
+	Actor string
+
+	// Action selects events for this method and route, like "DELETE /api/users/:id".
+	Action string
+
+	// Target selects events that changed this user ID or admin username.
+	Target string
+
+	// Since selects events at or after this time.
+	Since time.Time
+
+	// BeforeID selects events with an ID less than this one.
+	BeforeID int
+
+	// Limit is the maximum number of events to return. Events are always ordered from newest to
+	// oldest.
+	Limit int
+}
+
+// Values encodes the filter as URL query parameters.
+func (f *AuditFilter) Values() url.Values {
+	out := url.Values{}
+
+	if f.Actor != "" {
+		out.Set("actor", f.Actor)
+	}
+	if f.Action != "" {
+		out.Set("action", f.Action)
+	}
+	if f.Target != "" {
+		out.Set("target", f.Target)
+	}
+	if !f.Since.IsZero() {
+		out.Set("since", f.Since.Format(time.RFC3339Nano))
+	}
+	if f.BeforeID > 0 {
+		out.Set("before", strconv.Itoa(f.BeforeID))
+	}
+	if f.Limit > 0 {
+		out.Set("limit", strconv.Itoa(f.Limit))
+	}
+
+	return out
+}
+
+// ParseAuditFilter decodes an AuditFilter from URL query parameters, as encoded by Values.
+func ParseAuditFilter(v url.Values) (*AuditFilter, error) {
+	var out AuditFilter
+	var err error
+
+	out.Actor = v.Get("actor")
+	out.Action = v.Get("action")
+	out.Target = v.Get("target")
+
+	if s := v.Get("since"); s != "" {
+		out.Since, err = time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return &out, fmt.Errorf("invalid value for since: %s", s)
+		}
+	}
+	out.BeforeID, err = parseNonNegative(v, "before")
+	if err != nil {
+		return &out, err
+	}
+	out.Limit, err = parseNonNegative(v, "limit")
+	if err != nil {
+		return &out, err
+	}
+
+	return &out, nil
+}
+
+func parseNonNegative(v url.Values, param string) (int, error) {
+	s := v.Get(param)
+	if s == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %s", param, s)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,92 @@
+package api_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+func TestUserFilter_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]api.UserFilter{
+		"empty":   {},
+		"keyHash": {KeyHash: "abcdef"},
+		"page":    {AfterID: 12, Limit: 100},
+		"all":     {KeyHash: "abcdef", AfterID: 1, Limit: 1},
+	}
+
+	for name, f := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			q, err := url.ParseQuery(f.Values().Encode())
+			if err != nil {
+				t.Fatalf("failed to parse encoded query: %v", err)
+			}
+
+			got, err := api.ParseUserFilter(q)
+			if err != nil {
+				t.Fatalf("unexpected error from ParseUserFilter: %v", err)
+			}
+			if diff := cmp.Diff(&f, got); diff != "" {
+				t.Error("unexpected filter (-want +got):\n" + diff)
+			}
+		})
+	}
+}
+
+func TestParseUserFilter_Errors(t *testing.T) {
+	t.Parallel()
+
+	for _, q := range []string{"after=x", "limit=-1", "keyHash=a&limit=1.5", "limit=1001"} {
+		v, err := url.ParseQuery(q)
+		if err != nil {
+			t.Fatalf("failed to parse query %q: %v", q, err)
+		}
+
+		_, err = api.ParseUserFilter(v)
+		if err == nil {
+			t.Errorf("expected error parsing %q", q)
+		}
+	}
+}
+
+func TestAuditFilter_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	since := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	tests := map[string]api.AuditFilter{
+		"empty":  {},
+		"actor":  {Actor: "jane"},
+		"target": {Action: "DELETE /api/users/:id", Target: "12"},
+		"page":   {Since: since, BeforeID: 40, Limit: 20},
+	}
+
+	for name, f := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			q, err := url.ParseQuery(f.Values().Encode())
+			if err != nil {
+				t.Fatalf("failed to parse encoded query: %v", err)
+			}
+
+			got, err := api.ParseAuditFilter(q)
+			if err != nil {
+				t.Fatalf("unexpected error from ParseAuditFilter: %v", err)
+			}
+			if diff := cmp.Diff(&f, got); diff != "" {
+				t.Error("unexpected filter (-want +got):\n" + diff)
+			}
+		})
+	}
+
+	_, err := api.ParseAuditFilter(url.Values{"since": {"yesterday"}})
+	if err == nil {
+		t.Error("expected error for invalid since")
+	}
+}
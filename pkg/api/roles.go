@@ -0,0 +1,63 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Role determines which scopes an admin account is granted.
+type Role string
+
+// These are the roles an admin can have.
+const (
+	// RoleViewer can only read users.
+	RoleViewer Role = "viewer"
+
+	// RoleUploader can read and create users, but not change or delete them.
+	RoleUploader Role = "uploader"
+
+	// RoleMigrator can read and update users and migrate Discord members to a new cohort.
+	RoleMigrator Role = "migrator"
+
+	// RoleOwner can do everything.
+	RoleOwner Role = "owner"
+)
+
+// AllRoles returns every role, from least to most powerful.
+func AllRoles() []Role {
+	return []Role{RoleViewer, RoleUploader, RoleMigrator, RoleOwner}
+}
+
+// Scopes returns the scopes granted to the role. Unknown roles have no scopes.
+func (r Role) Scopes() []Scope {
+	switch r {
+	case RoleViewer:
+		return []Scope{ScopeUsersRead}
+	case RoleUploader:
+		return []Scope{ScopeUsersRead, ScopeUsersCreate}
+	case RoleMigrator:
+		return []Scope{ScopeUsersRead, ScopeUsersUpdate, ScopeDiscordMigrate}
+	case RoleOwner:
+		return AllScopes()
+	default:
+		return nil
+	}
+}
+
+// Allows returns whether the role grants the scope.
+func (r Role) Allows(scope Scope) bool {
+	return slices.Contains(r.Scopes(), scope)
+}
+
+// ErrUnknownRole is returned by ParseRole for a role the server does not know about.
+var ErrUnknownRole = errors.New("unknown role")
+
+// ParseRole checks that s is a known role.
+func ParseRole(s string) (Role, error) {
+	if !slices.Contains(AllRoles(), Role(s)) {
+		return "", fmt.Errorf("%w: %q", ErrUnknownRole, s)
+	}
+
+	return Role(s), nil
+}
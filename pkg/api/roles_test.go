@@ -0,0 +1,35 @@
+package api_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+func TestRole_Allows(t *testing.T) {
+	t.Parallel()
+
+	for _, role := range api.AllRoles() {
+		if !role.Allows(api.ScopeUsersRead) {
+			t.Errorf("role %s can't read users", role)
+		}
+	}
+
+	if api.RoleUploader.Allows(api.ScopeUsersDelete) {
+		t.Error("uploader can delete users")
+	}
+	for _, scope := range api.AllScopes() {
+		if !api.RoleOwner.Allows(scope) {
+			t.Errorf("owner doesn't have scope %s", scope)
+		}
+	}
+	if api.Role("janitor").Allows(api.ScopeUsersRead) {
+		t.Error("unknown role can read users")
+	}
+
+	_, err := api.ParseRole("janitor")
+	if !errors.Is(err, api.ErrUnknownRole) {
+		t.Errorf("expected ErrUnknownRole, got %v", err)
+	}
+}
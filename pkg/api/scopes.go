@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Scope names one kind of access to the API. Every route requires a scope, and API tokens are only
+// granted the scopes they were created with.
+type Scope string
+
+// These are the scopes checked by the server.
+const (
+	ScopeUsersRead      Scope = "users:read"
+	ScopeUsersCreate    Scope = "users:create"
+	ScopeUsersUpdate    Scope = "users:update"
+	ScopeUsersDelete    Scope = "users:delete"
+	ScopeDiscordMigrate Scope = "discord:migrate"
+	ScopeAdminsManage   Scope = "admins:manage"
+	ScopeAuditRead      Scope = "audit:read"
+)
+
+// AllScopes returns every scope known to the server.
+func AllScopes() []Scope {
+	return []Scope{
+		ScopeUsersRead,
+		ScopeUsersCreate,
+		ScopeUsersUpdate,
+		ScopeUsersDelete,
+		ScopeDiscordMigrate,
+		ScopeAdminsManage,
+		ScopeAuditRead,
+	}
+}
+
+// ErrUnknownScope is returned by ParseScopes for a scope the server does not know about.
+var ErrUnknownScope = errors.New("unknown scope")
+
+// ParseScopes checks that each string is a known scope and returns them as Scopes.
+func ParseScopes(ss []string) ([]Scope, error) {
+	known := AllScopes()
+
+	out := make([]Scope, len(ss))
+	for i, s := range ss {
+		if !slices.Contains(known, Scope(s)) {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownScope, s)
+		}
+		out[i] = Scope(s)
+	}
+
+	return out, nil
+}
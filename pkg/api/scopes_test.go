@@ -0,0 +1,26 @@
+package api_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+func TestParseScopes(t *testing.T) {
+	t.Parallel()
+
+	got, err := api.ParseScopes([]string{"users:read", "discord:migrate"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]api.Scope{api.ScopeUsersRead, api.ScopeDiscordMigrate}, got); diff != "" {
+		t.Error("unexpected scopes (-want +got):\n" + diff)
+	}
+
+	_, err = api.ParseScopes([]string{"users:read", "users:all"})
+	if !errors.Is(err, api.ErrUnknownScope) {
+		t.Errorf("expected ErrUnknownScope, got %v", err)
+	}
+}
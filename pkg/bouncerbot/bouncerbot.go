@@ -2,14 +2,21 @@
 package bouncerbot
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/cobaltspeech/log"
 	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/tracing"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 	"github.com/kylrth/disco-bouncer/pkg/encrypt"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Bot assigns roles to users once they send the correct code to the bot in a DM. The correct code
@@ -21,28 +28,34 @@ import (
 type Bot struct {
 	*discordgo.Session
 
-	l log.Logger
-	d Decrypter
+	l     log.Logger
+	d     Decrypter
+	audit Recorder
 
-	gi     *GuildInfo
-	giLock sync.RWMutex
+	gi        *GuildInfo
+	giUpdated time.Time
+	giLock    sync.RWMutex
 
 	guildInfoCallbacks []func(*GuildInfo)
+
+	admissions admissions
+	observer   Observer
 }
 
-// New creates a new bouncer bot using the provided bot token, backed by the provided UserTable.
-func New(l log.Logger, token string, users *db.UserTable) (*Bot, error) {
-	return NewWithDecrypter(l, token, TableDecrypter{users})
+// New creates a new bouncer bot using the provided bot token, backed by the provided UserStore.
+func New(l log.Logger, token string, users db.UserStore) (*Bot, error) {
+	return NewWithDecrypter(l, token, TableDecrypter{Table: users, Logger: l})
 }
 
 // NewWithDecrypter sets up the bot using the provided Decrypter, instead of the default Decrypter
-// backed with a db.UserTable.
+// backed with a db.UserStore.
 func NewWithDecrypter(l log.Logger, token string, d Decrypter) (*Bot, error) {
 	dg, err := discordgo.New("Bot " + token)
 	b := Bot{
-		Session: dg,
-		l:       l,
-		d:       d,
+		Session:  dg,
+		l:        l,
+		d:        d,
+		observer: nopObserver{},
 	}
 
 	if err != nil {
@@ -65,6 +78,16 @@ func NewWithDecrypter(l log.Logger, token string, d Decrypter) (*Bot, error) {
 	return &b, nil
 }
 
+// Recorder records events in the audit trail. Every db.AuditStore implements it.
+type Recorder interface {
+	Record(ctx context.Context, e *db.AuditEvent) error
+}
+
+// RecordAdmissions makes the bot record every user it admits in the audit trail.
+func (b *Bot) RecordAdmissions(r Recorder) {
+	b.audit = r
+}
+
 // AddGuildInfoCallback ensures f will be called when the guild info is filled in. A read lock will
 // be held while the callbacks are called.
 func (b *Bot) AddGuildInfoCallback(f func(*GuildInfo)) {
@@ -78,6 +101,20 @@ func (b *Bot) guildInfoIsNil() bool {
 	return b.gi == nil
 }
 
+// KnownCohort reports whether the guild has a cohort role for the finish year. ok is false if the
+// guild info has not been discovered yet.
+func (b *Bot) KnownCohort(year string) (known, ok bool) {
+	b.giLock.RLock()
+	defer b.giLock.RUnlock()
+
+	if b.gi == nil {
+		return false, false
+	}
+	_, known = b.gi.RolesByYear[year]
+
+	return known, true
+}
+
 func (b *Bot) handleMemberJoin(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
 	if b.guildInfoIsNil() {
 		b.GetGuildInfo(m.GuildID)
@@ -104,6 +141,7 @@ func (b *Bot) GetGuildInfo(guildID string) {
 
 	b.giLock.Lock()
 	b.gi = GetGuildInfo(b.l, roles, guildID)
+	b.giUpdated = time.Now()
 	b.giLock.Unlock()
 
 	b.giLock.RLock()
@@ -128,50 +166,74 @@ func (b *Bot) handleMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
 		// a message from us
 		return
 	}
+	if !b.admissions.start() {
+		b.l.Info("msg", "ignoring DM during shutdown", "userID", m.Author.ID)
+
+		return
+	}
+	defer b.admissions.done()
 
-	u, err := b.d.Decrypt(m.Content)
+	ctx, span := tracing.Start(context.Background(), "bouncerbot.handleMessage",
+		trace.WithSpanKind(trace.SpanKindConsumer))
+	defer span.End()
+
+	// The key is never logged, because it decrypts the user's real name.
+	start := time.Now()
+	u, err := b.d.Decrypt(ctx, m.Content)
+	b.observer.ObserveDecrypt(time.Since(start), err)
 	if err != nil {
 		if errors.As(err, &encrypt.BadKeyError{}) {
-			b.l.Info("msg", "DM did not provide acceptable key", "key", m.Content, "error", err)
+			b.l.Info("msg", "DM did not provide acceptable key", "keyLength", len(m.Content), "error", err)
 			b.message(m.ChannelID, messageBadKey)
+			b.observer.ObserveAdmission(OutcomeBadKey)
 
 			return
 		}
 		if errors.Is(err, ErrNotFound) {
-			b.l.Info("msg", "key did not decrypt any current user", "key", m.Content, "error", err)
+			b.l.Info("msg", "key did not decrypt any current user", "keyLength", len(m.Content),
+				"error", err)
 			b.message(m.ChannelID, messageNotFound)
+			b.observer.ObserveAdmission(OutcomeNotFound)
 
 			return
 		}
 
-		b.l.Error("msg", "error decrypting with key", "key", m.Content, "error", err)
+		b.l.Error("msg", "error decrypting with key", "keyLength", len(m.Content), "error", err)
+		span.SetStatus(codes.Error, err.Error())
 		b.message(m.ChannelID, messageDecryptionError)
+		b.observer.ObserveAdmission(OutcomeDecryptionError)
 
 		return
 	}
 
 	b.message(m.ChannelID, messageSuccessful)
 
-	err = b.admit(u, m.Author.ID)
+	outcome := OutcomeSuccess
+	err = b.admit(ctx, u, m.Author.ID)
 	if err != nil {
 		if err.Error() != errNick403 {
+			span.SetStatus(codes.Error, err.Error())
 			b.l.Error("msg", "failed to admit new user", "error", err)
 			b.message(m.ChannelID, messageAdmitError)
+			b.observer.ObserveAdmission(OutcomeAdmitError)
 
 			return
 		}
 
 		b.message(m.ChannelID, messageNickPerm)
+		outcome = OutcomeNickPermission
 	}
+	b.observer.ObserveAdmission(outcome)
 
 	b.l.Info(
 		"msg", "admitted new user", "userID", m.Author.ID, "username", m.Author.Username,
 		"name", u.Name, "finishYear", u.FinishYear, "isProf", u.Professor, "isTA", u.TA,
 		"isSL", u.StudentLeadership, "isAB", u.AlumniBoard,
 	)
+	b.recordAdmission(ctx, u, m.Author)
 
 	// Delete the user now that we've successfully admitted them.
-	err = b.d.Delete(u.ID)
+	err = b.d.Delete(ctx, u.ID)
 	if err != nil {
 		b.l.Error("msg", "failed to delete user after admitting", "id", u.ID)
 	}
@@ -241,7 +303,7 @@ func (b *Bot) message(channelID, whatFor string) {
 	}
 }
 
-func (b *Bot) admit(u *db.User, dID string) error {
+func (b *Bot) admit(ctx context.Context, u *api.User, dID string) error {
 	if b.guildInfoIsNil() {
 		return errors.New("guild info not discovered yet")
 	}
@@ -255,18 +317,24 @@ func (b *Bot) admit(u *db.User, dID string) error {
 	b.giLock.RUnlock()
 
 	for _, roleID := range rolesToAdd {
-		err := b.GuildMemberRoleAdd(guildID, dID, roleID)
+		err := b.discordCall(ctx, "GuildMemberRoleAdd", func(opt discordgo.RequestOption) error {
+			return b.GuildMemberRoleAdd(guildID, dID, roleID, opt)
+		})
 		if err != nil {
 			errs = append(errs, fmt.Errorf("set role '%s': %w", roleID, err))
 		}
 	}
 
-	err := b.GuildMemberRoleRemove(guildID, dID, newbieRole)
+	err := b.discordCall(ctx, "GuildMemberRoleRemove", func(opt discordgo.RequestOption) error {
+		return b.GuildMemberRoleRemove(guildID, dID, newbieRole, opt)
+	})
 	if err != nil {
 		errs = append(errs, fmt.Errorf("remove newbie role: %w", err))
 	}
 
-	err = b.GuildMemberNickname(guildID, dID, u.Name)
+	err = b.discordCall(ctx, "GuildMemberNickname", func(opt discordgo.RequestOption) error {
+		return b.GuildMemberNickname(guildID, dID, u.Name, opt)
+	})
 	if err != nil {
 		errs = append(errs, fmt.Errorf("set nick: %w", err))
 	}
@@ -274,6 +342,50 @@ func (b *Bot) admit(u *db.User, dID string) error {
 	return errors.Join(errs...)
 }
 
+// discordCall makes a request to the Discord API with f in a span named after the method, like
+// "discord.GuildMemberRoleAdd". The span is a child of the span in ctx.
+func (b *Bot) discordCall(
+	ctx context.Context, method string, f func(opt discordgo.RequestOption) error,
+) error {
+	ctx, span := tracing.Start(ctx, "discord."+method, trace.WithSpanKind(trace.SpanKindClient))
+	err := f(discordgo.WithContext(ctx))
+	tracing.End(span, err)
+
+	return err
+}
+
+// AuditActor is the actor of the audit events recorded by the bot, and ActionAdmit is the action
+// recorded when it admits a user.
+const (
+	AuditActor  = "bouncerbot"
+	ActionAdmit = "admit"
+)
+
+// recordAdmission records who the Discord user was admitted as. The audit table logs any error.
+func (b *Bot) recordAdmission(ctx context.Context, u *api.User, du *discordgo.User) {
+	if b.audit == nil {
+		return
+	}
+
+	changes := make(map[string]api.FieldChange)
+	for name, v := range map[string]any{
+		"user_id":          u.ID,
+		"name":             u.Name,
+		"finish_year":      u.FinishYear,
+		"discord_username": du.Username,
+	} {
+		after, _ := json.Marshal(v) //nolint:errchkjson // Strings and ints always encode.
+		changes[name] = api.FieldChange{After: after}
+	}
+
+	_ = b.audit.Record(ctx, &db.AuditEvent{
+		Actor:   AuditActor,
+		Action:  ActionAdmit,
+		Target:  du.ID,
+		Changes: changes,
+	})
+}
+
 const errNick403 = `set nick: HTTP 403 Forbidden, {"message": "Missing Permissions", "code": 50013}`
 
 func (b *Bot) handleRoleCreate(_ *discordgo.Session, m *discordgo.GuildRoleCreate) {
@@ -296,12 +408,13 @@ var (
 	// the Discord server.
 	ErrUnknownYear = errors.New("unknown cohort year")
 
-	// ErrNoUser is returned by Migrate when the specified user isn't found on the server.
+	// ErrNoUser is returned by Migrate and MemberRoleNames when the specified user isn't found on
+	// the server.
 	ErrNoUser = errors.New("user not found")
 )
 
 // Migrate moves the specified user by name from the pre-core role to their new cohort role.
-func (b *Bot) Migrate(name, year string) error {
+func (b *Bot) Migrate(ctx context.Context, name, year string) error {
 	if b.guildInfoIsNil() {
 		b.l.Error(
 			"msg", "failed to migrate user due to missing guild info", "name", name, "year", year)
@@ -321,7 +434,13 @@ func (b *Bot) Migrate(name, year string) error {
 		return ErrUnknownYear
 	}
 
-	found, err := b.GuildMembersSearch(guildID, name, 1)
+	var found []*discordgo.Member
+	err := b.discordCall(ctx, "GuildMembersSearch", func(opt discordgo.RequestOption) error {
+		var err error
+		found, err = b.GuildMembersSearch(guildID, name, 1, opt)
+
+		return err
+	})
 	if err != nil {
 		b.l.Error("msg", "failed to search for Discord user to migrate", "error", err)
 
@@ -345,7 +464,9 @@ func (b *Bot) Migrate(name, year string) error {
 		"msg", "found matching Discord user for migration",
 		"name", name, "year", year, "user", user.User.ID)
 
-	err = b.GuildMemberRoleAdd(guildID, user.User.ID, cohort)
+	err = b.discordCall(ctx, "GuildMemberRoleAdd", func(opt discordgo.RequestOption) error {
+		return b.GuildMemberRoleAdd(guildID, user.User.ID, cohort, opt)
+	})
 	if err != nil {
 		b.l.Error(
 			"msg", "failed to add new cohort role", "year", year, "cohort", cohort, "user", user)
@@ -353,7 +474,9 @@ func (b *Bot) Migrate(name, year string) error {
 		return fmt.Errorf("add new cohort role: %w", err)
 	}
 
-	err = b.GuildMemberRoleRemove(guildID, user.User.ID, preCore)
+	err = b.discordCall(ctx, "GuildMemberRoleRemove", func(opt discordgo.RequestOption) error {
+		return b.GuildMemberRoleRemove(guildID, user.User.ID, preCore, opt)
+	})
 	if err != nil {
 		b.l.Error("msg", "failed to remove pre-core role", "user", user)
 
@@ -362,3 +485,52 @@ func (b *Bot) Migrate(name, year string) error {
 
 	return nil
 }
+
+// MemberRoleNames returns the names of the roles the Discord user holds in the guild. ErrNoUser is
+// returned if the user is not a member of the guild.
+func (b *Bot) MemberRoleNames(userID string) ([]string, error) {
+	if b.guildInfoIsNil() {
+		b.l.Error("msg", "failed to check member roles due to missing guild info", "user", userID)
+
+		return nil, errors.New("guild info not discovered yet")
+	}
+
+	b.giLock.RLock()
+	guildID := b.gi.GuildID
+	b.giLock.RUnlock()
+
+	member, err := b.GuildMember(guildID, userID)
+	if err != nil {
+		var restErr *discordgo.RESTError
+		if errors.As(err, &restErr) && restErr.Message != nil &&
+			restErr.Message.Code == discordgo.ErrCodeUnknownMember {
+			b.l.Info("msg", "Discord user is not a guild member", "user", userID)
+
+			return nil, ErrNoUser
+		}
+
+		b.l.Error("msg", "failed to get guild member", "user", userID, "error", err)
+
+		return nil, fmt.Errorf("get guild member: %w", err)
+	}
+
+	roles, err := b.GuildRoles(guildID)
+	if err != nil {
+		b.l.Error("msg", "failed to get guild roles", "error", err)
+
+		return nil, fmt.Errorf("get guild roles: %w", err)
+	}
+	names := make(map[string]string, len(roles))
+	for _, r := range roles {
+		names[r.ID] = r.Name
+	}
+
+	out := make([]string, 0, len(member.Roles))
+	for _, id := range member.Roles {
+		if name, ok := names[id]; ok {
+			out = append(out, name)
+		}
+	}
+
+	return out, nil
+}
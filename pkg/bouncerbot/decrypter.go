@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/cobaltspeech/log"
 	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 	"github.com/kylrth/disco-bouncer/pkg/encrypt"
 )
 
@@ -13,11 +15,11 @@ import (
 type Decrypter interface {
 	// Decrypt attempts to decrypt any user info using the key provided. It returns ErrNotFound if
 	// the key did not decrypt anything.
-	Decrypt(key string) (*db.User, error)
+	Decrypt(ctx context.Context, key string) (*api.User, error)
 
 	// Delete removes the user info after it's been decrypted and used. It should be called only
 	// after the successful use of data returned by Decrypt.
-	Delete(id int) error
+	Delete(ctx context.Context, id int) error
 }
 
 // ErrNotFound is returned by a Decrypter if the key did not decrypt any info.
@@ -26,16 +28,37 @@ var ErrNotFound = errors.New("user info not found")
 // TableDecrypter implements Decrypter by using the key to attempt to decrypt all the users in the
 // database.
 type TableDecrypter struct {
-	Table *db.UserTable
+	Table db.UserStore
+
+	// Retry says how long to retry queries that fail with transient errors, which are logged to
+	// Logger. The zero value doesn't retry.
+	Retry  db.RetryPolicy
+	Logger log.Logger
+}
+
+// retry calls f according to the retry policy.
+func (d TableDecrypter) retry(ctx context.Context, what string, f func() error) error {
+	l := d.Logger
+	if l == nil {
+		l = log.NewDiscardLogger()
+	}
+
+	return d.Retry.Do(ctx, l, what, f)
 }
 
-func (d TableDecrypter) Decrypt(key string) (*db.User, error) {
+func (d TableDecrypter) Decrypt(ctx context.Context, key string) (*api.User, error) {
 	keyHash, err := encrypt.MD5Hash(key)
 	if err != nil {
 		return nil, encrypt.NewBadKeyError(err)
 	}
 
-	users, err := d.Table.GetUsers(context.Background(), db.WithKeyHash(keyHash))
+	var users []*db.User
+	err = d.retry(ctx, "get users by key hash", func() error {
+		var getErr error
+		users, getErr = d.Table.GetUsers(ctx, db.WithKeyHash(keyHash))
+
+		return getErr
+	})
 	if err != nil {
 		return nil, fmt.Errorf("get users: %w", err)
 	}
@@ -51,12 +74,25 @@ func (d TableDecrypter) Decrypt(key string) (*db.User, error) {
 			continue
 		}
 
-		return user, nil
+		return user.API(), nil
 	}
 
 	return nil, ErrNotFound
 }
 
-func (d TableDecrypter) Delete(id int) error {
-	return d.Table.DeleteUser(context.Background(), id)
+// Delete deletes the user, retrying transient errors. If an attempt fails after the deletion was
+// committed (for example, if the connection drops before the result arrives), the user is already
+// gone when it is retried, so db.ErrNoUser is not an error on later attempts.
+func (d TableDecrypter) Delete(ctx context.Context, id int) error {
+	var attempts int
+
+	return d.retry(ctx, "delete user", func() error {
+		attempts++
+		err := d.Table.DeleteUser(ctx, id)
+		if errors.Is(err, db.ErrNoUser) && attempts > 1 {
+			return nil
+		}
+
+		return err
+	})
 }
@@ -0,0 +1,113 @@
+package bouncerbot_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cobaltspeech/log"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/internal/db/memory"
+	"github.com/kylrth/disco-bouncer/pkg/bouncerbot"
+	"github.com/kylrth/disco-bouncer/pkg/encrypt"
+)
+
+// flakyUsers fails the first few calls to GetUsers and DeleteUser, like a database that is
+// restarting. Deletions are committed before failing, like a connection dropped before the result
+// arrives.
+type flakyUsers struct {
+	db.UserStore
+
+	failures int
+	err      error
+}
+
+func (f *flakyUsers) GetUsers(ctx context.Context, opts ...db.FilterOption) ([]*db.User, error) {
+	if f.failures > 0 {
+		f.failures--
+
+		return nil, f.err
+	}
+
+	return f.UserStore.GetUsers(ctx, opts...)
+}
+
+func (f *flakyUsers) DeleteUser(ctx context.Context, id int, opts ...db.WriteOption) error {
+	err := f.UserStore.DeleteUser(ctx, id, opts...)
+	if f.failures > 0 {
+		f.failures--
+
+		return f.err
+	}
+
+	return err
+}
+
+func TestTableDecrypter_Retry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	l := log.NewDiscardLogger()
+
+	table := memory.NewUserTable(l, memory.New())
+	ciphertext, key, err := encrypt.Encrypt("John Doe")
+	if err != nil {
+		t.Fatalf("failed to encrypt name: %v", err)
+	}
+	hash, err := encrypt.MD5Hash(key)
+	if err != nil {
+		t.Fatalf("failed to hash key: %v", err)
+	}
+	_, err = table.CreateUser(ctx, &db.User{Name: ciphertext, NameKeyHash: hash, FinishYear: "2021"})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	retry := db.RetryPolicy{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+		Timeout:         time.Second,
+	}
+	transient := &pgconn.PgError{Code: pgerrcode.AdminShutdown}
+
+	// transient errors are retried
+	users := &flakyUsers{UserStore: table, failures: 2, err: transient}
+	d := bouncerbot.TableDecrypter{Table: users, Retry: retry}
+	u, err := d.Decrypt(ctx, key)
+	if err != nil {
+		t.Fatalf("error from Decrypt: %v", err)
+	}
+	if u.Name != "John Doe" {
+		t.Errorf("expected John Doe, got %q", u.Name)
+	}
+
+	// without a retry policy, they are not
+	users.failures = 1
+	d.Retry = db.RetryPolicy{}
+	_, err = d.Decrypt(ctx, key)
+	if !errors.Is(err, transient) {
+		t.Errorf("expected transient error without retries, got %v", err)
+	}
+
+	// nor are other errors
+	users.failures, users.err = 1, errors.New("syntax error")
+	d.Retry = retry
+	_, err = d.Decrypt(ctx, key)
+	if !errors.Is(err, users.err) {
+		t.Errorf("expected error to be returned right away, got %v", err)
+	}
+
+	// a deletion whose result was lost isn't retried into an error
+	users.failures, users.err = 1, transient
+	err = d.Delete(ctx, u.ID)
+	if err != nil {
+		t.Errorf("error from Delete: %v", err)
+	}
+	err = d.Delete(ctx, u.ID)
+	if !errors.Is(err, db.ErrNoUser) {
+		t.Errorf("expected ErrNoUser deleting a missing user, got %v", err)
+	}
+}
@@ -6,7 +6,7 @@ import (
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/cobaltspeech/log"
-	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 )
 
 const (
@@ -61,12 +61,9 @@ func GetGuildInfo(l log.Logger, roles []*discordgo.Role, guildID string) *GuildI
 		}
 	}
 
-	checkRoleFilled(l, out.ProfessorRole, professorRole)
-	checkRoleFilled(l, out.TARole, taRole)
-	checkRoleFilled(l, out.StudentLeadershipRole, studentLeadershipRole)
-	checkRoleFilled(l, out.AlumniBoardRole, alumniBoardRole)
-	checkRoleFilled(l, out.NewbieRole, newbieRole)
-	checkRoleFilled(l, out.PreCoreRole, preCoreRole)
+	for _, name := range out.MissingRoles() {
+		l.Error("msg", "role info not found", "role", name)
+	}
 
 	l.Debug("msg", "Collected guild info.", "RolesByYear", out.RolesByYear)
 
@@ -95,14 +92,27 @@ func getYearIfPresent(s string) string {
 	return s[:yearEnd]
 }
 
-func checkRoleFilled(l log.Logger, field, name string) {
-	if field == "" {
-		l.Error("msg", "role info not found", "role", name)
+// MissingRoles returns the names of the roles used by the bot that were not found in the guild.
+func (i *GuildInfo) MissingRoles() []string {
+	var out []string
+	for _, r := range []struct{ id, name string }{
+		{i.ProfessorRole, professorRole},
+		{i.TARole, taRole},
+		{i.StudentLeadershipRole, studentLeadershipRole},
+		{i.AlumniBoardRole, alumniBoardRole},
+		{i.NewbieRole, newbieRole},
+		{i.PreCoreRole, preCoreRole},
+	} {
+		if r.id == "" {
+			out = append(out, r.name)
+		}
 	}
+
+	return out
 }
 
 // GetRoleIDsForUser returns the role IDs that the user should be given.
-func (i *GuildInfo) GetRoleIDsForUser(l log.Logger, u *db.User) []string {
+func (i *GuildInfo) GetRoleIDsForUser(l log.Logger, u *api.User) []string {
 	roleIDs := []string{}
 	if u.FinishYear != "" {
 		if role, ok := i.RolesByYear[u.FinishYear]; ok {
@@ -7,7 +7,7 @@ import (
 	"github.com/cobaltspeech/log/pkg/testinglog"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
-	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 	"github.com/kylrth/disco-bouncer/pkg/bouncerbot"
 )
 
@@ -135,6 +135,21 @@ func TestGetGuildInfo(t *testing.T) {
 	}
 }
 
+func TestGuildInfo_MissingRoles(t *testing.T) {
+	t.Parallel()
+
+	info := bouncerbot.GuildInfo{
+		TARole:          taRole.ID,
+		AlumniBoardRole: boardRole.ID,
+		PreCoreRole:     preCoreRole.ID,
+	}
+
+	want := []string{"professor", "student leadership", "newbie"}
+	if diff := cmp.Diff(want, info.MissingRoles()); diff != "" {
+		t.Error("unexpected missing roles (-want +got):\n" + diff)
+	}
+}
+
 func TestGuildInfo_GetRoleIDsForUser(t *testing.T) {
 	t.Parallel()
 
@@ -152,28 +167,28 @@ func TestGuildInfo_GetRoleIDsForUser(t *testing.T) {
 	}
 
 	type testCase struct {
-		in   *db.User
+		in   *api.User
 		want []string
 	}
 	tests := map[string]testCase{
 		"2022": {
-			&db.User{FinishYear: "2022", StudentLeadership: true},
+			&api.User{FinishYear: "2022", StudentLeadership: true},
 			[]string{cohort2022.ID, slRole.ID},
 		},
 		"2021": {
-			&db.User{FinishYear: "2021"},
+			&api.User{FinishYear: "2021"},
 			nil,
 		},
 		"TA_alum": {
-			&db.User{FinishYear: "2019", TA: true, AlumniBoard: true},
+			&api.User{FinishYear: "2019", TA: true, AlumniBoard: true},
 			[]string{cohort2019.ID, taRole.ID, boardRole.ID},
 		},
 		"prof": {
-			&db.User{Professor: true},
+			&api.User{Professor: true},
 			[]string{profRole.ID},
 		},
 		"pre-core": {
-			&db.User{},
+			&api.User{},
 			[]string{preCoreRole.ID},
 		},
 	}
@@ -0,0 +1,102 @@
+package bouncerbot
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Outcome is the result of handling a DM with a key.
+type Outcome string
+
+const (
+	OutcomeSuccess         Outcome = "success"
+	OutcomeBadKey          Outcome = "bad_key"
+	OutcomeNotFound        Outcome = "not_found"
+	OutcomeDecryptionError Outcome = "decryption_error"
+	OutcomeNickPermission  Outcome = "nick_permission"
+	OutcomeAdmitError      Outcome = "admit_error"
+)
+
+// Outcomes lists every Outcome.
+var Outcomes = []Outcome{
+	OutcomeSuccess, OutcomeBadKey, OutcomeNotFound, OutcomeDecryptionError, OutcomeNickPermission,
+	OutcomeAdmitError,
+}
+
+// Observer is told what the bot does, for example to record metrics. Its methods are called
+// concurrently.
+type Observer interface {
+	// ObserveAdmission is called with the outcome of each DM with a key.
+	ObserveAdmission(o Outcome)
+
+	// ObserveDecrypt is called with how long each call to the Decrypter's Decrypt method took.
+	ObserveDecrypt(d time.Duration, err error)
+
+	// ObserveDiscord is called after each request to the Discord REST API. The route is the path
+	// of the request with the IDs replaced by ":id", like "/guilds/:id/members/:id". The status is
+	// 0 if the request failed without a response.
+	ObserveDiscord(method, route string, status int, d time.Duration)
+}
+
+// Observe makes the bot tell o what it does. It must be called before the bot is opened.
+func (b *Bot) Observe(o Observer) {
+	b.observer = o
+
+	next := b.Client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	b.Client.Transport = &observedTransport{next: next, o: o}
+}
+
+// GuildInfoUpdated returns when the guild info was last discovered or changed, or the zero time if
+// it hasn't been discovered yet.
+func (b *Bot) GuildInfoUpdated() time.Time {
+	b.giLock.RLock()
+	defer b.giLock.RUnlock()
+
+	return b.giUpdated
+}
+
+type nopObserver struct{}
+
+func (nopObserver) ObserveAdmission(Outcome)                          {}
+func (nopObserver) ObserveDecrypt(time.Duration, error)               {}
+func (nopObserver) ObserveDiscord(string, string, int, time.Duration) {}
+
+// observedTransport tells an Observer about each request to the Discord REST API.
+type observedTransport struct {
+	next http.RoundTripper
+	o    Observer
+}
+
+func (t *observedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	var status int
+	if err == nil {
+		status = resp.StatusCode
+	}
+	t.o.ObserveDiscord(req.Method, discordRoute(req.URL.Path), status, time.Since(start))
+
+	return resp, err
+}
+
+// discordRoute removes the API prefix and IDs from the path, so that it can be used as a metric
+// label.
+func discordRoute(p string) string {
+	p = strings.TrimPrefix(p, "/"+strings.TrimPrefix(discordgo.EndpointAPI, discordgo.EndpointDiscord))
+
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		if part != "" && strings.Trim(part, "0123456789") == "" {
+			parts[i] = ":id"
+		}
+	}
+
+	return "/" + strings.TrimPrefix(strings.Join(parts, "/"), "/")
+}
@@ -0,0 +1,73 @@
+package bouncerbot_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cobaltspeech/log/pkg/testinglog"
+	"github.com/google/go-cmp/cmp"
+	"github.com/kylrth/disco-bouncer/pkg/bouncerbot"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type discordCall struct {
+	method, route string
+	status        int
+}
+
+type fakeObserver struct {
+	mu    sync.Mutex
+	calls []discordCall
+}
+
+func (*fakeObserver) ObserveAdmission(bouncerbot.Outcome) {}
+func (*fakeObserver) ObserveDecrypt(time.Duration, error) {}
+
+func (o *fakeObserver) ObserveDiscord(method, route string, status int, _ time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.calls = append(o.calls, discordCall{method, route, status})
+}
+
+func TestBot_Observe(t *testing.T) {
+	t.Parallel()
+
+	l := testinglog.NewConvenientLogger(t)
+	defer l.Done()
+
+	bot, err := bouncerbot.NewWithDecrypter(l, "token", nil)
+	if err != nil {
+		t.Fatalf("error from NewWithDecrypter: %v", err)
+	}
+	bot.Client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusNoContent,
+			Header:     make(http.Header),
+			Body:       io.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	})
+
+	var o fakeObserver
+	bot.Observe(&o)
+
+	err = bot.GuildMemberRoleAdd("123456", "7890", "42")
+	if err != nil {
+		t.Fatalf("error from GuildMemberRoleAdd: %v", err)
+	}
+
+	want := []discordCall{{http.MethodPut, "/guilds/:id/members/:id/roles/:id", 204}}
+	if diff := cmp.Diff(want, o.calls, cmp.AllowUnexported(discordCall{})); diff != "" {
+		t.Error("unexpected Discord calls (-want +got):\n" + diff)
+	}
+}
@@ -0,0 +1,105 @@
+package bouncerbot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// Run connects to Discord and handles events until ctx is canceled. Then it disconnects and waits
+// until the time returned by deadline for admissions in progress to finish, so that no one is left
+// half-admitted. Run can be called again after it returns.
+func (b *Bot) Run(ctx context.Context, deadline func() time.Time) error {
+	b.admissions.open()
+
+	err := b.Open()
+	if err != nil {
+		return fmt.Errorf("open Discord connection: %w", err)
+	}
+	b.l.Info("msg", "connected to Discord")
+
+	<-ctx.Done()
+
+	err = b.Close()
+	if err != nil {
+		b.l.Error("msg", "error closing Discord connection", "error", err)
+	}
+
+	wctx, cancel := context.WithDeadline(context.Background(), deadline())
+	defer cancel()
+
+	return b.admissions.wait(wctx)
+}
+
+// Status returns the state of the bot, for readiness checks.
+func (b *Bot) Status() *api.DiscordStatus {
+	b.RLock()
+	connected := b.DataReady
+	b.RUnlock()
+
+	out := api.DiscordStatus{Connected: connected}
+
+	b.giLock.RLock()
+	defer b.giLock.RUnlock()
+
+	if b.gi != nil {
+		out.GuildInfoDiscovered = true
+		out.MissingRoles = b.gi.MissingRoles()
+	}
+
+	return &out
+}
+
+// admissions tracks the DMs being handled, so that shutdown can wait for them.
+type admissions struct {
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func (a *admissions) open() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.closed = false
+}
+
+// start reports whether a new DM can be handled. If so, done must be called when it is finished.
+func (a *admissions) start() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return false
+	}
+	a.wg.Add(1)
+
+	return true
+}
+
+func (a *admissions) done() {
+	a.wg.Done()
+}
+
+// wait stops new DMs from being handled and waits for the ones in progress.
+func (a *admissions) wait(ctx context.Context) error {
+	a.mu.Lock()
+	a.closed = true
+	a.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wait for admissions in progress: %w", ctx.Err())
+	}
+}
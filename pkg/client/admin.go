@@ -2,9 +2,10 @@ package client
 
 import (
 	"context"
-	"errors"
 	"net/http"
-	"strings"
+	"strconv"
+
+	"github.com/kylrth/disco-bouncer/pkg/api"
 )
 
 // AdminService is used to manage authentication with the server.
@@ -14,16 +15,30 @@ type AdminService struct {
 
 var (
 	// ErrInvalidCredentials is returned when the server rejects credentials while logging in.
-	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidCredentials = api.ErrInvalidCredentials
 
 	// ErrPasswordTooShort is returned by ChangePassword if the password is too short.
-	ErrPasswordTooShort = errors.New("password too short")
+	ErrPasswordTooShort = api.ErrPasswordTooShort
 
 	// ErrPasswordTooLong is returned by ChangePassword if the password is too long.
-	ErrPasswordTooLong = errors.New("password too long")
+	ErrPasswordTooLong = api.ErrPasswordTooLong
+
+	// ErrTOTPRequired is returned by Login if the password was correct but the admin has
+	// two-factor authentication enabled. Call LoginTOTP with a code to finish logging in.
+	ErrTOTPRequired = api.ErrTOTPRequired
+
+	// ErrAccountLocked is returned by Login if the admin is locked out after too many failed
+	// logins. An owner must clear the lockout on the server.
+	ErrAccountLocked = api.ErrAccountLocked
+
+	// ErrTooManyRequests is returned by Login if there have been recent failed logins for the
+	// username or from this address. The error message says how long to wait.
+	ErrTooManyRequests = api.ErrTooManyRequests
 )
 
-// Login and store the session for later use by the client.
+// Login and store the session for later use by the client. If the server rejects the credentials,
+// the error matches ErrInvalidCredentials. If the admin has two-factor authentication enabled, the
+// error matches ErrTOTPRequired and LoginTOTP must be called next.
 func (s *AdminService) Login(ctx context.Context, user, pass string) error {
 	const p = "/login"
 
@@ -34,10 +49,6 @@ func (s *AdminService) Login(ctx context.Context, user, pass string) error {
 
 	resp, err := s.c.postJSON(ctx, p, body)
 	if err != nil {
-		if errors.Is(err, ErrNotLoggedIn) {
-			return ErrInvalidCredentials
-		}
-
 		return err
 	}
 	resp.Body.Close() // If it was 200 OK, the body is "Login successful".
@@ -59,7 +70,8 @@ func (s *AdminService) Logout(ctx context.Context) error {
 	return nil
 }
 
-// ChangePassword updates the password on the server. Must be logged in.
+// ChangePassword updates the password on the server. Must be logged in. If the new password is
+// rejected, the error matches ErrPasswordTooShort or ErrPasswordTooLong.
 func (s *AdminService) ChangePassword(ctx context.Context, oldPass, newPass string) error {
 	const p = "/admin/pass"
 
@@ -70,23 +82,79 @@ func (s *AdminService) ChangePassword(ctx context.Context, oldPass, newPass stri
 
 	resp, err := s.c.postJSON(ctx, p, body)
 	if err != nil {
-		switch resp.StatusCode {
-		case http.StatusUnauthorized:
-			return ErrNotLoggedIn
-		case http.StatusBadRequest:
-			if strings.Contains(err.Error(), "Password too short") {
-				return ErrPasswordTooShort
-			}
-			if strings.Contains(err.Error(), "Password too long") {
-				return ErrPasswordTooLong
-			}
-
-			fallthrough
-		default:
-			return err
-		}
+		return err
 	}
 	resp.Body.Close() // If it was 200 OK, the body is "Password updated successfully"
 
 	return nil
 }
+
+// LoginTOTP finishes logging in with a code from an authenticator app or a recovery code, after
+// Login returned ErrTOTPRequired. If the server rejects the code, the error matches
+// ErrInvalidCredentials.
+func (s *AdminService) LoginTOTP(ctx context.Context, code string) error {
+	const p = "/login/totp"
+
+	resp, err := s.c.postJSON(ctx, p, api.TOTPCode{Code: code})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close() // If it was 200 OK, the body is "Login successful".
+
+	return nil
+}
+
+// EnrollTOTP starts enrolling the logged-in admin in two-factor authentication. The returned secret
+// must be added to an authenticator app, and then a code from the app passed to ConfirmTOTP.
+func (s *AdminService) EnrollTOTP(ctx context.Context) (*api.TOTPEnrollment, error) {
+	const p = "/admin/totp"
+
+	var out api.TOTPEnrollment
+	err := s.c.postJSONrecvJSON(ctx, p, struct{}{}, &out)
+
+	return &out, err
+}
+
+// ConfirmTOTP enables two-factor authentication with a code from the authenticator app, and
+// returns recovery codes that can each be used once if the authenticator app is lost.
+func (s *AdminService) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	const p = "/admin/totp/confirm"
+
+	var out api.RecoveryCodes
+	err := s.c.postJSONrecvJSON(ctx, p, api.TOTPCode{Code: code}, &out)
+
+	return out.Codes, err
+}
+
+// DisableTOTP turns off two-factor authentication for the logged-in admin. The code can be from the
+// authenticator app or a recovery code.
+func (s *AdminService) DisableTOTP(ctx context.Context, code string) error {
+	const p = "/admin/totp"
+
+	resp, err := s.c.sendJSON(ctx, http.MethodDelete, p, nil, api.TOTPCode{Code: code})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close() // If it was 200 OK, the body is "Two-factor authentication disabled".
+
+	return nil
+}
+
+// Sessions lists the logged-in sessions of the admin, most recently used first. The session used
+// by this client is marked as current.
+func (s *AdminService) Sessions(ctx context.Context) ([]api.Session, error) {
+	const p = "/admin/sessions"
+
+	var out []api.Session
+	err := s.c.getJSON(ctx, p, &out)
+
+	return out, err
+}
+
+// RevokeSession ends one of the admin's sessions. If the session does not exist, the error matches
+// ErrNotFound.
+func (s *AdminService) RevokeSession(ctx context.Context, id int) error {
+	p := "/admin/sessions/" + strconv.Itoa(id)
+
+	return s.c.delete(ctx, p, nil)
+}
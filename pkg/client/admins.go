@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// AdminsService is used by owners to manage the admin accounts on the server.
+type AdminsService struct {
+	c *Client
+}
+
+// List gets every admin account.
+func (s *AdminsService) List(ctx context.Context) ([]*api.Admin, error) {
+	const p = "/api/admins"
+
+	var out []*api.Admin
+
+	return out, s.c.getJSON(ctx, p, &out)
+}
+
+// Create creates a new admin with an initial password and role. If the username is taken, the
+// error matches ErrConflict.
+func (s *AdminsService) Create(
+	ctx context.Context, username, password string, role api.Role,
+) (*api.Admin, error) {
+	const p = "/api/admins"
+
+	body := api.NewAdmin{Username: username, Password: password, Role: role}
+
+	var out api.Admin
+
+	return &out, s.c.postJSONrecvJSON(ctx, p, &body, &out)
+}
+
+// Patch changes the role of an admin or disables or enables them, and returns the updated admin.
+// Disabled admins can't log in, and their sessions and API tokens stop working.
+func (s *AdminsService) Patch(
+	ctx context.Context, username string, patch *api.AdminPatch,
+) (*api.Admin, error) {
+	p, err := url.JoinPath("/api/admins", username)
+	if err != nil {
+		return nil, err
+	}
+
+	var out api.Admin
+
+	return &out, s.c.sendJSONrecvJSON(ctx, http.MethodPatch, p, nil, patch, &out)
+}
+
+// ResetPassword sets a new password for an admin without needing the old one.
+func (s *AdminsService) ResetPassword(ctx context.Context, username, password string) error {
+	p, err := url.JoinPath("/api/admins", username, "password")
+	if err != nil {
+		return err
+	}
+
+	body := map[string]string{"password": password}
+
+	resp, err := s.c.sendJSON(ctx, http.MethodPut, p, nil, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close() // If it was 200 OK, the body is "Password reset successfully".
+
+	return nil
+}
+
+// Delete removes an admin. Their sessions stop working and their API tokens are deleted.
+func (s *AdminsService) Delete(ctx context.Context, username string) error {
+	p, err := url.JoinPath("/api/admins", username)
+	if err != nil {
+		return err
+	}
+
+	return s.c.delete(ctx, p, nil)
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// AuditService is used to read the audit trail of changes made through the API.
+type AuditService struct {
+	c *Client
+}
+
+// List gets the audit events selected by the filter, newest first. The filter may be nil. To get
+// the next page, set f.BeforeID to the ID of the last event received.
+func (s *AuditService) List(ctx context.Context, f *api.AuditFilter) ([]*api.AuditEvent, error) {
+	p := "/api/audit"
+	if f != nil {
+		if q := f.Values(); len(q) > 0 {
+			p += "?" + q.Encode()
+		}
+	}
+
+	var out []*api.AuditEvent
+
+	return out, s.c.getJSON(ctx, p, &out)
+}
+
+// Head gets the newest event in the audit chain. Keeping a copy of its hash somewhere else makes it
+// possible to tell later if the audit trail was rewritten. If there are no events yet, the error
+// matches ErrNotFound.
+func (s *AuditService) Head(ctx context.Context) (*api.AuditHead, error) {
+	const p = "/api/audit/head"
+
+	var out api.AuditHead
+
+	return &out, s.c.getJSON(ctx, p, &out)
+}
@@ -5,7 +5,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,6 +12,12 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/kylrth/disco-bouncer/pkg/api"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/publicsuffix"
 )
 
@@ -20,14 +25,26 @@ import (
 type Client struct {
 	baseURL string
 	client  *http.Client
+	token   string
 
 	Admin   AdminService
+	Admins  AdminsService
 	Users   UsersService
 	Discord DiscordService
+	Audit   AuditService
+}
+
+// Option changes the configuration of a Client.
+type Option = func(c *Client)
+
+// WithToken makes the client authenticate every request with an API token, so that there is no
+// need to log in.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
 }
 
 // NewClient creates a new client that sends requests to the given URL.
-func NewClient(baseURL string) (*Client, error) {
+func NewClient(baseURL string, opts ...Option) (*Client, error) {
 	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
 	if err != nil {
 		return nil, err
@@ -41,28 +58,94 @@ func NewClient(baseURL string) (*Client, error) {
 		},
 	}
 	c.Admin = AdminService{&c}
+	c.Admins = AdminsService{&c}
 	c.Users = UsersService{&c}
 	c.Discord = DiscordService{&c}
+	c.Audit = AuditService{&c}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
 
 	return &c, nil
 }
 
-// ErrNotLoggedIn is returned when a 401: Unauthorized is returned by the server.
-var ErrNotLoggedIn = errors.New("not logged in")
+// do sends the request, adding the API token if there is one.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	// The trace context of the request is sent to the server, so that its spans join the trace.
+	// Without a configured TracerProvider, this does nothing.
+	ctx, span := otel.Tracer("github.com/kylrth/disco-bouncer/pkg/client").Start(
+		req.Context(), req.Method+" "+req.URL.Path, trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
-func handleNotOK(resp *http.Response) error {
-	defer resp.Body.Close()
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		return ErrNotLoggedIn
+		return nil, err
 	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	return resp, nil
+}
+
+// These errors can be checked with errors.Is against errors returned by the client. They match the
+// error codes sent by the server.
+var (
+	// ErrNotLoggedIn is returned when a 401: Unauthorized is returned by the server.
+	ErrNotLoggedIn = api.ErrUnauthenticated
+
+	// ErrForbidden is returned when the credentials are valid but not allowed to make the request.
+	ErrForbidden = api.ErrForbidden
+
+	// ErrNotFound is returned when the requested resource does not exist.
+	ErrNotFound = api.ErrNotFound
+
+	// ErrConflict is returned when the request conflicts with the current state on the server.
+	ErrConflict = api.ErrConflict
 
-	errMsg, err := io.ReadAll(resp.Body)
+	// ErrBadRequest is returned when the server rejects the request as malformed.
+	ErrBadRequest = api.ErrBadRequest
+
+	// ErrValidation is returned when the server rejects some fields of the request. Use errors.As
+	// with *api.Error to see the details.
+	ErrValidation = api.ErrValidation
+
+	// ErrPreconditionFailed is returned when a write made with IfMatch finds that the resource has
+	// changed since that version.
+	ErrPreconditionFailed = api.ErrPreconditionFailed
+)
+
+// handleNotOK reads the error envelope from a non-successful response. The returned error is an
+// *api.Error.
+func handleNotOK(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return fmt.Errorf("read error message body: %w", err)
 	}
 
-	return errors.New(string(errMsg))
+	var envelope api.ErrorResponse
+	err = json.Unmarshal(body, &envelope)
+	if err != nil || envelope.Error == nil {
+		// not from our server, or from before error codes were added
+		return &api.Error{
+			Status:  resp.StatusCode,
+			Code:    api.CodeForStatus(resp.StatusCode),
+			Message: string(body),
+		}
+	}
+
+	envelope.Error.Status = resp.StatusCode
+
+	return envelope.Error
 }
 
 // joinURL adds the endpoint path to the end of the baseURL. endpoint may contain query parameters.
@@ -97,7 +180,7 @@ func (c *Client) get(ctx context.Context, p string) (*http.Response, error) {
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return resp, err
 	}
@@ -141,7 +224,7 @@ func (c *Client) post(
 		return nil, err
 	}
 	req.Header.Set("Content-Type", contentType)
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return resp, err
 	}
@@ -171,36 +254,55 @@ func (c *Client) postJSONrecvJSON(ctx context.Context, p string, data, v any) er
 	return unmarshalBody(resp, v)
 }
 
-func (c *Client) putJSONrecvJSON(ctx context.Context, p string, data, v any) error {
+// sendJSON sends data as JSON with the given method and header.
+func (c *Client) sendJSON(
+	ctx context.Context, method, p string, header http.Header, data any,
+) (*http.Response, error) {
 	body, err := json.Marshal(data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	p, err = joinURL(c.baseURL, p)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, p, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, method, p, bytes.NewBuffer(body))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	for k, vals := range header {
+		req.Header[k] = vals
+	}
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return handleNotOK(resp)
+		return nil, handleNotOK(resp)
+	}
+
+	return resp, nil
+}
+
+// sendJSONrecvJSON sends data as JSON with the given method and header, and decodes the response
+// into v.
+func (c *Client) sendJSONrecvJSON(
+	ctx context.Context, method, p string, header http.Header, data, v any,
+) error {
+	resp, err := c.sendJSON(ctx, method, p, header, data)
+	if err != nil {
+		return err
 	}
 
 	return unmarshalBody(resp, v)
 }
 
-func (c *Client) delete(ctx context.Context, p string) error {
+func (c *Client) delete(ctx context.Context, p string, header http.Header) error {
 	p, err := joinURL(c.baseURL, p)
 	if err != nil {
 		return err
@@ -211,7 +313,10 @@ func (c *Client) delete(ctx context.Context, p string) error {
 		return err
 	}
 
-	resp, err := c.client.Do(req)
+	for k, vals := range header {
+		req.Header[k] = vals
+	}
+	resp, err := c.do(req)
 	if err != nil {
 		return err
 	}
@@ -3,7 +3,7 @@ package client
 import (
 	"context"
 
-	"github.com/kylrth/disco-bouncer/internal/server"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 )
 
 // DiscordService is used to perform actions on Discord through the bouncerbot.
@@ -16,7 +16,7 @@ type DiscordService struct {
 func (s *DiscordService) Migrate(ctx context.Context, name, year string) error {
 	const p = "/api/discord/migrate"
 
-	m := server.Migration{
+	m := api.Migration{
 		Name: name,
 		Year: year,
 	}
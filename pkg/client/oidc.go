@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/kylrth/disco-bouncer/pkg/api"
+)
+
+// LoginOIDC logs in with the single sign-on identity provider configured on the server. The open
+// function is called with a URL to open in a browser. After logging in there, the browser is sent
+// back to a listener on a loopback address, which receives a one-time code to exchange for a
+// session. If the identity is not allowed to log in, the error matches ErrForbidden.
+func (s *AdminService) LoginOIDC(ctx context.Context, open func(u string) error) error {
+	return s.loginInBrowser(ctx, "/login/oidc", open)
+}
+
+// LoginDiscord logs in with a Discord account in the same way as LoginOIDC. If the Discord account
+// does not hold an admin role in the guild, the error matches ErrForbidden.
+func (s *AdminService) LoginDiscord(ctx context.Context, open func(u string) error) error {
+	return s.loginInBrowser(ctx, "/login/discord", open)
+}
+
+// loginInBrowser opens the login endpoint in a browser and exchanges the resulting login code at
+// the endpoint's /exchange.
+func (s *AdminService) loginInBrowser(
+	ctx context.Context, p string, open func(u string) error,
+) error {
+	ln, err := (&net.ListenConfig{}).Listen(ctx, "tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("listen for login callback: %w", err)
+	}
+
+	results := make(chan loginCallback, 1)
+	srv := &http.Server{
+		Handler:           loginCallbackHandler(results),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go srv.Serve(ln) //nolint:errcheck // Serve always returns an error after Shutdown.
+
+	defer srv.Shutdown(context.Background()) //nolint:errcheck // The login is already finished.
+
+	returnTo := "http://" + ln.Addr().String() + "/callback"
+	loginURL, err := joinURL(s.c.baseURL,
+		p+"?"+url.Values{"return_to": {returnTo}}.Encode())
+	if err != nil {
+		return err
+	}
+	err = open(loginURL)
+	if err != nil {
+		return fmt.Errorf("open login page: %w", err)
+	}
+
+	var result loginCallback
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case result = <-results:
+	}
+	if result.err != nil {
+		return result.err
+	}
+
+	resp, err := s.c.postJSON(ctx, p+"/exchange", map[string]string{"code": result.code})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close() // If it was 200 OK, the body is "Login successful".
+
+	return nil
+}
+
+type loginCallback struct {
+	code string
+	err  error
+}
+
+// loginCallbackHandler sends the result of the first request to /callback.
+func loginCallbackHandler(results chan<- loginCallback) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		var result loginCallback
+		switch {
+		case q.Get("error") != "":
+			result.err = &api.Error{Code: api.Code(q.Get("error")), Message: q.Get("message")}
+		case q.Get("code") == "":
+			result.err = errors.New("login callback did not include a code")
+		default:
+			result.code = q.Get("code")
+		}
+
+		select {
+		case results <- result:
+		default: // A login was already received.
+		}
+
+		if result.err != nil {
+			http.Error(w, "Login failed: "+result.err.Error(), http.StatusUnauthorized)
+
+			return
+		}
+		fmt.Fprintln(w, "Logged in. You can close this window.") //nolint:errcheck // best effort
+	})
+
+	return mux
+}
@@ -3,11 +3,12 @@ package client
 import (
 	"context"
 	"fmt"
+	"iter"
+	"net/http"
 	"net/url"
 	"strconv"
-	"strings"
 
-	"github.com/kylrth/disco-bouncer/internal/db"
+	"github.com/kylrth/disco-bouncer/pkg/api"
 	"github.com/kylrth/disco-bouncer/pkg/encrypt"
 )
 
@@ -16,94 +17,179 @@ type UsersService struct {
 	c *Client
 }
 
-type filters struct {
-	keyHash string
-}
-
-// FilterOption is a way to add filter options to the request when calling GetAllUsers.
-type FilterOption = func(f *filters)
+// FilterOption is a way to add filter options to the request when calling GetAllUsers or All.
+type FilterOption = func(f *api.UserFilter)
 
 // WithKeyHash returns a FilterOption that filters by the provided MD5 key hash.
 func WithKeyHash(keyHash string) FilterOption {
-	return func(f *filters) { f.keyHash = keyHash }
+	return func(f *api.UserFilter) { f.KeyHash = keyHash }
 }
 
-func (f *filters) formatQueryParams() string {
-	var out strings.Builder
+// WithAfterID returns a FilterOption that only requests users with an ID greater than id.
+func WithAfterID(id int) FilterOption {
+	return func(f *api.UserFilter) { f.AfterID = id }
+}
 
-	if f.keyHash != "" {
-		out.WriteString(",keyHash=" + f.keyHash)
-	}
+// WithLimit returns a FilterOption that requests at most n users, where n is at most
+// api.MaxUserLimit. When used with All, n is the number of users requested per page.
+func WithLimit(n int) FilterOption {
+	return func(f *api.UserFilter) { f.Limit = n }
+}
+
+func usersPath(f *api.UserFilter) string {
+	const p = "/api/users"
 
-	if out.Len() == 0 {
-		return ""
+	q := f.Values()
+	if len(q) == 0 {
+		return p
 	}
 
-	return "?" + out.String()[1:] // remove initial ","
+	return p + "?" + q.Encode()
 }
 
 // GetAllUsers gets the current users table.
-func (s *UsersService) GetAllUsers(ctx context.Context, opts ...FilterOption) ([]*db.User, error) {
-	var f filters
+func (s *UsersService) GetAllUsers(ctx context.Context, opts ...FilterOption) ([]*api.User, error) {
+	var f api.UserFilter
 	for _, opt := range opts {
 		opt(&f)
 	}
 
-	p := "/api/users" + f.formatQueryParams()
-
-	var out []*db.User
+	var out []*api.User
 
-	err := s.c.getJSON(ctx, p, &out)
+	err := s.c.getJSON(ctx, usersPath(&f), &out)
 
 	return out, err
 }
 
+// DefaultPageSize is the number of users requested at a time by All, unless WithLimit is provided.
+const DefaultPageSize = 100
+
+// All iterates over the users table, requesting one page of users at a time. If an error occurs,
+// it is yielded with a nil user and iteration stops.
+func (s *UsersService) All(ctx context.Context, opts ...FilterOption) iter.Seq2[*api.User, error] {
+	return func(yield func(*api.User, error) bool) {
+		f := api.UserFilter{Limit: DefaultPageSize}
+		for _, opt := range opts {
+			opt(&f)
+		}
+		if f.Limit <= 0 {
+			f.Limit = DefaultPageSize
+		}
+
+		for {
+			var page []*api.User
+
+			err := s.c.getJSON(ctx, usersPath(&f), &page)
+			if err != nil {
+				yield(nil, err)
+
+				return
+			}
+
+			for _, u := range page {
+				if !yield(u, nil) {
+					return
+				}
+			}
+
+			if len(page) < f.Limit {
+				return
+			}
+			f.AfterID = page[len(page)-1].ID
+		}
+	}
+}
+
 // GetUser gets the information of the user with the specified ID.
-func (s *UsersService) GetUser(ctx context.Context, id int) (*db.User, error) {
+func (s *UsersService) GetUser(ctx context.Context, id int) (*api.User, error) {
 	p, err := url.JoinPath("/api/users", strconv.Itoa(id))
 	if err != nil {
 		return nil, err
 	}
 
-	var out db.User
+	var out api.User
 
 	return &out, s.c.getJSON(ctx, p, &out)
 }
 
 // CreateUser creates a new user and returns the ID. u.ID is ignored.
-func (s *UsersService) CreateUser(ctx context.Context, u *db.User) (int, error) {
+func (s *UsersService) CreateUser(ctx context.Context, u *api.User) (int, error) {
 	const p = "/api/users"
 
-	var out db.User
+	var out api.User
 
 	return out.ID, s.c.postJSONrecvJSON(ctx, p, u, &out)
 }
 
-// UpdateUser updates the information for an existing user, selected by u.ID.
-func (s *UsersService) UpdateUser(ctx context.Context, u *db.User) error {
+// WriteOption modifies a request that changes an existing user.
+type WriteOption = func(h http.Header)
+
+// IfMatch returns a WriteOption that makes the server refuse the change with ErrPreconditionFailed
+// if the user is no longer at the given version. A version of 0 has no effect.
+func IfMatch(version int) WriteOption {
+	return func(h http.Header) {
+		if version > 0 {
+			h.Set("If-Match", strconv.Quote(strconv.Itoa(version)))
+		}
+	}
+}
+
+func writeHeader(opts []WriteOption) http.Header {
+	h := make(http.Header)
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+// UpdateUser replaces the information for an existing user, selected by u.ID. u.Version is set to
+// the new version of the user.
+func (s *UsersService) UpdateUser(ctx context.Context, u *api.User, opts ...WriteOption) error {
 	p, err := url.JoinPath("/api/users", strconv.Itoa(u.ID))
 	if err != nil {
 		return err
 	}
 
-	var out db.User
+	var out api.User
+	err = s.c.sendJSONrecvJSON(ctx, http.MethodPut, p, writeHeader(opts), u, &out)
+	if err != nil {
+		return err
+	}
+
+	u.Version = out.Version
+
+	return nil
+}
+
+// Patch changes only the fields set in the patch for the user with the specified ID, and returns
+// the updated user.
+func (s *UsersService) Patch(
+	ctx context.Context, id int, patch *api.UserPatch, opts ...WriteOption,
+) (*api.User, error) {
+	p, err := url.JoinPath("/api/users", strconv.Itoa(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var out api.User
 
-	return s.c.putJSONrecvJSON(ctx, p, u, &out)
+	return &out, s.c.sendJSONrecvJSON(ctx, http.MethodPatch, p, writeHeader(opts), patch, &out)
 }
 
 // DeleteUser removes the user from the server.
-func (s *UsersService) DeleteUser(ctx context.Context, id int) error {
+func (s *UsersService) DeleteUser(ctx context.Context, id int, opts ...WriteOption) error {
 	p, err := url.JoinPath("/api/users", strconv.Itoa(id))
 	if err != nil {
 		return err
 	}
 
-	return s.c.delete(ctx, p)
+	return s.c.delete(ctx, p, writeHeader(opts))
 }
 
 // Upload uploads a new user to the server. It encrypts u.Name, fills in u.NameKeyHash, and returns
 // the received ID and the encrypted key. The fields of u will be updated.
-func (s *UsersService) Upload(ctx context.Context, u *db.User) (id int, key string, err error) {
+func (s *UsersService) Upload(ctx context.Context, u *api.User) (id int, key string, err error) {
 	u.Name, key, err = encrypt.Encrypt(u.Name)
 	if err != nil {
 		return 0, key, fmt.Errorf("encrypt name: %w", err)
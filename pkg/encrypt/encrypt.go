@@ -11,7 +11,10 @@ import (
 	"io"
 )
 
-const nonceLength = 12
+const (
+	nonceLength = 12
+	tagLength   = 16 // the GCM authentication tag appended to the sealed text
+)
 
 // Encrypt encodes plain text into a ciphertext using a randomly-generated key (which is then
 // returned as a hexadecimal string).
@@ -22,15 +25,21 @@ func Encrypt(text string) (ciphertext, key string, err error) {
 	}
 	key = hex.EncodeToString(bkey)
 
+	ciphertext, err = WithKey(text, bkey)
+
+	return ciphertext, key, err
+}
+
+// WithKey encrypts the text using the same algorithm as Encrypt, but with the given key and a
+// randomly-generated nonce. The result can be decrypted by Decrypt with the hex-encoded key.
+func WithKey(text string, key []byte) (string, error) {
 	nonce := make([]byte, nonceLength)
-	_, err = io.ReadFull(rand.Reader, nonce)
+	_, err := io.ReadFull(rand.Reader, nonce)
 	if err != nil {
-		return "", key, fmt.Errorf("generate nonce: %w", err)
+		return "", fmt.Errorf("generate nonce: %w", err)
 	}
 
-	ciphertext, err = WithKeyAndNonce(text, bkey, nonce)
-
-	return ciphertext, key, err
+	return WithKeyAndNonce(text, key, nonce)
 }
 
 func generateKey() ([]byte, error) {
@@ -92,6 +101,20 @@ func Decrypt(ciphertext, key string) (string, error) {
 	return string(btext), nil
 }
 
+// CheckCiphertext returns a BadCiphertextError if the ciphertext could not have been produced by
+// Encrypt. The key is not needed, so this cannot tell whether the ciphertext is authentic.
+func CheckCiphertext(ciphertext string) error {
+	bciphertext, err := hex.DecodeString(ciphertext)
+	if err != nil {
+		return BadCiphertextError{err}
+	}
+	if len(bciphertext) < nonceLength+tagLength {
+		return BadCiphertextError{errors.New("ciphertext too short")}
+	}
+
+	return nil
+}
+
 // BadCiphertextError is returned if the ciphertext is invalid.
 type BadCiphertextError struct {
 	error
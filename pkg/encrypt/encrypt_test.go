@@ -197,3 +197,37 @@ func TestDecrypt_Errors(t *testing.T) {
 		t.Errorf("error did not match BadKeyError: %v", err)
 	}
 }
+
+func TestCheckCiphertext(t *testing.T) {
+	t.Parallel()
+
+	empty, _, err := encrypt.Encrypt("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		in    string
+		valid bool
+	}{
+		"fromJS":    {ciphertext, true},
+		"emptyText": {empty, true},
+		"nonhex":    {"atdfifoewfijaba", false},
+		"tooShort":  {ciphertext[:2*(nonceLength+15)], false},
+		"plaintext": {"John Doe", false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := encrypt.CheckCiphertext(tc.in)
+			if tc.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tc.valid && !errors.As(err, &encrypt.BadCiphertextError{}) {
+				t.Errorf("expected BadCiphertextError, got %v", err)
+			}
+		})
+	}
+}